@@ -8,11 +8,17 @@ import (
 	"goapp/internal/app/features/third"
 	"goapp/internal/app/global"
 	"goapp/internal/app/middleware"
+	"goapp/pkg/core"
 	"goapp/pkg/ids"
+	"goapp/pkg/lifecycle"
+	"goapp/pkg/metrics"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/autotls"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
@@ -46,6 +52,10 @@ func main() {
 		panic("无法设置节点 ID")
 	}
 
+	// 指标需要在各组件创建之前设置，未设置时不做统计
+	prom := metrics.NewPrometheusProvider("goapp")
+	metrics.SetDefault(prom)
+
 	ctx := context.Background()
 	global.Init(ctx) // 初始化全局变量, 失败时会 panic
 	defer global.Release()
@@ -93,14 +103,113 @@ func main() {
 		features.RegisterRoutes(v1)
 	}
 
+	// 收到退出信号后依次：停止接收新的请求，排空长连接，停止消费者，刷新日志，释放资源
+	lc := global.Lifecycle()
+	serverCtx, stopServer := context.WithCancel(ctx)
+	serverDone := make(chan core.Empty)
+	lc.Register("http server", lifecycle.StageIngress, time.Second, func(ctx context.Context) error {
+		stopServer()
+		return nil
+	})
+	// 与排空长连接同时进行：SSE 连接结束后，服务器才能关闭
+	lc.Register("http requests", lifecycle.StageDrain, 15*time.Second, func(ctx context.Context) error {
+		select {
+		case <-serverDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	// Prometheus 指标在单独的内网地址上提供，不经过公网的路由
+	if addr := global.GetAppConfig().MetricsAddr; len(addr) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", prom.Handler())
+		metricsSrv := &http.Server{Addr: addr, Handler: mux}
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("指标服务启动失败")
+			}
+		}()
+		lc.Register("metrics server", lifecycle.StageRelease, 5*time.Second, metricsSrv.Shutdown)
+	}
+	go core.WaitSysSignal(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), global.ShutdownTimeout())
+		defer cancel()
+		if err := lc.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("关闭时出错")
+		}
+	})
+
 	if env == "dev" {
-		err = r.RunTLS(global.GetAppConfig().Addr, "E:\\experiment\\certs\\localhost+2.pem", "E:\\experiment\\certs\\localhost+2-key.pem")
+		err = runTLS(serverCtx, r, global.GetAppConfig().Addr, "E:\\experiment\\certs\\localhost+2.pem", "E:\\experiment\\certs\\localhost+2-key.pem")
 	} else {
 		// Start HTTPS server with automatic Let's Encrypt certificate management and HTTP-to-HTTPS redirection.
-		// The server runs until interrupted and shuts down gracefully.
-		err = autotls.Run(r, global.GetAppConfig().Domains...)
+		// The server runs until serverCtx is cancelled and shuts down gracefully.
+		err = runAutoTLS(serverCtx, r, global.GetAppConfig().Domains...)
 	}
+	// runTLS、runAutoTLS 在 Shutdown 返回后才返回，此时进行中的请求均已结束
+	close(serverDone)
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatal().Stack().Err(err).Msg("启动服务器失败")
 	}
+	// 等待其余的关闭钩子执行完毕
+	if lc.Stopping() {
+		<-lc.Done()
+	}
+}
+
+// 服务器优雅关闭的超时时间
+const serverShutdownTimeout = 10 * time.Second
+
+// 运行服务器直到 ctx 结束；ListenAndServe 在 Shutdown 开始时就会返回 ErrServerClosed，
+// 所以需要等待 Shutdown 返回（进行中的请求处理完毕）后再返回
+func serve(ctx context.Context, srv *http.Server, listen func() error) error {
+	shutdownDone := make(chan core.Empty)
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Str("addr", srv.Addr).Msg("服务器关闭超时")
+		}
+	}()
+	err := listen()
+	if err == http.ErrServerClosed {
+		<-shutdownDone
+	}
+	return err
+}
+
+// 与 gin.Engine.RunTLS 相同，ctx 结束时优雅关闭
+func runTLS(ctx context.Context, r http.Handler, addr, certFile, keyFile string) error {
+	srv := &http.Server{Addr: addr, Handler: r}
+	return serve(ctx, srv, func() error {
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+// 与 autotls.RunWithContext 相同：Let's Encrypt 自动证书，HTTP 重定向到 HTTPS，ctx 结束时优雅关闭
+func runAutoTLS(ctx context.Context, r http.Handler, domains ...string) error {
+	redirectSrv := &http.Server{Addr: ":http", Handler: http.HandlerFunc(redirectHTTPS), ReadHeaderTimeout: autotls.ReadHeaderTimeout}
+	srv := &http.Server{Handler: r, ReadHeaderTimeout: autotls.ReadHeaderTimeout}
+
+	var g errgroup.Group
+	g.Go(func() error {
+		return serve(ctx, redirectSrv, redirectSrv.ListenAndServe)
+	})
+	g.Go(func() error {
+		return serve(ctx, srv, func() error {
+			return srv.Serve(autocert.NewListener(domains...))
+		})
+	})
+	return g.Wait()
+}
+
+func redirectHTTPS(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if len(host) == 0 {
+		host = req.URL.Host
+	}
+	http.Redirect(w, req, "https://"+host+req.RequestURI, http.StatusMovedPermanently)
 }
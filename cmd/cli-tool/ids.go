@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"goapp/pkg/ids"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 解析 id 并输出其各种表示；-new 时生成新的 id
+func runId(ctx context.Context, args []string) error {
+	fs := newFlagSet("id")
+	kind := fs.String("new", "", "生成新的 id：uid、bigid 或 snowflake")
+	if err := parseArgs(fs, args, -1); err != nil {
+		return err
+	}
+
+	if len(*kind) > 0 {
+		switch *kind {
+		case "uid":
+			printUID(ids.NewUID())
+		case "bigid", "snowflake":
+			// 节点 ID 与 app 一样从环境变量 node_id 读取，未设置时为 0
+			if len(os.Getenv("node_id")) > 0 {
+				if err := ids.IDSetNodeIDFromEnv("node_id"); err != nil {
+					return err
+				}
+			}
+			printSnowflake(ids.NewBigID().ToInt64())
+		default:
+			return fmt.Errorf("unknown id kind: %s", *kind)
+		}
+		return nil
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	s := fs.Arg(0)
+	// 雪花 ID 及 BigID 为十进制整数，其余按 UID 解析
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		printSnowflake(v)
+		return nil
+	}
+	uid, err := parseUID(s)
+	if err != nil {
+		return err
+	}
+	printUID(uid)
+	return nil
+}
+
+// 解析 ids.UID，支持十六进制（可以带 -）及 base64 两种格式
+func parseUID(s string) (ids.UID, error) {
+	var uid ids.UID
+	s = strings.TrimSpace(s)
+	if len(s) == 22 {
+		return ids.NewUIDFromBase64(s)
+	}
+	if err := uid.UnmarshalText([]byte(s)); err != nil {
+		return uid, fmt.Errorf("invalid uid %q: %w", s, err)
+	}
+	return uid, nil
+}
+
+func printUID(uid ids.UID) {
+	fmt.Printf("uid:     %s\n", uid)
+	fmt.Printf("uuid:    %s\n", uid.ToUUID())
+	fmt.Printf("base64:  %s\n", uid.ToBase64())
+	fmt.Printf("version: %d\n", uid.Version())
+	if uid.Version() == 7 {
+		fmt.Printf("time:    %s\n", uid.Time().Format(time.RFC3339Nano))
+	}
+}
+
+func printSnowflake(v int64) {
+	id := ids.BigID(v)
+	fmt.Printf("id:          %d\n", v)
+	text, _ := id.MarshalText()
+	fmt.Printf("bigid:       %q\n", text)
+	fmt.Printf("time:        %s\n", id.Timestamp().Format(time.RFC3339Nano))
+	fmt.Printf("node:        %d\n", id.NodeID())
+	fmt.Printf("clock back:  %d\n", id.ClockBackTimes())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"goapp/internal/app/shared/crypto"
+)
+
+// 生成密钥对，输出可以直接粘贴到配置文件的 authenticator 中
+func runKeys(ctx context.Context, args []string) error {
+	fs := newFlagSet("keys")
+	keyType := fs.String("type", "", "box: 用于协商加密密钥的密钥对，sign: 用于签名的密钥对，为空时都生成")
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if *keyType != "" && *keyType != "box" && *keyType != "sign" {
+		return fmt.Errorf("unknown key type: %s", *keyType)
+	}
+
+	if *keyType == "" || *keyType == "box" {
+		pub, pri, err := crypto.NewNegotiateKeyPair()
+		if err != nil {
+			return err
+		}
+		printKeyPair("box_key_pair", crypto.Base64Encode(pub), crypto.Base64Encode(pri))
+	}
+	if *keyType == "" || *keyType == "sign" {
+		pub, pri, err := crypto.NewSignKeyPair()
+		if err != nil {
+			return err
+		}
+		printKeyPair("sign_key_pair", pub, pri)
+	}
+	return nil
+}
+
+func printKeyPair(name, pub, pri string) {
+	fmt.Printf("%s:\n  pub: %s\n  pri: %s\n", name, pub, pri)
+}
@@ -1,7 +1,109 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"goapp/internal/app/global"
+	"os"
 
+	"github.com/rs/zerolog"
+)
+
+// 子命令
+type command struct {
+	name  string
+	usage string
+	// 是否需要连接数据库、缓存等，此时与 app 一样通过环境变量 env 及 config_dir 读取配置
+	needGlobal bool
+	run        func(ctx context.Context, args []string) error
+}
+
+var commands []*command
+
+// 在 init 中赋值，避免与 newFlagSet 形成初始化循环
+func init() {
+	commands = []*command{
+		{name: "keys", usage: "keys [-type box|sign]  生成 AuthenticatorConfig 使用的密钥对", run: runKeys},
+		{name: "ban", usage: "ban <userId>  封禁用户，并吊销其所有令牌", needGlobal: true, run: runBan},
+		{name: "unban", usage: "unban <userId>  解除封禁", needGlobal: true, run: runUnban},
+		{name: "revoke-tokens", usage: "revoke-tokens <userId>  吊销用户所有的令牌", needGlobal: true, run: runRevokeTokens},
+		{name: "unlock", usage: "unlock [-kind phone|ip|client] <value>  解除登录失败导致的锁定", needGlobal: true, run: runUnlock},
+		{name: "token", usage: "token <accessToken>  查看访问令牌对应的用户信息", needGlobal: true, run: runToken},
+		{name: "migrate", usage: "migrate [-dry-run] [up | down -steps n | status]  执行数据库迁移", needGlobal: true, run: runMigrate},
+		{name: "id", usage: "id [-new uid|bigid|snowflake] [id]  解析 ids.UID、ids.BigID 及雪花 ID，或生成新的 ID", run: runId},
+	}
+}
+
+// 运维使用的命令行工具
 func main() {
-	fmt.Println("这是cli-tool的入口")
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	var cmd *command
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+			break
+		}
+	}
+	if cmd == nil {
+		printUsage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	if cmd.needGlobal {
+		global.Init(ctx) // 初始化全局变量, 失败时会 panic
+	}
+	err := cmd.run(ctx, os.Args[2:])
+	if cmd.needGlobal {
+		global.Release()
+	}
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: cli-tool <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintln(os.Stderr, "  "+c.usage)
+	}
+}
+
+// 子命令的参数解析，参数错误时输出用法
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintln(fs.Output(), "usage: cli-tool "+c.usage)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// 解析参数，并要求恰好有 n 个位置参数，n 小于 0 时不限制；参数错误时已输出用法，返回 flag.ErrHelp
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if n >= 0 && fs.NArg() != n {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	return nil
 }
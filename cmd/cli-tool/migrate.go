@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"goapp/internal/app/global"
+	"goapp/migrations"
+	"goapp/pkg/migrate"
+	"os"
+	"time"
+)
+
+// 执行 migrations 中的迁移：up 执行所有未执行的迁移，down 回滚最近的迁移，status 查看执行情况
+func runMigrate(ctx context.Context, args []string) error {
+	fs := newFlagSet("migrate")
+	dryRun := fs.Bool("dry-run", false, "只输出将要执行的脚本，不修改数据库")
+	steps := fs.Int("steps", 1, "down 时回滚的迁移数")
+	if err := parseArgs(fs, args, -1); err != nil {
+		return err
+	}
+	action := "up"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+		// 参数也可以写在 action 之后
+		if err := parseArgs(fs, fs.Args()[1:], 0); err != nil {
+			return err
+		}
+	}
+
+	options := []migrate.Option{migrate.WithLogger(os.Stdout)}
+	if *dryRun {
+		options = append(options, migrate.WithDryRun(os.Stdout))
+	}
+	m := migrate.NewMigrator(global.DB(), migrations.FS, options...)
+
+	switch action {
+	case "up":
+		done, err := m.Up(ctx)
+		if err == nil && !*dryRun {
+			fmt.Printf("%d migrations applied\n", len(done))
+		}
+		return err
+	case "down":
+		done, err := m.Down(ctx, *steps)
+		if err == nil && !*dryRun {
+			fmt.Printf("%d migrations rolled back\n", len(done))
+		}
+		return err
+	case "status":
+		all, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mg := range all {
+			appliedAt := "pending"
+			if !mg.AppliedAt.IsZero() {
+				appliedAt = mg.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-32s %s\n", mg, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action: %s", action)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/pkg/features/logging"
+	"goapp/pkg/db"
+)
+
+// 解除登录失败导致的锁定，并清空失败次数
+func runUnlock(ctx context.Context, args []string) error {
+	fs := newFlagSet("unlock")
+	kind := fs.String("kind", string(stores.AttemptKindPhone), "锁定的对象：phone（含国家代码，如 08613800000000）、ip 或 client")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	switch stores.AttemptKind(*kind) {
+	case stores.AttemptKindPhone, stores.AttemptKindIp, stores.AttemptKindClient:
+	default:
+		return fmt.Errorf("unknown kind: %s", *kind)
+	}
+
+	subject := stores.AttemptSubject{Kind: stores.AttemptKind(*kind), Value: fs.Arg(0)}
+	locked, err := stores.NewLoginAttemptStore().Unlock(ctx, subject)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		fmt.Printf("%s is not locked, failed attempts cleared\n", subject)
+		return nil
+	}
+	logging.Info("管理员解除登录锁定", logging.WithData(db.JSON{"kind": subject.Kind, "value": subject.Value}))
+	fmt.Printf("%s unlocked\n", subject)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/app/features/users"
+	"goapp/internal/app/models"
+	"goapp/pkg/ids"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func runBan(ctx context.Context, args []string) error {
+	fs := newFlagSet("ban")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	userId, err := parseUID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := setUserStatus(ctx, userId, models.UserStatusBanned); err != nil {
+		return err
+	}
+	// 已登录的客户端需要重新登录，此时会被拒绝
+	n, err := stores.NewAuthStore().RevokeUserTokens(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("user banned, but revoke tokens failed: %w", err)
+	}
+	fmt.Printf("user %s banned, %d tokens revoked\n", userId, n)
+	return nil
+}
+
+func runUnban(ctx context.Context, args []string) error {
+	fs := newFlagSet("unban")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	userId, err := parseUID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := setUserStatus(ctx, userId, models.UserStatusNormal); err != nil {
+		return err
+	}
+	fmt.Printf("user %s unbanned\n", userId)
+	return nil
+}
+
+func setUserStatus(ctx context.Context, userId ids.UID, status models.UserStatus) error {
+	err := users.NewUserStore().SetStatus(ctx, userId, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user not found: %s", userId)
+	}
+	return err
+}
+
+func runRevokeTokens(ctx context.Context, args []string) error {
+	fs := newFlagSet("revoke-tokens")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	userId, err := parseUID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	n, err := stores.NewAuthStore().RevokeUserTokens(ctx, userId)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d tokens revoked for user %s\n", n, userId)
+	return nil
+}
+
+func runToken(ctx context.Context, args []string) error {
+	fs := newFlagSet("token")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	token := fs.Arg(0)
+	store := stores.NewAuthStore()
+	claims, err := store.GetAccessTokenClaims(ctx, token)
+	if errors.Is(err, redis.Nil) {
+		return errors.New("token not found or expired")
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(claims, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	if ttl, err := store.GetAccessTokenTtl(ctx, token); err == nil {
+		fmt.Printf("expires in %s (at %s)\n", ttl.Round(time.Second), time.Now().Add(ttl).Format(time.RFC3339))
+	}
+	return nil
+}
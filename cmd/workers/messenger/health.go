@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"goapp/pkg/lifecycle"
+	"net/http"
+	"time"
+)
+
+// 就绪检查，返回错误时视为未就绪
+type readyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// 健康检查及指标
+//
+//	/healthz 进程存活
+//	/readyz  依赖的数据库、缓存等可用且未开始关闭
+func newHealthServer(addr string, lc *lifecycle.Manager, metricsHandler http.Handler, checks ...readyCheck) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if lc.Stopping() {
+			http.Error(w, "stopping", http.StatusServiceUnavailable)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		for _, c := range checks {
+			if err := c.check(ctx); err != nil {
+				http.Error(w, fmt.Sprintf("%s: %v", c.name, err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte("ok"))
+	})
+	if metricsHandler != nil {
+		mux.Handle("/metrics", metricsHandler)
+	}
+	return &http.Server{Addr: addr, Handler: mux}
+}
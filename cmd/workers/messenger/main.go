@@ -1,5 +1,101 @@
 package main
 
+import (
+	"context"
+	"goapp/internal/app/global"
+	"goapp/internal/pkg"
+	"goapp/pkg/core"
+	"goapp/pkg/lifecycle"
+	"goapp/pkg/metrics"
+	"goapp/pkg/outbox"
+	"goapp/pkg/rmq"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// messenger 后台任务进程：将 outbox 中的消息发布到消息队列，执行关键词搜索任务
 func main() {
-	println("hello world")
+	env := os.Getenv("env")
+	log.Info().Msgf("messenger starting... running in [ %s ] mode", env)
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	if env == "release" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+
+	// 指标需要在各组件创建之前设置，未设置时不做统计
+	prom := metrics.NewPrometheusProvider("goapp")
+	metrics.SetDefault(prom)
+
+	ctx := context.Background()
+	global.Init(ctx) // 初始化全局变量, 失败时会 panic
+	defer global.Release()
+
+	options := []outbox.RelayOption{
+		outbox.WithPublisher(outbox.DestinationQueue, outbox.QueuePublisher(global.Queue())),
+	}
+	if addr := global.GetAppConfig().Rabbit.Addr; len(addr) > 0 {
+		client := rmq.NewClient(addr)
+		if err := client.Connect(); err != nil {
+			log.Fatal().Err(err).Msg("连接 RabbitMQ 失败")
+		}
+		defer client.Close()
+		options = append(options, outbox.WithPublisher(outbox.DestinationRmq, outbox.RmqPublisher(client, nil)))
+	}
+	relay := outbox.NewRelay(global.DB(), options...)
+
+	config := global.GetAppConfig().Messenger
+	searchTimeout := time.Duration(config.SearchTimeout) * time.Second
+	searcher := pkg.NewSiteSearcher(config.SearchUrl, searchTimeout)
+	worker, err := newSearchWorker(global.DB(), global.Queue(), searcher, config.SearchConcurrency, searchTimeout)
+	if err != nil {
+		log.Fatal().Err(err).Msg("创建搜索任务协程池失败")
+	}
+	if err := worker.Start(ctx, global.GetAppConfig().Id); err != nil {
+		log.Fatal().Err(err).Msg("订阅搜索任务失败")
+	}
+
+	// 收到退出信号后：就绪检查失败，停止消费并等待正在执行的任务，再释放资源
+	lc := global.Lifecycle()
+	lc.Register("search tasks", lifecycle.StageWorkers, 15*time.Second, worker.Stop)
+	if len(config.HealthAddr) > 0 {
+		srv := newHealthServer(config.HealthAddr, lc, prom.Handler(),
+			readyCheck{"database", global.DB().PingContext},
+			readyCheck{"cache", func(ctx context.Context) error { return global.Cache().Master().Ping(ctx).Err() }},
+		)
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("健康检查服务启动失败")
+			}
+		}()
+		lc.Register("health server", lifecycle.StageRelease, 5*time.Second, srv.Shutdown)
+	}
+
+	relayCtx, stopRelay := context.WithCancel(ctx)
+	relayDone := make(chan core.Empty)
+	lc.Register("outbox relay", lifecycle.StageWorkers, 10*time.Second, func(ctx context.Context) error {
+		stopRelay()
+		select {
+		case <-relayDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	go core.WaitSysSignal(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), global.ShutdownTimeout())
+		defer cancel()
+		if err := lc.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("关闭时出错")
+		}
+	})
+
+	go func() {
+		relay.Run(relayCtx)
+		close(relayDone)
+	}()
+	<-lc.Done()
 }
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"goapp/internal/app/models/tasks"
+	"goapp/internal/pkg"
+	"goapp/pkg/core"
+	"goapp/pkg/distribute"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+)
+
+// 消费关键词搜索任务的消费者组
+const searchGroup = "messenger"
+
+// 停止后收到的消息返回此错误，留在队列中由其它节点重新处理
+var errSearchWorkerStopped = errors.New("search worker stopped")
+
+// 执行关键词搜索任务：更新 tasks.TaskWebSearch，并在 MQTopicSearchKeywordsProgress 上报告进度
+type searchWorker struct {
+	db       *bun.DB
+	searcher pkg.Searcher
+	tasks    *distribute.Topic[pkg.SearchKeywordsMsg]
+	progress *distribute.Topic[pkg.SearchKeywordsProgressMsg]
+	pool     *ants.Pool // 限制同时执行的搜索数
+	timeout  time.Duration
+	run      func(msg pkg.SearchKeywordsMsg) error // 执行一个搜索任务，默认为 search
+
+	// 关闭超时后取消正在执行的搜索
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+
+	mu          sync.Mutex
+	stopped     bool               // 停止后不再接收新的消息
+	unsubscribe context.CancelFunc // 停止订阅
+}
+
+func newSearchWorker(db *bun.DB, queue distribute.MessageQueue, searcher pkg.Searcher, concurrency int, timeout time.Duration) (*searchWorker, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	// 阻塞模式：协程都在执行任务时，Submit 等待空闲的协程
+	pool, err := ants.NewPool(concurrency, ants.WithExpiryDuration(5*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &searchWorker{
+		db:       db,
+		searcher: searcher,
+		tasks:    pkg.SearchKeywordsTopic(queue),
+		progress: pkg.SearchKeywordsProgressTopic(queue),
+		pool:     pool,
+		timeout:  timeout,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	w.run = w.search
+	return w, nil
+}
+
+// 订阅搜索任务；每个协程对应一个消费者，使多条消息可以同时处理
+func (w *searchWorker) Start(ctx context.Context, consumerPrefix string) error {
+	ctx, unsubscribe := context.WithCancel(ctx)
+	w.mu.Lock()
+	w.unsubscribe = unsubscribe
+	w.mu.Unlock()
+	for i := range w.pool.Cap() {
+		consumer := fmt.Sprintf("%s-%d", consumerPrefix, i)
+		if err := w.tasks.Subscribe(ctx, searchGroup, consumer, w.handle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 停止订阅，并等待正在执行的搜索完成；ctx 结束时取消这些搜索，任务恢复为待处理，消息由其它节点重新处理
+//
+// 可以与队列的 Drain 同时执行：停止后仍在投递的消息直接返回错误，不再进入协程池
+func (w *searchWorker) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	w.stopped = true
+	if w.unsubscribe != nil {
+		w.unsubscribe()
+	}
+	w.mu.Unlock()
+
+	idle := make(chan core.Empty)
+	go func() {
+		w.running.Wait()
+		close(idle)
+	}()
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+		w.cancel()
+		<-idle
+	}
+	w.pool.Release()
+	return err
+}
+
+// 在协程池中执行搜索，返回错误时消息按队列的策略重试
+func (w *searchWorker) handle(ctx context.Context, msg pkg.SearchKeywordsMsg) error {
+	// 与 Stop 互斥，保证 Stop 开始等待之后不再有新的任务
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return errSearchWorkerStopped
+	}
+	w.running.Add(1)
+	w.mu.Unlock()
+
+	done := make(chan error, 1)
+	err := w.pool.Submit(func() {
+		defer w.running.Done()
+		done <- w.run(msg)
+	})
+	if err != nil {
+		w.running.Done()
+		return err
+	}
+	return <-done
+}
+
+func (w *searchWorker) search(msg pkg.SearchKeywordsMsg) error {
+	// 更新数据库及报告进度不受取消的影响
+	bg := context.Background()
+	task := &tasks.TaskWebSearch{ID: msg.TaskId}
+	err := w.db.NewSelect().Model(task).WherePK().Scan(bg)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Warn().Str("taskId", msg.TaskId).Str("traceId", msg.TraceId).Msg("搜索任务不存在，忽略")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// 重复投递的消息
+	if task.Status == tasks.TaskStatusCompleted {
+		return nil
+	}
+
+	now := time.Now()
+	task.Status = tasks.TaskStatusRunning
+	task.Progress = 0
+	task.StatusText = "正在搜索"
+	task.SearchAt = &now
+	if err := w.update(bg, task, "status", "progress", "status_text", "search_at"); err != nil {
+		return err
+	}
+	w.report(bg, task, pkg.SearcherStatusRunning)
+
+	ctx, cancel := context.WithTimeout(w.ctx, w.timeout)
+	defer cancel()
+	result, err := w.searcher.Search(ctx, msg.Keywords, func(progress float32, statusText string) {
+		task.Progress = progress
+		task.StatusText = statusText
+		if err := w.update(bg, task, "progress", "status_text"); err != nil {
+			log.Error().Err(err).Str("taskId", task.ID).Msg("更新搜索进度失败")
+		}
+		w.report(bg, task, pkg.SearcherStatusRunning)
+	})
+
+	// 关闭时被取消，交给其它节点重新处理
+	if err != nil && w.ctx.Err() != nil {
+		task.Status = tasks.TaskStatusPending
+		task.StatusText = "等待重新搜索"
+		if err := w.update(bg, task, "status", "status_text"); err != nil {
+			log.Error().Err(err).Str("taskId", task.ID).Msg("恢复搜索任务失败")
+		}
+		return w.ctx.Err()
+	}
+
+	finishAt := time.Now()
+	task.FinishAt = &finishAt
+	if err != nil {
+		task.Status = tasks.TaskStatusFailed
+		task.StatusText = err.Error()
+		if err := w.update(bg, task, "status", "status_text", "finish_at"); err != nil {
+			return err
+		}
+		w.report(bg, task, pkg.SearcherStatusError)
+		log.Error().Err(err).Str("taskId", task.ID).Str("traceId", msg.TraceId).Msg("搜索失败")
+		// 返回错误，由队列按重试策略重新投递
+		return err
+	}
+
+	task.Status = tasks.TaskStatusCompleted
+	task.Progress = 1
+	task.StatusText = "搜索完成"
+	task.Result = result
+	if err := w.update(bg, task, "status", "progress", "status_text", "result", "finish_at"); err != nil {
+		return err
+	}
+	w.report(bg, task, pkg.SearcherStatusFinish)
+	return nil
+}
+
+func (w *searchWorker) update(ctx context.Context, task *tasks.TaskWebSearch, columns ...string) error {
+	_, err := w.db.NewUpdate().Model(task).Column(append(columns, "updated_at")...).WherePK().Exec(ctx)
+	return err
+}
+
+// 进度只用于展示，发布失败时不影响任务
+func (w *searchWorker) report(ctx context.Context, task *tasks.TaskWebSearch, status pkg.SearcherStatus) {
+	msg := pkg.SearchKeywordsProgressMsg{
+		TaskId:     task.ID,
+		Progress:   task.Progress,
+		Status:     status,
+		StatusText: task.StatusText,
+	}
+	if status == pkg.SearcherStatusFinish {
+		msg.Result = task.Result
+	}
+	if err := w.progress.Publish(ctx, msg); err != nil {
+		log.Error().Err(err).Str("taskId", task.ID).Msg("发布搜索进度失败")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"goapp/internal/pkg"
+	"goapp/pkg/distribute"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestSearchWorker(t *testing.T, concurrency int, run func(w *searchWorker, msg pkg.SearchKeywordsMsg) error) (*searchWorker, *distribute.MemoryMessageQueue) {
+	t.Helper()
+	queue, err := distribute.NewMemoryMessageQueue(nil, "", 1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(queue.Close)
+	w, err := newSearchWorker(nil, queue, nil, concurrency, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.run = func(msg pkg.SearchKeywordsMsg) error { return run(w, msg) }
+	if err := w.Start(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	return w, queue
+}
+
+func TestSearchWorkerStopWhileDelivering(t *testing.T) {
+	var handled atomic.Int32
+	w, queue := newTestSearchWorker(t, 4, func(w *searchWorker, msg pkg.SearchKeywordsMsg) error {
+		time.Sleep(5 * time.Millisecond)
+		handled.Add(1)
+		return nil
+	})
+
+	// 停止与队列排空同时进行，期间仍有消息在投递
+	ctx := context.Background()
+	for i := range 200 {
+		w.tasks.Publish(ctx, pkg.SearchKeywordsMsg{TaskId: strconv.Itoa(i)})
+	}
+	for handled.Load() < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	stopCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- queue.Drain(stopCtx) }()
+	if err := w.Stop(stopCtx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+
+	// 停止后收到的消息不再执行，留在队列中
+	n := handled.Load()
+	if err := w.handle(ctx, pkg.SearchKeywordsMsg{TaskId: "late"}); !errors.Is(err, errSearchWorkerStopped) {
+		t.Fatalf("expected errSearchWorkerStopped, got: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if handled.Load() != n {
+		t.Fatalf("search ran after stop: %d -> %d", n, handled.Load())
+	}
+}
+
+func TestSearchWorkerStopCancelsRunningSearches(t *testing.T) {
+	started := make(chan struct{}, 1)
+	var cancelled atomic.Bool
+	w, _ := newTestSearchWorker(t, 1, func(w *searchWorker, msg pkg.SearchKeywordsMsg) error {
+		started <- struct{}{}
+		<-w.ctx.Done()
+		cancelled.Store(true)
+		return w.ctx.Err()
+	})
+	w.tasks.Publish(context.Background(), pkg.SearchKeywordsMsg{TaskId: "t1"})
+	<-started
+
+	// 超时后取消正在执行的搜索，并等待其返回
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := w.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+	if !cancelled.Load() {
+		t.Fatal("running search not cancelled")
+	}
+}
@@ -28,9 +28,14 @@ func (h *AuthHandler) RegisterRoutes(router *gin.RouterGroup) {
 	})
 
 	authGroup.POST("/login/prepare", h.handleLoginPrepare)
+	authGroup.POST("/login/code", h.handleSendMsgCode)
 	authGroup.POST("/login/do", h.handleLoginDo)
+	authGroup.POST("/login/password", h.handleLoginPassword)
 	authGroup.POST("/refresh", h.handleRefresh)
 	authGroup.POST("/logout", h.handleLogout)
+	authGroup.GET("/sessions", h.handleListSessions)
+	authGroup.POST("/sessions/revoke", h.handleRevokeSession)
+	authGroup.POST("/sessions/revoke-others", h.handleRevokeOtherSessions)
 }
 
 func (h *AuthHandler) handleLoginPrepare(c *gin.Context) {
@@ -43,6 +48,26 @@ func (h *AuthHandler) handleLoginPrepare(c *gin.Context) {
 	c.JSON(200, reply)
 }
 
+// 发送短信验证码
+func (h *AuthHandler) handleSendMsgCode(c *gin.Context) {
+	var req authers.SendMsgCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(200, h.NewResponseInvalidArgs(""))
+		return
+	}
+
+	// 图形验证码错误与登录失败一样计数，避免绕过登录限制来猜测验证码
+	var reply *authers.SendMsgCodeResponseDto
+	NewLoginGuard().Guard(c, req.CountryCode+req.Phone, func() {
+		reply = authers.NewMsgCodeAuther().SendMsgCode(c, &req)
+	})
+	if c.IsAborted() {
+		return
+	}
+
+	c.JSON(200, reply)
+}
+
 // 手机验证码登录
 func (h *AuthHandler) handleLoginDo(c *gin.Context) {
 	var req authers.MsgCodeLoginRequest
@@ -61,6 +86,24 @@ func (h *AuthHandler) handleLoginDo(c *gin.Context) {
 	c.JSON(200, reply)
 }
 
+// 手机号密码登录
+func (h *AuthHandler) handleLoginPassword(c *gin.Context) {
+	var req authers.PasswordLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(200, h.NewResponseInvalidArgs(""))
+		return
+	}
+
+	svr := NewAuthService()
+	svr.SetAuther(authers.NewPasswordAuther())
+	reply := svr.Authorize(c, &req)
+	if c.IsAborted() {
+		return
+	}
+
+	c.JSON(200, reply)
+}
+
 // 刷新Token
 func (h *AuthHandler) handleRefresh(c *gin.Context) {
 	svr := NewAuthService()
@@ -77,3 +120,35 @@ func (h *AuthHandler) handleLogout(c *gin.Context) {
 	svr.Logout(c)
 	c.Status(200)
 }
+
+// 当前用户已登录的客户端
+func (h *AuthHandler) handleListSessions(c *gin.Context) {
+	reply := NewSessionService().ListSessions(c)
+	if c.IsAborted() {
+		return
+	}
+	c.JSON(200, reply)
+}
+
+// 让指定的客户端退出登录
+func (h *AuthHandler) handleRevokeSession(c *gin.Context) {
+	var req RevokeSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(200, h.NewResponseInvalidArgs(""))
+		return
+	}
+	reply := NewSessionService().RevokeSession(c, &req)
+	if c.IsAborted() {
+		return
+	}
+	c.JSON(200, reply)
+}
+
+// 让当前客户端之外的所有客户端退出登录
+func (h *AuthHandler) handleRevokeOtherSessions(c *gin.Context) {
+	reply := NewSessionService().RevokeOtherSessions(c)
+	if c.IsAborted() {
+		return
+	}
+	c.JSON(200, reply)
+}
@@ -8,13 +8,16 @@ import (
 	"fmt"
 	"goapp/internal/app/features/authes/authers"
 	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/app/features/hubs/chat"
 	"goapp/internal/app/features/users"
 	"goapp/internal/app/global"
 	"goapp/internal/app/models"
 	"goapp/internal/app/shared"
 	"goapp/internal/app/shared/claims"
 	"goapp/internal/app/shared/headers"
+	"goapp/internal/pkg/features/logging"
 	"goapp/pkg/core"
+	"goapp/pkg/db"
 	"goapp/pkg/ids"
 	"net/http"
 	"strconv"
@@ -22,6 +25,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/mojocn/base64Captcha"
+	"github.com/rs/zerolog/log"
 )
 
 type AuthService struct {
@@ -90,10 +94,26 @@ func (a *AuthService) Authorize(ctx *gin.Context, req authers.AuthRequest) *Auth
 		ctx.AbortWithStatus(500)
 		return nil
 	}
+	// 按手机号、IP 及客户端限制登录失败的次数
+	phone := ""
+	if r, ok := req.(authers.PhoneRequest); ok {
+		phone = r.FullPhone()
+	}
+	guard := NewLoginGuard()
+	if !guard.Reserve(ctx, phone) {
+		return nil
+	}
 	user := a.auther.Authorize(ctx, req)
 	if ctx.IsAborted() {
+		// 只统计验证失败，不统计服务端的错误
+		if ctx.Writer.Status() == 400 {
+			guard.Failed(ctx, phone)
+		} else {
+			guard.Release(ctx, phone)
+		}
 		return nil
 	}
+	guard.Succeeded(ctx, phone)
 
 	// 该用户已被禁用
 	if user.Status == models.UserStatusBanned {
@@ -101,8 +121,8 @@ func (a *AuthService) Authorize(ctx *gin.Context, req authers.AuthRequest) *Auth
 		return nil
 	}
 
-	// 生成token, 将这些Token与该用户绑定
-	accessToken, refreshToken, err := a.GenerateTokenPair(ctx, user.ID)
+	// 生成token, 将这些Token与该用户绑定；每次登录开始一个新的令牌族
+	accessToken, refreshToken, err := a.GenerateTokenPair(ctx, user.ID, ids.NewUID().String())
 	if err != nil {
 		ctx.AbortWithError(500, err)
 		return nil
@@ -146,6 +166,7 @@ func (a *AuthService) RefreshToken(ctx *gin.Context) *AuthResponseDto {
 
 	credentials := a.authRepo.GetRefreshTokenCredential(ctx, token)
 	if credentials == nil {
+		a.detectRefreshTokenReuse(ctx, token)
 		ctx.AbortWithStatus(401) // client need re-login
 		return nil
 	}
@@ -155,14 +176,36 @@ func (a *AuthService) RefreshToken(ctx *gin.Context) *AuthResponseDto {
 		return nil
 	}
 
-	err := a.RevokeRefreshToken(ctx, token)
+	// 轮换后的令牌仍属于原令牌族，之前登录的令牌没有令牌族时开始一个新的
+	familyId := credentials.FamilyId
+	if len(familyId) == 0 {
+		familyId = ids.NewUID().String()
+	}
+	refreshTtl := time.Duration(global.AuthConfig().Jwt.RefreshTtl) * time.Minute
+	err := a.authRepo.MarkRefreshTokenRotated(ctx, token, &stores.RotatedRefreshToken{
+		FamilyId:  familyId,
+		UserId:    credentials.UserId,
+		ClientId:  credentials.ClientId,
+		RotatedAt: time.Now().UnixMilli(),
+	}, refreshTtl)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil
+	}
+	// 并发刷新同一个令牌时只有一个请求能删除成功，其它请求按重复使用处理
+	consumed, err := a.authRepo.ConsumeRefreshToken(ctx, token)
 	if err != nil {
 		ctx.AbortWithError(500, err)
 		return nil
 	}
+	if !consumed {
+		a.detectRefreshTokenReuse(ctx, token)
+		ctx.AbortWithStatus(401)
+		return nil
+	}
 
 	// 轮换 clientid 与 refresh token
-	accessToken, refreshToken, err := a.GenerateTokenPair(ctx, credentials.UserId)
+	accessToken, refreshToken, err := a.GenerateTokenPair(ctx, credentials.UserId, familyId)
 	if err != nil {
 		ctx.AbortWithStatus(401) // token 已经删除，此时只能重新登录
 		return nil
@@ -177,6 +220,49 @@ func (a *AuthService) RefreshToken(ctx *gin.Context) *AuthResponseDto {
 	return &AuthResponseDto{Code: shared.RespCodeSucceed, Data: &AuthResponse{accessToken, refreshToken}}
 }
 
+// 已轮换的刷新令牌被再次使用，说明令牌可能已被盗用：吊销整个令牌族及其访问令牌，记录安全日志，并按配置通知用户。
+// 轮换后很短时间内的重复使用通常是客户端并发刷新，不处理
+func (a *AuthService) detectRefreshTokenReuse(ctx *gin.Context, token string) {
+	jwtConfig := global.AuthConfig().Jwt
+	grace := time.Duration(jwtConfig.RefreshReuseGrace) * time.Second
+	rotated, revoked, err := a.authRepo.RevokeReusedRefreshToken(ctx, token, grace)
+	if err != nil {
+		log.Error().Err(err).Msg("吊销重复使用的刷新令牌所在的令牌族出错")
+	}
+	if rotated == nil {
+		return
+	}
+	logging.Warn("检测到已轮换的刷新令牌被重复使用，已吊销该令牌族", logging.WithData(db.JSON{
+		"userId":    rotated.UserId,
+		"clientId":  rotated.ClientId,
+		"familyId":  rotated.FamilyId,
+		"rotatedAt": rotated.RotatedAt,
+		"revoked":   revoked,
+		"ip":        ctx.ClientIP(),
+		"reqClient": headers.GetClientId(ctx),
+		"userAgent": headers.GetUserAgent(ctx),
+	}))
+	// 已经吊销过时不再重复处理
+	if revoked == 0 {
+		return
+	}
+	chat.CloseClientLines(rotated.UserId, rotated.ClientId)
+	if !jwtConfig.NotifyTokenReuse {
+		return
+	}
+	if h := chat.GetChatHub(); h != nil && h.Hub != nil {
+		err := h.NotifySecurityAlert(rotated.UserId.String(), &chat.ChatSecurityAlert{
+			Kind:     chat.ChatSecurityAlertTokenReuse,
+			ClientId: rotated.ClientId,
+			Ip:       ctx.ClientIP(),
+			Time:     time.Now().UnixMilli(),
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("推送安全提醒出错")
+		}
+	}
+}
+
 func (a *AuthService) Logout(ctx *gin.Context) {
 	accessToken := headers.GetAccessToken(ctx)
 	refreshToken := headers.GetRefreshToken(ctx)
@@ -184,8 +270,11 @@ func (a *AuthService) Logout(ctx *gin.Context) {
 	a.RevokeAccessToken(ctx, accessToken)
 	a.RevokeRefreshToken(ctx, refreshToken)
 
-	// 关闭所有的 hub
-	// TODO: 可能不需要，客户端主动关闭也行
+	// 删除当前客户端的会话，并关闭其在 hub 中的连接
+	if cc := claims.GetClaims(ctx); cc != nil {
+		a.authRepo.RevokeSession(ctx, cc.UserId, cc.ClientId)
+		chat.CloseClientLines(cc.UserId, cc.ClientId)
+	}
 
 	// 删除 cookie
 	jwtConfig := global.AuthConfig().Jwt
@@ -229,10 +318,11 @@ func (a *AuthService) RevokeRefreshToken(ctx context.Context, refreshToken strin
 	return a.authRepo.DeleteRefreshToken(ctx, refreshToken) // 调用Repository层的方法
 }
 
-func (a *AuthService) GenerateTokenPair(ctx *gin.Context, userID ids.UID) (string, string, error) {
+// 生成属于令牌族 familyId 的访问令牌和刷新令牌
+func (a *AuthService) GenerateTokenPair(ctx *gin.Context, userID ids.UID, familyId string) (string, string, error) {
 	clientId := headers.GetClientId(ctx)
 	platform := headers.GetPlatform(ctx)
-	accessToken, claims, err := a.GenerateAccessToken(ctx, userID, clientId, platform)
+	accessToken, claims, err := a.GenerateAccessToken(ctx, userID, clientId, platform, familyId)
 	if err != nil {
 		return "", "", err
 	}
@@ -254,7 +344,7 @@ func (a *AuthService) CreateToken() string {
 	return hex.EncodeToString(b)
 }
 
-func (a *AuthService) GenerateAccessToken(ctx *gin.Context, userID ids.UID, clientId string, platform core.Platform) (string, *claims.AuthorizedClaims, error) {
+func (a *AuthService) GenerateAccessToken(ctx *gin.Context, userID ids.UID, clientId string, platform core.Platform, familyId string) (string, *claims.AuthorizedClaims, error) {
 	if len(clientId) == 0 || platform == core.Unspecify {
 		return "", nil, errors.New("invalid args")
 	}
@@ -266,6 +356,7 @@ func (a *AuthService) GenerateAccessToken(ctx *gin.Context, userID ids.UID, clie
 		ClientId:        clientId,
 		UserAgentHashed: headers.GetUserAgentHashed(ctx),
 		Ip:              ctx.ClientIP(),
+		FamilyId:        familyId,
 	}
 	err := a.authRepo.SaveAccessToken(ctx, token, time.Duration(global.AuthConfig().Jwt.AccessTtl)*time.Minute, &claims)
 	if err != nil {
@@ -8,6 +8,11 @@ import (
 
 type AuthRequest any
 
+// 包含手机号的登录请求，用于按手机号统计登录失败次数
+type PhoneRequest interface {
+	FullPhone() string
+}
+
 type Auther interface {
 	Authorize(ctx *gin.Context, req AuthRequest) *models.User
 }
@@ -1,41 +1,147 @@
 package authers
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"goapp/internal/app/features/authes/stores"
 	"goapp/internal/app/features/users"
+	"goapp/internal/app/global"
 	"goapp/internal/app/models"
+	"goapp/internal/app/shared"
 	"goapp/internal/app/shared/headers"
 	"goapp/pkg/strs"
-	"math/rand"
+	"goapp/pkg/third"
+	"goapp/pkg/times"
+	"math/big"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 )
 
+const (
+	smsProviderAliyun = "aliyun"
+	smsProviderLog    = "log"
+
+	defaultSmsCodeTtl       = 5 * time.Minute
+	defaultSmsPhoneCooldown = time.Minute
+	defaultSmsMaxAttempts   = 5
+)
+
+// 按配置创建短信发送器，未配置时只输出到控制台
+var smsSender = sync.OnceValue(func() third.SmsSender {
+	c := global.AuthConfig().Sms
+	switch c.Provider {
+	case smsProviderAliyun:
+		return third.NewAliyunSmsSender(c.AccessKeyId, c.AccessKeySecret, c.SignName, c.TemplateCode)
+	case smsProviderLog, "":
+	default:
+		log.Warn().Str("provider", c.Provider).Msg("未知的短信服务商，验证码只输出到控制台")
+	}
+	return third.NewLogSmsSender()
+})
+
 type MsgCodeAuther struct {
 	authRepo *stores.AuthStore
 	userRepo *users.UserStore
+	sender   third.SmsSender
 }
 
 func NewMsgCodeAuther() *MsgCodeAuther {
 	return &MsgCodeAuther{
 		authRepo: stores.NewAuthStore(),
 		userRepo: users.NewUserStore(),
+		sender:   smsSender(),
 	}
 }
 
 type MsgCodeLoginRequest struct {
 	CountryCode string `json:"countryCode" binding:"required"`
 	Phone       string `json:"phone" binding:"required"`
-	ImgCode     string `json:"imgCode" binding:"required"`
 	MsgCode     string `json:"msgCode" binding:"required"`
 	CsrfToken   string `json:"csrfToken" binding:"required"`
 }
 
-// 生成随机验证码
-func (a *MsgCodeAuther) GenerateMsgCode() string {
-	code := rand.Intn(9000) + 1000 // 生成4位数验证码
-	return fmt.Sprintf("%04d", code)
+func (r *MsgCodeLoginRequest) FullPhone() string {
+	return r.CountryCode + r.Phone
+}
+
+type SendMsgCodeRequest struct {
+	CountryCode string `json:"countryCode" binding:"required"`
+	Phone       string `json:"phone" binding:"required"`
+	ImgCode     string `json:"imgCode" binding:"required"`
+	CsrfToken   string `json:"csrfToken" binding:"required"`
+}
+
+type SendMsgCodeResponse struct {
+	RetryAfter int64 `json:"retryAfter"` // in second，多久之后可以重新发送
+}
+
+type SendMsgCodeResponseDto = shared.ResponseDto[*SendMsgCodeResponse]
+
+// 生成6位随机验证码
+func (a *MsgCodeAuther) GenerateMsgCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// 发送短信验证码
+//
+// 需要与登录相同的 csrf token 及图形验证码，验证后图形验证码作废，重新发送时需要重新获取；
+// 同一手机号及 IP 的发送间隔、每天的发送次数受配置限制
+func (a *MsgCodeAuther) SendMsgCode(ctx *gin.Context, req *SendMsgCodeRequest) *SendMsgCodeResponseDto {
+	csrfToken := headers.GetCsrfToken(ctx)
+	if csrfToken != req.CsrfToken {
+		ctx.AbortWithStatus(400)
+		return nil
+	}
+	if !strs.IsCountryCode(req.CountryCode) || !strs.IsCellPhone(req.Phone) {
+		return &SendMsgCodeResponseDto{Code: shared.RespCodeInvalidPhone}
+	}
+	valid, err := a.authRepo.VerifyCaptcha(ctx, csrfToken, req.ImgCode, true)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil
+	}
+	if !valid {
+		ctx.AbortWithStatus(400)
+		return nil
+	}
+
+	c := global.AuthConfig().Sms
+	phoneCooldown := times.SecondsOr(c.PhoneCooldown, defaultSmsPhoneCooldown)
+	fullphone := req.CountryCode + req.Phone
+	wait, err := a.authRepo.AcquireSmsSend(ctx, fullphone, ctx.ClientIP(), phoneCooldown, time.Duration(c.IpCooldown)*time.Second, c.PhoneDailyLimit, c.IpDailyLimit)
+	if errors.Is(err, stores.ErrSmsDailyLimit) {
+		return &SendMsgCodeResponseDto{Code: shared.RespCodeTooFrequent, Msg: "daily limit exceeded"}
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil
+	}
+	if wait > 0 {
+		return &SendMsgCodeResponseDto{Code: shared.RespCodeTooFrequent, Data: &SendMsgCodeResponse{RetryAfter: times.CeilSeconds(wait)}}
+	}
+
+	code, err := a.GenerateMsgCode()
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil
+	}
+	if err := a.authRepo.SaveSmsCode(ctx, fullphone, code, times.SecondsOr(c.CodeTtl, defaultSmsCodeTtl)); err != nil {
+		ctx.AbortWithError(500, err)
+		return nil
+	}
+	if err := a.sender.SendCode(ctx, req.CountryCode, req.Phone, code); err != nil {
+		log.Error().Err(err).Str("phone", strs.MaskPhone(req.Phone)).Msg("发送短信验证码失败")
+		return &SendMsgCodeResponseDto{Code: shared.RespCodeFailed, Msg: "send failed"}
+	}
+	return &SendMsgCodeResponseDto{Code: shared.RespCodeSucceed, Data: &SendMsgCodeResponse{RetryAfter: times.CeilSeconds(phoneCooldown)}}
 }
 
 func (a *MsgCodeAuther) Authorize(ctx *gin.Context, r AuthRequest) *models.User {
@@ -53,26 +159,24 @@ func (a *MsgCodeAuther) Authorize(ctx *gin.Context, r AuthRequest) *models.User
 		ctx.AbortWithStatus(400)
 		return nil
 	}
-	// 从缓存中取图形验证码
-	imgCode, err := a.authRepo.GetCsrfToken(ctx, csrfToken, true)
+	// 图形验证码在发送短信验证码时已经验证并作废，这里只验证短信验证码，验证成功后作废
+	fullphone := req.CountryCode + req.Phone
+	maxAttempts := global.AuthConfig().Sms.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultSmsMaxAttempts
+	}
+	valid, err := a.authRepo.VerifySmsCode(ctx, fullphone, req.MsgCode, maxAttempts)
 	if err != nil {
 		ctx.AbortWithError(500, err)
 		return nil
 	}
-	// 验证验证码
-	if req.ImgCode != imgCode {
-		ctx.AbortWithStatus(400)
-		return nil
-	}
-	// 验证安全码
-	if req.MsgCode != "8888" {
+	if !valid {
 		ctx.AbortWithStatus(400)
 		return nil
 	}
 
 	// 通过手机号注册或获取用户信息
 	ip := ctx.ClientIP()
-	fullphone := req.CountryCode + req.Phone
 	user, err := a.userRepo.Upsert(ctx, fullphone, ip)
 	if err != nil {
 		ctx.AbortWithError(500, err)
@@ -1,16 +1,29 @@
 package authers
 
 import (
+	"database/sql"
+	"errors"
+	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/app/features/users"
 	"goapp/internal/app/models"
+	"goapp/internal/app/shared/crypto"
+	"goapp/internal/app/shared/headers"
+	"goapp/pkg/strs"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 )
 
 type PasswordAuther struct {
+	authRepo *stores.AuthStore
+	userRepo *users.UserStore
 }
 
 func NewPasswordAuther() *PasswordAuther {
-	return &PasswordAuther{}
+	return &PasswordAuther{
+		authRepo: stores.NewAuthStore(),
+		userRepo: users.NewUserStore(),
+	}
 }
 
 type PasswordLoginRequest struct {
@@ -21,6 +34,67 @@ type PasswordLoginRequest struct {
 	CsrfToken   string `json:"csrfToken" binding:"required"`
 }
 
+func (r *PasswordLoginRequest) FullPhone() string {
+	return r.CountryCode + r.Phone
+}
+
 func (a *PasswordAuther) Authorize(ctx *gin.Context, r AuthRequest) *models.User {
-	return nil
+	req, ok := r.(*PasswordLoginRequest)
+	if !ok {
+		ctx.AbortWithStatus(400)
+		return nil
+	}
+	csrfToken := headers.GetCsrfToken(ctx)
+	if csrfToken != req.CsrfToken {
+		ctx.AbortWithStatus(400)
+		return nil
+	}
+	if !strs.IsCountryCode(req.CountryCode) || !strs.IsCellPhone(req.Phone) {
+		ctx.AbortWithStatus(400)
+		return nil
+	}
+	// 验证图形验证码，验证码只能使用一次
+	valid, err := a.authRepo.VerifyCaptcha(ctx, csrfToken, req.ImgCode, true)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil
+	}
+	if !valid {
+		ctx.AbortWithStatus(400)
+		return nil
+	}
+
+	fullphone := req.CountryCode + req.Phone
+	user, err := a.userRepo.GetByPhone(ctx, fullphone)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		ctx.AbortWithError(500, err)
+		return nil
+	}
+	// 用户不存在或未设置密码时也验证一次，不通过响应时间暴露手机号是否注册
+	hash := crypto.DummyPasswordHash()
+	if user != nil && len(user.Password) > 0 {
+		hash = user.Password
+	}
+	hasher := crypto.PasswordHasher()
+	matched, rehash, err := hasher.Verify(req.Password, hash)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil
+	}
+	if !matched || user == nil || len(user.Password) == 0 {
+		ctx.AbortWithStatus(400)
+		return nil
+	}
+
+	// 哈希的算法或参数与当前配置不同，用明文密码重新生成；失败不影响本次登录
+	if rehash {
+		if newHash, err := hasher.Hash(req.Password); err != nil {
+			log.Error().Err(err).Str("userId", user.ID.String()).Msg("重新生成密码哈希失败")
+		} else if err := a.userRepo.UpdatePassword(ctx, user.ID, user.Password, newHash); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Str("userId", user.ID.String()).Msg("保存重新生成的密码哈希失败")
+		}
+	}
+
+	a.userRepo.UpsertLatestIP(ctx, user.ID, ctx.ClientIP())
+	return user
 }
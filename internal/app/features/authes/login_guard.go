@@ -0,0 +1,151 @@
+package authes
+
+import (
+	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/app/global"
+	"goapp/internal/app/shared/headers"
+	"goapp/internal/pkg/features/logging"
+	"goapp/pkg/db"
+	"goapp/pkg/times"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAttemptWindow       = time.Hour
+	defaultFreeAttempts        = 3
+	defaultAttemptBaseDelay    = time.Second
+	defaultAttemptMaxDelay     = time.Minute
+	defaultLockThreshold       = 10
+	defaultIpLockThreshold     = 50
+	defaultAttemptLockDuration = 15 * time.Minute
+)
+
+// 限制登录失败：失败次数较多时要求等待，且等待时间逐次翻倍，达到阈值后临时锁定
+type LoginGuard struct {
+	store           *stores.LoginAttemptStore
+	window          time.Duration
+	freeAttempts    int64
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	lockThreshold   int64
+	ipLockThreshold int64
+	lockFor         time.Duration
+}
+
+func NewLoginGuard() *LoginGuard {
+	c := global.AuthConfig().LoginAttempt
+	g := &LoginGuard{
+		store:           stores.NewLoginAttemptStore(),
+		window:          times.SecondsOr(c.Window, defaultAttemptWindow),
+		freeAttempts:    c.FreeAttempts,
+		baseDelay:       times.SecondsOr(c.BaseDelay, defaultAttemptBaseDelay),
+		maxDelay:        times.SecondsOr(c.MaxDelay, defaultAttemptMaxDelay),
+		lockThreshold:   c.LockThreshold,
+		ipLockThreshold: c.IpLockThreshold,
+		lockFor:         times.SecondsOr(c.LockDuration, defaultAttemptLockDuration),
+	}
+	if g.freeAttempts <= 0 {
+		g.freeAttempts = defaultFreeAttempts
+	}
+	if g.lockThreshold <= 0 {
+		g.lockThreshold = defaultLockThreshold
+	}
+	if g.ipLockThreshold <= 0 {
+		g.ipLockThreshold = defaultIpLockThreshold
+	}
+	return g
+}
+
+// 统计对象：手机号（可以为空）、IP 及客户端
+func (g *LoginGuard) subjects(ctx *gin.Context, phone string) []stores.AttemptSubject {
+	subjects := []stores.AttemptSubject{{Kind: stores.AttemptKindIp, Value: ctx.ClientIP()}}
+	if len(phone) > 0 {
+		subjects = append(subjects, stores.AttemptSubject{Kind: stores.AttemptKindPhone, Value: phone})
+	}
+	if clientId := headers.GetClientId(ctx); len(clientId) > 0 {
+		subjects = append(subjects, stores.AttemptSubject{Kind: stores.AttemptKindClient, Value: clientId})
+	}
+	return subjects
+}
+
+// 登录前预留一次尝试；处于锁定或等待中时以 429 中止请求，并通过 Retry-After 告知需要等待的秒数。
+// 预留成功后必须调用 Failed、Succeeded 或 Release 之一
+func (g *LoginGuard) Reserve(ctx *gin.Context, phone string) bool {
+	wait, err := g.store.Reserve(ctx, g.window, g.freeAttempts, g.baseDelay, g.maxDelay, g.subjects(ctx, phone)...)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return false
+	}
+	if wait <= 0 {
+		return true
+	}
+	ctx.Header("Retry-After", strconv.FormatInt(times.CeilSeconds(wait), 10))
+	ctx.AbortWithStatus(429)
+	return false
+}
+
+// 预留的尝试失败，达到阈值时锁定并记录日志
+func (g *LoginGuard) Failed(ctx *gin.Context, phone string) {
+	for _, subject := range g.subjects(ctx, phone) {
+		threshold := g.lockThreshold
+		if subject.Kind == stores.AttemptKindIp {
+			threshold = g.ipLockThreshold
+		}
+		fails, locked, err := g.store.RecordFailure(ctx, subject, threshold, g.lockFor)
+		if err != nil {
+			log.Error().Err(err).Str("subject", subject.String()).Msg("记录登录失败次数出错")
+			continue
+		}
+		if locked {
+			logging.Warn("登录失败次数过多，已临时锁定", logging.WithData(db.JSON{
+				"kind":        subject.Kind,
+				"value":       subject.Value,
+				"fails":       fails,
+				"lockSeconds": int64(g.lockFor / time.Second),
+				"ip":          ctx.ClientIP(),
+				"clientId":    headers.GetClientId(ctx),
+				"path":        ctx.Request.URL.Path,
+			}))
+		}
+	}
+}
+
+// 撤销预留的尝试，用于服务端出错或不需要计入失败的请求
+func (g *LoginGuard) Release(ctx *gin.Context, phone string) {
+	if err := g.store.Release(ctx, g.subjects(ctx, phone)...); err != nil {
+		log.Error().Err(err).Msg("撤销登录尝试出错")
+	}
+}
+
+// 登录成功后清空手机号及客户端的失败次数；IP 可能被多人共用，不清空，避免攻击者登录自己的账号来重置，只撤销本次预留
+func (g *LoginGuard) Succeeded(ctx *gin.Context, phone string) {
+	var subjects []stores.AttemptSubject
+	for _, subject := range g.subjects(ctx, phone) {
+		if subject.Kind != stores.AttemptKindIp {
+			subjects = append(subjects, subject)
+		}
+	}
+	if err := g.store.Reset(ctx, subjects...); err != nil {
+		log.Error().Err(err).Msg("清空登录失败次数出错")
+	}
+	if err := g.store.Release(ctx, stores.AttemptSubject{Kind: stores.AttemptKindIp, Value: ctx.ClientIP()}); err != nil {
+		log.Error().Err(err).Msg("撤销登录尝试出错")
+	}
+}
+
+// 在预留的尝试中执行 fn：请求以 400 中止时计为失败，其它情况撤销预留；用于发送验证码等不算登录成功的操作
+func (g *LoginGuard) Guard(ctx *gin.Context, phone string, fn func()) {
+	if !g.Reserve(ctx, phone) {
+		return
+	}
+	fn()
+	if ctx.IsAborted() && ctx.Writer.Status() == 400 {
+		g.Failed(ctx, phone)
+		return
+	}
+	g.Release(ctx, phone)
+}
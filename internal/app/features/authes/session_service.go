@@ -0,0 +1,131 @@
+package authes
+
+import (
+	"context"
+	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/app/features/hubs/chat"
+	"goapp/internal/app/shared"
+	"goapp/internal/app/shared/claims"
+	"goapp/pkg/core"
+	"goapp/pkg/ids"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 会话的最后活跃时间最多每分钟更新一次
+const sessionTouchThrottle = time.Minute
+
+type SessionService struct {
+	authRepo *stores.AuthStore
+}
+
+func NewSessionService() *SessionService {
+	return &SessionService{authRepo: stores.NewAuthStore()}
+}
+
+type SessionResponse struct {
+	ClientId   string        `json:"clientId"`
+	Platform   core.Platform `json:"platform"`
+	UserAgent  string        `json:"userAgent"`
+	Ip         string        `json:"ip"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	LastSeenAt time.Time     `json:"lastSeenAt"`
+	Current    bool          `json:"current"` // 是否为发起请求的客户端
+}
+
+type ListSessionsResponseDto = shared.ResponseDto[[]*SessionResponse]
+
+type RevokeSessionRequest struct {
+	ClientId string `json:"clientId" binding:"required"`
+}
+
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+type RevokeSessionsResponseDto = shared.ResponseDto[*RevokeSessionsResponse]
+
+// 当前用户已登录的客户端，最近活跃的在前
+func (s *SessionService) ListSessions(c *gin.Context) *ListSessionsResponseDto {
+	cc := claims.GetClaims(c)
+	if cc == nil {
+		c.AbortWithStatus(401)
+		return nil
+	}
+	sessions, err := s.authRepo.ListSessions(c, cc.UserId)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	slices.SortFunc(sessions, func(a, b *stores.Session) int { return b.LastSeenAt.Compare(a.LastSeenAt) })
+
+	data := make([]*SessionResponse, len(sessions))
+	for i, session := range sessions {
+		data[i] = &SessionResponse{
+			ClientId:   session.ClientId,
+			Platform:   session.Platform,
+			UserAgent:  session.UserAgent,
+			Ip:         session.Ip,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ClientId == cc.ClientId,
+		}
+	}
+	return &ListSessionsResponseDto{Code: shared.RespCodeSucceed, Data: data}
+}
+
+// 吊销当前用户的一个会话，该客户端需要重新登录
+func (s *SessionService) RevokeSession(c *gin.Context, req *RevokeSessionRequest) *RevokeSessionsResponseDto {
+	cc := claims.GetClaims(c)
+	if cc == nil {
+		c.AbortWithStatus(401)
+		return nil
+	}
+	n, err := s.revoke(c, cc.UserId, req.ClientId)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	return &RevokeSessionsResponseDto{Code: shared.RespCodeSucceed, Data: &RevokeSessionsResponse{Revoked: n}}
+}
+
+// 吊销当前用户除发起请求的客户端之外的所有会话
+func (s *SessionService) RevokeOtherSessions(c *gin.Context) *RevokeSessionsResponseDto {
+	cc := claims.GetClaims(c)
+	if cc == nil {
+		c.AbortWithStatus(401)
+		return nil
+	}
+	// 同时吊销不属于任何会话的令牌，比如没有 clientId 的客户端登录时签发的令牌
+	revoked, err := s.authRepo.RevokeOtherSessions(c, cc.UserId, cc.ClientId)
+	chat.CloseClientLines(cc.UserId, revoked...)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	return &RevokeSessionsResponseDto{Code: shared.RespCodeSucceed, Data: &RevokeSessionsResponse{Revoked: len(revoked)}}
+}
+
+// 更新会话的最后活跃时间及 ip
+func (s *SessionService) Touch(c *gin.Context, cc *claims.AuthorizedClaims) {
+	// 只影响会话列表中显示的时间，失败时忽略
+	s.authRepo.TouchSession(c, cc.UserId, cc.ClientId, c.ClientIP(), sessionTouchThrottle)
+}
+
+// 吊销会话并关闭这些客户端在 hub 中的连接（包括其它节点上的连接），返回吊销的会话数
+func (s *SessionService) revoke(ctx context.Context, userId ids.UID, clientIds ...string) (int, error) {
+	n := 0
+	for _, clientId := range clientIds {
+		ok, err := s.authRepo.RevokeSession(ctx, userId, clientId)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	chat.CloseClientLines(userId, clientIds...)
+	return n, nil
+}
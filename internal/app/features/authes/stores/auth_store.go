@@ -2,11 +2,16 @@ package stores
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goapp/internal/app/global"
 	"goapp/internal/app/shared/claims"
 	"goapp/pkg/cache"
+	"goapp/pkg/ids"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -31,6 +36,11 @@ func NewAuthStore() *AuthStore {
 	return &AuthStore{cache: global.Cache()}
 }
 
+// 使用指定的缓存，便于测试
+func NewAuthStoreWithCache(c *cache.Cache) *AuthStore {
+	return &AuthStore{cache: c}
+}
+
 func (a *AuthStore) SaveCsrfToken(ctx context.Context, token, val string, expire time.Duration) error {
 	_, err := a.cache.Set(ctx, fmt.Sprintf("csrf_token:%s", token), val, expire)
 	return err
@@ -51,6 +61,15 @@ func (a *AuthStore) GetCsrfToken(ctx context.Context, token string, del bool) (s
 	}
 }
 
+// 验证 csrf token 对应的图形验证码；del 为 true 时无论是否正确，验证码都作废
+func (a *AuthStore) VerifyCaptcha(ctx context.Context, csrfToken, imgCode string, del bool) (bool, error) {
+	answer, err := a.GetCsrfToken(ctx, csrfToken, del)
+	if err != nil {
+		return false, err
+	}
+	return len(answer) > 0 && subtle.ConstantTimeCompare([]byte(answer), []byte(imgCode)) == 1, nil
+}
+
 func (a *AuthStore) SaveHandledRequest(ctx context.Context, requestId string, expireAfter time.Duration) (bool, error) {
 	exists, err := a.cache.SetNX(ctx, "handled_requests:"+requestId, "1", expireAfter)
 	if err != nil {
@@ -67,12 +86,14 @@ func (a *AuthStore) SaveAccessToken(ctx context.Context, token string, ttl time.
 	}
 
 	_, err = a.cache.Set(ctx, key, val, ttl)
-	return err
+	if err != nil {
+		return err
+	}
+	return a.indexUserToken(ctx, claims, TokenTypeAccess, token, ttl)
 }
 
 func (a *AuthStore) DeleteAccessToken(ctx context.Context, token string) error {
-	key := fmt.Sprintf("access_token:%s", token)
-	_, err := a.cache.KeyDel(ctx, key)
+	_, err := a.deleteToken(ctx, TokenTypeAccess, token)
 	return err
 }
 
@@ -103,15 +124,63 @@ func (a *AuthStore) SaveRefreshToken(ctx context.Context, token string, credendi
 	}
 
 	_, err = a.cache.Set(ctx, key, val, expire)
-	return err
+	if err != nil {
+		return err
+	}
+	if err := a.indexUserToken(ctx, credendials, TokenTypeRefresh, token, expire); err != nil {
+		return err
+	}
+	return a.saveSession(ctx, credendials, expire)
 }
 
 func (a *AuthStore) DeleteRefreshToken(ctx context.Context, token string) error {
-	key := fmt.Sprintf("refresh_token:%s", token)
-	_, err := a.cache.KeyDel(ctx, key)
+	_, err := a.deleteToken(ctx, TokenTypeRefresh, token)
 	return err
 }
 
+func tokenKey(tokenType TokenType, token string) string {
+	if tokenType == TokenTypeRefresh {
+		return fmt.Sprintf("refresh_token:%s", token)
+	}
+	return fmt.Sprintf("access_token:%s", token)
+}
+
+// 删除令牌，并将其从用户、会话及令牌族的令牌索引中移除；返回令牌是否存在
+func (a *AuthStore) deleteToken(ctx context.Context, tokenType TokenType, token string) (bool, error) {
+	val, err := a.cache.GetDel(ctx, tokenKey(tokenType, token))
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var cc claims.AuthorizedClaims
+	if json.Unmarshal([]byte(val), &cc) != nil {
+		return true, nil
+	}
+	member := tokenMember(tokenType, token)
+	_, err = a.cache.Master().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, userTokensKey(cc.UserId), member)
+		if len(cc.ClientId) > 0 {
+			pipe.SRem(ctx, sessionTokensKey(cc.UserId, cc.ClientId), member)
+		}
+		if len(cc.FamilyId) > 0 {
+			pipe.SRem(ctx, tokenFamilyKey(cc.FamilyId), member)
+		}
+		return nil
+	})
+	return true, err
+}
+
+// 按令牌索引的成员删除令牌
+func (a *AuthStore) deleteTokenMember(ctx context.Context, member string) (bool, error) {
+	tokenType, token, ok := parseTokenMember(member)
+	if !ok {
+		return false, nil
+	}
+	return a.deleteToken(ctx, tokenType, token)
+}
+
 func (a *AuthStore) GetRefreshTokenCredential(ctx context.Context, token string) *claims.AuthorizedClaims {
 	key := fmt.Sprintf("refresh_token:%s", token)
 	jsonStr, err := a.cache.Get(ctx, key)
@@ -128,6 +197,196 @@ func (a *AuthStore) GetRefreshTokenCredential(ctx context.Context, token string)
 	return &dto
 }
 
-func (a *AuthStore) SaveSMSCode(ctx context.Context, phone string, code string) error {
-	return nil
+// 获取访问令牌剩余的有效期，令牌不存在时返回 redis.Nil
+func (a *AuthStore) GetAccessTokenTtl(ctx context.Context, token string) (time.Duration, error) {
+	ttl, err := a.cache.Master().TTL(ctx, fmt.Sprintf("access_token:%s", token)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, redis.Nil
+	}
+	return ttl, nil
+}
+
+func userTokensKey(userId ids.UID) string {
+	return fmt.Sprintf("user_tokens:%s", userId)
+}
+
+// 令牌索引的成员
+func tokenMember(tokenType TokenType, token string) string {
+	return fmt.Sprintf("%d:%s", tokenType, token)
+}
+
+func parseTokenMember(member string) (TokenType, string, bool) {
+	tokenType, token, ok := strings.Cut(member, ":")
+	if !ok {
+		return 0, "", false
+	}
+	switch tokenType {
+	case strconv.Itoa(int(TokenTypeAccess)):
+		return TokenTypeAccess, token, true
+	case strconv.Itoa(int(TokenTypeRefresh)):
+		return TokenTypeRefresh, token, true
+	}
+	return 0, "", false
+}
+
+// 记录用户、会话及令牌族持有的令牌，用于吊销用户、会话或令牌族所有的令牌；索引的有效期与其中最晚过期的令牌一致
+func (a *AuthStore) indexUserToken(ctx context.Context, cc *claims.AuthorizedClaims, tokenType TokenType, token string, ttl time.Duration) error {
+	member := tokenMember(tokenType, token)
+	keys := []string{userTokensKey(cc.UserId)}
+	if len(cc.ClientId) > 0 {
+		keys = append(keys, sessionTokensKey(cc.UserId, cc.ClientId))
+	}
+	if len(cc.FamilyId) > 0 {
+		keys = append(keys, tokenFamilyKey(cc.FamilyId))
+	}
+	_, err := a.cache.Master().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.SAdd(ctx, key, member)
+			pipe.ExpireNX(ctx, key, ttl)
+			pipe.ExpireGT(ctx, key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// 令牌索引的成员对应的令牌 key
+func tokenKeys(members []string) []string {
+	keys := make([]string, 0, len(members))
+	for _, m := range members {
+		if tokenType, token, ok := parseTokenMember(m); ok {
+			keys = append(keys, tokenKey(tokenType, token))
+		}
+	}
+	return keys
+}
+
+// 吊销用户所有的访问令牌和刷新令牌并删除所有会话，返回吊销的令牌数量；用户需要重新登录
+func (a *AuthStore) RevokeUserTokens(ctx context.Context, userId ids.UID) (int, error) {
+	key := userTokensKey(userId)
+	members, err := a.cache.SMembers(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	keys := append(tokenKeys(members), key)
+	n, err := a.cache.KeyDel(ctx, keys...)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.deleteUserSessions(ctx, userId); err != nil {
+		return 0, err
+	}
+	// 不计索引本身
+	return int(max(n-1, 0)), nil
+}
+
+var ErrSmsDailyLimit = errors.New("sms daily limit exceeded")
+
+var (
+	// KEYS: 手机号冷却, IP 冷却, 手机号当日计数, IP 当日计数；ARGV: 手机号冷却毫秒, IP 冷却毫秒, 手机号每日上限, IP 每日上限, 计数的过期秒数
+	// 返回 0 表示可以发送，-1 表示超过每日上限，其余为还需等待的毫秒数
+	luaAcquireSmsSend = redis.NewScript(`
+	local wait = redis.call('PTTL', KEYS[1])
+	if wait > 0 then return wait end
+	wait = redis.call('PTTL', KEYS[2])
+	if wait > 0 then return wait end
+	for i = 3, 4 do
+		local limit = tonumber(ARGV[i])
+		if limit > 0 and tonumber(redis.call('GET', KEYS[i]) or '0') >= limit then return -1 end
+	end
+	if tonumber(ARGV[1]) > 0 then redis.call('SET', KEYS[1], '1', 'PX', ARGV[1]) end
+	if tonumber(ARGV[2]) > 0 then redis.call('SET', KEYS[2], '1', 'PX', ARGV[2]) end
+	for i = 3, 4 do
+		redis.call('INCR', KEYS[i])
+		redis.call('EXPIRE', KEYS[i], ARGV[5])
+	end
+	return 0
+	`)
+	// 取出验证码并增加验证次数，达到上限时删除；返回 {验证码, 是否已删除}
+	luaTakeSmsCode = redis.NewScript(`
+	local code = redis.call('HGET', KEYS[1], 'code')
+	if not code then return false end
+	local n = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+	if n >= tonumber(ARGV[1]) then
+		redis.call('DEL', KEYS[1])
+		return {code, 1}
+	end
+	return {code, 0}
+	`)
+	// 验证码未被替换时删除，保证只有一个请求能使用
+	luaConsumeSmsCode = redis.NewScript(`
+	if redis.call('HGET', KEYS[1], 'code') == ARGV[1] then
+		return redis.call('DEL', KEYS[1])
+	end
+	return 0
+	`)
+)
+
+func smsCodeKey(phone string) string {
+	return fmt.Sprintf("sms_code:%s", phone)
+}
+
+// 发送短信验证码前检查同一手机号及 IP 的冷却时间、手机号及 IP 当日的发送次数，通过时开始新的冷却；
+// 冷却中时返回需要等待的时间，超过当日次数时返回 ErrSmsDailyLimit；上限为 0 时不限制
+func (a *AuthStore) AcquireSmsSend(ctx context.Context, phone, ip string, phoneCooldown, ipCooldown time.Duration, phoneDailyLimit, ipDailyLimit int64) (time.Duration, error) {
+	day := time.Now().Format("20060102")
+	keys := []string{
+		fmt.Sprintf("sms_cooldown:phone:%s", phone),
+		fmt.Sprintf("sms_cooldown:ip:%s", ip),
+		fmt.Sprintf("sms_daily:%s:%s", phone, day),
+		fmt.Sprintf("sms_daily:ip:%s:%s", ip, day),
+	}
+	v, err := luaAcquireSmsSend.Run(ctx, a.cache.Master(), keys,
+		phoneCooldown.Milliseconds(), ipCooldown.Milliseconds(), phoneDailyLimit, ipDailyLimit, int64(24*time.Hour/time.Second)).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, ErrSmsDailyLimit
+	}
+	return time.Duration(v) * time.Millisecond, nil
+}
+
+// 保存短信验证码，替换之前发送的验证码并重置验证次数
+func (a *AuthStore) SaveSmsCode(ctx context.Context, phone, code string, ttl time.Duration) error {
+	key := smsCodeKey(phone)
+	_, err := a.cache.Master().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, "code", code, "attempts", 0)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// 验证短信验证码，验证成功后验证码作废；每个验证码最多验证 maxAttempts 次
+func (a *AuthStore) VerifySmsCode(ctx context.Context, phone, code string, maxAttempts int64) (bool, error) {
+	key := smsCodeKey(phone)
+	res, err := luaTakeSmsCode.Run(ctx, a.cache.Master(), []string{key}, max(maxAttempts, 1)).Slice()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(res) != 2 {
+		return false, fmt.Errorf("unexpected sms code result: %v", res)
+	}
+	saved, _ := res[0].(string)
+	deleted, _ := res[1].(int64)
+	// 在本地以固定时间比较，不在 redis 中比较
+	if subtle.ConstantTimeCompare([]byte(saved), []byte(code)) != 1 {
+		return false, nil
+	}
+	if deleted == 1 {
+		return true, nil
+	}
+	n, err := luaConsumeSmsCode.Run(ctx, a.cache.Master(), []string{key}, saved).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
 }
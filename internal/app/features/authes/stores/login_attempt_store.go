@@ -0,0 +1,213 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"goapp/internal/app/global"
+	"goapp/pkg/cache"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 登录失败次数的统计对象
+type AttemptKind string
+
+const (
+	AttemptKindPhone  AttemptKind = "phone"
+	AttemptKindIp     AttemptKind = "ip"
+	AttemptKindClient AttemptKind = "client"
+)
+
+type AttemptSubject struct {
+	Kind  AttemptKind
+	Value string
+}
+
+func (s AttemptSubject) String() string {
+	return fmt.Sprintf("%s:%s", s.Kind, s.Value)
+}
+
+func (s AttemptSubject) attemptsKey() string {
+	return fmt.Sprintf("login_attempts:%s", s)
+}
+
+func (s AttemptSubject) lockKey() string {
+	return fmt.Sprintf("login_lock:%s", s)
+}
+
+// 统计对象在统计周期内的失败情况
+type AttemptState struct {
+	Fails       int64
+	LastFailure time.Time
+	LockedFor   time.Duration // 剩余的锁定时间，未锁定时为 0
+}
+
+var (
+	// KEYS: 依次为每个统计对象的失败统计及锁定；ARGV: 当前毫秒, 统计周期秒数, 免等待次数, 基础等待毫秒, 最长等待毫秒
+	// 任一统计对象处于锁定或等待中时返回需要等待的毫秒数；否则预先为所有统计对象记录一次失败并返回 0，
+	// 并发的登录请求因此能看到彼此，不会同时通过检查
+	luaReserveLoginAttempt = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	local free = tonumber(ARGV[3])
+	local base = tonumber(ARGV[4])
+	local maxDelay = tonumber(ARGV[5])
+	local wait = 0
+	for i = 1, #KEYS, 2 do
+		local locked = redis.call('PTTL', KEYS[i + 1])
+		if locked > wait then wait = locked end
+		local state = redis.call('HMGET', KEYS[i], 'fails', 'last')
+		local fails = tonumber(state[1]) or 0
+		if fails > free then
+			local delay = base
+			for _ = free + 2, fails do
+				if delay >= maxDelay then break end
+				delay = delay * 2
+			end
+			if delay > maxDelay then delay = maxDelay end
+			local left = (tonumber(state[2]) or 0) + delay - now
+			if left > wait then wait = left end
+		end
+	end
+	if wait > 0 then return wait end
+	for i = 1, #KEYS, 2 do
+		redis.call('HINCRBY', KEYS[i], 'fails', 1)
+		redis.call('HSET', KEYS[i], 'last', ARGV[1])
+		redis.call('EXPIRE', KEYS[i], ARGV[2])
+	end
+	return 0
+	`)
+	// KEYS: 失败统计, 锁定；ARGV: 锁定阈值, 锁定秒数
+	// 失败已在预留时记录，这里只检查阈值：达到阈值时锁定并清空失败统计，返回 {失败次数, 是否锁定}
+	luaRecordLoginFailure = redis.NewScript(`
+	local n = tonumber(redis.call('HGET', KEYS[1], 'fails') or '0')
+	local threshold = tonumber(ARGV[1])
+	if threshold > 0 and n >= threshold then
+		redis.call('SET', KEYS[2], n, 'EX', ARGV[2])
+		redis.call('DEL', KEYS[1])
+		return {n, 1}
+	end
+	return {n, 0}
+	`)
+	// KEYS: 失败统计；撤销预留时记录的失败
+	luaReleaseLoginAttempt = redis.NewScript(`
+	for i = 1, #KEYS do
+		if redis.call('EXISTS', KEYS[i]) == 1 and redis.call('HINCRBY', KEYS[i], 'fails', -1) <= 0 then
+			redis.call('DEL', KEYS[i])
+		end
+	end
+	return 0
+	`)
+)
+
+// 按手机号、IP、客户端统计登录失败次数及锁定状态，多个节点共享
+type LoginAttemptStore struct {
+	cache *cache.Cache
+}
+
+func NewLoginAttemptStore() *LoginAttemptStore {
+	return &LoginAttemptStore{cache: global.Cache()}
+}
+
+// 使用指定的缓存，便于测试
+func NewLoginAttemptStoreWithCache(c *cache.Cache) *LoginAttemptStore {
+	return &LoginAttemptStore{cache: c}
+}
+
+// 查询多个统计对象的状态，顺序与 subjects 相同
+func (s *LoginAttemptStore) States(ctx context.Context, subjects ...AttemptSubject) ([]AttemptState, error) {
+	pipe := s.cache.Master().Pipeline()
+	fails := make([]*redis.SliceCmd, len(subjects))
+	locks := make([]*redis.DurationCmd, len(subjects))
+	for i, subject := range subjects {
+		fails[i] = pipe.HMGet(ctx, subject.attemptsKey(), "fails", "last")
+		locks[i] = pipe.PTTL(ctx, subject.lockKey())
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	states := make([]AttemptState, len(subjects))
+	for i := range subjects {
+		vals := fails[i].Val()
+		if len(vals) == 2 {
+			if v, ok := vals[0].(string); ok {
+				states[i].Fails, _ = strconv.ParseInt(v, 10, 64)
+			}
+			if v, ok := vals[1].(string); ok {
+				ms, _ := strconv.ParseInt(v, 10, 64)
+				states[i].LastFailure = time.UnixMilli(ms)
+			}
+		}
+		if ttl := locks[i].Val(); ttl > 0 {
+			states[i].LockedFor = ttl
+		}
+	}
+	return states, nil
+}
+
+// 登录前为所有统计对象预留一次尝试，预留的尝试先按失败计算；
+// 任一统计对象处于锁定中，或失败次数超过 freeAttempts 且距上次尝试不足等待时间时不预留，返回需要等待的时间
+func (s *LoginAttemptStore) Reserve(ctx context.Context, window time.Duration, freeAttempts int64, baseDelay, maxDelay time.Duration, subjects ...AttemptSubject) (time.Duration, error) {
+	keys := make([]string, 0, len(subjects)*2)
+	for _, subject := range subjects {
+		keys = append(keys, subject.attemptsKey(), subject.lockKey())
+	}
+	wait, err := luaReserveLoginAttempt.Run(ctx, s.cache.Master(), keys,
+		time.Now().UnixMilli(), int64(window/time.Second), freeAttempts, baseDelay.Milliseconds(), maxDelay.Milliseconds()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// 确认预留的尝试失败，失败次数达到 threshold 时锁定 lockFor；返回失败次数及是否因此被锁定
+func (s *LoginAttemptStore) RecordFailure(ctx context.Context, subject AttemptSubject, threshold int64, lockFor time.Duration) (int64, bool, error) {
+	keys := []string{subject.attemptsKey(), subject.lockKey()}
+	res, err := luaRecordLoginFailure.Run(ctx, s.cache.Master(), keys, threshold, int64(lockFor/time.Second)).Int64Slice()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(res) != 2 {
+		return 0, false, fmt.Errorf("unexpected login failure result: %v", res)
+	}
+	return res[0], res[1] == 1, nil
+}
+
+// 撤销预留的尝试，用于登录成功但不清空失败统计的对象，或者服务端出错的情况
+func (s *LoginAttemptStore) Release(ctx context.Context, subjects ...AttemptSubject) error {
+	if len(subjects) == 0 {
+		return nil
+	}
+	keys := make([]string, len(subjects))
+	for i, subject := range subjects {
+		keys[i] = subject.attemptsKey()
+	}
+	return luaReleaseLoginAttempt.Run(ctx, s.cache.Master(), keys).Err()
+}
+
+// 登录成功后清空失败统计，不解除锁定
+func (s *LoginAttemptStore) Reset(ctx context.Context, subjects ...AttemptSubject) error {
+	if len(subjects) == 0 {
+		return nil
+	}
+	keys := make([]string, len(subjects))
+	for i, subject := range subjects {
+		keys[i] = subject.attemptsKey()
+	}
+	_, err := s.cache.KeyDel(ctx, keys...)
+	return err
+}
+
+// 解除锁定并清空失败统计，返回之前是否处于锁定状态
+func (s *LoginAttemptStore) Unlock(ctx context.Context, subject AttemptSubject) (bool, error) {
+	locked, err := s.cache.KeyDel(ctx, subject.lockKey())
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.cache.KeyDel(ctx, subject.attemptsKey()); err != nil {
+		return false, err
+	}
+	return locked > 0, nil
+}
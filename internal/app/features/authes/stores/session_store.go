@@ -0,0 +1,214 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"goapp/internal/app/shared/claims"
+	"goapp/pkg/core"
+	"goapp/pkg/ids"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 用户在一个客户端上的登录会话，clientId 全局唯一，也是该客户端在 hub 中的连接 id
+type Session struct {
+	ClientId   string
+	Platform   core.Platform
+	UserAgent  string
+	Ip         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// 用户的所有会话（clientId 集合）
+func userSessionsKey(userId ids.UID) string {
+	return fmt.Sprintf("user_sessions:%s", userId)
+}
+
+// 会话的信息
+func sessionKey(userId ids.UID, clientId string) string {
+	return fmt.Sprintf("session:%s:%s", userId, clientId)
+}
+
+// 会话持有的令牌，成员与 user_tokens 相同
+func sessionTokensKey(userId ids.UID, clientId string) string {
+	return fmt.Sprintf("session_tokens:%s:%s", userId, clientId)
+}
+
+func sessionSeenKey(userId ids.UID, clientId string) string {
+	return fmt.Sprintf("session_seen:%s:%s", userId, clientId)
+}
+
+// KEYS: 节流, 会话；ARGV: 当前毫秒, ip, 节流毫秒
+// 会话存在且不在节流期内时更新最后活跃时间及 ip
+var luaTouchSession = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then return 0 end
+if not redis.call('SET', KEYS[1], '1', 'NX', 'PX', ARGV[3]) then return 0 end
+redis.call('HSET', KEYS[2], 'seen', ARGV[1], 'ip', ARGV[2])
+return 1
+`)
+
+// 登录或刷新令牌时保存会话，会话的有效期与刷新令牌一致
+func (a *AuthStore) saveSession(ctx context.Context, cc *claims.AuthorizedClaims, ttl time.Duration) error {
+	if len(cc.ClientId) == 0 {
+		return nil
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	key := sessionKey(cc.UserId, cc.ClientId)
+	setKey := userSessionsKey(cc.UserId)
+	_, err := a.cache.Master().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSetNX(ctx, key, "created", now)
+		pipe.HSet(ctx, key, "platform", int(cc.Platform), "ua", cc.UserAgent, "ip", cc.Ip, "seen", now)
+		pipe.Expire(ctx, key, ttl)
+		pipe.SAdd(ctx, setKey, cc.ClientId)
+		pipe.ExpireNX(ctx, setKey, ttl)
+		pipe.ExpireGT(ctx, setKey, ttl)
+		return nil
+	})
+	return err
+}
+
+// 更新会话的最后活跃时间及 ip；每个会话在 throttle 内最多更新一次
+func (a *AuthStore) TouchSession(ctx context.Context, userId ids.UID, clientId, ip string, throttle time.Duration) error {
+	keys := []string{sessionSeenKey(userId, clientId), sessionKey(userId, clientId)}
+	return luaTouchSession.Run(ctx, a.cache.Master(), keys, time.Now().UnixMilli(), ip, throttle.Milliseconds()).Err()
+}
+
+// 用户所有未过期的会话，已过期的会话顺便从索引中移除
+func (a *AuthStore) ListSessions(ctx context.Context, userId ids.UID) ([]*Session, error) {
+	setKey := userSessionsKey(userId)
+	clientIds, err := a.cache.SMembers(ctx, setKey)
+	if err != nil {
+		return nil, err
+	}
+	pipe := a.cache.Master().Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(clientIds))
+	for i, clientId := range clientIds {
+		cmds[i] = pipe.HGetAll(ctx, sessionKey(userId, clientId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(clientIds))
+	var expired []any
+	for i, cmd := range cmds {
+		vals := cmd.Val()
+		if len(vals) == 0 {
+			expired = append(expired, clientIds[i])
+			continue
+		}
+		platform, _ := strconv.Atoi(vals["platform"])
+		created, _ := strconv.ParseInt(vals["created"], 10, 64)
+		seen, _ := strconv.ParseInt(vals["seen"], 10, 64)
+		sessions = append(sessions, &Session{
+			ClientId:   clientIds[i],
+			Platform:   core.Platform(platform),
+			UserAgent:  vals["ua"],
+			Ip:         vals["ip"],
+			CreatedAt:  time.UnixMilli(created),
+			LastSeenAt: time.UnixMilli(seen),
+		})
+	}
+	if len(expired) > 0 {
+		a.cache.SRemove(ctx, setKey, expired...)
+	}
+	return sessions, nil
+}
+
+// 吊销会话及其持有的令牌，返回会话是否存在
+func (a *AuthStore) RevokeSession(ctx context.Context, userId ids.UID, clientId string) (bool, error) {
+	tokensKey := sessionTokensKey(userId, clientId)
+	members, err := a.cache.SMembers(ctx, tokensKey)
+	if err != nil {
+		return false, err
+	}
+	keys := tokenKeys(members)
+	keys = append(keys, tokensKey, sessionSeenKey(userId, clientId))
+	if _, err := a.cache.KeyDel(ctx, keys...); err != nil {
+		return false, err
+	}
+	n, err := a.cache.KeyDel(ctx, sessionKey(userId, clientId))
+	if err != nil {
+		return false, err
+	}
+	_, err = a.cache.Master().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, userSessionsKey(userId), clientId)
+		if len(members) > 0 {
+			pipe.SRem(ctx, userTokensKey(userId), toAny(members)...)
+		}
+		return nil
+	})
+	return n > 0, err
+}
+
+// 吊销用户除 clientId 以外的所有会话，以及不属于该会话的令牌，返回吊销的会话；clientId 为空时吊销所有的会话和令牌
+func (a *AuthStore) RevokeOtherSessions(ctx context.Context, userId ids.UID, clientId string) ([]string, error) {
+	clientIds, err := a.cache.SMembers(ctx, userSessionsKey(userId))
+	if err != nil {
+		return nil, err
+	}
+	if len(clientId) == 0 {
+		_, err := a.RevokeUserTokens(ctx, userId)
+		return clientIds, err
+	}
+	var revoked []string
+	for _, id := range clientIds {
+		if id == clientId {
+			continue
+		}
+		ok, err := a.RevokeSession(ctx, userId, id)
+		if err != nil {
+			return revoked, err
+		}
+		if ok {
+			revoked = append(revoked, id)
+		}
+	}
+
+	// 不属于任何会话的令牌，比如没有 clientId 的客户端登录时签发的令牌
+	kept, err := a.cache.SMembers(ctx, sessionTokensKey(userId, clientId))
+	if err != nil {
+		return revoked, err
+	}
+	members, err := a.cache.SMembers(ctx, userTokensKey(userId))
+	if err != nil {
+		return revoked, err
+	}
+	others := slices.DeleteFunc(members, func(m string) bool { return slices.Contains(kept, m) })
+	if len(others) == 0 {
+		return revoked, nil
+	}
+	if _, err := a.cache.KeyDel(ctx, tokenKeys(others)...); err != nil {
+		return revoked, err
+	}
+	_, err = a.cache.SRemove(ctx, userTokensKey(userId), toAny(others)...)
+	return revoked, err
+}
+
+// 删除用户所有的会话信息，令牌由调用方删除
+func (a *AuthStore) deleteUserSessions(ctx context.Context, userId ids.UID) error {
+	setKey := userSessionsKey(userId)
+	clientIds, err := a.cache.SMembers(ctx, setKey)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(clientIds)*3+1)
+	for _, clientId := range clientIds {
+		keys = append(keys, sessionKey(userId, clientId), sessionTokensKey(userId, clientId), sessionSeenKey(userId, clientId))
+	}
+	keys = append(keys, setKey)
+	_, err = a.cache.KeyDel(ctx, keys...)
+	return err
+}
+
+func toAny(members []string) []any {
+	vals := make([]any, len(members))
+	for i, m := range members {
+		vals[i] = m
+	}
+	return vals
+}
@@ -0,0 +1,110 @@
+package stores
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goapp/pkg/ids"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 已被轮换的刷新令牌，用于识别被盗用后重复使用的刷新令牌
+type RotatedRefreshToken struct {
+	FamilyId  string  `json:"familyId"`
+	UserId    ids.UID `json:"userId"`
+	ClientId  string  `json:"clientId"`
+	RotatedAt int64   `json:"rotatedAt"` // in millisecond
+}
+
+// 令牌族持有的令牌，成员与 user_tokens 相同
+func tokenFamilyKey(familyId string) string {
+	return fmt.Sprintf("token_family:%s", familyId)
+}
+
+func rotatedRefreshTokenKey(token string) string {
+	return fmt.Sprintf("rotated_refresh_token:%s", token)
+}
+
+// 记录刷新令牌已被轮换，保留到该令牌原本的过期时间
+func (a *AuthStore) MarkRefreshTokenRotated(ctx context.Context, token string, rotated *RotatedRefreshToken, ttl time.Duration) error {
+	val, err := json.Marshal(rotated)
+	if err != nil {
+		return err
+	}
+	_, err = a.cache.Set(ctx, rotatedRefreshTokenKey(token), val, ttl)
+	return err
+}
+
+// 查询已被轮换的刷新令牌，未轮换或已过期时返回 nil
+func (a *AuthStore) GetRotatedRefreshToken(ctx context.Context, token string) (*RotatedRefreshToken, error) {
+	val, err := a.cache.Get(ctx, rotatedRefreshTokenKey(token))
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rotated RotatedRefreshToken
+	if err := json.Unmarshal([]byte(val), &rotated); err != nil {
+		return nil, err
+	}
+	return &rotated, nil
+}
+
+// 原子地删除刷新令牌，返回是否由本次调用删除；并发刷新同一个令牌时只有一个请求返回 true
+func (a *AuthStore) ConsumeRefreshToken(ctx context.Context, token string) (bool, error) {
+	return a.deleteToken(ctx, TokenTypeRefresh, token)
+}
+
+// 处理已轮换的刷新令牌被再次使用：距轮换超过 grace 时吊销整个令牌族，返回该令牌的轮换记录及吊销的令牌数量；
+// 令牌未被轮换或仍在 grace 内（通常是客户端并发刷新）时返回 nil
+func (a *AuthStore) RevokeReusedRefreshToken(ctx context.Context, token string, grace time.Duration) (*RotatedRefreshToken, int, error) {
+	rotated, err := a.GetRotatedRefreshToken(ctx, token)
+	if err != nil || rotated == nil {
+		return nil, 0, err
+	}
+	if time.Since(time.UnixMilli(rotated.RotatedAt)) < grace {
+		return nil, 0, nil
+	}
+	revoked, err := a.RevokeTokenFamily(ctx, rotated.UserId, rotated.ClientId, rotated.FamilyId)
+	return rotated, revoked, err
+}
+
+// 吊销令牌族中所有的访问令牌和刷新令牌，返回吊销的令牌数量；
+// 会话不再持有令牌时一并删除
+func (a *AuthStore) RevokeTokenFamily(ctx context.Context, userId ids.UID, clientId, familyId string) (int, error) {
+	if len(familyId) == 0 {
+		return 0, nil
+	}
+	key := tokenFamilyKey(familyId)
+	members, err := a.cache.SMembers(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range members {
+		deleted, err := a.deleteTokenMember(ctx, m)
+		if err != nil {
+			return n, err
+		}
+		if deleted {
+			n++
+		}
+	}
+	if _, err := a.cache.KeyDel(ctx, key); err != nil {
+		return n, err
+	}
+	if len(clientId) == 0 {
+		return n, nil
+	}
+	left, err := a.cache.Master().SCard(ctx, sessionTokensKey(userId, clientId)).Result()
+	if err != nil {
+		return n, err
+	}
+	if left == 0 {
+		_, err = a.RevokeSession(ctx, userId, clientId)
+	}
+	return n, err
+}
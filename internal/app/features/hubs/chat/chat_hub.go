@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"goapp/internal/app/global"
@@ -22,8 +23,39 @@ const (
 	ChatMsgTypeReady ChatMsgType = 1
 	ChatMsgTypePing  ChatMsgType = 5
 	ChatMsgTypePong  ChatMsgType = 6
+	// 服务端发起，需要客户端应答
+	ChatMsgTypeForceLogout ChatMsgType = 20
+	// 补发的离线消息，客户端处理后通过 ChatMsgTypeOfflineAck 确认
+	ChatMsgTypeOfflineMsg ChatMsgType = 21
+	ChatMsgTypeOfflineAck ChatMsgType = 22
+	// 服务端推送的安全提醒，用户离线时存入离线信箱
+	ChatMsgTypeSecurityAlert ChatMsgType = 23
 )
 
+// 补发的离线消息
+type ChatOfflineMsg struct {
+	Id   string `msgpack:"id"`
+	Data []byte `msgpack:"data"`
+}
+
+// 客户端确认 Id 及之前的离线消息已收到
+type ChatOfflineAck struct {
+	Id string `msgpack:"id"`
+}
+
+// 安全提醒的类型
+const (
+	ChatSecurityAlertTokenReuse = "token_reuse" // 已轮换的刷新令牌被重复使用，相关客户端已退出登录
+)
+
+// 安全提醒
+type ChatSecurityAlert struct {
+	Kind     string `msgpack:"kind"`
+	ClientId string `msgpack:"clientId"` // 受影响的客户端
+	Ip       string `msgpack:"ip"`       // 发起请求的 ip
+	Time     int64  `msgpack:"time"`     // in millisecond
+}
+
 type ChatRespCode byte
 
 const (
@@ -37,6 +69,7 @@ type ChatHub struct {
 	config *global.HubConfig
 
 	protooal *bytes.PacketProtocol
+	router   *hub.RpcRouter
 }
 
 func NewChatHub() *ChatHub {
@@ -48,6 +81,10 @@ func NewChatHub() *ChatHub {
 }
 
 func (h *ChatHub) Start(router *gin.RouterGroup, path string) {
+	backpressure, err := hub.ParseBackpressurePolicy(h.config.Backpressure)
+	if err != nil {
+		panic(err)
+	}
 	hub, err := hub.NewHub(
 		h.config.SubProtocols,
 		time.Second*time.Duration(h.config.LiveCheckDuration),
@@ -61,11 +98,23 @@ func (h *ChatHub) Start(router *gin.RouterGroup, path string) {
 			origin := r.Header.Get("Origin")
 			return slices.Contains(global.GetAppConfig().Cors.AllowOrigins, origin)
 		},
+		// 多实例部署时，通过 Redis 将消息路由到用户所在的节点
+		hub.WithBackplane(global.GetAppConfig().Id, hub.NewRedisBackplane(global.Cache(), "hub:chat", 0)),
+		hub.WithPresence(global.Presence()),
+		hub.WithProtocol(h.protooal),
+		// 用户不在线时，消息存入离线信箱，上线后补发
+		hub.WithMailbox(
+			hub.NewRedisMailbox(global.Cache(), "hub:chat", h.config.MailboxCap, time.Second*time.Duration(h.config.MailboxTtl)),
+			h.encodeOfflineMsg,
+		),
+		hub.WithBackpressure(backpressure, h.config.WriteQueueSize),
+		hub.WithMetrics("chat", nil),
 	)
 	if err != nil {
 		panic(err)
 	}
 	h.Hub = hub
+	h.router = h.newRouter()
 
 	go h.listen()
 
@@ -125,24 +174,65 @@ func (h *ChatHub) upgrade(c *gin.Context) {
 	extraData.SetValue(headers.KeyClientKeys, clientKeys)
 
 	err := h.UpgradeWebSocket(userId, claims.Platform, clientId, extraData, c.Writer, c.Request)
-	if err != nil {
+	if errors.Is(err, hub.ErrHubDraining) {
+		// 本节点正在下线，客户端稍后重试，由负载均衡分配到其它节点
+		c.Header("Retry-After", "1")
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+	} else if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 }
 
+// 注册客户端请求的处理器
+func (h *ChatHub) newRouter() *hub.RpcRouter {
+	router := hub.NewRpcRouter(h.Hub, h.protooal, time.Second*time.Duration(h.config.RpcTimeout))
+	router.Use(hub.RpcLogger(), hub.RpcRateLimit(50*time.Millisecond, 40))
+
+	router.Handle(byte(ChatMsgTypePing), h.handlePing)
+	router.Handle(byte(ChatMsgTypeOfflineAck), hub.TypedRpcHandler(h.handleOfflineAck))
+	return router
+}
+
 func (h *ChatHub) handleReceivedMsg(msg *hub.LineMessage) {
-	meta, err := h.protooal.GetMeta(msg.Data)
-	if err != nil {
-		// log err
-		return
+	if err := h.router.Dispatch(msg); err != nil {
+		fmt.Printf("[HUB] dispatch msg failed: userid->%v, line->%v, err:%v\n", msg.UserId, msg.LineId, err)
+	}
+}
+
+func (h *ChatHub) handlePing(c *hub.RpcContext) (any, error) {
+	c.RespType = byte(ChatMsgTypePong)
+	return nil, nil
+}
+
+func (h *ChatHub) encodeOfflineMsg(msg *hub.MailboxMessage) ([]byte, error) {
+	return h.protooal.EncodeResp(int32(ChatMsgTypeOfflineMsg), 0, byte(ChatRespCodeOk), &ChatOfflineMsg{Id: msg.Id, Data: msg.Data})
+}
+
+func (h *ChatHub) handleOfflineAck(c *hub.RpcContext, req *ChatOfflineAck) (any, error) {
+	if len(req.Id) == 0 {
+		return nil, &hub.RpcError{Code: hub.RpcCodeBadRequest, Msg: "id required"}
 	}
-	fmt.Printf("[HUB] receive msg: userid->%v, platform->%v, line->%v, msg type->%v, requestId->%v, timestamp(relative)->%v\n", msg.UserId, msg.Platform, msg.LineId, meta.MsgType, meta.RequestId, meta.GetConvertedTimestamp())
-	if meta.MsgType == byte(ChatMsgTypePing) {
-		resp, err := h.protooal.EncodeResp(int32(ChatMsgTypePong), meta.RequestId, byte(ChatRespCodeOk), nil)
-		if err == nil {
-			h.PushToUserLines(msg.UserId, resp, msg.LineId)
+	return nil, h.AckMailbox(c, c.Message.UserId, req.Id)
+}
+
+// 通知用户在所有节点上的连接退出登录，等待客户端确认后关闭这些连接
+func (h *ChatHub) ForceLogout(ctx context.Context, userId string, reason string) {
+	for _, reply := range h.RequestUserLines(ctx, userId, byte(ChatMsgTypeForceLogout), core.MapX{"reason": reason}) {
+		if reply.Err != nil {
+			fmt.Printf("[HUB] force logout not acked: userid->%v, line->%v, err:%v\n", userId, reply.LineId, reply.Err)
 		}
 	}
+	h.CloseUserLines(userId)
+}
+
+// 向用户的所有连接（包括其它节点上的连接）推送安全提醒，用户不在线时上线后补发
+func (h *ChatHub) NotifySecurityAlert(userId string, alert *ChatSecurityAlert) error {
+	resp, err := h.protooal.EncodeResp(int32(ChatMsgTypeSecurityAlert), 0, byte(ChatRespCodeOk), alert)
+	if err != nil {
+		return err
+	}
+	h.PushMessage([]string{userId}, resp)
+	return nil
 }
 
 func (h *ChatHub) handleLineRegistered(r *hub.Line) {
@@ -158,5 +248,10 @@ func (h *ChatHub) handleLineUnegistered(u *hub.Line) {
 }
 
 func (h *ChatHub) handleLineError(e *hub.LineError) {
+	var bpErr *hub.BackpressureError
+	if errors.As(e.Error, &bpErr) {
+		fmt.Printf("[HUB] slow consumer: userid->%v, platform->%v, line->%v, policy->%v, queue->%v, dropped->%v\n", e.UserId, e.Platform, e.LineId, bpErr.Policy, bpErr.QueueLen, bpErr.Dropped)
+		return
+	}
 	fmt.Printf("[HUB] line error: userid->%v, platform->%v, line->%v, err:%v\n", e.UserId, e.Platform, e.LineId, e.Error)
 }
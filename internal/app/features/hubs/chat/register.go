@@ -1,6 +1,13 @@
 package chat
 
-import "github.com/gin-gonic/gin"
+import (
+	"goapp/internal/app/global"
+	"goapp/pkg/ids"
+	"goapp/pkg/lifecycle"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
 
 var chatHub *ChatHub
 
@@ -11,4 +18,13 @@ func GetChatHub() *ChatHub {
 func RegisterHubs(hubGroup *gin.RouterGroup) {
 	chatHub = NewChatHub()
 	chatHub.Start(hubGroup, "/chat")
+	// 关闭时排空连接，客户端收到关闭帧后重连到其它节点
+	global.Lifecycle().Register("chat hub", lifecycle.StageDrain, 15*time.Second, chatHub.Drain)
+}
+
+// 关闭用户在这些客户端上的连接（包括其它节点上的连接），hub 中连接的 id 即 clientId
+func CloseClientLines(userId ids.UID, clientIds ...string) {
+	if chatHub != nil && chatHub.Hub != nil && len(clientIds) > 0 {
+		chatHub.CloseUserLine(userId.String(), clientIds...)
+	}
 }
@@ -14,6 +14,13 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// AI 流式输出的事件名称，前端通过 addEventListener 分别监听
+const (
+	AIEventToken = "token" // 增量输出的内容
+	AIEventDone  = "done"  // 输出结束
+	AIEventError = "error" // 输出出错
+)
+
 type AIHub struct {
 	hub *sse.Hub
 
@@ -22,7 +29,13 @@ type AIHub struct {
 
 func NewAIHub() (*AIHub, error) {
 	pool := global.GoroutinePool()
-	h, err := sse.NewHub(pool, 30*time.Second)
+	h, err := sse.NewHub(pool, 30*time.Second,
+		sse.WithPresence(global.Presence()),
+		// 网络抖动重连后，根据 Last-Event-ID 补发期间错过的 token
+		sse.WithEventLog(sse.NewRedisEventLog(global.Cache(), "sse:ai", 512, 10*time.Minute)),
+		sse.WithRetry(3*time.Second),
+		sse.WithMetrics("ai", nil),
+	)
 	if err != nil {
 		panic(err)
 	}
@@ -57,6 +70,21 @@ func (h *AIHub) Serve(c *gin.Context) {
 	h.hub.Serve(c, userId, claims.Platform, lineID, extraData)
 }
 
+// 向用户的指定连接推送增量内容
+func (h *AIHub) PushToken(userId, lineId, token string) error {
+	return h.hub.PushEventToUserLines(userId, &sse.Event{Event: AIEventToken, Data: token}, lineId)
+}
+
+// 通知用户的指定连接输出结束，data 会编码为 JSON
+func (h *AIHub) PushDone(userId, lineId string, data any) error {
+	return h.hub.PushEventToUserLines(userId, &sse.Event{Event: AIEventDone, Data: data}, lineId)
+}
+
+// 通知用户的指定连接输出出错
+func (h *AIHub) PushError(userId, lineId string, msg string) error {
+	return h.hub.PushEventToUserLines(userId, &sse.Event{Event: AIEventError, Data: msg}, lineId)
+}
+
 func (h *AIHub) StartBroadcastTest() {
 	ticker := time.NewTicker(5 * time.Second)
 	go func() {
@@ -1,6 +1,12 @@
 package sse
 
-import "github.com/gin-gonic/gin"
+import (
+	"goapp/internal/app/global"
+	"goapp/pkg/lifecycle"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
 
 var aiHub *AIHub
 
@@ -20,4 +26,6 @@ func NewSSEHubs() {
 func RegisterHubs(hubGroup *gin.RouterGroup) {
 	NewSSEHubs()
 	sseHandler.RegisterRoutes(hubGroup)
+	// 关闭时排空连接，客户端重连到其它节点后补发错过的事件
+	global.Lifecycle().Register("ai hub", lifecycle.StageDrain, 15*time.Second, aiHub.hub.Drain)
 }
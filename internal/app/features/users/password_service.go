@@ -0,0 +1,149 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/app/features/hubs/chat"
+	"goapp/internal/app/shared"
+	"goapp/internal/app/shared/claims"
+	"goapp/internal/app/shared/crypto"
+	"goapp/internal/app/shared/headers"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordService struct {
+	authRepo *stores.AuthStore
+	userRepo *UserStore
+}
+
+func NewPasswordService() *PasswordService {
+	return &PasswordService{
+		authRepo: stores.NewAuthStore(),
+		userRepo: NewUserStore(),
+	}
+}
+
+// 首次设置密码，比如通过短信验证码注册的用户
+type SetPasswordRequest struct {
+	Password  string `json:"password" binding:"required"`
+	ImgCode   string `json:"imgCode" binding:"required"`
+	CsrfToken string `json:"csrfToken" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+	ImgCode     string `json:"imgCode" binding:"required"`
+	CsrfToken   string `json:"csrfToken" binding:"required"`
+}
+
+type PasswordResponseDto = shared.ResponseDto[any]
+
+func (s *PasswordService) SetPassword(c *gin.Context, req *SetPasswordRequest) *PasswordResponseDto {
+	if !s.verifyCaptcha(c, req.CsrfToken, req.ImgCode) {
+		return nil
+	}
+	if !crypto.IsValidPassword(req.Password) {
+		return &PasswordResponseDto{Code: shared.RespCodeInvalidPassword, Msg: "password too short or too long"}
+	}
+	cc := claims.GetClaims(c)
+	if cc == nil {
+		c.AbortWithStatus(401)
+		return nil
+	}
+
+	hash, err := crypto.PasswordHasher().Hash(req.Password)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	err = s.userRepo.UpdatePassword(c, cc.UserId, "", hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 已设置过密码，需要通过修改密码验证旧密码
+		return &PasswordResponseDto{Code: shared.RespCodeFailed, Msg: "password already set"}
+	}
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	return &PasswordResponseDto{Code: shared.RespCodeSucceed}
+}
+
+func (s *PasswordService) ChangePassword(c *gin.Context, req *ChangePasswordRequest) *PasswordResponseDto {
+	if !s.verifyCaptcha(c, req.CsrfToken, req.ImgCode) {
+		return nil
+	}
+	if !crypto.IsValidPassword(req.NewPassword) {
+		return &PasswordResponseDto{Code: shared.RespCodeInvalidPassword, Msg: "password too short or too long"}
+	}
+	cc := claims.GetClaims(c)
+	if cc == nil {
+		c.AbortWithStatus(401)
+		return nil
+	}
+
+	oldHash, err := s.userRepo.GetPasswordHash(c, cc.UserId)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.AbortWithStatus(401)
+		return nil
+	}
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	if len(oldHash) == 0 {
+		return &PasswordResponseDto{Code: shared.RespCodeFailed, Msg: "password not set"}
+	}
+
+	hasher := crypto.PasswordHasher()
+	matched, _, err := hasher.Verify(req.OldPassword, oldHash)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	if !matched {
+		return &PasswordResponseDto{Code: shared.RespCodeInvalidPassword, Msg: "wrong password"}
+	}
+	hash, err := hasher.Hash(req.NewPassword)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	err = s.userRepo.UpdatePassword(c, cc.UserId, oldHash, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 验证旧密码后，密码又被其他请求修改了
+		return &PasswordResponseDto{Code: shared.RespCodeFailed, Msg: "password changed concurrently"}
+	}
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	// 密码可能已泄露，其它客户端需要用新密码重新登录
+	revoked, err := s.authRepo.RevokeOtherSessions(c, cc.UserId, cc.ClientId)
+	chat.CloseClientLines(cc.UserId, revoked...)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return nil
+	}
+	return &PasswordResponseDto{Code: shared.RespCodeSucceed}
+}
+
+// 与登录相同，请求体中的 csrf token 必须与 cookie 或请求头中的一致，且图形验证码正确
+func (s *PasswordService) verifyCaptcha(c *gin.Context, csrfToken, imgCode string) bool {
+	if headers.GetCsrfToken(c) != csrfToken {
+		c.AbortWithStatus(400)
+		return false
+	}
+	valid, err := s.authRepo.VerifyCaptcha(c, csrfToken, imgCode, true)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return false
+	}
+	if !valid {
+		c.AbortWithStatus(400)
+		return false
+	}
+	return true
+}
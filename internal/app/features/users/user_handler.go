@@ -1,14 +1,19 @@
 package users
 
 import (
+	"goapp/internal/app/shared"
+
 	"github.com/gin-gonic/gin"
 )
 
 type UserHandler struct {
+	*shared.RouteHandler
 }
 
 var (
-	userHandler *UserHandler = &UserHandler{}
+	userHandler *UserHandler = &UserHandler{
+		RouteHandler: &shared.RouteHandler{},
+	}
 )
 
 func GetUserHandler() *UserHandler {
@@ -18,6 +23,8 @@ func GetUserHandler() *UserHandler {
 func (u *UserHandler) RegisterRoutes(router *gin.RouterGroup) {
 	g := router.Group("/user")
 	g.GET("/info", u.handleGetSelfUserInfo)
+	g.POST("/password/set", u.handleSetPassword)
+	g.POST("/password/change", u.handleChangePassword)
 	g.POST("/:id", func(ctx *gin.Context) {
 		// update user info
 	})
@@ -34,3 +41,31 @@ func (u *UserHandler) handleGetSelfUserInfo(c *gin.Context) {
 	}
 	c.JSON(200, user)
 }
+
+// 设置密码，仅用于尚未设置密码的用户；需要先通过 /auth/login/prepare 获取图形验证码
+func (u *UserHandler) handleSetPassword(c *gin.Context) {
+	var req SetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(200, u.NewResponseInvalidArgs(""))
+		return
+	}
+	reply := NewPasswordService().SetPassword(c, &req)
+	if c.IsAborted() {
+		return
+	}
+	c.JSON(200, reply)
+}
+
+// 修改密码，需要验证旧密码及图形验证码
+func (u *UserHandler) handleChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(200, u.NewResponseInvalidArgs(""))
+		return
+	}
+	reply := NewPasswordService().ChangePassword(c, &req)
+	if c.IsAborted() {
+		return
+	}
+	c.JSON(200, reply)
+}
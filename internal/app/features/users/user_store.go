@@ -2,14 +2,16 @@ package users
 
 import (
 	"context"
+	"database/sql"
 	"goapp/internal/app/global"
 	"goapp/internal/app/models"
+	"goapp/internal/pkg"
 	"goapp/pkg/cache"
-	"goapp/pkg/db"
 	"goapp/pkg/ids"
+	"goapp/pkg/outbox"
 	"time"
 
-	"github.com/jackc/pgerrcode"
+	"github.com/uptrace/bun"
 )
 
 const (
@@ -36,25 +38,28 @@ func (r *UserStore) Upsert(ctx context.Context, phone, ip string) (*models.User,
 		Status: models.UserStatusNormal,
 	}
 
-	// 方式一：通过唯一索引的错误来判断是否存在
-	_, err := global.DB().NewInsert().Model(user).
-		Exec(ctx)
-	if err != nil && !db.IsPGErrorCode(err, pgerrcode.UniqueViolation) {
+	// 手机号已存在时不插入；不能依赖唯一索引的错误，出错后事务中的后续语句都会失败
+	// 新用户与注册事件在同一个事务中写入，由 messenger 经 outbox 发布
+	err := global.DB().RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewInsert().Model(user).
+			On("CONFLICT DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		return outbox.AddTopic(ctx, tx, pkg.UserRegisteredTopic(nil), user.ID.String(), pkg.UserRegisteredMsg{
+			UserId:       user.ID.String(),
+			Ip:           ip,
+			RegisteredAt: user.CreatedAt,
+		})
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// // 方式二：通过约束来处理唯一索引冲突
-	// ALTER TABLE users DROP constraint if Exists users_unique;
-	// ALTER TABLE users
-	// ADD CONSTRAINT users_unique
-	// UNIQUE NULLS NOT DISTINCT (phone, deleted_at);
-	// _, err := global.DB().NewInsert().Model(user).
-	// 	On("CONFLICT on CONSTRAINT users_unique DO NOTHING").
-	// 	Exec(ctx)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
 	err = global.DB().NewSelect().Model(user).Where("phone = ?", phone).Scan(ctx)
 	if err != nil {
 		return nil, err
@@ -76,6 +81,59 @@ func (r *UserStore) DeleteByID(ctx context.Context, userId ids.UID) error {
 	return err
 }
 
+// 修改用户状态，比如封禁；用户不存在时返回 sql.ErrNoRows
+func (r *UserStore) SetStatus(ctx context.Context, userId ids.UID, status models.UserStatus) error {
+	res, err := global.DB().NewUpdate().Model((*models.User)(nil)).
+		Set("status = ?", status).
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", userId).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	r.cache.KeyDelayDoubleDel(ctx, time.Millisecond*500, CacheKeyPrefixUser+userId.String())
+	return nil
+}
+
+// 通过完整的手机号（含国家码）查找用户，不经过缓存；用户不存在时返回 sql.ErrNoRows
+func (r *UserStore) GetByPhone(ctx context.Context, phone string) (*models.User, error) {
+	user := new(models.User)
+	err := global.DB().NewSelect().Model(user).Where("phone = ?", phone).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// 用户的密码哈希，未设置密码时为空；不经过缓存，缓存中的可能已过期
+func (r *UserStore) GetPasswordHash(ctx context.Context, userId ids.UID) (string, error) {
+	var hash string
+	err := global.DB().NewSelect().Model((*models.User)(nil)).Column("password").Where("id = ?", userId).Scan(ctx, &hash)
+	return hash, err
+}
+
+// 当密码哈希仍为 oldHash 时将其改为 hash，oldHash 为空表示尚未设置密码；
+// 期间密码已被修改时返回 sql.ErrNoRows，避免并发修改时后者覆盖前者
+func (r *UserStore) UpdatePassword(ctx context.Context, userId ids.UID, oldHash, hash string) error {
+	res, err := global.DB().NewUpdate().Model((*models.User)(nil)).
+		Set("password = ?", hash).
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", userId).
+		Where("password = ?", oldHash).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	r.cache.KeyDelayDoubleDel(ctx, time.Millisecond*500, CacheKeyPrefixUser+userId.String())
+	return nil
+}
+
 func (r *UserStore) GetByID(ctx context.Context, userId ids.UID) (*models.User, error) {
 	key := CacheKeyPrefixUser + userId.String()
 	var user models.User
@@ -7,24 +7,30 @@ import (
 )
 
 type AppConfig struct {
-	Name          string              `mapstructure:"name"`
-	Addr          string              `mapstructure:"addr"`
-	Domains       []string            `mapstructure:"domains"`
-	Id            string              `mapstructure:"id"`
-	WorkerId      int64               `mapstructure:"worker_id"`
-	Database      DatabaseConfig      `mapstructure:"database"`
-	Cache         CacheConfig         `mapstructure:"cache"`
-	Locker        LockerConfig        `mapstructure:"locker"`
-	Queue         QueueConfig         `mapstructure:"queue"`
-	Hub           HubConfig           `mapstructure:"hub"`
-	Authenticator AuthenticatorConfig `mapstructure:"authenticator"`
-	Cors          CorsConfig          `mapstructure:"cors"`
+	Name            string              `mapstructure:"name"`
+	Addr            string              `mapstructure:"addr"`
+	MetricsAddr     string              `mapstructure:"metrics_addr"` // 仅供内网访问的 /metrics 监听地址，为空时不监听
+	Domains         []string            `mapstructure:"domains"`
+	Id              string              `mapstructure:"id"`
+	WorkerId        int64               `mapstructure:"worker_id"`
+	Database        DatabaseConfig      `mapstructure:"database"`
+	Cache           CacheConfig         `mapstructure:"cache"`
+	Locker          LockerConfig        `mapstructure:"locker"`
+	Queue           QueueConfig         `mapstructure:"queue"`
+	Rabbit          RabbitConfig        `mapstructure:"rabbit"`
+	Messenger       MessengerConfig     `mapstructure:"messenger"`
+	Hub             HubConfig           `mapstructure:"hub"`
+	Authenticator   AuthenticatorConfig `mapstructure:"authenticator"`
+	Cors            CorsConfig          `mapstructure:"cors"`
+	ShutdownTimeout int                 `mapstructure:"shutdown_timeout"` // 收到退出信号后，关闭过程的最长时间，单位：秒
 }
 
 type DatabaseConfig struct {
 	Master   string    `mapstructure:"master"`
 	Replicas []string  `mapstructure:"replicas"`
 	Driver   db.Driver `mapstructure:"driver"`
+	// 启动时执行 migrations 中未执行的迁移；多个节点同时启动时只有一个执行
+	MigrateOnStart bool `mapstructure:"migrate_on_start"`
 }
 
 type CacheConfig struct {
@@ -56,10 +62,22 @@ func (c *LockerConfig) GetRedisOption() *redis.Options {
 }
 
 type QueueConfig struct {
+	// 队列的实现：redis（默认）或 memory，memory 仅适用于单节点部署及测试
+	Driver string `mapstructure:"driver"`
+	// memory 队列持久化的目录，为空时仅保存在内存中
+	DataDir    string `mapstructure:"data_dir"`
 	Addr       string `mapstructure:"addr"`
 	Db         int    `mapstructure:"db"`
 	XAddMaxLen int    `mapstructure:"xadd_max_len"`
 	BatchSize  int    `mapstructure:"batch_size"`
+	// 消息最多投递的次数，超过后转入死信队列
+	MaxDeliveries int64 `mapstructure:"max_deliveries"`
+	// 重试的初始间隔及最长间隔，单位：秒
+	RetryBase int `mapstructure:"retry_base"`
+	RetryMax  int `mapstructure:"retry_max"`
+	// 认领其它消费者遗留消息的间隔，以及消息的最短空闲时间，单位：秒
+	ClaimInterval int `mapstructure:"claim_interval"`
+	ClaimMinIdle  int `mapstructure:"claim_min_idle"`
 }
 
 func (c *QueueConfig) GetRedisOption() *redis.Options {
@@ -69,6 +87,18 @@ func (c *QueueConfig) GetRedisOption() *redis.Options {
 	}
 }
 
+type RabbitConfig struct {
+	Addr string `mapstructure:"addr"` // 为空时不使用 RabbitMQ
+}
+
+// messenger 后台任务进程的配置
+type MessengerConfig struct {
+	HealthAddr        string `mapstructure:"health_addr"`        // 健康检查及指标的监听地址，为空时不监听
+	SearchConcurrency int    `mapstructure:"search_concurrency"` // 同时执行的搜索任务数
+	SearchTimeout     int    `mapstructure:"search_timeout"`     // in second, 单个搜索任务的超时时间
+	SearchUrl         string `mapstructure:"search_url"`         // 搜索页面的地址，%s 替换为关键词
+}
+
 type HubConfig struct {
 	SubProtocols      []string `mapstructure:"sub_protocols"`
 	LiveCheckDuration int64    `mapstructure:"live_check_duration"` // in second
@@ -77,6 +107,12 @@ type HubConfig struct {
 	WriteTimeout      int64    `mapstructure:"write_timeout"`       // in second
 	HandshakeTimeout  int64    `mapstructure:"handshake_timeout"`   // in second
 	EnableCompression bool     `mapstructure:"enable_compression"`
+	PresenceTtl       int64    `mapstructure:"presence_ttl"`     // in second, 在线状态的过期时间，连接会每隔 1/3 的时间续期一次
+	RpcTimeout        int64    `mapstructure:"rpc_timeout"`      // in second, 客户端请求的处理超时时间
+	MailboxTtl        int64    `mapstructure:"mailbox_ttl"`      // in second, 离线消息的保存时间
+	MailboxCap        int64    `mapstructure:"mailbox_cap"`      // 每个用户最多保存的离线消息数
+	WriteQueueSize    int      `mapstructure:"write_queue_size"` // 每个连接的发送队列长度
+	Backpressure      string   `mapstructure:"backpressure"`     // 发送队列满时的策略：block, drop_oldest, drop_newest, disconnect, coalesce
 }
 
 type KeyPair struct {
@@ -84,6 +120,45 @@ type KeyPair struct {
 	PrivateKey string `mapstructure:"pri"`
 }
 
+// 密码哈希的算法及参数；修改后，旧哈希在用户下次登录成功时按新参数重新生成
+type PasswordConfig struct {
+	Algorithm     string `mapstructure:"algorithm"`      // argon2id 或 bcrypt，默认 argon2id
+	Argon2Memory  uint32 `mapstructure:"argon2_memory"`  // in KiB
+	Argon2Time    uint32 `mapstructure:"argon2_time"`    // 迭代次数
+	Argon2Threads uint8  `mapstructure:"argon2_threads"` // 并行度
+	BcryptCost    int    `mapstructure:"bcrypt_cost"`
+	MinLength     int    `mapstructure:"min_length"` // 密码的最小长度
+}
+
+// 短信验证码的发送及验证
+type SmsConfig struct {
+	Provider        string `mapstructure:"provider"` // aliyun 或 log，log 只输出到控制台，用于本地开发
+	AccessKeyId     string `mapstructure:"access_key_id"`
+	AccessKeySecret string `mapstructure:"access_key_secret"`
+	SignName        string `mapstructure:"sign_name"`
+	TemplateCode    string `mapstructure:"template_code"`
+	CodeTtl         int64  `mapstructure:"code_ttl"`          // in second，验证码的有效期
+	MaxAttempts     int64  `mapstructure:"max_attempts"`      // 每个验证码最多验证的次数，超过后作废
+	PhoneCooldown   int64  `mapstructure:"phone_cooldown"`    // in second，同一手机号两次发送的最小间隔
+	IpCooldown      int64  `mapstructure:"ip_cooldown"`       // in second，同一 IP 两次发送的最小间隔
+	PhoneDailyLimit int64  `mapstructure:"phone_daily_limit"` // 同一手机号每天最多发送的次数，0 表示不限制
+	IpDailyLimit    int64  `mapstructure:"ip_daily_limit"`    // 同一 IP 每天最多发送的次数，0 表示不限制
+}
+
+// 登录失败的限制，分别按手机号、IP 及客户端统计
+//
+// 失败次数超过 FreeAttempts 后，下次登录需要等待 BaseDelay，之后每次失败等待时间翻倍，最长 MaxDelay；
+// 达到锁定阈值时锁定 LockDuration，可通过 cli-tool unlock 解除
+type LoginAttemptConfig struct {
+	Window          int64 `mapstructure:"window"`            // in second，失败次数的统计周期，从最后一次失败开始计算
+	FreeAttempts    int64 `mapstructure:"free_attempts"`     // 不需要等待的失败次数
+	BaseDelay       int64 `mapstructure:"base_delay"`        // in second
+	MaxDelay        int64 `mapstructure:"max_delay"`         // in second
+	LockThreshold   int64 `mapstructure:"lock_threshold"`    // 手机号及客户端的锁定阈值
+	IpLockThreshold int64 `mapstructure:"ip_lock_threshold"` // IP 可能被多人共用，阈值应该更大
+	LockDuration    int64 `mapstructure:"lock_duration"`     // in second
+}
+
 type JwtConfig struct {
 	Issuer             string `mapstructure:"issuer"`
 	Secret             string `mapstructure:"secret"`
@@ -93,18 +168,23 @@ type JwtConfig struct {
 	CookieSecure       bool   `mapstructure:"cookie_secure"`
 	CookieHttpOnly     bool   `mapstructure:"cookie_httponly"`
 	CookieSameSiteMode int    `mapstructure:"cookie_same_site_mode"`
+	RefreshReuseGrace  int64  `mapstructure:"refresh_reuse_grace"` // in second，刷新令牌轮换后这段时间内再次使用视为客户端并发刷新，不视为盗用
+	NotifyTokenReuse   bool   `mapstructure:"notify_token_reuse"`  // 检测到刷新令牌被盗用时，是否通过 hub 通知用户
 }
 
 type AuthenticatorConfig struct {
-	BoxKeyPair        KeyPair   `mapstructure:"box_key_pair"`     // 用于加密和解密数据
-	SignKeyPair       KeyPair   `mapstructure:"sign_key_pair"`    // 用于签名和验证数据
-	EnableCrypto      bool      `mapstructure:"enable_crypto"`    // 是否启用加密
-	PathsNeedCrypt    []string  `mapstructure:"paths_need_crypt"` // 如果包含*号，表示所有请求都是加密请求
-	PathsNotCrypt     []string  `mapstructure:"paths_not_crypt"`  // 指定哪些请求不加密，优先级高于 PathsNeedCrypt
-	PathsNeedAuth     []string  `mapstructure:"paths_need_auth"`  // 如果包含*号，表示所有请求都需要认证
-	PathsNotAuth      []string  `mapstructure:"paths_not_auth"`   // 认证排除路径，优先级高于 PathsNeedAuth
-	Jwt               JwtConfig `mapstructure:"jwt"`
-	ReplayMaxInterval int64     `mapstructure:"replay_max_interval"` // in second，超过这个间隔时间的请求会被视为重放请求
+	BoxKeyPair        KeyPair            `mapstructure:"box_key_pair"`     // 用于加密和解密数据
+	SignKeyPair       KeyPair            `mapstructure:"sign_key_pair"`    // 用于签名和验证数据
+	EnableCrypto      bool               `mapstructure:"enable_crypto"`    // 是否启用加密
+	PathsNeedCrypt    []string           `mapstructure:"paths_need_crypt"` // 如果包含*号，表示所有请求都是加密请求
+	PathsNotCrypt     []string           `mapstructure:"paths_not_crypt"`  // 指定哪些请求不加密，优先级高于 PathsNeedCrypt
+	PathsNeedAuth     []string           `mapstructure:"paths_need_auth"`  // 如果包含*号，表示所有请求都需要认证
+	PathsNotAuth      []string           `mapstructure:"paths_not_auth"`   // 认证排除路径，优先级高于 PathsNeedAuth
+	Jwt               JwtConfig          `mapstructure:"jwt"`
+	Password          PasswordConfig     `mapstructure:"password"`
+	Sms               SmsConfig          `mapstructure:"sms"`
+	LoginAttempt      LoginAttemptConfig `mapstructure:"login_attempt"`
+	ReplayMaxInterval int64              `mapstructure:"replay_max_interval"` // in second，超过这个间隔时间的请求会被视为重放请求
 }
 
 type CorsConfig struct {
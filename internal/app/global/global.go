@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"goapp/internal/pkg/features/logging"
+	"goapp/migrations"
 	"goapp/pkg/cache"
 	"goapp/pkg/core"
 	"goapp/pkg/db"
 	"goapp/pkg/distribute"
 	"goapp/pkg/ids"
+	"goapp/pkg/lifecycle"
+	"goapp/pkg/migrate"
+	"goapp/pkg/presence"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -30,6 +34,8 @@ var locker *distribute.Locker
 var queue distribute.MessageQueue
 var appConfig *AppConfig
 var bunDB *bun.DB
+var presenceRegistry presence.Registry
+var lifecycleManager = lifecycle.NewManager()
 
 func Init(ctx context.Context) {
 	mut.Lock()
@@ -78,6 +84,12 @@ func Init(ctx context.Context) {
 	// query.SetDefault(ormdb)
 
 	bunDB = db.MustOpenDB(appConfig.Database.Master, appConfig.Database.Replicas, 10*time.Second)
+	if appConfig.Database.MigrateOnStart {
+		_, err = migrate.NewMigrator(bunDB, migrations.FS, migrate.WithLogger(os.Stdout)).Up(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	cach, err = cache.NewCache(ctx, appConfig.Cache.GetRedisOption(), nil)
 	if err != nil {
@@ -92,13 +104,41 @@ func Init(ctx context.Context) {
 		panic(err)
 	}
 
-	queue, err = distribute.NewRedisMessageQueue(ctx, appConfig.Queue.GetRedisOption(), pool, appConfig.Queue.XAddMaxLen, appConfig.Queue.BatchSize)
+	queue, err = newQueue(ctx, &appConfig.Queue)
 	if err != nil {
 		panic(err)
 	}
 
+	presenceRegistry = presence.NewRedisRegistry(cach, "presence", appConfig.Id, time.Duration(appConfig.Hub.PresenceTtl)*time.Second)
+
 	// 初始化日志系统
 	logging.Start(ctx, appConfig.Name, logging.NewDBStore())
+
+	// 关闭时：先停止消费者，再刷新日志，最后释放资源
+	lifecycleManager.Register("queue consumers", lifecycle.StageWorkers, 10*time.Second, queue.Drain)
+	lifecycleManager.Register("logging", lifecycle.StageFlush, 5*time.Second, logging.Shutdown)
+	lifecycleManager.Register("global resources", lifecycle.StageRelease, 10*time.Second, func(ctx context.Context) error {
+		Release()
+		return nil
+	})
+}
+
+func newQueue(ctx context.Context, config *QueueConfig) (distribute.MessageQueue, error) {
+	options := []distribute.QueueOption{
+		distribute.WithMaxDeliveries(config.MaxDeliveries),
+		distribute.WithRetryBackoff(time.Duration(config.RetryBase)*time.Second, time.Duration(config.RetryMax)*time.Second),
+		distribute.WithClaimIdle(time.Duration(config.ClaimInterval)*time.Second, time.Duration(config.ClaimMinIdle)*time.Second),
+	}
+	switch config.Driver {
+	case "", "redis":
+		// 多个节点中只有一个搬运到期的延迟消息
+		options = append(options, distribute.WithDelayedMover(locker, time.Second))
+		return distribute.NewRedisMessageQueue(ctx, config.GetRedisOption(), pool, config.XAddMaxLen, config.BatchSize, options...)
+	case "memory":
+		return distribute.NewMemoryMessageQueue(pool, config.DataDir, config.XAddMaxLen, config.BatchSize, options...)
+	default:
+		return nil, fmt.Errorf("unknown queue driver: %s", config.Driver)
+	}
 }
 
 func loadConfig() error {
@@ -107,6 +147,10 @@ func loadConfig() error {
 	viper.SetConfigName(fmt.Sprintf("config.%s", env))
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
+	// 多个可执行文件可以通过环境变量 config_dir 共用同一份配置
+	if dir := os.Getenv("config_dir"); len(dir) > 0 {
+		viper.AddConfigPath(dir)
+	}
 	// 读取配置文件
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
@@ -141,11 +185,13 @@ func Release() {
 	}
 	defer released.Store(true)
 
-	bunDB.Close()
+	// 消费者可能仍在使用协程池和数据库，先停止
+	queue.Close()
+	logging.Stop()
 	pool.Release()
+	bunDB.Close()
 	cach.Close()
 	locker.Close()
-	queue.Close()
 }
 
 func DB() *bun.DB {
@@ -164,6 +210,22 @@ func Locker() *distribute.Locker {
 func Queue() distribute.MessageQueue {
 	return queue
 }
+func Presence() presence.Registry {
+	return presenceRegistry
+}
+
+// 关闭时的生命周期管理，各子系统在此注册关闭钩子
+func Lifecycle() *lifecycle.Manager {
+	return lifecycleManager
+}
+
+// 关闭过程的最长时间，默认 30 秒
+func ShutdownTimeout() time.Duration {
+	if appConfig == nil || appConfig.ShutdownTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(appConfig.ShutdownTimeout) * time.Second
+}
 func GetAppConfig() *AppConfig {
 	return appConfig
 }
@@ -22,6 +22,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		claimsValid := svc.IsClaimsValid(c, cc)
 		if claimsValid {
 			claims.SaveClaims(c, cc)
+			authes.NewSessionService().Touch(c, cc)
 		}
 		if isPathNeedAuth(c.Request.URL.Path) && !claimsValid {
 			if err != nil && err != redis.Nil {
@@ -39,7 +39,7 @@ type User struct {
 	ID            ids.UID                `bun:"id,pk" json:"id"`
 	Phone         string                 `bun:"phone,notnull" json:"phone"`
 	Name          string                 `bun:"name,notnull" json:"name"`
-	Password      string                 `bun:"password,notnull" json:"password"`
+	Password      string                 `bun:"password,notnull" json:"-"` // 密码哈希，不参与序列化，也不会写入缓存
 	Role          UserRole               `bun:"role,notnull" json:"role"`
 	Profiles      db.Object[UserProfile] `bun:"profiles" json:"profiles"`
 	Invite        db.Object[UserInvite]  `bun:"invite" json:"invite"`
@@ -14,6 +14,7 @@ type AuthorizedClaims struct {
 	UserAgentHashed string        `json:"userAgentHashed"`
 	ClientId        string        `json:"clientId"`
 	Ip              string        `json:"ip"`
+	FamilyId        string        `json:"familyId,omitempty"` // 一次登录及之后轮换产生的令牌属于同一个令牌族
 }
 
 const (
@@ -9,6 +9,8 @@ const (
 	RespCodeInvalidPhone      RespCode = "invalidPhone"
 	RespCodeInvalidMsgCode    RespCode = "invalidMsgCode"
 	RespCodeInvalidSecureCode RespCode = "invalidSecureCode"
+	RespCodeInvalidPassword   RespCode = "invalidPassword"
+	RespCodeTooFrequent       RespCode = "tooFrequent"
 	RespCodeFailed            RespCode = "fail"
 )
 
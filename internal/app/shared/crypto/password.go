@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"goapp/internal/app/global"
+	"goapp/pkg/cryptos"
+	"sync"
+)
+
+const defaultPasswordMinLength = 8
+
+var passwordHasher = sync.OnceValue(func() *cryptos.PasswordHasher {
+	c := global.AuthConfig().Password
+	if cryptos.PasswordAlgorithm(c.Algorithm) == cryptos.PasswordBcrypt {
+		return cryptos.NewPasswordHasher(cryptos.WithBcrypt(c.BcryptCost))
+	}
+	params := cryptos.DefaultArgon2Params
+	if c.Argon2Memory > 0 {
+		params.Memory = c.Argon2Memory
+	}
+	if c.Argon2Time > 0 {
+		params.Time = c.Argon2Time
+	}
+	if c.Argon2Threads > 0 {
+		params.Threads = c.Argon2Threads
+	}
+	return cryptos.NewPasswordHasher(cryptos.WithArgon2id(params))
+})
+
+// 按配置生成密码哈希的 PasswordHasher
+func PasswordHasher() *cryptos.PasswordHasher {
+	return passwordHasher()
+}
+
+// 用于用户不存在时也验证一次密码，使响应时间与用户存在时相同，避免通过耗时探测手机号是否已注册
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := PasswordHasher().Hash("dummy password")
+	return hash
+})
+
+func DummyPasswordHash() string {
+	return dummyPasswordHash()
+}
+
+// 密码长度是否符合要求；bcrypt 最多使用 72 个字节
+func IsValidPassword(password string) bool {
+	minLen := global.AuthConfig().Password.MinLength
+	if minLen <= 0 {
+		minLen = defaultPasswordMinLength
+	}
+	return len(password) >= minLen && len(password) <= 72
+}
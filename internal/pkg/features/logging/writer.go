@@ -2,8 +2,10 @@ package logging
 
 import (
 	"context"
+	"goapp/pkg/core"
 	"goapp/pkg/ids"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -11,7 +13,11 @@ import (
 
 var store Store
 var writer *Writer
-var writeMu sync.Mutex = sync.Mutex{}
+var writeMu sync.RWMutex = sync.RWMutex{}
+var stopped atomic.Bool
+
+// 停止时刷新缓冲的超时时间
+const stopTimeout = 5 * time.Second
 
 type WriterOptions func(*Writer)
 
@@ -45,6 +51,7 @@ func Start(ctx context.Context, serviceName string, s Store, opts ...WriterOptio
 	if writer != nil {
 		writer.release()
 	}
+	stopped.Store(false)
 
 	writer = &Writer{
 		interval:    time.Second,
@@ -55,24 +62,57 @@ func Start(ctx context.Context, serviceName string, s Store, opts ...WriterOptio
 		opt(writer)
 	}
 	writer.init()
+	ctx, writer.cancel = context.WithCancel(ctx)
 	go writer.listen(ctx)
 }
 
 func Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	Shutdown(ctx)
+}
+
+// 停止写入，并将缓冲中的日志写入存储；ctx 用于限制写入的时间
+//
+// 停止之后记录的日志只输出到控制台
+func Shutdown(ctx context.Context) error {
 	writeMu.Lock()
 	defer writeMu.Unlock()
 
+	var err error
 	if writer != nil {
-		writer.release()
+		err = writer.stop(ctx)
 	}
 	writer = nil
+	stopped.Store(true)
+	return err
 }
 
-func post(log *ServiceLog) {
-	if writer == nil {
+// 持有读锁直到日志放入通道，Start、Shutdown 替换 writer 时不会丢失日志
+//
+// 写循环已退出（比如 Start 的 ctx 被取消）时不再等待通道，改为输出到控制台，
+// 避免一直持有读锁导致 Shutdown 无法获取写锁
+func post(l *ServiceLog) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+
+	w := writer
+	if w == nil {
+		if stopped.Load() {
+			console(l, "logging stopped")
+			return
+		}
 		panic("logging: writer is nil, please call Start() first")
 	}
-	writer.writeCh <- log
+	select {
+	case w.writeCh <- l:
+	case <-w.doneChan:
+		console(l, "logging writer exited")
+	}
+}
+
+func console(l *ServiceLog, reason string) {
+	log.Warn().Str("level", string(l.Level)).Msgf("%s: %s", reason, l.Message)
 }
 
 type Writer struct {
@@ -82,6 +122,7 @@ type Writer struct {
 	writeCh     chan *ServiceLog
 	timer       *time.Timer
 	cancel      context.CancelFunc
+	doneChan    chan core.Empty // 写循环退出后关闭
 	serviceName string
 
 	bufferedLogs []*ServiceLog
@@ -99,20 +140,35 @@ func (w *Writer) init() {
 		w.interval = time.Duration(100 * time.Millisecond)
 	}
 	w.timer = time.NewTimer(w.interval)
+	w.doneChan = make(chan core.Empty)
 }
 
 func (w *Writer) release() {
-	close(w.writeCh)
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	w.stop(ctx)
+}
+
+// 停止写循环，将通道及缓冲中剩余的日志写入存储
+func (w *Writer) stop(ctx context.Context) error {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	select {
+	case <-w.doneChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	w.timer.Stop()
-	w.cancel()
-	w.timer = nil
-	w.writeCh = nil
-	w.cancel = nil
+	for len(w.writeCh) > 0 {
+		w.bufferedLogs = append(w.bufferedLogs, <-w.writeCh)
+	}
+	return w.flush(ctx)
 }
 
-func (w *Writer) flush(ctx context.Context) {
+func (w *Writer) flush(ctx context.Context) error {
 	if len(w.bufferedLogs) == 0 {
-		return
+		return nil
 	}
 
 	for _, log := range w.bufferedLogs {
@@ -121,16 +177,18 @@ func (w *Writer) flush(ctx context.Context) {
 		log.CreatedAt = time.Now()
 	}
 
-	if err := store.WriteMany(ctx, w.bufferedLogs); err != nil {
+	err := store.WriteMany(ctx, w.bufferedLogs)
+	if err != nil {
 		log.Error().Err(err).Msg("logging: failed to write logs")
 	}
 	w.bufferedLogs = w.bufferedLogs[:0]
+	return err
 }
 
 func (w *Writer) listen(ctx context.Context) {
-	ctx, cancel := context.WithCancel(ctx)
-	w.cancel = cancel
-	defer cancel()
+	defer close(w.doneChan)
+	// 停止时 ctx 会被取消，写入中的日志不受影响
+	flushCtx := context.WithoutCancel(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -138,10 +196,11 @@ func (w *Writer) listen(ctx context.Context) {
 		case logData := <-w.writeCh:
 			w.bufferedLogs = append(w.bufferedLogs, logData)
 			if len(w.bufferedLogs) >= w.maxCount {
-				w.flush(ctx)
+				w.flush(flushCtx)
 			}
 		case <-w.timer.C:
-			w.flush(ctx)
+			w.flush(flushCtx)
+			w.timer.Reset(w.interval)
 		}
 	}
 }
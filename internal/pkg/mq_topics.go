@@ -1,8 +1,57 @@
 package pkg
 
+import (
+	"goapp/pkg/distribute"
+	"time"
+)
+
 type MQTopic string
 
 const (
 	MQTopicSearchKeywords         MQTopic = "search_keywords"          // 关键词搜索
 	MQTopicSearchKeywordsProgress MQTopic = "search_keywords_progress" // 关键词搜索进度, progress 为 1 时为结果关键词搜索结果
+	MQTopicUserRegistered         MQTopic = "user_registered"          // 用户注册，经 outbox 发布
 )
+
+// 关键词搜索任务
+type SearchKeywordsMsg struct {
+	TaskId   string `json:"taskId" msgpack:"taskId"`
+	Keywords string `json:"keywords" msgpack:"keywords"`
+	TraceId  string `json:"traceId" msgpack:"traceId"`
+}
+
+// 关键词搜索进度
+type SearchKeywordsProgressMsg struct {
+	TaskId     string         `json:"taskId" msgpack:"taskId"`
+	Progress   float32        `json:"progress" msgpack:"progress"`
+	Status     SearcherStatus `json:"status" msgpack:"status"`
+	StatusText string         `json:"statusText" msgpack:"statusText"`
+	Result     string         `json:"result" msgpack:"result"` // progress 为 1 时有值
+}
+
+// 用户注册
+type UserRegisteredMsg struct {
+	UserId       string    `json:"userId" msgpack:"userId"`
+	Ip           string    `json:"ip" msgpack:"ip"`
+	RegisteredAt time.Time `json:"registeredAt" msgpack:"registeredAt"`
+}
+
+// 消息结构变化时提升版本号，并在 Topic.Upgrade 中注册旧版本的转换
+const (
+	searchKeywordsVersion         = 1
+	searchKeywordsProgressVersion = 1
+	userRegisteredVersion         = 1
+)
+
+func SearchKeywordsTopic(queue distribute.MessageQueue) *distribute.Topic[SearchKeywordsMsg] {
+	return distribute.NewTopic[SearchKeywordsMsg](queue, string(MQTopicSearchKeywords), distribute.MsgPackCodec, searchKeywordsVersion)
+}
+
+func SearchKeywordsProgressTopic(queue distribute.MessageQueue) *distribute.Topic[SearchKeywordsProgressMsg] {
+	return distribute.NewTopic[SearchKeywordsProgressMsg](queue, string(MQTopicSearchKeywordsProgress), distribute.MsgPackCodec, searchKeywordsProgressVersion)
+}
+
+// queue 为 nil 时只能用于编码，比如写入 outbox
+func UserRegisteredTopic(queue distribute.MessageQueue) *distribute.Topic[UserRegisteredMsg] {
+	return distribute.NewTopic[UserRegisteredMsg](queue, string(MQTopicUserRegistered), distribute.MsgPackCodec, userRegisteredVersion)
+}
@@ -1,5 +1,13 @@
 package pkg
 
+import (
+	"context"
+	"fmt"
+	"goapp/pkg/scrapers"
+	"net/url"
+	"time"
+)
+
 type SearcherStatus uint8
 
 const (
@@ -8,3 +16,58 @@ const (
 	SearcherStatusRunning
 	SearcherStatusFinish
 )
+
+// 报告搜索进度，progress 取值 0 ~ 1
+type SearchProgressFunc func(progress float32, statusText string)
+
+// 关键词搜索
+type Searcher interface {
+	// 搜索关键词，返回搜索结果；ctx 结束时应尽快返回
+	Search(ctx context.Context, keywords string, report SearchProgressFunc) (string, error)
+}
+
+type SearcherFunc func(ctx context.Context, keywords string, report SearchProgressFunc) (string, error)
+
+func (f SearcherFunc) Search(ctx context.Context, keywords string, report SearchProgressFunc) (string, error) {
+	return f(ctx, keywords, report)
+}
+
+// 使用无头浏览器打开搜索页面，返回页面的内容
+type SiteSearcher struct {
+	scraper   *scrapers.SiteScraper
+	urlFormat string
+}
+
+// urlFormat 为搜索页面的地址，其中的 %s 替换为转义后的关键词，比如 https://www.bing.com/search?q=%s
+func NewSiteSearcher(urlFormat string, timeout time.Duration) *SiteSearcher {
+	return &SiteSearcher{
+		scraper:   scrapers.NewLinkScraperHeadless(timeout),
+		urlFormat: urlFormat,
+	}
+}
+
+func (s *SiteSearcher) Search(ctx context.Context, keywords string, report SearchProgressFunc) (string, error) {
+	link := fmt.Sprintf(s.urlFormat, url.QueryEscape(keywords))
+	report(0.1, "正在打开搜索页面")
+
+	type result struct {
+		html string
+		err  error
+	}
+	// 抓取受 scraper 的超时时间限制，ctx 结束时不再等待
+	done := make(chan result, 1)
+	go func() {
+		_, html, err := s.scraper.GetPageHtml(link)
+		done <- result{html, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return "", r.err
+		}
+		report(0.9, "正在整理搜索结果")
+		return r.html, nil
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_account_bindings;
+DROP TABLE IF EXISTS user_ips;
+DROP TABLE IF EXISTS users;
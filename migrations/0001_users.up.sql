@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS users (
+    id          uuid PRIMARY KEY,
+    phone       varchar(32) NOT NULL,
+    name        varchar(64) NOT NULL,
+    password    varchar(255) NOT NULL DEFAULT '',
+    role        integer NOT NULL DEFAULT 0,
+    profiles    jsonb,
+    invite      jsonb,
+    status      smallint NOT NULL DEFAULT 0,
+    created_at  timestamptz NOT NULL DEFAULT current_timestamp,
+    updated_at  timestamptz NOT NULL DEFAULT current_timestamp,
+    deleted_at  timestamptz
+);
+
+-- 注册时依赖手机号唯一：INSERT ... ON CONFLICT DO NOTHING；已注销（软删除）的用户不占用手机号
+CREATE UNIQUE INDEX IF NOT EXISTS users_phone_idx ON users (phone) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS user_ips (
+    id          uuid PRIMARY KEY,
+    register    varchar(64) NOT NULL,
+    latest      varchar(64) NOT NULL,
+    created_at  timestamptz,
+    updated_at  timestamptz,
+    deleted_at  timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS user_account_bindings (
+    id            uuid PRIMARY KEY,
+    account_type  integer NOT NULL,
+    account       varchar(255) NOT NULL,
+    status        smallint NOT NULL DEFAULT 0,
+    status_text   varchar(255) NOT NULL DEFAULT '',
+    user_id       uuid NOT NULL,
+    created_at    timestamptz,
+    updated_at    timestamptz,
+    deleted_at    timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_account_bindings_account_idx ON user_account_bindings (account_type, account);
+CREATE INDEX IF NOT EXISTS user_account_bindings_user_id_idx ON user_account_bindings (user_id);
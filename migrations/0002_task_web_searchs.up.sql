@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS task_web_searchs (
+    id           varchar(64) PRIMARY KEY,
+    keywords     text NOT NULL,
+    trace_id     varchar(64) NOT NULL,
+    progress     real NOT NULL DEFAULT 0,
+    status       smallint NOT NULL DEFAULT 0,
+    status_text  text NOT NULL DEFAULT '',
+    result       text,
+    created_at   timestamptz NOT NULL DEFAULT current_timestamp,
+    updated_at   timestamptz NOT NULL DEFAULT current_timestamp,
+    search_at    timestamptz,
+    finish_at    timestamptz
+);
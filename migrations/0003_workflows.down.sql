@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS workflow_session_tasks;
+DROP TABLE IF EXISTS workflow_sessions;
+DROP TABLE IF EXISTS workflows;
@@ -0,0 +1,41 @@
+-- "taskNames" 在模型中为驼峰命名，需要加引号
+CREATE TABLE IF NOT EXISTS workflows (
+    id           uuid PRIMARY KEY,
+    name         varchar(255) NOT NULL,
+    description  text NOT NULL DEFAULT '',
+    "taskNames"  jsonb NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT current_timestamp,
+    updated_at   timestamptz NOT NULL DEFAULT current_timestamp,
+    deleted_at   timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS workflow_sessions (
+    id            uuid PRIMARY KEY,
+    flow_id       uuid NOT NULL,
+    "taskNames"   jsonb NOT NULL,
+    current_task  varchar(255),
+    progress      double precision,
+    input         jsonb,
+    output        jsonb,
+    status        integer,
+    status_text   text,
+    created_at    timestamptz NOT NULL DEFAULT current_timestamp,
+    updated_at    timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS workflow_sessions_flow_id_idx ON workflow_sessions (flow_id);
+
+CREATE TABLE IF NOT EXISTS workflow_session_tasks (
+    id           uuid PRIMARY KEY,
+    task_name    varchar(255) NOT NULL,
+    flow_id      uuid NOT NULL,
+    session_id   uuid NOT NULL,
+    input        jsonb,
+    output       jsonb,
+    status       integer,
+    status_text  text,
+    created_at   timestamptz NOT NULL DEFAULT current_timestamp,
+    updated_at   timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS workflow_session_tasks_session_id_idx ON workflow_session_tasks (session_id);
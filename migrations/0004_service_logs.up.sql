@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS service_logs (
+    id           uuid PRIMARY KEY,
+    service      varchar(64),
+    trace_id     uuid,
+    level        varchar(16),
+    message      text,
+    stack_trace  text,
+    data         jsonb,
+    created_at   timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS service_logs_created_at_idx ON service_logs (created_at);
+CREATE INDEX IF NOT EXISTS service_logs_trace_id_idx ON service_logs (trace_id);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id             bigserial PRIMARY KEY,
+    destination    varchar NOT NULL,
+    topic          varchar NOT NULL,
+    aggregate_key  varchar NOT NULL,
+    seq            bigint NOT NULL,
+    body           bytea NOT NULL,
+    attempts       bigint NOT NULL DEFAULT 0,
+    last_error     varchar NOT NULL DEFAULT '',
+    created_at     timestamptz NOT NULL DEFAULT current_timestamp,
+    available_at   timestamptz NOT NULL DEFAULT current_timestamp,
+    sent_at        timestamptz
+);
+
+-- 聚合内的序号，写入时按序号查找最大值
+CREATE UNIQUE INDEX IF NOT EXISTS outbox_aggregate_seq_idx ON outbox (aggregate_key, seq);
+-- 待发布的消息
+CREATE INDEX IF NOT EXISTS outbox_unsent_idx ON outbox (id) WHERE sent_at IS NULL;
+-- 正在等待重试的聚合
+CREATE INDEX IF NOT EXISTS outbox_retry_idx ON outbox (aggregate_key, available_at) WHERE sent_at IS NULL;
+-- 清理已发布的消息
+CREATE INDEX IF NOT EXISTS outbox_sent_at_idx ON outbox (sent_at) WHERE sent_at IS NOT NULL;
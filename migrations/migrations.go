@@ -0,0 +1,9 @@
+// 数据库迁移脚本，由 pkg/migrate 执行
+//
+// 文件名为 {版本}_{名称}.up.sql 及 {版本}_{名称}.down.sql；已发布的脚本不要修改，结构变化时新增版本
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -77,12 +77,53 @@ func (m *PacketProtocol) EncodeResp(msgType, requestId int32, code byte, payload
 	out = append(out, byte(timestamp>>24&0x00FF), byte(timestamp>>16&0x00FF), byte(timestamp>>8&0x00FF), byte(timestamp&0x00FF))
 	out = append(out, code)
 
-	if len(body) > 0 && m.cryptor != nil {
+	if len(body) > 0 {
+		if m.cryptor != nil {
+			var err error
+			body, err = m.cryptor.Encrypt(body)
+			if err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, body...)
+	}
+
+	if m.signer != nil {
+		signature, err := m.signer.Sign(out)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, signature...)
+	}
+
+	return out, nil
+}
+
+// 按照请求的格式编码，用于服务端主动向客户端发起请求
+func (m *PacketProtocol) EncodeReq(msgType byte, requestId int32, payload any) ([]byte, error) {
+	var body []byte
+	if payload != nil {
 		var err error
-		body, err = m.cryptor.Encrypt(body)
+		body, err = m.marshaler.Marshal(payload)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	timestamp := int32(time.Since(protocolStartTime).Seconds())
+	out := []byte{msgType}
+	out = append(out, byte(requestId>>24&0x00FF), byte(requestId>>16&0x00FF), byte(requestId>>8&0x00FF), byte(requestId&0x00FF))
+	out = append(out, byte(timestamp>>24&0x00FF), byte(timestamp>>16&0x00FF), byte(timestamp>>8&0x00FF), byte(timestamp&0x00FF))
+
+	if len(body) > 0 {
+		if m.cryptor != nil {
+			var err error
+			body, err = m.cryptor.Encrypt(body)
+			if err != nil {
+				return nil, err
+			}
+		}
 		out = append(out, body...)
 	}
 
@@ -99,6 +140,16 @@ func (m *PacketProtocol) EncodeResp(msgType, requestId int32, code byte, payload
 }
 
 func (m *PacketProtocol) DecodeReq(data []byte) (*RequestPacket, error) {
+	var payload any
+	meta, err := m.DecodeReqTo(data, &payload)
+	if err != nil {
+		return nil, err
+	}
+	return &RequestPacket{*meta, payload}, nil
+}
+
+// 解码请求，并将负载解码到 v 中；没有负载时 v 保持不变
+func (m *PacketProtocol) DecodeReqTo(data []byte, v any) (*PacketMetaData, error) {
 	meta, err := m.GetMeta(data)
 	if err != nil {
 		return nil, err
@@ -126,13 +177,10 @@ func (m *PacketProtocol) DecodeReq(data []byte) (*RequestPacket, error) {
 			}
 		}
 
-		var payload any
-		if err = m.marshaler.Unmarshal(body, &payload); err != nil {
+		if err = m.marshaler.Unmarshal(body, v); err != nil {
 			return nil, err
 		}
-
-		return &RequestPacket{*meta, payload}, nil
 	}
 
-	return &RequestPacket{*meta, nil}, nil
+	return meta, nil
 }
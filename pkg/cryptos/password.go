@@ -0,0 +1,166 @@
+package cryptos
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PasswordAlgorithm string
+
+const (
+	PasswordArgon2id PasswordAlgorithm = "argon2id"
+	PasswordBcrypt   PasswordAlgorithm = "bcrypt"
+)
+
+var (
+	ErrPasswordHashFormat = errors.New("invalid password hash format")
+	ErrPasswordTooLong    = errors.New("password too long")
+)
+
+// bcrypt 只使用密码的前 72 个字节，超出时拒绝而不是截断
+const bcryptMaxPasswordLen = 72
+
+var passwordEncoding = base64.RawStdEncoding
+
+// argon2id 的参数，Memory 单位为 KiB
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// OWASP 推荐的最低参数之一：m=19MiB, t=2, p=1
+var DefaultArgon2Params = Argon2Params{Memory: 19 * 1024, Time: 2, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+type PasswordHasherOption func(*PasswordHasher)
+
+// 使用 argon2id 生成哈希，默认算法
+func WithArgon2id(params Argon2Params) PasswordHasherOption {
+	return func(h *PasswordHasher) {
+		h.algorithm = PasswordArgon2id
+		h.argon2 = params
+	}
+}
+
+// 使用 bcrypt 生成哈希，cost 小于 bcrypt.MinCost 时使用 bcrypt.DefaultCost
+func WithBcrypt(cost int) PasswordHasherOption {
+	return func(h *PasswordHasher) {
+		h.algorithm = PasswordBcrypt
+		h.bcryptCost = cost
+		if cost < bcrypt.MinCost {
+			h.bcryptCost = bcrypt.DefaultCost
+		}
+	}
+}
+
+// 密码哈希，哈希值中记录了算法、版本及参数：
+//
+//	argon2id: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//	bcrypt:   $2a$10$<salt+hash>
+//
+// 两种格式都能验证，只用配置的算法及参数生成新的哈希；
+// 验证时如果哈希的算法或参数与当前配置不同，提示调用方用明文密码重新生成哈希
+type PasswordHasher struct {
+	algorithm  PasswordAlgorithm
+	argon2     Argon2Params
+	bcryptCost int
+}
+
+func NewPasswordHasher(options ...PasswordHasherOption) *PasswordHasher {
+	h := &PasswordHasher{
+		algorithm:  PasswordArgon2id,
+		argon2:     DefaultArgon2Params,
+		bcryptCost: bcrypt.DefaultCost,
+	}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+func (h *PasswordHasher) Algorithm() PasswordAlgorithm {
+	return h.algorithm
+}
+
+// 用当前的算法及参数生成哈希
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.algorithm == PasswordBcrypt {
+		if len(password) > bcryptMaxPasswordLen {
+			return "", ErrPasswordTooLong
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(hash), err
+	}
+
+	p := h.argon2
+	salt, err := SecureBytes(int(p.SaltLen))
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", PasswordArgon2id, argon2.Version, p.Memory, p.Time, p.Threads,
+		passwordEncoding.EncodeToString(salt), passwordEncoding.EncodeToString(key)), nil
+}
+
+// 验证密码；ok 为 true 且 rehash 为 true 时，应该用 Hash 重新生成哈希并保存
+func (h *PasswordHasher) Verify(password, encoded string) (ok bool, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$"+string(PasswordArgon2id)+"$"):
+		return h.verifyArgon2id(password, encoded)
+	case strings.HasPrefix(encoded, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, _ := bcrypt.Cost([]byte(encoded))
+		return true, h.algorithm != PasswordBcrypt || cost != h.bcryptCost, nil
+	default:
+		return false, false, ErrPasswordHashFormat
+	}
+}
+
+func (h *PasswordHasher) verifyArgon2id(password, encoded string) (bool, bool, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrPasswordHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, ErrPasswordHashFormat
+	}
+	var p Argon2Params
+	// argon2.IDKey 在 t 或 p 为 0 时会 panic
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil || p.Time == 0 || p.Threads == 0 {
+		return false, false, ErrPasswordHashFormat
+	}
+	salt, err := passwordEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrPasswordHashFormat
+	}
+	key, err := passwordEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, false, ErrPasswordHashFormat
+	}
+	if version != argon2.Version {
+		return false, false, fmt.Errorf("%w: unsupported argon2 version %d", ErrPasswordHashFormat, version)
+	}
+	p.SaltLen, p.KeyLen = uint32(len(salt)), uint32(len(key))
+
+	actual := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return false, false, nil
+	}
+	return true, h.algorithm != PasswordArgon2id || p != h.argon2, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"goapp/pkg/core"
 	"goapp/pkg/ids"
+	"goapp/pkg/metrics"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,6 +34,7 @@ type Locker struct {
 	defaultTtl           time.Duration
 	defaultRetryStrategy RetryStrategy
 	defaultLockTimeout   time.Duration
+	metrics              *lockerMetrics
 }
 
 type LockerOption func(*Locker)
@@ -78,6 +80,9 @@ func NewLocker(ctx context.Context, opt *redis.Options, options ...LockerOption)
 	if l.defaultRetryStrategy == nil {
 		l.defaultRetryStrategy = LinearRetryStrategy(100 * time.Millisecond)
 	}
+	if l.metrics == nil {
+		l.metrics = newLockerMetrics(nil)
+	}
 	return l, nil
 }
 
@@ -146,20 +151,25 @@ func (l *Locker) TryLock(ctx context.Context, resource string, options ...LockOp
 	}
 	ok, err := client.SetNX(ctx, opt.Resource, opt.Owner, opt.Ttl).Result()
 	if err != nil {
+		l.metrics.acquired.Add(1, "trylock", resultFailed)
 		return nil, err
 	}
 	if ok {
+		l.metrics.acquired.Add(1, "trylock", resultOk)
 		lock := &Lock{
-			client:   client,
-			resource: opt.Resource,
-			owner:    opt.Owner,
-			ttl:      opt.Ttl,
+			client:     client,
+			resource:   opt.Resource,
+			owner:      opt.Owner,
+			ttl:        opt.Ttl,
+			metrics:    l.metrics,
+			acquiredAt: time.Now(),
 		}
 		if !opt.disableAutoExtend {
 			lock.autoExtend(context.Background(), opt.Ttl/3*2)
 		}
 		return lock, nil
 	}
+	l.metrics.acquired.Add(1, "trylock", resultBusy)
 	return nil, nil
 }
 
@@ -173,11 +183,23 @@ func (l *Locker) Lock(ctx context.Context, resource string, options ...LockOptio
 	return l.lockWithOptions(ctx, opt)
 }
 
-func (l *Locker) lockWithOptions(ctx context.Context, opt *LockOptions) (*Lock, error) {
+func (l *Locker) lockWithOptions(ctx context.Context, opt *LockOptions) (lock *Lock, err error) {
 	// make sure we don't retry forever
 	ctx, cancel := context.WithTimeout(ctx, opt.lockTimeout)
 	defer cancel()
 
+	start := time.Now()
+	defer func() {
+		result := resultOk
+		if err == ErrLockFailed {
+			result = resultTimeout
+		} else if err != nil {
+			result = resultFailed
+		}
+		l.metrics.acquired.Add(1, "lock", result)
+		metrics.ObserveSince(l.metrics.wait, start, "lock")
+	}()
+
 	minDur := 10 * time.Millisecond
 	for {
 		l.mutex.RLock()
@@ -196,10 +218,12 @@ func (l *Locker) lockWithOptions(ctx context.Context, opt *LockOptions) (*Lock,
 			return nil, err
 		} else if ok {
 			lock := &Lock{
-				client:   client,
-				resource: opt.Resource,
-				owner:    opt.Owner,
-				ttl:      opt.Ttl,
+				client:     client,
+				resource:   opt.Resource,
+				owner:      opt.Owner,
+				ttl:        opt.Ttl,
+				metrics:    l.metrics,
+				acquiredAt: time.Now(),
 			}
 			if !opt.disableAutoExtend {
 				lock.autoExtend(context.Background(), opt.Ttl/3*2)
@@ -246,6 +270,9 @@ type Lock struct {
 	owner    string
 	ttl      time.Duration
 
+	metrics    *lockerMetrics
+	acquiredAt time.Time
+
 	released             atomic.Bool
 	mut                  sync.RWMutex
 	autoExtendCancelFunc context.CancelFunc
@@ -320,6 +347,9 @@ func (l *Lock) Unlock(ctx context.Context) error {
 		return nil
 	}
 	l.released.Store(true)
+	if l.metrics != nil {
+		metrics.ObserveSince(l.metrics.held, l.acquiredAt)
+	}
 
 	fmt.Println("unlocking...")
 
@@ -0,0 +1,58 @@
+package distribute
+
+import (
+	"goapp/pkg/metrics"
+)
+
+type queueMetrics struct {
+	published      metrics.Counter   // 发布的消息数，按结果区分
+	publishLatency metrics.Histogram // 发布耗时
+	consumed       metrics.Counter   // 消费的消息数，按结果区分
+	consumeLatency metrics.Histogram // 处理器的耗时
+	readErrors     metrics.Counter   // 拉取消息失败的次数
+	claimed        metrics.Counter   // 认领的其它消费者遗留的消息数
+	promoted       metrics.Counter   // 到期后写入消息流的延迟消息数
+}
+
+func newQueueMetrics(p metrics.Provider) *queueMetrics {
+	p = metrics.Or(p)
+	return &queueMetrics{
+		published:      p.Counter("mq_published_total", "Messages published to the Redis stream.", "topic", "result"),
+		publishLatency: p.Histogram("mq_publish_seconds", "Time spent publishing a message.", nil, "topic"),
+		consumed:       p.Counter("mq_consumed_total", "Messages handled by consumers.", "topic", "group", "result"),
+		consumeLatency: p.Histogram("mq_consume_seconds", "Time spent in the consume handler.", nil, "topic", "group"),
+		readErrors:     p.Counter("mq_read_errors_total", "Failed reads from the Redis stream.", "topic", "group"),
+		claimed:        p.Counter("mq_claimed_total", "Stale pending messages claimed from other consumers.", "topic", "group"),
+		promoted:       p.Counter("mq_delayed_promoted_total", "Delayed messages moved into the stream when due.", "topic"),
+	}
+}
+
+type lockerMetrics struct {
+	acquired metrics.Counter   // 获取锁的次数，按方法和结果区分
+	wait     metrics.Histogram // 获取锁的等待时间
+	held     metrics.Histogram // 持有锁的时间
+}
+
+func newLockerMetrics(p metrics.Provider) *lockerMetrics {
+	p = metrics.Or(p)
+	return &lockerMetrics{
+		acquired: p.Counter("lock_acquire_total", "Attempts to acquire a distributed lock.", "method", "result"),
+		wait:     p.Histogram("lock_wait_seconds", "Time spent waiting to acquire a distributed lock.", nil, "method"),
+		held:     p.Histogram("lock_held_seconds", "Time a distributed lock was held.", nil),
+	}
+}
+
+// 操作结果的标签值
+const (
+	resultOk      = "ok"
+	resultFailed  = "failed"
+	resultBusy    = "busy"    // TryLock 时锁已被占用
+	resultTimeout = "timeout" // Lock 超时
+)
+
+// 设置指标的提供者，未设置时使用 metrics.Default()
+func WithLockerMetrics(p metrics.Provider) LockerOption {
+	return func(opt *Locker) {
+		opt.metrics = newLockerMetrics(p)
+	}
+}
@@ -2,8 +2,12 @@ package distribute
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"goapp/pkg/core"
+	"goapp/pkg/metrics"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,39 +18,108 @@ type ConsumeMsgHandler func(ctx context.Context, id string, msg map[string]any)
 
 type MessageQueue interface {
 	Publish(ctx context.Context, topic string, body map[string]any) error
+	// 在指定的时间发布消息，消费者与普通消息一样通过 Subscribe 接收
+	PublishAt(ctx context.Context, topic string, body map[string]any, at time.Time) error
+	// 延迟 delay 之后发布消息
+	PublishAfter(ctx context.Context, topic string, body map[string]any, delay time.Duration) error
 	Subscribe(ctx context.Context, topic, group, consumer string, handler ConsumeMsgHandler) error
+	// 停止所有消费者，并等待处理中的消息处理完毕
+	Drain(ctx context.Context) error
 	Close()
 }
 
+var ErrQueueClosed = errors.New("queue closed")
+
+// 阻塞等待新消息的最长时间，超时后检查消费者是否需要退出
+const consumeBlock = 2 * time.Second
+
 type RedisMessageQueue struct {
 	client     *redis.Client      // Redis连接
 	pool       core.CoroutinePool // 协程池
 	xaddMaxLen int                // 发布消息时XAddArgs中MaxLen的值
 	batchSize  int                // 消费消息时每次批量获取一批的大小
 	closeChan  chan core.Empty
+	metrics    *queueMetrics
+	closeOnce  sync.Once
+	consumers  sync.WaitGroup // 运行中的消费者
+	lastErrors lastErrors
+
+	queueOptions
 }
 
-func NewRedisMessageQueue(ctx context.Context, opt *redis.Options, pool core.CoroutinePool, xaddMaxLen, batchSize int) (*RedisMessageQueue, error) {
+func NewRedisMessageQueue(ctx context.Context, opt *redis.Options, pool core.CoroutinePool, xaddMaxLen, batchSize int, options ...QueueOption) (*RedisMessageQueue, error) {
 	client := redis.NewClient(opt)
 	_, err := client.Ping(ctx).Result()
 	if err != nil {
 		return nil, err
 	}
-	return &RedisMessageQueue{client, pool, xaddMaxLen, batchSize, make(chan core.Empty)}, nil
+	m := &RedisMessageQueue{
+		client:       client,
+		pool:         pool,
+		xaddMaxLen:   xaddMaxLen,
+		batchSize:    batchSize,
+		closeChan:    make(chan core.Empty),
+		metrics:      newQueueMetrics(nil),
+		queueOptions: newQueueOptions(options),
+	}
+
+	// 搬运到期的延迟消息
+	m.consumers.Add(1)
+	err = pool.Submit(func() {
+		defer m.consumers.Done()
+		m.delayedMoverLoop()
+	})
+	if err != nil {
+		m.consumers.Done()
+		client.Close()
+		return nil, err
+	}
+	return m, nil
 }
 
 func (m *RedisMessageQueue) Close() {
 	if m.client == nil {
 		return
 	}
-	m.closeChan <- core.Empty{}
-	time.Sleep(500 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m.Drain(ctx)
 	m.client.Close()
 	m.client = nil
 }
 
+// 停止所有消费者，并等待处理中的消息处理完毕，ctx 结束时不再等待
+//
+// 已拉取但未处理的消息没有 ACK，会留在 Pending 列表中等待再次投递
+func (m *RedisMessageQueue) Drain(ctx context.Context) error {
+	m.closeOnce.Do(func() {
+		close(m.closeChan)
+	})
+	done := make(chan core.Empty)
+	go func() {
+		m.consumers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *RedisMessageQueue) closed() bool {
+	select {
+	case <-m.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
 // 发布消息
 func (m *RedisMessageQueue) Publish(ctx context.Context, topic string, body map[string]any) error {
+	start := time.Now()
 	res := m.client.XAdd(ctx, &redis.XAddArgs{
 		Stream: topic,
 		MaxLen: int64(m.xaddMaxLen),
@@ -54,42 +127,68 @@ func (m *RedisMessageQueue) Publish(ctx context.Context, topic string, body map[
 		ID:     "*", // 让Redis生成时间戳和序列号
 		Values: body,
 	})
-	return res.Err()
+	metrics.ObserveSince(m.metrics.publishLatency, start, topic)
+	if res.Err() != nil {
+		m.metrics.published.Add(1, topic, resultFailed)
+		return res.Err()
+	}
+	m.metrics.published.Add(1, topic, resultOk)
+	return nil
 }
 
 // 开启协程后台消费。返回值代表消费过程中遇到的无法处理的错误
 // group 消费者组，一般为当前服务的名称
 // consumer 消费者组里的消费者，一般为一个uuid
 // handler 消费消息的处理器，如果返回nil，则表示消息被成功消费，如果返回非nil，则表示消息被消费失败，需要重试
+//
+// 失败的消息按指数退避重试，投递次数达到上限后转入死信队列；其它消费者遗留的消息空闲一段时间后会被认领并重新投递
 func (m *RedisMessageQueue) Subscribe(ctx context.Context, topic, group, consumer string, handler ConsumeMsgHandler) error {
+	if m.closed() {
+		return ErrQueueClosed
+	}
 	res := m.client.XGroupCreateMkStream(ctx, topic, group, "0") // start 用于创建消费者组的时候指定起始消费ID，0表示从头开始消费，$表示从最后一条消息开始消费
 	err := res.Err()
 	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
 		return err
 	}
-	return m.pool.Submit(func() {
+	m.consumers.Add(1)
+	err = m.pool.Submit(func() {
+		defer m.consumers.Done()
 		for {
 			select {
-			case _, ok := <-m.closeChan:
-				if ok {
-					return
-				}
-			case _, ok := <-ctx.Done():
-				if ok {
-					return
-				}
+			case <-m.closeChan:
+				return
+			case <-ctx.Done():
+				return
 			default:
-				// 拉取新消息
-				if err := m.consume(ctx, topic, group, consumer, ">", m.batchSize, handler); err != nil {
+				// 拉取新消息，没有新消息时阻塞一段时间后返回 redis.Nil
+				err := m.consume(ctx, topic, group, consumer, ">", m.batchSize, handler)
+				if err != nil && err != redis.Nil {
+					// Redis 不可用时，避免空转
+					time.Sleep(consumeBlock)
 					continue
 				}
-				// 拉取已经投递却未被ACK的消息，保证消息至少被成功消费1次
-				if err := m.consume(ctx, topic, group, consumer, "0", m.batchSize, handler); err != nil {
-					continue
+				// 重试已经投递却未被ACK的消息，保证消息至少被成功消费1次
+				if err := m.retryPending(ctx, topic, group, consumer, handler); err != nil {
+					fmt.Printf("[MQ] retry pending failed, topic: %s, group: %s, err: %v\n", topic, group, err)
 				}
 			}
 		}
 	})
+	if err != nil {
+		m.consumers.Done()
+		return err
+	}
+	// 认领其它消费者遗留的消息
+	m.consumers.Add(1)
+	err = m.pool.Submit(func() {
+		defer m.consumers.Done()
+		m.claimLoop(ctx, topic, group, consumer)
+	})
+	if err != nil {
+		m.consumers.Done()
+	}
+	return err
 }
 
 func (m *RedisMessageQueue) consume(ctx context.Context, topic, group, consumer, id string, batchSize int, h ConsumeMsgHandler) error {
@@ -99,31 +198,24 @@ func (m *RedisMessageQueue) consume(ctx context.Context, topic, group, consumer,
 		Consumer: consumer,
 		Streams:  []string{topic, id},
 		Count:    int64(batchSize),
+		Block:    consumeBlock,
 		NoAck:    false,
 	}).Result()
 	if err != nil {
+		if err != redis.Nil {
+			m.metrics.readErrors.Add(1, topic, group)
+		}
 		return err
 	}
 	// 处理消息
 	for _, msg := range result[0].Messages {
 		select {
-		case _, ok := <-m.closeChan:
-			if ok {
-				return nil
-			}
-		case _, ok := <-ctx.Done():
-			if ok {
-				return nil
-			}
+		case <-m.closeChan:
+			return nil
+		case <-ctx.Done():
+			return nil
 		default:
-			err := h(ctx, msg.ID, msg.Values)
-			if err != nil {
-				continue
-			}
-			err = m.client.XAck(ctx, topic, group, msg.ID).Err()
-			if err != nil {
-				continue
-			}
+			m.handle(ctx, topic, group, msg, h)
 		}
 	}
 	return nil
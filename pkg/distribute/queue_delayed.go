@@ -0,0 +1,247 @@
+package distribute
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"goapp/pkg/ids"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// 延迟消息的有序集合，score 为投递时间（毫秒）
+	delayedKeyPrefix = "mq:delayed:"
+	// 有延迟消息的主题
+	delayedTopicsKey = "mq:delayed-topics"
+	// 负责搬运延迟消息的节点持有的锁
+	delayedMoverLock = "mq:delayed-mover"
+
+	defaultDelayedInterval = time.Second
+	// 每个主题每次最多搬运的消息数
+	delayedBatchSize = 100
+)
+
+// 将到期的延迟消息写入消息流，并从有序集合中删除；在同一个脚本中执行，保证每条消息只投递一次
+//
+// 消息的格式见 encodeDelayed；有序集合为空时将主题移出 delayedTopicsKey，PublishAt 会重新加入
+var luaPromoteDelayed = redis.NewScript(`
+local function field(item, pos)
+	local colon = string.find(item, ':', pos, true)
+	local len = tonumber(string.sub(item, pos, colon - 1))
+	return string.sub(item, colon + 1, colon + len), colon + len + 1
+end
+
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, item in ipairs(items) do
+	local args = {'XADD', KEYS[2]}
+	if ARGV[3] ~= '0' then
+		table.insert(args, 'MAXLEN')
+		table.insert(args, '~')
+		table.insert(args, ARGV[3])
+	end
+	table.insert(args, '*')
+	local _, pos = field(item, 1)
+	while pos <= #item do
+		local v
+		v, pos = field(item, pos)
+		table.insert(args, v)
+	end
+	redis.call(unpack(args))
+	redis.call('ZREM', KEYS[1], item)
+end
+if redis.call('ZCARD', KEYS[1]) == 0 then
+	redis.call('SREM', KEYS[3], ARGV[4])
+end
+return #items
+`)
+
+// 设置搬运延迟消息的间隔，以及用于选出搬运节点的锁；默认每秒一次
+//
+// 仅用于 RedisMessageQueue。locker 为 nil 时每个节点都会搬运，脚本的原子性同样保证每条消息只投递一次，只是会增加 Redis 的负载
+func WithDelayedMover(locker *Locker, interval time.Duration) QueueOption {
+	return func(o *queueOptions) {
+		o.delayedLocker = locker
+		if interval > 0 {
+			o.delayedInterval = interval
+		}
+	}
+}
+
+// 将延迟消息编码为有序集合的成员：id 及各字段名、值依次以「长度:内容」的格式拼接，可以保存任意二进制内容
+//
+// id 保证内容相同的消息不会被有序集合合并
+func encodeDelayed(id string, body map[string]any) string {
+	var b strings.Builder
+	writeField := func(s string) {
+		b.WriteString(strconv.Itoa(len(s)))
+		b.WriteByte(':')
+		b.WriteString(s)
+	}
+	writeField(id)
+	for k, v := range body {
+		writeField(k)
+		writeField(formatValue(v))
+	}
+	return b.String()
+}
+
+// 与 go-redis 写入参数时的格式保持一致，投递后消费者收到的内容与 Publish 相同
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.FormatInt(int64(v), 10)
+	case int8:
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10)
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// 在指定的时间发布消息；时间已过时立即发布
+func (m *RedisMessageQueue) PublishAt(ctx context.Context, topic string, body map[string]any, at time.Time) error {
+	if len(body) == 0 {
+		return errors.New("empty message body")
+	}
+	if !at.After(time.Now()) {
+		return m.Publish(ctx, topic, body)
+	}
+	member := encodeDelayed(ids.NewUUID(), body)
+	_, err := m.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, delayedKeyPrefix+topic, redis.Z{Score: float64(at.UnixMilli()), Member: member})
+		pipe.SAdd(ctx, delayedTopicsKey, topic)
+		return nil
+	})
+	if err != nil {
+		m.metrics.published.Add(1, topic, resultFailed)
+		return err
+	}
+	m.metrics.published.Add(1, topic, "delayed")
+	return nil
+}
+
+// 延迟 delay 之后发布消息
+func (m *RedisMessageQueue) PublishAfter(ctx context.Context, topic string, body map[string]any, delay time.Duration) error {
+	return m.PublishAt(ctx, topic, body, time.Now().Add(delay))
+}
+
+// 主题中等待发布的延迟消息数
+func (m *RedisMessageQueue) DelayedCount(ctx context.Context, topic string) (int64, error) {
+	return m.client.ZCard(ctx, delayedKeyPrefix+topic).Result()
+}
+
+// 定期将到期的延迟消息搬运到消息流中；使用 Locker 时，同一时间只有持有锁的节点搬运
+func (m *RedisMessageQueue) delayedMoverLoop() {
+	ticker := time.NewTicker(m.delayedInterval)
+	defer ticker.Stop()
+
+	var lock *Lock
+	defer func() {
+		if lock != nil {
+			lock.Unlock(context.Background())
+		}
+	}()
+	for {
+		select {
+		case <-m.closeChan:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.delayedInterval*5)
+		if m.delayedLocker != nil {
+			lock = m.holdMoverLock(ctx, lock)
+			if lock == nil {
+				cancel()
+				continue
+			}
+		}
+		if err := m.promoteDelayed(ctx); err != nil {
+			fmt.Printf("[MQ] promote delayed messages failed, err: %v\n", err)
+		}
+		cancel()
+	}
+}
+
+// 获取或续期搬运锁，返回 nil 表示其它节点正在搬运
+func (m *RedisMessageQueue) holdMoverLock(ctx context.Context, lock *Lock) *Lock {
+	if lock != nil {
+		if lock.Extend(ctx) == nil {
+			return lock
+		}
+		lock.Unlock(ctx)
+	}
+	// 锁的有效期覆盖几次搬运，持有者崩溃后其它节点很快接手
+	lock, err := m.delayedLocker.TryLock(ctx, delayedMoverLock, LockWithTtl(m.delayedInterval*5), LockWithDisableAutoExtend())
+	if err != nil {
+		fmt.Printf("[MQ] acquire delayed mover lock failed, err: %v\n", err)
+		return nil
+	}
+	return lock
+}
+
+func (m *RedisMessageQueue) promoteDelayed(ctx context.Context) error {
+	topics, err := m.client.SMembers(ctx, delayedTopicsKey).Result()
+	if err != nil {
+		return err
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	for _, topic := range topics {
+		for {
+			n, err := luaPromoteDelayed.Run(ctx, m.client, []string{delayedKeyPrefix + topic, topic, delayedTopicsKey}, now, delayedBatchSize, m.xaddMaxLen, topic).Int()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				m.metrics.promoted.Add(float64(n), topic)
+			}
+			if n < delayedBatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,717 @@
+package distribute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapp/pkg/core"
+	"goapp/pkg/ids"
+	"goapp/pkg/metrics"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 进程内的消息队列，与 RedisMessageQueue 的语义相同：
+//   - 每个消费者组从主题的第一条消息开始消费，组内的消费者分摊消息，每次最多拉取 batchSize 条
+//   - 处理成功后确认；失败的消息按指数退避重试，投递次数达到上限后转入死信队列
+//   - 组内其它消费者遗留的消息空闲超过 claimMinIdle 后被认领
+//   - 消息的内容按 go-redis 的格式转为字符串，消费者收到的内容与 Redis 相同
+//
+// 用于单元测试及不依赖外部服务的单节点部署；需要在重启后保留消息时使用磁盘模式
+type MemoryMessageQueue struct {
+	pool       core.CoroutinePool // 协程池，为 nil 时直接启动协程
+	xaddMaxLen int                // 每个主题保留的消息数，为 0 时不限制
+	batchSize  int                // 消费消息时每次批量获取一批的大小
+	closeChan  chan core.Empty
+	metrics    *queueMetrics
+	closeOnce  sync.Once
+	consumers  sync.WaitGroup // 运行中的消费者
+	lastErrors lastErrors
+
+	mu      sync.Mutex
+	streams map[string]*memStream
+	delayed map[string]*memDelayed // key: 延迟消息的 id
+	log     *memLog                // 磁盘模式的日志，为 nil 时仅保存在内存中
+
+	queueOptions
+}
+
+// dir 不为空时使用磁盘模式：消息、消费进度及延迟消息以追加日志的形式写入该目录，重启后恢复
+//
+// 日志在打开时以及追加的记录达到阈值（见 WithLogCompaction）时压缩；同一个目录只能由一个进程使用
+func NewMemoryMessageQueue(pool core.CoroutinePool, dir string, xaddMaxLen, batchSize int, options ...QueueOption) (*MemoryMessageQueue, error) {
+	m := &MemoryMessageQueue{
+		pool:         pool,
+		xaddMaxLen:   xaddMaxLen,
+		batchSize:    max(batchSize, 1),
+		closeChan:    make(chan core.Empty),
+		metrics:      newQueueMetrics(nil),
+		streams:      make(map[string]*memStream),
+		delayed:      make(map[string]*memDelayed),
+		queueOptions: newQueueOptions(options),
+	}
+	if len(dir) > 0 {
+		log, err := openMemLog(dir, m.apply, m.snapshot, m.logCompactRecords, m.logCompactSize)
+		if err != nil {
+			return nil, err
+		}
+		m.log = log
+	}
+	m.mu.Lock()
+	for _, d := range m.delayed {
+		m.schedule(d)
+	}
+	m.mu.Unlock()
+	return m, nil
+}
+
+// 消息流中的 id，格式与 Redis 相同：毫秒时间戳-序号
+type streamId struct {
+	ms, seq uint64
+}
+
+func parseStreamId(s string) (streamId, error) {
+	msPart, seqPart, _ := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamId{}, fmt.Errorf("invalid stream id: %s", s)
+	}
+	var seq uint64
+	if len(seqPart) > 0 {
+		seq, err = strconv.ParseUint(seqPart, 10, 64)
+		if err != nil {
+			return streamId{}, fmt.Errorf("invalid stream id: %s", s)
+		}
+	}
+	return streamId{ms, seq}, nil
+}
+
+func (id streamId) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamId) less(other streamId) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+type memEntry struct {
+	id   streamId
+	body map[string]string
+}
+
+// 投递给消费者的消息，每次投递都是一份新的拷贝
+func (e *memEntry) message() redis.XMessage {
+	values := make(map[string]any, len(e.body))
+	for k, v := range e.body {
+		values[k] = v
+	}
+	return redis.XMessage{ID: e.id.String(), Values: values}
+}
+
+type memStream struct {
+	entries []*memEntry // 按 id 排序
+	lastId  streamId    // 最后生成的 id
+	groups  map[string]*memGroup
+	notify  chan core.Empty // 有新消息时关闭，唤醒等待的消费者
+}
+
+type memGroup struct {
+	lastId  streamId // 最后投递的消息 id
+	pending map[streamId]*memPending
+}
+
+// 已投递未确认的消息
+type memPending struct {
+	consumer    string
+	deliveries  int64
+	deliveredAt time.Time
+}
+
+type memDelayed struct {
+	id    string
+	topic string
+	at    time.Time
+	body  map[string]string
+	timer *time.Timer
+}
+
+// 以下方法的调用方需要持有 m.mu
+
+func (m *MemoryMessageQueue) stream(topic string) *memStream {
+	s, ok := m.streams[topic]
+	if !ok {
+		s = &memStream{groups: make(map[string]*memGroup), notify: make(chan core.Empty)}
+		m.streams[topic] = s
+	}
+	return s
+}
+
+func (s *memStream) group(name string) *memGroup {
+	g, ok := s.groups[name]
+	if !ok {
+		g = &memGroup{pending: make(map[streamId]*memPending)}
+		s.groups[name] = g
+	}
+	return g
+}
+
+func (s *memStream) nextId(now time.Time) streamId {
+	ms := uint64(now.UnixMilli())
+	if ms > s.lastId.ms {
+		return streamId{ms, 0}
+	}
+	return streamId{s.lastId.ms, s.lastId.seq + 1}
+}
+
+// 追加消息；与 MAXLEN ~ 一样，超出一定数量后才裁剪，避免每次都移动数组
+func (s *memStream) append(e *memEntry, maxLen int) {
+	s.entries = append(s.entries, e)
+	s.lastId = e.id
+	if maxLen > 0 && len(s.entries) > maxLen+maxLen/10 {
+		s.entries = slices.Clone(s.entries[len(s.entries)-maxLen:])
+	}
+	close(s.notify)
+	s.notify = make(chan core.Empty)
+}
+
+// 第一条 id 不小于 id 的消息的位置
+func (s *memStream) search(id streamId) int {
+	return sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].id.less(id) })
+}
+
+func (s *memStream) find(id streamId) *memEntry {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].id == id {
+		return s.entries[i]
+	}
+	return nil
+}
+
+func (s *memStream) remove(id streamId) bool {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].id == id {
+		s.entries = slices.Delete(s.entries, i, i+1)
+		return true
+	}
+	return false
+}
+
+func (m *MemoryMessageQueue) write(r *memRecord) error {
+	if m.log == nil {
+		return nil
+	}
+	return m.log.write(r)
+}
+
+// 写入日志失败时，内存中的状态仍然有效，只是重启后可能重复投递
+func (m *MemoryMessageQueue) writeOrWarn(r *memRecord) {
+	if err := m.write(r); err != nil {
+		fmt.Printf("[MQ] write queue log failed, op: %s, topic: %s, id: %s, err: %v\n", r.Op, r.Topic, r.Id, err)
+	}
+}
+
+func (m *MemoryMessageQueue) add(topic string, body map[string]string) error {
+	s := m.stream(topic)
+	e := &memEntry{s.nextId(time.Now()), body}
+	if err := m.write(&memRecord{Op: memOpAdd, Topic: topic, Id: e.id.String(), Body: body}); err != nil {
+		return err
+	}
+	s.append(e, m.xaddMaxLen)
+	return nil
+}
+
+func (m *MemoryMessageQueue) deliver(topic, group string, id streamId, p *memPending) {
+	m.writeOrWarn(&memRecord{Op: memOpDeliver, Topic: topic, Group: group, Id: id.String(), Consumer: p.consumer, Deliveries: p.deliveries})
+}
+
+func (m *MemoryMessageQueue) ack(topic, group string, g *memGroup, id streamId) {
+	if _, ok := g.pending[id]; !ok {
+		return
+	}
+	delete(g.pending, id)
+	m.writeOrWarn(&memRecord{Op: memOpAck, Topic: topic, Group: group, Id: id.String()})
+}
+
+func (m *MemoryMessageQueue) submit(task func()) error {
+	if m.pool == nil {
+		go task()
+		return nil
+	}
+	return m.pool.Submit(task)
+}
+
+func (m *MemoryMessageQueue) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m.Drain(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.delayed {
+		d.timer.Stop()
+	}
+	if m.log != nil {
+		m.log.close()
+		m.log = nil
+	}
+}
+
+// 停止所有消费者，并等待处理中的消息处理完毕，ctx 结束时不再等待
+//
+// 已拉取但未处理的消息没有确认，会留在待确认列表中等待再次投递；之后到期的延迟消息不再发布
+func (m *MemoryMessageQueue) Drain(ctx context.Context) error {
+	m.closeOnce.Do(func() {
+		close(m.closeChan)
+	})
+	done := make(chan core.Empty)
+	go func() {
+		m.consumers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *MemoryMessageQueue) closed() bool {
+	select {
+	case <-m.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func formatBody(body map[string]any) map[string]string {
+	values := make(map[string]string, len(body))
+	for k, v := range body {
+		values[k] = formatValue(v)
+	}
+	return values
+}
+
+// 发布消息
+func (m *MemoryMessageQueue) Publish(ctx context.Context, topic string, body map[string]any) error {
+	if len(body) == 0 {
+		return errors.New("empty message body")
+	}
+	start := time.Now()
+	values := formatBody(body)
+	m.mu.Lock()
+	err := m.add(topic, values)
+	m.mu.Unlock()
+	metrics.ObserveSince(m.metrics.publishLatency, start, topic)
+	if err != nil {
+		m.metrics.published.Add(1, topic, resultFailed)
+		return err
+	}
+	m.metrics.published.Add(1, topic, resultOk)
+	return nil
+}
+
+// 开启协程后台消费，参数及重试规则与 RedisMessageQueue.Subscribe 相同
+func (m *MemoryMessageQueue) Subscribe(ctx context.Context, topic, group, consumer string, handler ConsumeMsgHandler) error {
+	if m.closed() {
+		return ErrQueueClosed
+	}
+	m.mu.Lock()
+	s := m.stream(topic)
+	if _, ok := s.groups[group]; !ok {
+		if err := m.write(&memRecord{Op: memOpGroup, Topic: topic, Group: group}); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		s.group(group)
+	}
+	m.mu.Unlock()
+
+	m.consumers.Add(1)
+	err := m.submit(func() {
+		defer m.consumers.Done()
+		m.consumeLoop(ctx, topic, group, consumer, handler)
+	})
+	if err != nil {
+		m.consumers.Done()
+	}
+	return err
+}
+
+func (m *MemoryMessageQueue) consumeLoop(ctx context.Context, topic, group, consumer string, h ConsumeMsgHandler) {
+	lastClaim := time.Now()
+	for {
+		select {
+		case <-m.closeChan:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// 拉取新消息，没有新消息时阻塞一段时间
+		msgs, notify, wait := m.read(topic, group, consumer)
+		if len(msgs) == 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-notify:
+			case <-timer.C:
+			case <-m.closeChan:
+			case <-ctx.Done():
+			}
+			timer.Stop()
+		}
+		for _, msg := range msgs {
+			if m.closed() || ctx.Err() != nil {
+				return
+			}
+			m.handle(ctx, topic, group, msg, h)
+		}
+
+		// 认领其它消费者遗留的消息
+		if time.Since(lastClaim) >= m.claimInterval {
+			lastClaim = time.Now()
+			m.lastErrors.prune()
+			m.claim(topic, group, consumer)
+		}
+		// 重试已经投递却未被确认的消息，保证消息至少被成功消费1次
+		m.retryPending(ctx, topic, group, consumer, h)
+	}
+}
+
+// 拉取组内还未投递的消息，同时返回有新消息时的通知，以及没有新消息时等待的时间：
+// 最长为 consumeBlock，有消息需要重试时等到重试的时间
+func (m *MemoryMessageQueue) read(topic, group, consumer string) ([]redis.XMessage, <-chan core.Empty, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stream(topic)
+	g := s.group(group)
+	i := s.search(g.lastId)
+	if i < len(s.entries) && s.entries[i].id == g.lastId {
+		i++
+	}
+	entries := s.entries[i:min(i+m.batchSize, len(s.entries))]
+	msgs := make([]redis.XMessage, 0, len(entries))
+	now := time.Now()
+	for _, e := range entries {
+		p := &memPending{consumer: consumer, deliveries: 1, deliveredAt: now}
+		g.pending[e.id] = p
+		g.lastId = e.id
+		m.deliver(topic, group, e.id, p)
+		msgs = append(msgs, e.message())
+	}
+
+	wait := consumeBlock
+	for _, p := range g.pending {
+		if p.consumer != consumer {
+			continue
+		}
+		if p.deliveries >= m.maxDeliveries {
+			wait = 0
+			break
+		}
+		wait = min(wait, time.Until(p.deliveredAt.Add(m.retryDelay(p.deliveries))))
+	}
+	return msgs, s.notify, max(wait, 0)
+}
+
+// 处理一条消息：成功时确认，失败时记录错误，等待重试
+func (m *MemoryMessageQueue) handle(ctx context.Context, topic, group string, msg redis.XMessage, h ConsumeMsgHandler) {
+	start := time.Now()
+	err := h(ctx, msg.ID, msg.Values)
+	metrics.ObserveSince(m.metrics.consumeLatency, start, topic, group)
+	if err != nil {
+		m.metrics.consumed.Add(1, topic, group, resultFailed)
+		m.lastErrors.record(topic, group, msg.ID, err)
+		return
+	}
+	id, _ := parseStreamId(msg.ID)
+	m.mu.Lock()
+	m.ack(topic, group, m.stream(topic).group(group), id)
+	m.mu.Unlock()
+	m.lastErrors.forget(topic, group, msg.ID)
+	m.metrics.consumed.Add(1, topic, group, resultOk)
+}
+
+// 本消费者待确认的消息，按 id 排序，最多 batchSize 条
+func (m *MemoryMessageQueue) ownPending(g *memGroup, consumer string) []streamId {
+	pending := make([]streamId, 0)
+	for id, p := range g.pending {
+		if p.consumer == consumer {
+			pending = append(pending, id)
+		}
+	}
+	slices.SortFunc(pending, func(a, b streamId) int {
+		if a.less(b) {
+			return -1
+		}
+		if b.less(a) {
+			return 1
+		}
+		return 0
+	})
+	return pending[:min(len(pending), m.batchSize)]
+}
+
+// 重新投递本消费者处理失败的消息：达到重试间隔的重新处理，投递次数达到上限的转入死信队列
+func (m *MemoryMessageQueue) retryPending(ctx context.Context, topic, group, consumer string, h ConsumeMsgHandler) {
+	type deadMsg struct {
+		id         streamId
+		deliveries int64
+	}
+	var dead []deadMsg
+	var retry []redis.XMessage
+
+	m.mu.Lock()
+	s := m.stream(topic)
+	g := s.group(group)
+	now := time.Now()
+	for _, id := range m.ownPending(g, consumer) {
+		p := g.pending[id]
+		if p.deliveries >= m.maxDeliveries {
+			dead = append(dead, deadMsg{id, p.deliveries})
+			continue
+		}
+		if now.Sub(p.deliveredAt) < m.retryDelay(p.deliveries) {
+			continue
+		}
+		e := s.find(id)
+		// 消息已被 MaxLen 裁剪
+		if e == nil {
+			m.ack(topic, group, g, id)
+			continue
+		}
+		p.deliveries++
+		p.deliveredAt = now
+		m.deliver(topic, group, id, p)
+		retry = append(retry, e.message())
+	}
+	m.mu.Unlock()
+
+	for _, d := range dead {
+		if err := m.deadLetter(topic, group, d.id, d.deliveries); err != nil {
+			fmt.Printf("[MQ] dead letter failed, topic: %s, group: %s, id: %s, err: %v\n", topic, group, d.id, err)
+		}
+	}
+	for _, msg := range retry {
+		if m.closed() || ctx.Err() != nil {
+			return
+		}
+		m.handle(ctx, topic, group, msg, h)
+	}
+}
+
+// 将消息转入死信队列，并从消费者组中确认
+func (m *MemoryMessageQueue) deadLetter(topic, group string, id streamId, deliveries int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stream(topic)
+	// 消息已被裁剪，无法保存
+	if e := s.find(id); e != nil {
+		values := make(map[string]string, len(e.body)+6)
+		for k, v := range e.body {
+			values[k] = v
+		}
+		values[deadLetterFieldPrefix+"id"] = id.String()
+		values[deadLetterFieldPrefix+"topic"] = topic
+		values[deadLetterFieldPrefix+"group"] = group
+		values[deadLetterFieldPrefix+"deliveries"] = strconv.FormatInt(deliveries, 10)
+		values[deadLetterFieldPrefix+"error"] = m.lastErrors.get(topic, group, id.String())
+		values[deadLetterFieldPrefix+"at"] = strconv.FormatInt(time.Now().UnixMilli(), 10)
+		if err := m.add(DeadLetterTopic(topic), values); err != nil {
+			return err
+		}
+	}
+	m.ack(topic, group, s.group(group), id)
+	m.lastErrors.forget(topic, group, id.String())
+	m.metrics.consumed.Add(1, topic, group, "dead")
+	fmt.Printf("[MQ] message dead, topic: %s, group: %s, id: %s, deliveries: %d\n", topic, group, id, deliveries)
+	return nil
+}
+
+// 认领组内其它消费者空闲超过 claimMinIdle 的消息，之后由 retryPending 重新投递；认领不增加投递次数
+func (m *MemoryMessageQueue) claim(topic, group, consumer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.stream(topic).group(group)
+	now := time.Now()
+	claimed := 0
+	for id, p := range g.pending {
+		if p.consumer == consumer || now.Sub(p.deliveredAt) < m.claimMinIdle {
+			continue
+		}
+		p.consumer = consumer
+		p.deliveredAt = now
+		m.deliver(topic, group, id, p)
+		claimed++
+	}
+	if claimed > 0 {
+		m.metrics.claimed.Add(float64(claimed), topic, group)
+	}
+}
+
+// 在指定的时间发布消息；时间已过时立即发布
+func (m *MemoryMessageQueue) PublishAt(ctx context.Context, topic string, body map[string]any, at time.Time) error {
+	if len(body) == 0 {
+		return errors.New("empty message body")
+	}
+	if !at.After(time.Now()) {
+		return m.Publish(ctx, topic, body)
+	}
+	d := &memDelayed{id: ids.NewUUID(), topic: topic, at: at, body: formatBody(body)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.write(&memRecord{Op: memOpDelay, Topic: topic, Id: d.id, At: at.UnixMilli(), Body: d.body})
+	if err != nil {
+		m.metrics.published.Add(1, topic, resultFailed)
+		return err
+	}
+	m.delayed[d.id] = d
+	m.schedule(d)
+	m.metrics.published.Add(1, topic, "delayed")
+	return nil
+}
+
+// 延迟 delay 之后发布消息
+func (m *MemoryMessageQueue) PublishAfter(ctx context.Context, topic string, body map[string]any, delay time.Duration) error {
+	return m.PublishAt(ctx, topic, body, time.Now().Add(delay))
+}
+
+// 主题中等待发布的延迟消息数
+func (m *MemoryMessageQueue) DelayedCount(ctx context.Context, topic string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, d := range m.delayed {
+		if d.topic == topic {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryMessageQueue) schedule(d *memDelayed) {
+	d.timer = time.AfterFunc(time.Until(d.at), func() {
+		m.promote(d.id)
+	})
+}
+
+// 发布到期的延迟消息；先写入消息再删除延迟消息，重启时最多重复发布一次
+func (m *MemoryMessageQueue) promote(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.delayed[id]
+	if !ok || m.closed() {
+		return
+	}
+	if err := m.add(d.topic, d.body); err != nil {
+		fmt.Printf("[MQ] promote delayed message failed, topic: %s, err: %v\n", d.topic, err)
+		d.timer.Reset(m.delayedInterval)
+		return
+	}
+	delete(m.delayed, id)
+	m.writeOrWarn(&memRecord{Op: memOpUndelay, Topic: d.topic, Id: id})
+	m.metrics.promoted.Add(1, d.topic)
+}
+
+// 死信队列中 id 不小于 start 的消息，最多 count 条
+func (m *MemoryMessageQueue) deadEntries(topic, start string, count int64) ([]redis.XMessage, error) {
+	s := m.stream(DeadLetterTopic(topic))
+	i := 0
+	if len(start) > 0 {
+		id, err := parseStreamId(start)
+		if err != nil {
+			return nil, err
+		}
+		i = s.search(id)
+	}
+	end := len(s.entries)
+	if count > 0 {
+		end = min(end, i+int(count))
+	}
+	msgs := make([]redis.XMessage, 0, end-i)
+	for _, e := range s.entries[i:end] {
+		msgs = append(msgs, e.message())
+	}
+	return msgs, nil
+}
+
+// 按时间顺序列出主题的死信；start 为起始的死信 id（包含），为空时从头开始
+func (m *MemoryMessageQueue) DeadLetters(ctx context.Context, topic, start string, count int64) ([]*DeadLetter, error) {
+	m.mu.Lock()
+	msgs, err := m.deadEntries(topic, start, count)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, parseDeadLetter(msg))
+	}
+	return letters, nil
+}
+
+// 将死信重新发布到原主题，并从死信队列中删除；ids 为空时重新发布所有死信。返回重新发布的数量
+func (m *MemoryMessageQueue) ReplayDeadLetters(ctx context.Context, topic string, ids ...string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs, err := m.deadEntries(topic, "", 0)
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	for _, msg := range msgs {
+		if len(ids) > 0 && !slices.Contains(ids, msg.ID) {
+			continue
+		}
+		if err := m.add(topic, formatBody(parseDeadLetter(msg).Body)); err != nil {
+			return replayed, err
+		}
+		if _, err := m.removeDead(topic, msg.ID); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// 删除主题的死信；ids 为空时清空死信队列。返回删除的数量
+func (m *MemoryMessageQueue) PurgeDeadLetters(ctx context.Context, topic string, ids ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(ids) == 0 {
+		for _, e := range m.stream(DeadLetterTopic(topic)).entries {
+			ids = append(ids, e.id.String())
+		}
+	}
+	var n int64
+	for _, id := range ids {
+		removed, err := m.removeDead(topic, id)
+		if err != nil {
+			return n, err
+		}
+		if removed {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryMessageQueue) removeDead(topic, id string) (bool, error) {
+	sid, err := parseStreamId(id)
+	if err != nil {
+		return false, err
+	}
+	dlq := DeadLetterTopic(topic)
+	if !m.stream(dlq).remove(sid) {
+		return false, nil
+	}
+	return true, m.write(&memRecord{Op: memOpDel, Topic: dlq, Id: id})
+}
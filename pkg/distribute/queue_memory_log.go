@@ -0,0 +1,305 @@
+package distribute
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MemoryMessageQueue 磁盘模式的日志文件名
+const memLogFile = "queue.log"
+
+// 日志记录的操作
+const (
+	memOpAdd     = "add"     // 追加消息
+	memOpDel     = "del"     // 删除消息
+	memOpGroup   = "group"   // 创建消费者组，压缩后的日志中带有最后投递的 id
+	memOpDeliver = "deliver" // 投递或认领消息
+	memOpAck     = "ack"     // 确认消息
+	memOpDelay   = "delay"   // 添加延迟消息
+	memOpUndelay = "undelay" // 延迟消息已发布
+)
+
+// 运行中压缩日志的默认阈值：上次压缩后追加的记录数或字节数达到阈值，且不少于压缩后日志的记录数或字节数
+const (
+	defaultMemLogCompactRecords = 100000
+	defaultMemLogCompactSize    = 64 << 20
+)
+
+// 日志中的一条记录，每行一条 JSON
+type memRecord struct {
+	Op         string  `json:"op"`
+	Topic      string  `json:"topic"`
+	Group      string  `json:"group,omitempty"`
+	Consumer   string  `json:"consumer,omitempty"`
+	Id         string  `json:"id,omitempty"`
+	Deliveries int64   `json:"deliveries,omitempty"`
+	At         int64   `json:"at,omitempty"` // 延迟消息的发布时间，单位：毫秒
+	Body       memBody `json:"data,omitempty"`
+}
+
+// 消息的内容，值以 base64 写入日志，可以保存任意二进制内容
+type memBody map[string]string
+
+func (b memBody) MarshalJSON() ([]byte, error) {
+	values := make(map[string][]byte, len(b))
+	for k, v := range b {
+		values[k] = []byte(v)
+	}
+	return json.Marshal(values)
+}
+
+func (b *memBody) UnmarshalJSON(data []byte) error {
+	var values map[string][]byte
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*b = make(memBody, len(values))
+	for k, v := range values {
+		(*b)[k] = string(v)
+	}
+	return nil
+}
+
+// 追加写入的日志；写入交给操作系统缓存，进程崩溃不丢失，断电可能丢失最后的记录
+type memLog struct {
+	path     string
+	file     *os.File
+	snapshot func() []*memRecord
+
+	compactRecords int   // 触发压缩的追加记录数
+	compactSize    int64 // 触发压缩的追加字节数
+	records        int   // 上次压缩后追加的记录数
+	size           int64 // 上次压缩后追加的字节数
+	baseRecords    int   // 上次压缩后日志中的记录数
+	baseSize       int64 // 上次压缩后日志的字节数
+}
+
+// 读取日志并逐条应用，然后用 snapshot 返回的记录替换日志，以去掉已删除的消息和已确认的投递
+//
+// 运行中追加的记录达到阈值后同样会用 snapshot 压缩，compactRecords、compactSize 为 0 时使用默认值
+func openMemLog(dir string, apply func(*memRecord) error, snapshot func() []*memRecord, compactRecords int, compactSize int64) (*memLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, memLogFile)
+	if err := replayMemLog(path, apply); err != nil {
+		return nil, err
+	}
+	l := &memLog{
+		path:           path,
+		snapshot:       snapshot,
+		compactRecords: compactRecords,
+		compactSize:    compactSize,
+	}
+	if l.compactRecords <= 0 {
+		l.compactRecords = defaultMemLogCompactRecords
+	}
+	if l.compactSize <= 0 {
+		l.compactSize = defaultMemLogCompactSize
+	}
+	if err := l.compact(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// 用当前状态替换日志；先写入临时文件，再替换原日志
+func (l *memLog) compact() error {
+	records := l.snapshot()
+	tmp := l.path + ".tmp"
+	size, err := writeMemLog(tmp, records)
+	if err != nil {
+		return err
+	}
+	// Windows 上无法替换打开中的文件，先关闭
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	renameErr := os.Rename(tmp, l.path)
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+	l.records, l.size = 0, 0
+	l.baseRecords, l.baseSize = len(records), size
+	return nil
+}
+
+// 追加的记录不少于压缩后日志的记录（或字节），说明日志中至少一半是可以去掉的旧记录
+func (l *memLog) needCompact() bool {
+	return l.records >= max(l.compactRecords, l.baseRecords) || l.size >= max(l.compactSize, l.baseSize)
+}
+
+func replayMemLog(path string, apply func(*memRecord) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var r memRecord
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// 进程崩溃时最后一条记录可能不完整
+			fmt.Printf("[MQ] queue log truncated, offset: %d, err: %v\n", dec.InputOffset(), err)
+			return nil
+		}
+		if err := apply(&r); err != nil {
+			return fmt.Errorf("replay queue log at offset %d: %w", dec.InputOffset(), err)
+		}
+	}
+}
+
+// 写入日志文件，返回写入的字节数
+func writeMemLog(path string, records []*memRecord) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), file.Sync()
+}
+
+// 写入前检查是否需要压缩：压缩使用的状态还不包含 r，r 随后追加到压缩后的日志中
+//
+// 因此调用方需要先写入日志、再修改状态，或者保证 r 重复应用时没有影响
+func (l *memLog) write(r *memRecord) error {
+	if l.needCompact() {
+		if err := l.compact(); err != nil {
+			fmt.Printf("[MQ] compact queue log failed, err: %v\n", err)
+			if l.file == nil {
+				return err
+			}
+		}
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	n, err := l.file.Write(append(b, '\n'))
+	l.records++
+	l.size += int64(n)
+	return err
+}
+
+func (l *memLog) close() {
+	if l.file == nil {
+		return
+	}
+	l.file.Sync()
+	l.file.Close()
+}
+
+// 将一条日志记录应用到内存中的状态
+func (m *MemoryMessageQueue) apply(r *memRecord) error {
+	switch r.Op {
+	case memOpDelay:
+		m.delayed[r.Id] = &memDelayed{id: r.Id, topic: r.Topic, at: time.UnixMilli(r.At), body: r.Body}
+		return nil
+	case memOpUndelay:
+		delete(m.delayed, r.Id)
+		return nil
+	}
+
+	s := m.stream(r.Topic)
+	var id streamId
+	if len(r.Id) > 0 {
+		var err error
+		if id, err = parseStreamId(r.Id); err != nil {
+			return err
+		}
+	}
+	switch r.Op {
+	case memOpAdd:
+		s.append(&memEntry{id, r.Body}, m.xaddMaxLen)
+	case memOpDel:
+		s.remove(id)
+	case memOpGroup:
+		s.group(r.Group).lastId = id
+	case memOpDeliver:
+		g := s.group(r.Group)
+		// 重启前的空闲时间无法得知，从恢复时开始计算
+		g.pending[id] = &memPending{consumer: r.Consumer, deliveries: r.Deliveries, deliveredAt: time.Now()}
+		if g.lastId.less(id) {
+			g.lastId = id
+		}
+	case memOpAck:
+		delete(s.group(r.Group).pending, id)
+	default:
+		return fmt.Errorf("unknown op: %s", r.Op)
+	}
+	return nil
+}
+
+// 当前状态对应的日志记录
+func (m *MemoryMessageQueue) snapshot() []*memRecord {
+	records := make([]*memRecord, 0)
+	topics := make([]string, 0, len(m.streams))
+	for topic := range m.streams {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	for _, topic := range topics {
+		s := m.streams[topic]
+		for _, e := range s.entries {
+			records = append(records, &memRecord{Op: memOpAdd, Topic: topic, Id: e.id.String(), Body: e.body})
+		}
+		groups := make([]string, 0, len(s.groups))
+		for name := range s.groups {
+			groups = append(groups, name)
+		}
+		slices.Sort(groups)
+		for _, name := range groups {
+			g := s.groups[name]
+			records = append(records, &memRecord{Op: memOpGroup, Topic: topic, Group: name, Id: g.lastId.String()})
+			for id, p := range g.pending {
+				records = append(records, &memRecord{Op: memOpDeliver, Topic: topic, Group: name, Id: id.String(), Consumer: p.consumer, Deliveries: p.deliveries})
+			}
+		}
+	}
+	delayed := make([]*memDelayed, 0, len(m.delayed))
+	for _, d := range m.delayed {
+		delayed = append(delayed, d)
+	}
+	slices.SortFunc(delayed, func(a, b *memDelayed) int {
+		return strings.Compare(a.id, b.id)
+	})
+	for _, d := range delayed {
+		records = append(records, &memRecord{Op: memOpDelay, Topic: d.topic, Id: d.id, At: d.at.UnixMilli(), Body: d.body})
+	}
+	return records
+}
@@ -0,0 +1,405 @@
+package distribute
+
+import (
+	"context"
+	"fmt"
+	"goapp/pkg/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type QueueOption func(*queueOptions)
+
+// 各实现共用的选项
+type queueOptions struct {
+	maxDeliveries int64         // 最多投递的次数，超过后转入死信队列
+	retryBase     time.Duration // 重试的初始间隔
+	retryMax      time.Duration // 重试的最长间隔
+	claimInterval time.Duration // 认领其它消费者遗留消息的间隔
+	claimMinIdle  time.Duration // 遗留消息的最短空闲时间
+
+	delayedLocker   *Locker       // 选出搬运延迟消息的节点
+	delayedInterval time.Duration // 搬运延迟消息的间隔
+
+	logCompactRecords int   // 磁盘模式下触发日志压缩的追加记录数
+	logCompactSize    int64 // 磁盘模式下触发日志压缩的追加字节数
+}
+
+func newQueueOptions(options []QueueOption) queueOptions {
+	o := queueOptions{
+		maxDeliveries:   defaultMaxDeliveries,
+		retryBase:       defaultRetryBase,
+		retryMax:        defaultRetryMax,
+		claimInterval:   defaultClaimInterval,
+		claimMinIdle:    defaultClaimMinIdle,
+		delayedInterval: defaultDelayedInterval,
+	}
+	for _, option := range options {
+		option(&o)
+	}
+	o.claimMinIdle = max(o.claimMinIdle, o.retryMax+o.claimInterval)
+	return o
+}
+
+// 消息最多被投递的次数，超过后转入死信队列；默认 5 次
+func WithMaxDeliveries(n int64) QueueOption {
+	return func(o *queueOptions) {
+		if n > 0 {
+			o.maxDeliveries = n
+		}
+	}
+}
+
+// 处理失败后重试的间隔：第 n 次失败后等待 base * 2^(n-1)，最长为 max；默认 1 秒、5 分钟
+func WithRetryBackoff(base, max time.Duration) QueueOption {
+	return func(o *queueOptions) {
+		if base > 0 {
+			o.retryBase = base
+		}
+		if max > 0 {
+			o.retryMax = max
+		}
+	}
+}
+
+// 每隔 interval 认领其它消费者空闲超过 minIdle 的待确认消息（比如消费者崩溃）；默认 30 秒、10 分钟
+//
+// minIdle 需要大于处理单条消息的最长时间，否则正在处理的消息会被重复投递；
+// 同时至少为最长重试间隔加上 interval，否则等待重试的消息会被反复认领而无法重试
+func WithClaimIdle(interval, minIdle time.Duration) QueueOption {
+	return func(o *queueOptions) {
+		if interval > 0 {
+			o.claimInterval = interval
+		}
+		if minIdle > 0 {
+			o.claimMinIdle = minIdle
+		}
+	}
+}
+
+// 磁盘模式下，上次压缩后追加了 records 条记录或 size 字节时压缩日志；默认 10 万条、64MB
+//
+// 仅用于 MemoryMessageQueue。追加的部分少于压缩后的日志时不压缩，避免消息积压时反复压缩
+func WithLogCompaction(records int, size int64) QueueOption {
+	return func(o *queueOptions) {
+		if records > 0 {
+			o.logCompactRecords = records
+		}
+		if size > 0 {
+			o.logCompactSize = size
+		}
+	}
+}
+
+const (
+	defaultMaxDeliveries = 5
+	defaultRetryBase     = time.Second
+	defaultRetryMax      = 5 * time.Minute
+	defaultClaimInterval = 30 * time.Second
+	defaultClaimMinIdle  = 10 * time.Minute
+
+	// 死信队列的后缀，每个主题一个死信队列
+	deadLetterSuffix = ":dead"
+	// 死信中附加字段的前缀
+	deadLetterFieldPrefix = "_dead_"
+	// 记录的处理错误保留的时间
+	lastErrorTtl = time.Hour
+)
+
+// 主题对应的死信队列
+func DeadLetterTopic(topic string) string { return topic + deadLetterSuffix }
+
+// 第 deliveries 次投递失败后，需要等待的时间
+func (o *queueOptions) retryDelay(deliveries int64) time.Duration {
+	delay := o.retryBase
+	for i := int64(1); i < deliveries && delay < o.retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, o.retryMax)
+}
+
+// 处理一条消息：成功时 ACK，失败时记录错误，等待重试
+func (m *RedisMessageQueue) handle(ctx context.Context, topic, group string, msg redis.XMessage, h ConsumeMsgHandler) {
+	start := time.Now()
+	err := h(ctx, msg.ID, msg.Values)
+	metrics.ObserveSince(m.metrics.consumeLatency, start, topic, group)
+	if err != nil {
+		m.metrics.consumed.Add(1, topic, group, resultFailed)
+		m.lastErrors.record(topic, group, msg.ID, err)
+		return
+	}
+	err = m.client.XAck(ctx, topic, group, msg.ID).Err()
+	if err != nil {
+		m.metrics.consumed.Add(1, topic, group, "ack_failed")
+		return
+	}
+	m.lastErrors.forget(topic, group, msg.ID)
+	m.metrics.consumed.Add(1, topic, group, resultOk)
+}
+
+// 重新投递本消费者处理失败的消息：达到重试间隔的重新认领并处理，投递次数达到上限的转入死信队列
+func (m *RedisMessageQueue) retryPending(ctx context.Context, topic, group, consumer string, h ConsumeMsgHandler) error {
+	// 只查询空闲达到最短重试间隔的消息，刚失败的消息不会占满一批而挡住可以重试的消息
+	pending, err := m.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   topic,
+		Group:    group,
+		Idle:     m.retryDelay(1),
+		Start:    "-",
+		End:      "+",
+		Count:    int64(m.batchSize),
+		Consumer: consumer,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		if m.closed() || ctx.Err() != nil {
+			return nil
+		}
+		if p.RetryCount >= m.maxDeliveries {
+			if err := m.deadLetter(ctx, topic, group, p.ID, p.RetryCount); err != nil {
+				fmt.Printf("[MQ] dead letter failed, topic: %s, group: %s, id: %s, err: %v\n", topic, group, p.ID, err)
+			}
+			continue
+		}
+		delay := m.retryDelay(p.RetryCount)
+		if p.Idle < delay {
+			continue
+		}
+		// 认领会增加投递次数；其它消费者抢先认领时返回空
+		msgs, err := m.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   topic,
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  delay,
+			Messages: []string{p.ID},
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			// 消息已被 MaxLen 裁剪时，只剩下 id
+			if msg.Values == nil {
+				m.client.XAck(ctx, topic, group, msg.ID)
+				continue
+			}
+			m.handle(ctx, topic, group, msg, h)
+		}
+	}
+	return nil
+}
+
+// 将消息转入死信队列，并从消费者组中确认
+func (m *RedisMessageQueue) deadLetter(ctx context.Context, topic, group, id string, deliveries int64) error {
+	msgs, err := m.client.XRangeN(ctx, topic, id, id, 1).Result()
+	if err != nil {
+		return err
+	}
+	// 消息已被裁剪，无法保存
+	if len(msgs) > 0 {
+		values := make(map[string]any, len(msgs[0].Values)+6)
+		for k, v := range msgs[0].Values {
+			values[k] = v
+		}
+		values[deadLetterFieldPrefix+"id"] = id
+		values[deadLetterFieldPrefix+"topic"] = topic
+		values[deadLetterFieldPrefix+"group"] = group
+		values[deadLetterFieldPrefix+"deliveries"] = deliveries
+		values[deadLetterFieldPrefix+"error"] = m.lastErrors.get(topic, group, id)
+		values[deadLetterFieldPrefix+"at"] = time.Now().UnixMilli()
+		err = m.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: DeadLetterTopic(topic),
+			MaxLen: int64(m.xaddMaxLen),
+			Approx: true,
+			Values: values,
+		}).Err()
+		if err != nil {
+			return err
+		}
+	}
+	m.lastErrors.forget(topic, group, id)
+	m.metrics.consumed.Add(1, topic, group, "dead")
+	fmt.Printf("[MQ] message dead, topic: %s, group: %s, id: %s, deliveries: %d\n", topic, group, id, deliveries)
+	return m.client.XAck(ctx, topic, group, id).Err()
+}
+
+// 定期认领崩溃或下线的消费者遗留的消息，之后由 retryPending 重新投递
+func (m *RedisMessageQueue) claimLoop(ctx context.Context, topic, group, consumer string) {
+	ticker := time.NewTicker(m.claimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.closeChan:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.lastErrors.prune()
+			start := "0-0"
+			for {
+				// JUSTID 不会增加投递次数
+				ids, next, err := m.client.XAutoClaimJustID(ctx, &redis.XAutoClaimArgs{
+					Stream:   topic,
+					Group:    group,
+					Consumer: consumer,
+					MinIdle:  m.claimMinIdle,
+					Start:    start,
+					Count:    int64(m.batchSize),
+				}).Result()
+				if err != nil {
+					fmt.Printf("[MQ] auto claim failed, topic: %s, group: %s, err: %v\n", topic, group, err)
+					break
+				}
+				if len(ids) > 0 {
+					m.metrics.claimed.Add(float64(len(ids)), topic, group)
+				}
+				if next == "0-0" || m.closed() {
+					break
+				}
+				start = next
+			}
+		}
+	}
+}
+
+// 死信
+type DeadLetter struct {
+	Id         string         // 在死信队列中的 id
+	MessageId  string         // 原消息的 id
+	Topic      string         // 原主题
+	Group      string         // 处理失败的消费者组
+	Deliveries int64          // 投递的次数
+	Error      string         // 最后一次处理的错误，可能为空
+	DeadAt     time.Time      // 转入死信队列的时间
+	Body       map[string]any // 原消息的内容
+}
+
+func parseDeadLetter(msg redis.XMessage) *DeadLetter {
+	dl := &DeadLetter{Id: msg.ID, Body: make(map[string]any, len(msg.Values))}
+	for k, v := range msg.Values {
+		name, ok := strings.CutPrefix(k, deadLetterFieldPrefix)
+		if !ok {
+			dl.Body[k] = v
+			continue
+		}
+		s := fmt.Sprint(v)
+		switch name {
+		case "id":
+			dl.MessageId = s
+		case "topic":
+			dl.Topic = s
+		case "group":
+			dl.Group = s
+		case "deliveries":
+			dl.Deliveries, _ = strconv.ParseInt(s, 10, 64)
+		case "error":
+			dl.Error = s
+		case "at":
+			ms, _ := strconv.ParseInt(s, 10, 64)
+			dl.DeadAt = time.UnixMilli(ms)
+		}
+	}
+	return dl
+}
+
+// 按时间顺序列出主题的死信；start 为起始的死信 id（包含），为空时从头开始
+func (m *RedisMessageQueue) DeadLetters(ctx context.Context, topic, start string, count int64) ([]*DeadLetter, error) {
+	if len(start) == 0 {
+		start = "-"
+	}
+	msgs, err := m.client.XRangeN(ctx, DeadLetterTopic(topic), start, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, parseDeadLetter(msg))
+	}
+	return letters, nil
+}
+
+// 将死信重新发布到原主题，并从死信队列中删除；ids 为空时重新发布所有死信。返回重新发布的数量
+//
+// 重新发布的消息是一条新消息，主题的所有消费者组都会再收到一次
+func (m *RedisMessageQueue) ReplayDeadLetters(ctx context.Context, topic string, ids ...string) (int, error) {
+	var msgs []redis.XMessage
+	if len(ids) == 0 {
+		all, err := m.client.XRange(ctx, DeadLetterTopic(topic), "-", "+").Result()
+		if err != nil {
+			return 0, err
+		}
+		msgs = all
+	} else {
+		for _, id := range ids {
+			found, err := m.client.XRangeN(ctx, DeadLetterTopic(topic), id, id, 1).Result()
+			if err != nil {
+				return 0, err
+			}
+			msgs = append(msgs, found...)
+		}
+	}
+
+	replayed := 0
+	for _, msg := range msgs {
+		if err := m.Publish(ctx, topic, parseDeadLetter(msg).Body); err != nil {
+			return replayed, err
+		}
+		if err := m.client.XDel(ctx, DeadLetterTopic(topic), msg.ID).Err(); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// 删除主题的死信；ids 为空时清空死信队列。返回删除的数量
+func (m *RedisMessageQueue) PurgeDeadLetters(ctx context.Context, topic string, ids ...string) (int64, error) {
+	if len(ids) > 0 {
+		return m.client.XDel(ctx, DeadLetterTopic(topic), ids...).Result()
+	}
+	n, err := m.client.XLen(ctx, DeadLetterTopic(topic)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return n, m.client.Del(ctx, DeadLetterTopic(topic)).Err()
+}
+
+type lastError struct {
+	msg string
+	at  time.Time
+}
+
+// 本节点记录的消息最后一次处理的错误，写入死信时使用
+type lastErrors struct {
+	errs sync.Map // key: topic|group|id
+}
+
+func (e *lastErrors) record(topic, group, id string, err error) {
+	e.errs.Store(topic+"|"+group+"|"+id, &lastError{err.Error(), time.Now()})
+}
+
+func (e *lastErrors) get(topic, group, id string) string {
+	v, ok := e.errs.Load(topic + "|" + group + "|" + id)
+	if !ok {
+		return ""
+	}
+	return v.(*lastError).msg
+}
+
+func (e *lastErrors) forget(topic, group, id string) {
+	e.errs.Delete(topic + "|" + group + "|" + id)
+}
+
+// 清理过期的错误，消息可能已被其它消费者认领并处理
+func (e *lastErrors) prune() {
+	e.errs.Range(func(key, value any) bool {
+		if time.Since(value.(*lastError).at) > lastErrorTtl {
+			e.errs.Delete(key)
+		}
+		return true
+	})
+}
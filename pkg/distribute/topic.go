@@ -0,0 +1,186 @@
+package distribute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapp/pkg/bytes"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Topic 发布的消息中的字段
+const (
+	HeaderPayload = "_payload" // 编码后的消息内容
+	HeaderCodec   = "_codec"   // 编码方式
+	HeaderSchema  = "_schema"  // 消息结构的版本
+)
+
+var ErrNotTypedMessage = errors.New("not a typed message")
+
+// 消息的编码方式
+type Codec interface {
+	bytes.PayloadMarshaler
+	Name() string
+}
+
+type namedCodec struct {
+	bytes.PayloadMarshaler
+	name string
+}
+
+func (c *namedCodec) Name() string { return c.name }
+
+var (
+	JsonCodec    Codec = &namedCodec{&bytes.JsonMarshaler{}, "json"}
+	MsgPackCodec Codec = &namedCodec{&bytes.MsgPackMarshaler{}, "msgpack"}
+)
+
+var codecs sync.Map // key: name, value: Codec
+
+func init() {
+	RegisterCodec(JsonCodec)
+	RegisterCodec(MsgPackCodec)
+}
+
+// 注册编码方式，消费时根据消息中的编码方式名称解码
+func RegisterCodec(codec Codec) {
+	codecs.Store(codec.Name(), codec)
+}
+
+// 将 PayloadMarshaler 包装为指定名称的编码方式
+func NewCodec(name string, marshaler bytes.PayloadMarshaler) Codec {
+	return &namedCodec{marshaler, name}
+}
+
+func codecByName(name string) (Codec, error) {
+	v, ok := codecs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %s", name)
+	}
+	return v.(Codec), nil
+}
+
+// 将旧版本的消息内容转换为当前版本
+type SchemaUpgrader[T any] func(payload []byte, codec Codec) (T, error)
+
+// 绑定了消息类型及编码方式的主题
+//
+// 消息结构变化时提升版本号：旧版本的消息由 Upgrade 注册的转换函数处理，没有注册时直接解码为当前类型；
+// 滚动发布期间收到的新版本消息同样直接解码，因此新增字段时应保持兼容
+type Topic[T any] struct {
+	queue     MessageQueue
+	name      string
+	codec     Codec
+	version   int
+	upgraders map[int]SchemaUpgrader[T]
+}
+
+// codec 为 nil 时使用 JsonCodec；version 为消息结构的版本，最小为 1
+func NewTopic[T any](queue MessageQueue, name string, codec Codec, version int) *Topic[T] {
+	if codec == nil {
+		codec = JsonCodec
+	}
+	return &Topic[T]{
+		queue:     queue,
+		name:      name,
+		codec:     codec,
+		version:   max(version, 1),
+		upgraders: make(map[int]SchemaUpgrader[T]),
+	}
+}
+
+func (t *Topic[T]) Name() string { return t.name }
+
+func (t *Topic[T]) Version() int { return t.version }
+
+// 注册旧版本消息的转换函数，需要在 Subscribe 之前完成
+func (t *Topic[T]) Upgrade(version int, upgrader SchemaUpgrader[T]) *Topic[T] {
+	t.upgraders[version] = upgrader
+	return t
+}
+
+// 编码消息，返回可以直接发布的消息内容；用于不经过 Publish 发布的场景，比如写入 outbox
+func (t *Topic[T]) Encode(msg T) (map[string]any, error) {
+	payload, err := t.codec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		HeaderPayload: payload,
+		HeaderCodec:   t.codec.Name(),
+		HeaderSchema:  t.version,
+	}, nil
+}
+
+// 解码消费到的消息
+func (t *Topic[T]) Decode(body map[string]any) (T, error) {
+	var msg T
+	payload, ok := body[HeaderPayload]
+	if !ok {
+		return msg, ErrNotTypedMessage
+	}
+	codec, err := codecByName(fmt.Sprint(body[HeaderCodec]))
+	if err != nil {
+		return msg, err
+	}
+	version, err := strconv.Atoi(fmt.Sprint(body[HeaderSchema]))
+	if err != nil {
+		return msg, fmt.Errorf("invalid schema version: %v", body[HeaderSchema])
+	}
+
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	case []byte:
+		data = p
+	default:
+		return msg, fmt.Errorf("invalid payload type: %T", payload)
+	}
+	if upgrader, ok := t.upgraders[version]; ok && version != t.version {
+		return upgrader(data, codec)
+	}
+	err = codec.Unmarshal(data, &msg)
+	return msg, err
+}
+
+func (t *Topic[T]) Publish(ctx context.Context, msg T) error {
+	body, err := t.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return t.queue.Publish(ctx, t.name, body)
+}
+
+func (t *Topic[T]) PublishAt(ctx context.Context, msg T, at time.Time) error {
+	body, err := t.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return t.queue.PublishAt(ctx, t.name, body, at)
+}
+
+func (t *Topic[T]) PublishAfter(ctx context.Context, msg T, delay time.Duration) error {
+	return t.PublishAt(ctx, msg, time.Now().Add(delay))
+}
+
+type messageIdKey struct{}
+
+// 获取正在处理的消息的 id，消费者可以据此判断消息是否已被处理
+func MessageIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(messageIdKey{}).(string)
+	return id
+}
+
+// 订阅主题；解码失败的消息与处理失败一样会被重试，最终进入死信队列
+func (t *Topic[T]) Subscribe(ctx context.Context, group, consumer string, handler func(ctx context.Context, msg T) error) error {
+	return t.queue.Subscribe(ctx, t.name, group, consumer, func(ctx context.Context, id string, body map[string]any) error {
+		msg, err := t.Decode(body)
+		if err != nil {
+			return fmt.Errorf("decode message %s of topic %s: %w", id, t.name, err)
+		}
+		return handler(context.WithValue(ctx, messageIdKey{}, id), msg)
+	})
+}
@@ -0,0 +1,303 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"slices"
+	"time"
+)
+
+// 集群消息的类型
+type ClusterMsgKind byte
+
+const (
+	ClusterMsgPush          ClusterMsgKind = 1  // 推送给指定用户的所有连接
+	ClusterMsgPushToLines   ClusterMsgKind = 2  // 推送给指定用户的指定连接
+	ClusterMsgBroadcast     ClusterMsgKind = 3  // 广播给所有用户
+	ClusterMsgCloseUsers    ClusterMsgKind = 4  // 关闭指定用户的所有连接
+	ClusterMsgCloseUserLine ClusterMsgKind = 5  // 关闭指定用户的指定连接
+	ClusterMsgPushToRoom    ClusterMsgKind = 6  // 推送给房间内的所有连接，LineIds 为排除的连接
+	ClusterMsgJoinRoom      ClusterMsgKind = 7  // 指定用户的连接加入房间
+	ClusterMsgLeaveRoom     ClusterMsgKind = 8  // 指定用户的连接离开房间
+	ClusterMsgRequest       ClusterMsgKind = 9  // 向指定用户的连接发起请求，LineIds 为空时为所有连接
+	ClusterMsgReply         ClusterMsgKind = 10 // 回传请求的应答
+)
+
+// 在节点之间传递的消息
+type ClusterMessage struct {
+	Kind     ClusterMsgKind `json:"kind"`
+	FromNode string         `json:"from"`
+	UserIds  []string       `json:"uids,omitempty"`
+	LineIds  []string       `json:"lids,omitempty"`
+	Room     string         `json:"room,omitempty"`
+	Data     []byte         `json:"data,omitempty"`
+	CallId   string         `json:"cid,omitempty"` // 请求与应答的关联 id
+	Deadline int64          `json:"dl,omitempty"`  // 请求的截止时间，unix 毫秒
+}
+
+// 集群背板：用于在多个 Hub 实例之间路由消息
+//
+// 每个节点在用户的连接加入时调用 Join，在用户的最后一个连接断开时调用 Leave；
+// 推送消息时，通过 Nodes 查出持有该用户连接的节点，然后通过 Send 投递到这些节点
+//
+// 节点通过 Heartbeat 定期续期自己的存活记录，存活记录过期的节点视为已宕机，其上的路由不再生效
+type Backplane interface {
+	// 记录 nodeId 上持有 userId 的连接
+	Join(ctx context.Context, nodeId, userId string) error
+	// 删除 nodeId 上 userId 的连接记录
+	Leave(ctx context.Context, nodeId, userId string) error
+	// 获取持有 userId 连接的所有存活节点
+	Nodes(ctx context.Context, userId string) ([]string, error)
+	// 续期 nodeId 的存活记录
+	Heartbeat(ctx context.Context, nodeId string) error
+	// 存活记录的有效期，Hub 每隔 TTL/3 续期一次；为 0 时不需要续期
+	TTL() time.Duration
+	// 向指定节点投递消息；节点没有在接收消息时（比如已宕机）返回 ErrNodeUnreachable
+	Send(ctx context.Context, nodeId string, msg *ClusterMessage) error
+	// 向所有节点投递消息（包括自身，接收方需要忽略自己发出的消息）
+	Broadcast(ctx context.Context, msg *ClusterMessage) error
+	// 开始接收发往 nodeId 的消息及广播消息，直到 ctx 被取消
+	Listen(ctx context.Context, nodeId string, handler func(msg *ClusterMessage)) error
+	Close() error
+}
+
+// 访问背板时的超时时间
+const backplaneTimeout = 5 * time.Second
+
+var ErrNodeUnreachable = errors.New("hub: node unreachable")
+
+type HubOption func(*Hub)
+
+// 使用集群背板，使得推送的消息可以到达连接在其它节点上的用户
+//
+// nodeId 为当前节点的唯一标识，一般为 AppConfig.Id
+func WithBackplane(nodeId string, backplane Backplane) HubOption {
+	return func(h *Hub) {
+		h.nodeId = nodeId
+		h.backplane = backplane
+	}
+}
+
+func (h *Hub) NodeId() string { return h.nodeId }
+
+func (h *Hub) Backplane() Backplane { return h.backplane }
+
+func (h *Hub) startBackplane() error {
+	if h.backplane == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.backplaneCancel = cancel
+	if err := h.backplane.Listen(ctx, h.nodeId, h.handleClusterMessage); err != nil {
+		return err
+	}
+	ttl := h.backplane.TTL()
+	if ttl <= 0 {
+		return nil
+	}
+	// 先续期一次，保证 Join 的路由立即生效
+	hbCtx, hbCancel := context.WithTimeout(ctx, backplaneTimeout)
+	defer hbCancel()
+	if err := h.backplane.Heartbeat(hbCtx, h.nodeId); err != nil {
+		return err
+	}
+	interval := max(ttl/3, time.Second)
+	return h.runLoop(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				hbCtx, hbCancel := context.WithTimeout(ctx, backplaneTimeout)
+				if err := h.backplane.Heartbeat(hbCtx, h.nodeId); err != nil {
+					fmt.Printf("[HUB] backplane heartbeat failed, err: %v\n", err)
+				}
+				hbCancel()
+			}
+		}
+	})
+}
+
+func (h *Hub) stopBackplane() {
+	if h.backplane == nil {
+		return
+	}
+	if h.backplaneCancel != nil {
+		h.backplaneCancel()
+		h.backplaneCancel = nil
+	}
+}
+
+// 处理从其它节点投递过来的消息：只在本地投递，不会再次转发
+func (h *Hub) handleClusterMessage(msg *ClusterMessage) {
+	if msg == nil || msg.FromNode == h.nodeId || h.isClosed.Load() {
+		return
+	}
+
+	switch msg.Kind {
+	case ClusterMsgPush:
+		// 发送方认为用户在本节点上，连接却已断开（比如排空下线），存入信箱以免丢失
+		missing := h.pushLocal(msg.UserIds, msg.Data)
+		if h.mailbox != nil && len(missing) > 0 {
+			h.putMailbox(missing, msg.Data)
+		}
+	case ClusterMsgPushToLines:
+		for _, userId := range msg.UserIds {
+			if uls := h.GetUserLines(userId); uls != nil {
+				uls.PushMessageToLines(msg.Data, msg.LineIds...)
+			}
+		}
+	case ClusterMsgBroadcast:
+		h.broadcastLocal(msg.Data)
+	case ClusterMsgCloseUsers:
+		h.closeUserLinesLocal(msg.UserIds...)
+	case ClusterMsgCloseUserLine:
+		for _, userId := range msg.UserIds {
+			if uls := h.GetUserLines(userId); uls != nil {
+				uls.CloseLines(msg.LineIds...)
+			}
+		}
+	case ClusterMsgPushToRoom:
+		h.pushToRoomLocal(msg.Room, msg.Data, msg.LineIds...)
+	case ClusterMsgJoinRoom:
+		for _, userId := range msg.UserIds {
+			h.joinRoomLocal(msg.Room, userId, msg.LineIds...)
+		}
+	case ClusterMsgLeaveRoom:
+		for _, userId := range msg.UserIds {
+			h.leaveRoomLocal(msg.Room, userId, msg.LineIds...)
+		}
+	case ClusterMsgRequest:
+		h.serveClusterRequest(msg)
+	case ClusterMsgReply:
+		h.resolveClusterReply(msg)
+	default:
+		fmt.Printf("[HUB] unknown cluster msg kind: %v, from: %s\n", msg.Kind, msg.FromNode)
+	}
+}
+
+// 按用户当前在本节点上是否有连接，向背板 Join 或 Leave
+//
+// 连接的加入与断开在不同的协程中处理，同一用户的同步操作需要串行，并以执行时的状态为准，
+// 否则先发生的 Leave 可能在之后的 Join 之后才到达背板，导致在线用户的路由被删除
+func (h *Hub) syncBackplane(userId string) {
+	if h.backplane == nil {
+		return
+	}
+	mu := &h.routeLocks[maphash.String(routeLockSeed, userId)%uint64(len(h.routeLocks))]
+	mu.Lock()
+	defer mu.Unlock()
+
+	h.backplaneMutex.RLock()
+	defer h.backplaneMutex.RUnlock()
+	if h.backplaneClosed {
+		return
+	}
+	if uls := h.GetUserLines(userId); uls != nil && uls.Len() > 0 {
+		h.joinBackplane(userId)
+	} else {
+		h.doLeaveBackplane(userId)
+	}
+}
+
+var routeLockSeed = maphash.MakeSeed()
+
+func (h *Hub) joinBackplane(userId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), backplaneTimeout)
+	defer cancel()
+	if err := h.backplane.Join(ctx, h.nodeId, userId); err != nil {
+		fmt.Printf("[HUB] backplane join failed, userId: %s, err: %v\n", userId, err)
+	}
+}
+
+func (h *Hub) leaveBackplane(userId string) {
+	if h.backplane == nil {
+		return
+	}
+	h.backplaneMutex.RLock()
+	defer h.backplaneMutex.RUnlock()
+	if h.backplaneClosed {
+		return
+	}
+	h.doLeaveBackplane(userId)
+}
+
+// 调用方持有 backplaneMutex 的读锁
+func (h *Hub) doLeaveBackplane(userId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), backplaneTimeout)
+	defer cancel()
+	if err := h.backplane.Leave(ctx, h.nodeId, userId); err != nil {
+		fmt.Printf("[HUB] backplane leave failed, userId: %s, err: %v\n", userId, err)
+	}
+}
+
+// 将消息转发到持有这些用户连接的其它节点，返回消息已送达其它节点的用户
+//
+// 同一节点上的多个用户会合并为一条消息投递
+func (h *Hub) forward(kind ClusterMsgKind, userIds []string, lineIds []string, data []byte) []string {
+	return h.forwardMessage(&ClusterMessage{Kind: kind, LineIds: lineIds, Data: data}, userIds)
+}
+
+// 以 msg 为模板，按节点填充 UserIds 后转发，返回消息已送达其它节点的用户
+//
+// 查询路由失败、或者持有连接的节点都没有收到消息的用户不计入，调用方可以将消息存入信箱；
+// 对方节点收到消息时用户已经断开的，由对方存入信箱
+func (h *Hub) forwardMessage(msg *ClusterMessage, userIds []string) []string {
+	if h.backplane == nil || len(userIds) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backplaneTimeout)
+	defer cancel()
+
+	nodeUsers := make(map[string][]string)
+	for _, userId := range userIds {
+		nodes, err := h.backplane.Nodes(ctx, userId)
+		if err != nil {
+			fmt.Printf("[HUB] backplane get nodes failed, userId: %s, err: %v\n", userId, err)
+			continue
+		}
+		for _, node := range nodes {
+			if node != h.nodeId {
+				nodeUsers[node] = append(nodeUsers[node], userId)
+			}
+		}
+	}
+
+	var delivered []string
+	for node, uids := range nodeUsers {
+		m := *msg
+		m.FromNode = h.nodeId
+		m.UserIds = uids
+		if err := h.backplane.Send(ctx, node, &m); err != nil {
+			fmt.Printf("[HUB] backplane send failed, node: %s, err: %v\n", node, err)
+			continue
+		}
+		for _, userId := range uids {
+			if !slices.Contains(delivered, userId) {
+				delivered = append(delivered, userId)
+			}
+		}
+	}
+	return delivered
+}
+
+// 向所有其它节点广播
+func (h *Hub) forwardBroadcast(kind ClusterMsgKind, data []byte) {
+	h.broadcastCluster(&ClusterMessage{Kind: kind, Data: data})
+}
+
+func (h *Hub) broadcastCluster(msg *ClusterMessage) {
+	if h.backplane == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backplaneTimeout)
+	defer cancel()
+	msg.FromNode = h.nodeId
+	if err := h.backplane.Broadcast(ctx, msg); err != nil {
+		fmt.Printf("[HUB] backplane broadcast failed, err: %v\n", err)
+	}
+}
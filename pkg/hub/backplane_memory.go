@@ -0,0 +1,124 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 进程内的集群背板，多个 Hub 共享同一个实例即可模拟多节点，一般用于测试或单机部署
+type MemoryBackplane struct {
+	mutex    sync.RWMutex
+	routes   map[string][]string                  // key: userId, value: nodeIds
+	handlers map[string]func(msg *ClusterMessage) // key: nodeId
+	closed   bool
+}
+
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		routes:   make(map[string][]string),
+		handlers: make(map[string]func(msg *ClusterMessage)),
+	}
+}
+
+func (b *MemoryBackplane) Join(ctx context.Context, nodeId, userId string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !slices.Contains(b.routes[userId], nodeId) {
+		b.routes[userId] = append(b.routes[userId], nodeId)
+	}
+	return nil
+}
+
+func (b *MemoryBackplane) Leave(ctx context.Context, nodeId, userId string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	nodes := slices.DeleteFunc(b.routes[userId], func(n string) bool { return n == nodeId })
+	if len(nodes) == 0 {
+		delete(b.routes, userId)
+	} else {
+		b.routes[userId] = nodes
+	}
+	return nil
+}
+
+func (b *MemoryBackplane) Nodes(ctx context.Context, userId string) ([]string, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return slices.Clone(b.routes[userId]), nil
+}
+
+// 进程内的节点与背板同生共死，不需要续期
+func (b *MemoryBackplane) Heartbeat(ctx context.Context, nodeId string) error {
+	return nil
+}
+
+func (b *MemoryBackplane) TTL() time.Duration {
+	return 0
+}
+
+func (b *MemoryBackplane) Send(ctx context.Context, nodeId string, msg *ClusterMessage) error {
+	b.mutex.RLock()
+	handler, ok := b.handlers[nodeId]
+	closed := b.closed
+	b.mutex.RUnlock()
+
+	if closed {
+		return errors.New("backplane closed")
+	}
+	if !ok {
+		return ErrNodeUnreachable
+	}
+	handler(msg)
+	return nil
+}
+
+func (b *MemoryBackplane) Broadcast(ctx context.Context, msg *ClusterMessage) error {
+	b.mutex.RLock()
+	handlers := make([]func(msg *ClusterMessage), 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	closed := b.closed
+	b.mutex.RUnlock()
+
+	if closed {
+		return errors.New("backplane closed")
+	}
+	for _, h := range handlers {
+		h(msg)
+	}
+	return nil
+}
+
+func (b *MemoryBackplane) Listen(ctx context.Context, nodeId string, handler func(msg *ClusterMessage)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return errors.New("backplane closed")
+	}
+	b.handlers[nodeId] = handler
+	go func() {
+		<-ctx.Done()
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.handlers, nodeId)
+	}()
+	return nil
+}
+
+func (b *MemoryBackplane) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	clear(b.handlers)
+	clear(b.routes)
+	return nil
+}
@@ -0,0 +1,201 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goapp/pkg/cache"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 基于 Redis Pub/Sub 的集群背板
+//
+// 路由表：{prefix}:route:{userId} 为一个 Set，存放持有该用户连接的节点；路由本身不过期，
+// 是否生效取决于节点是否存活
+//
+// 存活节点：{prefix}:nodes 为一个 ZSet，score 为节点存活记录的过期时间（毫秒），由心跳续期；
+// 节点上的用户：{prefix}:node_users:{nodeId}，用于清理宕机节点遗留的路由
+//
+// 节点通道：{prefix}:node:{nodeId}，广播通道：{prefix}:broadcast
+type RedisBackplane struct {
+	cache   *cache.Cache
+	prefix  string
+	nodeTtl time.Duration
+}
+
+// KEYS: 路由, 存活节点；ARGV: 当前毫秒
+// 返回存活的节点；宕机节点的路由由心跳统一清理
+var luaBackplaneNodes = redis.NewScript(`
+local alive = {}
+for _, node in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+	local expireAt = redis.call('ZSCORE', KEYS[2], node)
+	if expireAt and tonumber(expireAt) > tonumber(ARGV[1]) then
+		table.insert(alive, node)
+	end
+end
+return alive
+`)
+
+// 每次心跳最多清理的宕机节点数
+const backplaneReapBatch = 10
+
+// prefix 用于区分不同的 Hub，如 "hub:chat"
+//
+// nodeTtl 为节点存活记录的有效期，心跳间隔为其 1/3；节点宕机后，最多经过 nodeTtl 其路由失效，默认 30 秒
+func NewRedisBackplane(cache *cache.Cache, prefix string, nodeTtl time.Duration) *RedisBackplane {
+	if nodeTtl <= 0 {
+		nodeTtl = 30 * time.Second
+	}
+	return &RedisBackplane{cache: cache, prefix: prefix, nodeTtl: nodeTtl}
+}
+
+func (b *RedisBackplane) routeKey(userId string) string {
+	return fmt.Sprintf("%s:route:%s", b.prefix, userId)
+}
+
+func (b *RedisBackplane) nodesKey() string {
+	return b.prefix + ":nodes"
+}
+
+func (b *RedisBackplane) nodeUsersKey(nodeId string) string {
+	return fmt.Sprintf("%s:node_users:%s", b.prefix, nodeId)
+}
+
+func (b *RedisBackplane) nodeChannel(nodeId string) string {
+	return fmt.Sprintf("%s:node:%s", b.prefix, nodeId)
+}
+
+func (b *RedisBackplane) broadcastChannel() string {
+	return b.prefix + ":broadcast"
+}
+
+func (b *RedisBackplane) Join(ctx context.Context, nodeId, userId string) error {
+	pipe := b.cache.Master().TxPipeline()
+	pipe.SAdd(ctx, b.routeKey(userId), nodeId)
+	pipe.SAdd(ctx, b.nodeUsersKey(nodeId), userId)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (b *RedisBackplane) Leave(ctx context.Context, nodeId, userId string) error {
+	pipe := b.cache.Master().TxPipeline()
+	pipe.SRem(ctx, b.routeKey(userId), nodeId)
+	pipe.SRem(ctx, b.nodeUsersKey(nodeId), userId)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (b *RedisBackplane) Nodes(ctx context.Context, userId string) ([]string, error) {
+	// 路由表对实时性要求高，不从从库读取
+	keys := []string{b.routeKey(userId), b.nodesKey()}
+	return luaBackplaneNodes.Run(ctx, b.cache.Master(), keys, time.Now().UnixMilli()).StringSlice()
+}
+
+// 续期本节点的存活记录，并清理已宕机节点遗留的路由
+//
+// 存活记录过期后再经过一个 nodeTtl 才清理，避免心跳短暂延迟的节点丢失路由
+func (b *RedisBackplane) Heartbeat(ctx context.Context, nodeId string) error {
+	now := time.Now().UnixMilli()
+	ttl := b.nodeTtl.Milliseconds()
+	err := b.cache.Master().ZAdd(ctx, b.nodesKey(), redis.Z{Score: float64(now + ttl), Member: nodeId}).Err()
+	if err != nil {
+		return err
+	}
+	dead, err := b.cache.Master().ZRangeByScore(ctx, b.nodesKey(), &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(now-ttl, 10), Count: backplaneReapBatch,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, node := range dead {
+		if err := b.reapNode(ctx, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除宕机节点的所有路由；多个节点同时清理同一个节点时结果相同
+func (b *RedisBackplane) reapNode(ctx context.Context, nodeId string) error {
+	usersKey := b.nodeUsersKey(nodeId)
+	userIds, err := b.cache.Master().SMembers(ctx, usersKey).Result()
+	if err != nil {
+		return err
+	}
+	_, err = b.cache.Master().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userId := range userIds {
+			pipe.SRem(ctx, b.routeKey(userId), nodeId)
+		}
+		pipe.Del(ctx, usersKey)
+		pipe.ZRem(ctx, b.nodesKey(), nodeId)
+		return nil
+	})
+	return err
+}
+
+func (b *RedisBackplane) TTL() time.Duration {
+	return b.nodeTtl
+}
+
+func (b *RedisBackplane) Send(ctx context.Context, nodeId string, msg *ClusterMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	receivers, err := b.cache.Master().Publish(ctx, b.nodeChannel(nodeId), data).Result()
+	if err != nil {
+		return err
+	}
+	// 没有订阅者说明该节点已不在接收消息
+	if receivers == 0 {
+		return ErrNodeUnreachable
+	}
+	return nil
+}
+
+func (b *RedisBackplane) Broadcast(ctx context.Context, msg *ClusterMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.cache.Master().Publish(ctx, b.broadcastChannel(), data).Err()
+}
+
+func (b *RedisBackplane) Listen(ctx context.Context, nodeId string, handler func(msg *ClusterMessage)) error {
+	sub := b.cache.Master().Subscribe(ctx, b.nodeChannel(nodeId), b.broadcastChannel())
+	// 确保订阅成功之后再返回
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return err
+	}
+
+	go func() {
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				var msg ClusterMessage
+				if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+					fmt.Printf("[HUB] bad cluster msg: %v\n", err)
+					continue
+				}
+				handler(&msg)
+			}
+		}
+	}()
+	return nil
+}
+
+// 背板不持有 Redis 连接，连接由 cache 的所有者负责关闭
+func (b *RedisBackplane) Close() error {
+	return nil
+}
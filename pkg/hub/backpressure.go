@@ -0,0 +1,336 @@
+package hub
+
+import (
+	"fmt"
+	"goapp/pkg/core"
+	"sync"
+	"time"
+)
+
+// 客户端读取过慢、发送队列已满时的处理策略
+type BackpressurePolicy byte
+
+const (
+	BackpressureBlock      BackpressurePolicy = iota // 阻塞推送方，直到队列有空位（默认）
+	BackpressureDropOldest                           // 丢弃队列中最早的消息
+	BackpressureDropNewest                           // 丢弃新推送的消息
+	BackpressureDisconnect                           // 断开该连接，由客户端重连
+	BackpressureCoalesce                             // 队列中已有相同 key 的消息时，用新消息替换；否则丢弃最早的消息
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDropOldest:
+		return "drop_oldest"
+	case BackpressureDropNewest:
+		return "drop_newest"
+	case BackpressureDisconnect:
+		return "disconnect"
+	case BackpressureCoalesce:
+		return "coalesce"
+	}
+	return fmt.Sprintf("BackpressurePolicy(%d)", byte(p))
+}
+
+// 解析配置中的策略名称，为空时返回 BackpressureBlock
+func ParseBackpressurePolicy(name string) (BackpressurePolicy, error) {
+	for _, p := range []BackpressurePolicy{BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest, BackpressureDisconnect, BackpressureCoalesce} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	if len(name) == 0 {
+		return BackpressureBlock, nil
+	}
+	return BackpressureBlock, fmt.Errorf("unknown backpressure policy: %s", name)
+}
+
+// 发送队列已满、触发背压策略时，通过 ErrorChan 上报的错误
+type BackpressureError struct {
+	Policy   BackpressurePolicy
+	QueueLen int   // 触发时队列中的消息数
+	Dropped  int64 // 该连接累计丢弃的消息数
+}
+
+func (e *BackpressureError) Error() string {
+	return fmt.Sprintf("slow consumer, policy: %v, queue len: %d, dropped: %d", e.Policy, e.QueueLen, e.Dropped)
+}
+
+// 默认的发送队列长度
+const defaultWriteQueueSize = 2048
+
+// 同一个连接上报背压错误的最小间隔，避免持续丢弃时刷屏
+const backpressureReportInterval = time.Second
+
+// 设置发送队列的长度及队列满时的处理策略
+func WithBackpressure(policy BackpressurePolicy, queueSize int) HubOption {
+	return func(h *Hub) {
+		h.backpressure = policy
+		if queueSize > 0 {
+			h.writeQueueSize = queueSize
+		}
+	}
+}
+
+// 设置 BackpressureCoalesce 策略下消息的 key，返回空字符串的消息不会被合并
+func WithCoalesceKey(keyFn func(data []byte) string) HubOption {
+	return func(h *Hub) {
+		h.coalesceKey = keyFn
+	}
+}
+
+// 发送队列的统计数据
+type HubStats struct {
+	Lines          int   // 本节点上的连接数
+	QueuedMessages int   // 所有连接的发送队列中的消息总数
+	MaxQueueDepth  int   // 发送队列中消息数最多的连接的消息数
+	Dropped        int64 // 累计丢弃的消息数
+	Coalesced      int64 // 累计被合并的消息数
+	Disconnected   int64 // 累计因读取过慢被断开的连接数
+}
+
+// 获取发送队列的统计数据
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{
+		Dropped:      h.droppedCount.Load(),
+		Coalesced:    h.coalescedCount.Load(),
+		Disconnected: h.slowDisconnectCount.Load(),
+	}
+	h.connections.Range(func(key, value any) bool {
+		uls := value.(*UserLines)
+		uls.RLock()
+		for _, ln := range uls.lines {
+			depth := ln.QueueLen()
+			stats.Lines++
+			stats.QueuedMessages += depth
+			stats.MaxQueueDepth = max(stats.MaxQueueDepth, depth)
+		}
+		uls.RUnlock()
+		return true
+	})
+	return stats
+}
+
+type queuedMsg struct {
+	key  string
+	data []byte
+	at   time.Time // 入队时间
+	// 排空时放在队列末尾的关闭帧，写入后关闭连接
+	closing bool
+}
+
+type enqueueResult byte
+
+const (
+	enqueueOk enqueueResult = iota
+	enqueueCoalesced
+	enqueueDropped
+	enqueueOverflow
+	enqueueClosed
+)
+
+// 连接的发送队列
+type writeQueue struct {
+	mutex    sync.Mutex
+	notFull  *sync.Cond
+	items    []queuedMsg
+	inflight int // 已被写循环取出、尚未写完的消息数，同样占用队列容量
+	capacity int
+	notify   chan core.Empty // 有新消息时通知写循环
+	closed   bool
+	sealed   bool // 已放入关闭帧，不再接收新的消息
+}
+
+func newWriteQueue(capacity int) *writeQueue {
+	q := &writeQueue{
+		capacity: capacity,
+		notify:   make(chan core.Empty, 1),
+	}
+	q.notFull = sync.NewCond(&q.mutex)
+	return q
+}
+
+func (q *writeQueue) push(msg queuedMsg, policy BackpressurePolicy) enqueueResult {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed || q.sealed {
+		return enqueueClosed
+	}
+	result := enqueueOk
+	if policy == BackpressureCoalesce && len(msg.key) > 0 {
+		for i := range q.items {
+			if q.items[i].key == msg.key {
+				q.items[i] = msg
+				return enqueueCoalesced
+			}
+		}
+	}
+	if q.size() >= q.capacity {
+		switch policy {
+		case BackpressureBlock:
+			for q.size() >= q.capacity && !q.closed && !q.sealed {
+				q.notFull.Wait()
+			}
+			if q.closed || q.sealed {
+				return enqueueClosed
+			}
+		case BackpressureDropNewest:
+			return enqueueDropped
+		case BackpressureDisconnect:
+			return enqueueOverflow
+		default:
+			// 未写出的消息都已被写循环取出时，只能丢弃最新的消息
+			if len(q.items) == 0 {
+				return enqueueDropped
+			}
+			q.items = q.items[1:]
+			result = enqueueDropped
+		}
+	}
+	q.items = append(q.items, msg)
+	select {
+	case q.notify <- core.Empty{}:
+	default:
+	}
+	return result
+}
+
+// 不受容量限制地在队列末尾放入关闭帧，之后不再接收新的消息
+func (q *writeQueue) seal(msg queuedMsg) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed || q.sealed {
+		return
+	}
+	q.sealed = true
+	q.items = append(q.items, msg)
+	// 唤醒被阻塞的推送方，它们会得到 enqueueClosed
+	q.notFull.Broadcast()
+	select {
+	case q.notify <- core.Empty{}:
+	default:
+	}
+}
+
+// 队列占用的容量，调用方需持有锁
+func (q *writeQueue) size() int {
+	return len(q.items) + q.inflight
+}
+
+// 取出队列中所有的消息，每写完一条需调用 written 释放其占用的容量
+func (q *writeQueue) drain() []queuedMsg {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	items := q.items
+	q.items = nil
+	q.inflight += len(items)
+	return items
+}
+
+// 写循环写完一条消息
+func (q *writeQueue) written() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.inflight > 0 {
+		q.inflight--
+	}
+	q.notFull.Signal()
+}
+
+// 队列中的消息数，包括已被写循环取出、尚未写完的消息
+func (q *writeQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.size()
+}
+
+func (q *writeQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.items = nil
+	q.inflight = 0
+	close(q.notify)
+	q.notFull.Broadcast()
+}
+
+// 发送队列中的消息数，包括写循环正在写出的消息
+func (ln *Line) QueueLen() int {
+	if ln.queue == nil {
+		return 0
+	}
+	return ln.queue.len()
+}
+
+// 该连接累计丢弃的消息数
+func (ln *Line) DroppedCount() int64 { return ln.droppedCount.Load() }
+
+// 将消息放入发送队列，队列已满时按照 Hub 的背压策略处理
+//
+// 连接已关闭或正在排空、不再接收消息时返回 false
+func (ln *Line) enqueue(data []byte) bool {
+	if ln.queue == nil || ln.isClosed.Load() || ln.hub.isClosed.Load() {
+		return false
+	}
+	h := ln.hub
+	msg := queuedMsg{data: data, at: time.Now()}
+	if h.backpressure == BackpressureCoalesce && h.coalesceKey != nil {
+		msg.key = h.coalesceKey(data)
+	}
+
+	switch ln.queue.push(msg, h.backpressure) {
+	case enqueueClosed:
+		return false
+	case enqueueCoalesced:
+		h.coalescedCount.Add(1)
+		h.metrics.coalesced.Add(1, h.metrics.name)
+	case enqueueDropped:
+		h.droppedCount.Add(1)
+		h.metrics.dropped.Add(1, h.metrics.name, h.backpressure.String())
+		ln.droppedCount.Add(1)
+		ln.reportBackpressure()
+	case enqueueOverflow:
+		h.droppedCount.Add(1)
+		h.metrics.dropped.Add(1, h.metrics.name, h.backpressure.String())
+		ln.droppedCount.Add(1)
+		if ln.slowClosing.CompareAndSwap(false, true) {
+			h.slowDisconnectCount.Add(1)
+			h.metrics.disconnected.Add(1, h.metrics.name)
+			err := ln.backpressureError()
+			// 推送方可能持有锁，异步断开
+			h.pool.Submit(func() {
+				ln.close(true, err)
+			})
+		}
+	}
+	return true
+}
+
+func (ln *Line) backpressureError() *BackpressureError {
+	return &BackpressureError{Policy: ln.hub.backpressure, QueueLen: ln.QueueLen(), Dropped: ln.droppedCount.Load()}
+}
+
+// 上报背压错误，同一连接每秒最多一次，ErrorChan 已满时直接放弃
+func (ln *Line) reportBackpressure() {
+	now := time.Now().UnixNano()
+	last := ln.lastBackpressureAt.Load()
+	if now-last < int64(backpressureReportInterval) || !ln.lastBackpressureAt.CompareAndSwap(last, now) {
+		return
+	}
+	if ln.hub.isClosed.Load() {
+		return
+	}
+	select {
+	case ln.hub.errorChan <- &LineError{ln.userId, ln.platform, ln.id, ln.backpressureError()}:
+	default:
+	}
+}
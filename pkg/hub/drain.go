@@ -0,0 +1,64 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrHubDraining = errors.New("hub: draining")
+
+// 排空时检查连接是否已全部关闭的间隔
+const drainCheckInterval = 50 * time.Millisecond
+
+// 是否正在排空
+func (h *Hub) Draining() bool { return h.draining.Load() }
+
+// 排空本节点上的连接，用于滚动发布时平滑下线
+//
+// 不再接受新的连接；已在发送队列中的消息发送完毕后，向客户端发送 1012（服务重启）关闭帧，客户端据此重连到其它节点。
+// 所有连接关闭或 ctx 结束后关闭 Hub；ctx 结束时仍未关闭的连接会被直接关闭，并返回 ctx 的错误
+func (h *Hub) Drain(ctx context.Context) error {
+	if h.isClosed.Load() || !h.draining.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	lines := 0
+	h.connections.Range(func(key, value any) bool {
+		uls := value.(*UserLines)
+		uls.RLock()
+		for _, ln := range uls.lines {
+			ln.drain()
+			lines++
+		}
+		uls.RUnlock()
+		return true
+	})
+	fmt.Printf("[HUB] draining, lines: %d\n", lines)
+
+	var err error
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for h.LiveCount() > 0 && err == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	fmt.Printf("[HUB] drained, remaining lines: %d, err: %v\n", h.LiveCount(), err)
+	h.Close(0)
+	return err
+}
+
+// 在发送队列的末尾放入关闭帧，之后推送的消息不再进入队列
+func (ln *Line) drain() {
+	if ln.queue == nil || ln.isClosed.Load() {
+		return
+	}
+	frame := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+	ln.queue.seal(queuedMsg{data: frame, at: time.Now(), closing: true})
+}
@@ -1,10 +1,14 @@
 package hub
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"goapp/pkg/bytes"
 	"goapp/pkg/core"
+	"goapp/pkg/presence"
 	"net/http"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -35,6 +39,38 @@ type Hub struct {
 	unregisteredChan         chan *Line
 	errorChan                chan *LineError
 
+	nodeId          string
+	backplane       Backplane
+	backplaneCancel context.CancelFunc
+	backplaneMutex  sync.RWMutex   // 关闭时等待正在进行的 Join、Leave 完成
+	backplaneClosed bool           // Close 之后不再访问背板，背板可能已被调用方关闭
+	routeLocks      [64]sync.Mutex // 按用户串行地同步背板路由
+	presence        *presence.Tracker
+
+	rooms      map[string]map[*Line]core.Empty // key: room, value: 房间内的连接
+	roomsMutex sync.RWMutex
+
+	protocol   *bytes.PacketProtocol
+	requestSeq atomic.Uint32
+	callSeq    atomic.Uint64
+	calls      sync.Map // key: callId, value: chan []*LineReply，等待其它节点回传应答的请求
+
+	mailbox        Mailbox
+	mailboxEncoder MailboxEncoder
+
+	backpressure        BackpressurePolicy
+	writeQueueSize      int
+	coalesceKey         func(data []byte) string
+	droppedCount        atomic.Int64
+	coalescedCount      atomic.Int64
+	slowDisconnectCount atomic.Int64
+
+	metrics *hubMetrics
+
+	stopChan chan core.Empty // 关闭时通知后台循环退出
+	loops    sync.WaitGroup  // 后台循环，关闭时等待其退出后再释放资源
+
+	draining atomic.Bool
 	isClosed atomic.Bool
 }
 
@@ -46,6 +82,7 @@ func NewHub(
 	handshakeTimeout time.Duration,
 	enableCompression bool,
 	checkOriginFn func(r *http.Request) bool,
+	options ...HubOption,
 ) (*Hub, error) {
 	if pool == nil {
 		return nil, errors.New("pool must not nil")
@@ -70,6 +107,9 @@ func NewHub(
 		registeredChan:           make(chan *Line, 2048),
 		unregisteredChan:         make(chan *Line, 2048),
 		errorChan:                make(chan *LineError, 2048),
+		rooms:                    make(map[string]map[*Line]core.Empty),
+		stopChan:                 make(chan core.Empty),
+		writeQueueSize:           defaultWriteQueueSize,
 		upgrader: websocket.Upgrader{
 			EnableCompression: enableCompression,
 			HandshakeTimeout:  handshakeTimeout,
@@ -80,11 +120,22 @@ func NewHub(
 			CheckOrigin:       checkOriginFn,
 		},
 	}
+	for _, opt := range options {
+		opt(h)
+	}
+	if h.metrics == nil {
+		h.metrics = newHubMetrics("hub", nil)
+	}
 
 	// 检测连接可用性
-	err := h.pool.Submit(func() {
+	err := h.runLoop(func() {
 		ticker := h.liveTicker
-		for range ticker.C {
+		for {
+			select {
+			case <-h.stopChan:
+				return
+			case <-ticker.C:
+			}
 			delArr := make([]string, 0)
 			h.connections.Range(func(key, value any) bool {
 				conn := value.(*UserLines)
@@ -97,31 +148,54 @@ func NewHub(
 
 			for _, v := range delArr {
 				h.connections.Delete(v)
+				h.syncBackplane(v)
 			}
+			h.sampleQueues()
 		}
 	})
 	if err != nil {
 		return nil, err
 	}
 	// 新的连接加入
-	err = h.pool.Submit(func() {
-		for ln := range h.registeredChanInternal {
-			ln.closeChan = make(chan core.Empty)
-			ln.writeChan = make(chan []byte, 2048)
+	err = h.runLoop(func() {
+		for {
+			var ln *Line
+			select {
+			case <-h.stopChan:
+				return
+			case ln = <-h.registeredChanInternal:
+			}
 			// 新的连接加入
 			lines, _ := h.connections.LoadOrStore(ln.userId, &UserLines{lines: []*Line{}})
 			lines.(*UserLines).add(ln)
 			h.connCount.Add(1)
+			h.metrics.lineAdded(ln, 1)
+			h.syncBackplane(ln.userId)
+			h.presence.Register(ln.presenceInfo())
+			// 用户在本节点的第一条连接，补发离线消息
+			if lines.(*UserLines).Len() == 1 {
+				h.flushMailbox(ln)
+			}
 
-			h.registeredChan <- ln
+			select {
+			case h.registeredChan <- ln:
+			case <-h.stopChan:
+				return
+			}
 		}
 	})
 	if err != nil {
 		return nil, err
 	}
 	// 连接断开
-	err = h.pool.Submit(func() {
-		for ln := range h.unregisteredChanInternal {
+	err = h.runLoop(func() {
+		for {
+			var ln *Line
+			select {
+			case <-h.stopChan:
+				return
+			case ln = <-h.unregisteredChanInternal:
+			}
 			// 连接断开
 			lines, ok := h.connections.Load(ln.userId)
 			if !ok {
@@ -131,20 +205,36 @@ func NewHub(
 			userLines := lines.(*UserLines)
 			userLines.remove(ln.id)
 			h.connCount.Add(-1)
+			h.metrics.lineAdded(ln, -1)
+			h.presence.Unregister(ln.presenceInfo())
+			ln.LeaveRoom()
 			// 先删后关，防止在关闭之后，出现向通道意外发送的情况
 			h.closeLineChans(ln)
 
 			// 如果用户没有连接，则删除用户
 			if ok && userLines.Len() == 0 {
 				h.connections.Delete(ln.userId)
+				h.syncBackplane(ln.userId)
 			}
 
-			h.unregisteredChan <- ln
+			select {
+			case h.unregisteredChan <- ln:
+			case <-h.stopChan:
+				return
+			}
 		}
 	})
 	if err != nil {
 		return nil, err
 	}
+	// 接收其它节点转发过来的消息
+	if err = h.startBackplane(); err != nil {
+		return nil, err
+	}
+	// 定期续期在线状态
+	if err = h.presence.Start(); err != nil {
+		return nil, err
+	}
 
 	return h, nil
 }
@@ -160,16 +250,31 @@ func (h *Hub) ErrorChan() <-chan *LineError { return h.errorChan }
 
 func (h *Hub) LiveCount() int { return int(h.connCount.Load()) }
 
+// 在协程池中运行后台循环，循环需要在 stopChan 关闭后退出
+func (h *Hub) runLoop(loop func()) error {
+	h.loops.Add(1)
+	err := h.pool.Submit(func() {
+		defer h.loops.Done()
+		loop()
+	})
+	if err != nil {
+		h.loops.Done()
+	}
+	return err
+}
+
+// 关闭连接的通道；连接的读写协程仍在读取 closeChan，因此只关闭、不置空
 func (h *Hub) closeLineChans(ln *Line) {
+	if !ln.chansClosed.CompareAndSwap(false, true) {
+		return
+	}
+	ln.markDone()
 	if ln.closeChan != nil {
 		close(ln.closeChan)
 	}
-	if ln.writeChan != nil {
-		close(ln.writeChan)
+	if ln.queue != nil {
+		ln.queue.close()
 	}
-
-	ln.closeChan = nil
-	ln.writeChan = nil
 }
 
 func (h *Hub) Close(wait time.Duration) {
@@ -186,16 +291,29 @@ func (h *Hub) Close(wait time.Duration) {
 		}
 	}()
 
+	// 先停止后台循环并等待其退出，之后才能修改字段、关闭通道
+	close(h.stopChan)
+	h.stopBackplane()
+	h.loops.Wait()
 	if h.liveTicker != nil {
 		h.liveTicker.Stop()
 		h.liveTicker = nil
 	}
+	h.presence.Stop()
 	uls := make([]*UserLines, 0)
 	h.connections.Range(func(key, value any) bool {
 		uls = append(uls, value.(*UserLines))
+		h.leaveBackplane(key.(string))
 		return true
 	})
 	h.connections.Clear()
+	// 等待正在进行的 Join、Leave 完成，之后不再访问背板
+	h.backplaneMutex.Lock()
+	h.backplaneClosed = true
+	h.backplaneMutex.Unlock()
+	h.roomsMutex.Lock()
+	clear(h.rooms)
+	h.roomsMutex.Unlock()
 	// 关闭所有连接，并释放资源
 	for _, v := range uls {
 		v.Lock()
@@ -235,12 +353,37 @@ func (h *Hub) GetUserLine(userId, lineId string) *Line {
 	return lines.(*UserLines).Get(lineId)
 }
 
-// 关闭指定用户的所有连接
+// 关闭指定用户的所有连接（包括其它节点上的连接）
 func (h *Hub) CloseUserLines(userIds ...string) {
 	if len(userIds) == 0 {
 		return
 	}
 
+	h.closeUserLinesLocal(userIds...)
+	if h.backplane != nil {
+		h.pool.Submit(func() {
+			h.forward(ClusterMsgCloseUsers, userIds, nil, nil)
+		})
+	}
+}
+
+// 关闭指定用户的指定连接（包括其它节点上的连接）
+func (h *Hub) CloseUserLine(userId string, lineIds ...string) {
+	if len(userId) == 0 || len(lineIds) == 0 {
+		return
+	}
+
+	if uls := h.GetUserLines(userId); uls != nil {
+		uls.CloseLines(lineIds...)
+	}
+	if h.backplane != nil {
+		h.pool.Submit(func() {
+			h.forward(ClusterMsgCloseUserLine, []string{userId}, lineIds, nil)
+		})
+	}
+}
+
+func (h *Hub) closeUserLinesLocal(userIds ...string) {
 	for _, userId := range userIds {
 		if len(userId) == 0 {
 			continue
@@ -254,45 +397,82 @@ func (h *Hub) CloseUserLines(userIds ...string) {
 }
 
 // 推送消息给指定用户的所有连接
+//
+// 如果使用了集群背板，连接在其它节点上的用户也会收到消息；
+// 如果使用了离线信箱，没有任何连接的用户会将消息存入信箱
 func (h *Hub) PushMessage(userIds []string, data []byte) {
 	if len(userIds) == 0 || len(data) == 0 {
 		return
 	}
 	h.pool.Submit(func() {
-		for _, userId := range userIds {
-			lines, ok := h.connections.Load(userId)
-			if !ok {
-				continue
-			}
-			lines.(*UserLines).PushMessage(data)
+		missing := h.pushLocal(userIds, data)
+		remote := h.forward(ClusterMsgPush, userIds, nil, data)
+		if h.mailbox != nil && len(missing) > 0 {
+			offline := slices.DeleteFunc(missing, func(userId string) bool { return slices.Contains(remote, userId) })
+			h.putMailbox(offline, data)
 		}
 	})
 }
 
+// 推送给本节点上的连接，返回在本节点上没有连接、或连接都已在排空而未接收消息的用户
+func (h *Hub) pushLocal(userIds []string, data []byte) []string {
+	var missing []string
+	for _, userId := range userIds {
+		lines, ok := h.connections.Load(userId)
+		if !ok || !lines.(*UserLines).pushMessage(data) {
+			missing = append(missing, userId)
+		}
+	}
+	return missing
+}
+
 // 向用户指定的线路发送消息
+//
+// 如果使用了集群背板，该线路在其它节点上时也会收到消息
 func (h *Hub) PushToUserLines(userId string, data []byte, lineIds ...string) error {
 	uls := h.GetUserLines(userId)
-	if uls == nil {
+	if uls == nil && h.backplane == nil {
 		return errors.New("userlines empty")
 	}
-	uls.PushMessageToLines(data, lineIds...)
+	if uls != nil {
+		uls.PushMessageToLines(data, lineIds...)
+		// 指定的线路都在本节点，不需要转发
+		if uls.hasLines(lineIds...) {
+			return nil
+		}
+	}
+	if h.backplane != nil {
+		h.pool.Submit(func() {
+			h.forward(ClusterMsgPushToLines, []string{userId}, lineIds, data)
+		})
+	}
 	return nil
 }
 
 // 广播消息
+//
+// 如果使用了集群背板，所有节点上的用户都会收到消息
 func (h *Hub) BroadcastMessage(data []byte) {
 	if len(data) == 0 {
 		return
 	}
 	h.pool.Submit(func() {
-		h.connections.Range(func(key, lns any) bool {
-			lns.(*UserLines).PushMessage(data)
-			return true
-		})
+		h.broadcastLocal(data)
+		h.forwardBroadcast(ClusterMsgBroadcast, data)
+	})
+}
+
+func (h *Hub) broadcastLocal(data []byte) {
+	h.connections.Range(func(key, lns any) bool {
+		lns.(*UserLines).PushMessage(data)
+		return true
 	})
 }
 
 func (h *Hub) UpgradeWebSocket(userId string, platform core.Platform, lineId string, extraData core.MapX, w http.ResponseWriter, r *http.Request) error {
+	if h.draining.Load() {
+		return ErrHubDraining
+	}
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return err
@@ -300,13 +480,17 @@ func (h *Hub) UpgradeWebSocket(userId string, platform core.Platform, lineId str
 
 	// 存下该平台新的连接
 	ln := &Line{
-		hub:        h,
-		conn:       conn,
-		userId:     userId,
-		platform:   core.Platform(platform),
-		id:         lineId,
-		extraData:  extraData,
-		lastActive: time.Now().Unix(),
+		hub:         h,
+		conn:        conn,
+		userId:      userId,
+		platform:    core.Platform(platform),
+		id:          lineId,
+		extraData:   extraData,
+		lastActive:  time.Now().Unix(),
+		connectedAt: time.Now().Unix(),
+		closeChan:   make(chan core.Empty),
+		doneChan:    make(chan core.Empty),
+		queue:       newWriteQueue(h.writeQueueSize),
 	}
 
 	// 开始监听该连接的消息
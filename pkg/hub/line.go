@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"goapp/pkg/core"
 	"io"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -29,17 +30,27 @@ type LineError struct {
 
 // 客户端连接
 type Line struct {
-	hub        *Hub
-	conn       *websocket.Conn
-	id         string
-	userId     string
-	platform   core.Platform
-	extraData  core.MapX
-	lastActive int64
-	closeChan  chan core.Empty
-	writeChan  chan []byte
-
-	isClosed atomic.Bool
+	hub         *Hub
+	conn        *websocket.Conn
+	id          string
+	userId      string
+	platform    core.Platform
+	extraData   core.MapX
+	lastActive  int64
+	connectedAt int64
+	closeChan   chan core.Empty
+	queue       *writeQueue
+	rooms       map[string]core.Empty // 所在的房间，由 Hub.roomsMutex 保护
+	pending     sync.Map              // 等待客户端应答的请求，key: requestId, value: chan lineReply
+	doneChan    chan core.Empty       // 连接关闭后关闭
+	doneOnce    sync.Once
+
+	droppedCount       atomic.Int64
+	lastBackpressureAt atomic.Int64
+	slowClosing        atomic.Bool // 因读取过慢正在断开
+
+	isClosed    atomic.Bool
+	chansClosed atomic.Bool // closeChan 已关闭，不能再发送
 }
 
 func (ln *Line) Id() string { return ln.id }
@@ -50,6 +61,8 @@ func (ln *Line) Platform() core.Platform { return ln.platform }
 
 func (ln *Line) LastActive() int64 { return atomic.LoadInt64(&ln.lastActive) }
 
+func (ln *Line) ConnectedAt() int64 { return ln.connectedAt }
+
 func (ln *Line) Hub() *Hub { return ln.hub }
 
 func (ln *Line) start() error {
@@ -116,6 +129,11 @@ func (ln *Line) start() error {
 					ln.closeInternal(true)
 					return
 				}
+				// 对服务端请求的应答，不再交给业务处理
+				if ln.resolveReply(buf.Bytes()) {
+					continue
+				}
+				ln.hub.metrics.received.Add(1, ln.hub.metrics.name)
 				ln.hub.messageChan <- &LineMessage{ln.userId, ln.platform, ln.id, buf.Bytes()}
 			}
 		}
@@ -135,27 +153,35 @@ func (ln *Line) start() error {
 				return
 			}
 			select {
-			case msg, ok := <-ln.writeChan:
-				if ok {
+			case _, ok := <-ln.queue.notify:
+				if !ok {
+					fmt.Printf("[HUB] line write queue closed, userId: %s, platform: %v, lineId: %s\n", ln.userId, ln.platform, ln.id)
+					ln.close(true, nil)
+					return
+				}
+				for _, msg := range ln.queue.drain() {
 					err = ln.conn.SetWriteDeadline(time.Now().Add(ln.hub.writeTimeout))
 					if err != nil {
 						ln.close(false, err)
 						return
 					}
-					err = ln.conn.WriteMessage(websocket.BinaryMessage, msg)
+					if msg.closing {
+						ln.conn.WriteControl(websocket.CloseMessage, msg.data, time.Now().Add(ln.hub.writeTimeout))
+						ln.close(false, nil)
+						return
+					}
+					err = ln.conn.WriteMessage(websocket.BinaryMessage, msg.data)
 					if err != nil {
 						ln.close(false, err)
 						return
 					}
-				} else {
-					fmt.Printf("[HUB] line writeChan closed, userId: %s, platform: %v, lineId: %s\n", ln.userId, ln.platform, ln.id)
+					ln.queue.written()
+					ln.hub.metrics.written(msg)
 				}
 			case _, ok := <-ln.closeChan:
 				fmt.Printf("[HUB] line closeChan closed:[%v], userId: %s, platform: %v, lineId: %s\n", ok, ln.userId, ln.platform, ln.id)
 				ln.close(true, nil)
 				return
-			default:
-				continue
 			}
 		}
 	})
@@ -179,6 +205,8 @@ func (ln *Line) close(sendCloseCtrl bool, err error) {
 	if err != nil && !ln.hub.isClosed.Load() {
 		ln.hub.errorChan <- &LineError{ln.userId, ln.platform, ln.id, err}
 	}
+	// 唤醒被阻塞的推送方
+	ln.queue.close()
 
 	ln.closeInternal(sendCloseCtrl)
 
@@ -0,0 +1,91 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+)
+
+// 离线信箱中的一条消息
+type MailboxMessage struct {
+	Id   string // 信箱内单调递增的 id，客户端确认时使用
+	Data []byte
+}
+
+// 离线信箱：用户没有任何连接时，推送给该用户的消息会存入信箱，
+// 用户的第一条连接加入时按存入顺序补发，客户端确认后才删除
+type Mailbox interface {
+	// 存入消息，超出容量时丢弃最早的消息
+	Put(ctx context.Context, userId string, data []byte) error
+	// 按存入顺序获取未确认、未过期的消息，不会删除
+	Fetch(ctx context.Context, userId string, limit int) ([]*MailboxMessage, error)
+	// 确认 id 及之前的所有消息已送达，并删除它们
+	Ack(ctx context.Context, userId string, id string) error
+}
+
+// 将信箱中的消息编码为发送给客户端的数据，需要带上消息 id，以便客户端确认
+type MailboxEncoder func(msg *MailboxMessage) ([]byte, error)
+
+// 每次补发的最大消息数
+const mailboxFlushLimit = 1000
+
+// 使用离线信箱，encoder 用于将补发的消息编码，需要带上消息 id 以便客户端确认
+func WithMailbox(mailbox Mailbox, encoder MailboxEncoder) HubOption {
+	return func(h *Hub) {
+		h.mailbox = mailbox
+		h.mailboxEncoder = encoder
+	}
+}
+
+func (h *Hub) Mailbox() Mailbox { return h.mailbox }
+
+// 客户端确认 id 及之前的离线消息已送达
+func (h *Hub) AckMailbox(ctx context.Context, userId string, id string) error {
+	if h.mailbox == nil {
+		return nil
+	}
+	return h.mailbox.Ack(ctx, userId, id)
+}
+
+// 将消息存入离线用户的信箱
+func (h *Hub) putMailbox(userIds []string, data []byte) {
+	if h.mailbox == nil || len(userIds) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backplaneTimeout)
+	defer cancel()
+	for _, userId := range userIds {
+		if err := h.mailbox.Put(ctx, userId, data); err != nil {
+			fmt.Printf("[HUB] mailbox put failed, userId: %s, err: %v\n", userId, err)
+		}
+	}
+}
+
+// 将信箱中未确认的消息补发给新加入的连接
+func (h *Hub) flushMailbox(ln *Line) {
+	if h.mailbox == nil {
+		return
+	}
+	h.pool.Submit(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), backplaneTimeout)
+		defer cancel()
+		msgs, err := h.mailbox.Fetch(ctx, ln.userId, mailboxFlushLimit)
+		if err != nil {
+			fmt.Printf("[HUB] mailbox fetch failed, userId: %s, err: %v\n", ln.userId, err)
+			return
+		}
+		uls := h.GetUserLines(ln.userId)
+		if uls == nil {
+			return
+		}
+		for _, msg := range msgs {
+			data := msg.Data
+			if h.mailboxEncoder != nil {
+				if data, err = h.mailboxEncoder(msg); err != nil {
+					fmt.Printf("[HUB] mailbox encode failed, userId: %s, id: %s, err: %v\n", ln.userId, msg.Id, err)
+					continue
+				}
+			}
+			uls.PushMessageToLines(data, ln.id)
+		}
+	})
+}
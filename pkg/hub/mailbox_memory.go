@@ -0,0 +1,95 @@
+package hub
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type memoryMail struct {
+	seq      uint64
+	data     []byte
+	expireAt time.Time
+}
+
+// 进程内的离线信箱，重启后消息会丢失，一般用于测试或单机部署
+type MemoryMailbox struct {
+	mutex sync.Mutex
+	boxes map[string][]*memoryMail
+	seq   uint64
+	cap   int
+	ttl   time.Duration
+}
+
+// capacity 为每个用户最多保存的消息数，默认 500；ttl 为消息的保存时间，默认 7 天
+func NewMemoryMailbox(capacity int, ttl time.Duration) *MemoryMailbox {
+	if capacity <= 0 {
+		capacity = 500
+	}
+	if ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+	return &MemoryMailbox{boxes: make(map[string][]*memoryMail), cap: capacity, ttl: ttl}
+}
+
+func (m *MemoryMailbox) Put(ctx context.Context, userId string, data []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.seq++
+	box := append(m.expired(m.boxes[userId]), &memoryMail{seq: m.seq, data: slices.Clone(data), expireAt: time.Now().Add(m.ttl)})
+	if len(box) > m.cap {
+		box = box[len(box)-m.cap:]
+	}
+	m.boxes[userId] = box
+	return nil
+}
+
+func (m *MemoryMailbox) Fetch(ctx context.Context, userId string, limit int) ([]*MailboxMessage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	box := m.expired(m.boxes[userId])
+	m.boxes[userId] = box
+	if len(box) == 0 {
+		delete(m.boxes, userId)
+	}
+	if limit > 0 && len(box) > limit {
+		box = box[:limit]
+	}
+	msgs := make([]*MailboxMessage, 0, len(box))
+	for _, v := range box {
+		msgs = append(msgs, &MailboxMessage{Id: strconv.FormatUint(v.seq, 10), Data: v.data})
+	}
+	return msgs, nil
+}
+
+func (m *MemoryMailbox) Ack(ctx context.Context, userId string, id string) error {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	box := slices.DeleteFunc(m.boxes[userId], func(v *memoryMail) bool { return v.seq <= seq })
+	if len(box) == 0 {
+		delete(m.boxes, userId)
+	} else {
+		m.boxes[userId] = box
+	}
+	return nil
+}
+
+// 去掉已过期的消息，消息按存入顺序排列，过期的都在前面
+func (m *MemoryMailbox) expired(box []*memoryMail) []*memoryMail {
+	now := time.Now()
+	idx := 0
+	for idx < len(box) && now.After(box[idx].expireAt) {
+		idx++
+	}
+	return box[idx:]
+}
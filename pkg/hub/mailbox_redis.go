@@ -0,0 +1,86 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"goapp/pkg/cache"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 基于 Redis Stream 的离线信箱
+//
+// 每个用户一个 Stream：{prefix}:mailbox:{userId}，消息 id 即 Stream 的 id，天然有序且包含存入时间
+type RedisMailbox struct {
+	cache  *cache.Cache
+	prefix string
+	cap    int64
+	ttl    time.Duration
+}
+
+// capacity 为每个用户最多保存的消息数，默认 500；ttl 为消息的保存时间，默认 7 天
+func NewRedisMailbox(cache *cache.Cache, prefix string, capacity int64, ttl time.Duration) *RedisMailbox {
+	if capacity <= 0 {
+		capacity = 500
+	}
+	if ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+	return &RedisMailbox{cache: cache, prefix: prefix, cap: capacity, ttl: ttl}
+}
+
+func (m *RedisMailbox) key(userId string) string {
+	return fmt.Sprintf("%s:mailbox:%s", m.prefix, userId)
+}
+
+// 早于该 id 的消息已过期
+func (m *RedisMailbox) minId() string {
+	return strconv.FormatInt(time.Now().Add(-m.ttl).UnixMilli(), 10)
+}
+
+func (m *RedisMailbox) Put(ctx context.Context, userId string, data []byte) error {
+	key := m.key(userId)
+	pipe := m.cache.Master().TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: m.cap,
+		Values: []any{"d", data},
+	})
+	pipe.XTrimMinID(ctx, key, m.minId())
+	pipe.Expire(ctx, key, m.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (m *RedisMailbox) Fetch(ctx context.Context, userId string, limit int) ([]*MailboxMessage, error) {
+	if limit <= 0 {
+		limit = int(m.cap)
+	}
+	// 起始 id 为过期时间，过期但尚未清理的消息不会返回
+	entries, err := m.cache.Master().XRangeN(ctx, m.key(userId), m.minId(), "+", int64(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*MailboxMessage, 0, len(entries))
+	for _, e := range entries {
+		data, _ := e.Values["d"].(string)
+		msgs = append(msgs, &MailboxMessage{Id: e.ID, Data: []byte(data)})
+	}
+	return msgs, nil
+}
+
+func (m *RedisMailbox) Ack(ctx context.Context, userId string, id string) error {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return fmt.Errorf("invalid mailbox id: %s", id)
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid mailbox id: %s", id)
+	}
+	// 删除 id 及之前的所有消息
+	return m.cache.Master().XTrimMinID(ctx, m.key(userId), fmt.Sprintf("%s-%d", ms, n+1)).Err()
+}
@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"goapp/pkg/metrics"
+	"time"
+)
+
+// 写入耗时（从入队到写入 WebSocket）的分桶，单位：秒
+var writeLatencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type hubMetrics struct {
+	name          string
+	lines         metrics.Gauge     // 连接数，按平台区分
+	received      metrics.Counter   // 收到的消息数
+	sent          metrics.Counter   // 写入连接的消息数
+	dropped       metrics.Counter   // 被背压策略丢弃的消息数
+	coalesced     metrics.Counter   // 被合并的消息数
+	disconnected  metrics.Counter   // 因读取过慢被断开的连接数
+	writeLatency  metrics.Histogram // 消息在发送队列中等待并写入的耗时
+	queued        metrics.Gauge     // 所有发送队列中的消息总数
+	maxQueueDepth metrics.Gauge     // 最长的发送队列
+}
+
+func newHubMetrics(name string, p metrics.Provider) *hubMetrics {
+	p = metrics.Or(p)
+	return &hubMetrics{
+		name:          name,
+		lines:         p.Gauge("hub_lines", "Number of live WebSocket lines on this node.", "hub", "platform"),
+		received:      p.Counter("hub_messages_received_total", "Messages received from clients.", "hub"),
+		sent:          p.Counter("hub_messages_sent_total", "Messages written to clients.", "hub"),
+		dropped:       p.Counter("hub_messages_dropped_total", "Messages dropped by the backpressure policy.", "hub", "policy"),
+		coalesced:     p.Counter("hub_messages_coalesced_total", "Messages replaced by a newer message with the same key.", "hub"),
+		disconnected:  p.Counter("hub_slow_disconnects_total", "Lines disconnected for reading too slowly.", "hub"),
+		writeLatency:  p.Histogram("hub_write_seconds", "Time from enqueue to the message being written.", writeLatencyBuckets, "hub"),
+		queued:        p.Gauge("hub_write_queue_messages", "Messages waiting in all write queues.", "hub"),
+		maxQueueDepth: p.Gauge("hub_write_queue_max_depth", "Depth of the longest write queue.", "hub"),
+	}
+}
+
+// 设置指标的提供者；name 用于区分同一进程中的多个 Hub，默认为 "hub"
+//
+// 未设置时使用 metrics.Default()
+func WithMetrics(name string, p metrics.Provider) HubOption {
+	return func(h *Hub) {
+		if len(name) == 0 {
+			name = "hub"
+		}
+		h.metrics = newHubMetrics(name, p)
+	}
+}
+
+// 采集发送队列的统计数据，在连接检测时调用
+func (h *Hub) sampleQueues() {
+	stats := h.Stats()
+	h.metrics.queued.Set(float64(stats.QueuedMessages), h.metrics.name)
+	h.metrics.maxQueueDepth.Set(float64(stats.MaxQueueDepth), h.metrics.name)
+}
+
+func (m *hubMetrics) lineAdded(ln *Line, delta float64) {
+	m.lines.Add(delta, m.name, ln.platform.String())
+}
+
+func (m *hubMetrics) written(msg queuedMsg) {
+	m.sent.Add(1, m.name)
+	m.writeLatency.Observe(time.Since(msg.at).Seconds(), m.name)
+}
@@ -0,0 +1,39 @@
+package hub
+
+import (
+	"goapp/pkg/presence"
+)
+
+// 使用在线状态注册表，连接加入、断开时会同步到注册表中，并定期续期
+func WithPresence(registry presence.Registry) HubOption {
+	return func(h *Hub) {
+		h.presence = presence.NewTracker(registry, h.pool, "HUB", h.localPresenceInfos)
+	}
+}
+
+func (h *Hub) Presence() presence.Registry { return h.presence.Registry() }
+
+func (ln *Line) presenceInfo() *presence.LineInfo {
+	return &presence.LineInfo{
+		UserId:      ln.userId,
+		Kind:        presence.LineKindWebSocket,
+		LineId:      ln.id,
+		Platform:    ln.platform,
+		ConnectedAt: ln.connectedAt,
+	}
+}
+
+// 收集本节点上所有连接的在线信息
+func (h *Hub) localPresenceInfos() []*presence.LineInfo {
+	infos := make([]*presence.LineInfo, 0, h.LiveCount())
+	h.connections.Range(func(key, value any) bool {
+		uls := value.(*UserLines)
+		uls.RLock()
+		for _, ln := range uls.lines {
+			infos = append(infos, ln.presenceInfo())
+		}
+		uls.RUnlock()
+		return true
+	})
+	return infos
+}
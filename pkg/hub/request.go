@@ -0,0 +1,320 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goapp/pkg/bytes"
+	"goapp/pkg/core"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoProtocol   = errors.New("hub: packet protocol not set")
+	ErrLineClosed   = errors.New("hub: line closed")
+	ErrLineNotFound = errors.New("hub: line not found")
+)
+
+// 设置数据包协议：服务端主动发起的请求使用该协议编解码，读循环据此识别客户端的应答
+func WithProtocol(protocol *bytes.PacketProtocol) HubOption {
+	return func(h *Hub) {
+		h.protocol = protocol
+	}
+}
+
+func (h *Hub) Protocol() *bytes.PacketProtocol { return h.protocol }
+
+// 服务端发起的请求使用负数的 RequestId，与客户端发起的请求（正数）区分开
+func (h *Hub) nextRequestId() int32 {
+	for {
+		id := -int32(h.requestSeq.Add(1) & 0x7fffffff)
+		if id != 0 {
+			return id
+		}
+	}
+}
+
+type lineReply struct {
+	packet *bytes.RequestPacket
+	err    error
+}
+
+// 向客户端发起请求，并等待客户端使用相同的 RequestId 应答
+//
+// ctx 超时、连接关闭时返回错误；客户端的应答不会进入 MessageChan
+func (ln *Line) Request(ctx context.Context, msgType byte, payload any) (*bytes.RequestPacket, error) {
+	protocol := ln.hub.protocol
+	if protocol == nil {
+		return nil, ErrNoProtocol
+	}
+	if ln.isClosed.Load() || ln.hub.isClosed.Load() {
+		return nil, ErrLineClosed
+	}
+	uls := ln.hub.GetUserLines(ln.userId)
+	if uls == nil || uls.Get(ln.id) != ln {
+		return nil, ErrLineClosed
+	}
+
+	requestId := ln.hub.nextRequestId()
+	data, err := protocol.EncodeReq(msgType, requestId, payload)
+	if err != nil {
+		return nil, err
+	}
+	replyChan := make(chan lineReply, 1)
+	ln.pending.Store(requestId, replyChan)
+	defer ln.pending.Delete(requestId)
+
+	uls.PushMessageToLines(data, ln.id)
+
+	select {
+	case reply := <-replyChan:
+		return reply.packet, reply.err
+	case <-ln.doneChan:
+		return nil, ErrLineClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 如果是对服务端请求的应答，交给等待中的请求并返回 true
+func (ln *Line) resolveReply(data []byte) bool {
+	protocol := ln.hub.protocol
+	if protocol == nil {
+		return false
+	}
+	meta, err := protocol.GetMeta(data)
+	if err != nil || meta.RequestId >= 0 {
+		return false
+	}
+
+	// 已经超时的应答直接丢弃
+	v, ok := ln.pending.LoadAndDelete(meta.RequestId)
+	if !ok {
+		return true
+	}
+	packet, err := protocol.DecodeReq(data)
+	v.(chan lineReply) <- lineReply{packet, err}
+	return true
+}
+
+// 连接关闭时，通知所有等待中的请求
+func (ln *Line) markDone() {
+	if ln.doneChan == nil {
+		return
+	}
+	ln.doneOnce.Do(func() {
+		close(ln.doneChan)
+	})
+}
+
+// 向用户的指定连接发起请求，并等待应答
+//
+// 如果使用了集群背板，该连接在其它节点上时，请求会转发到该节点，应答再回传到本节点
+func (h *Hub) Request(ctx context.Context, userId, lineId string, msgType byte, payload any) (*bytes.RequestPacket, error) {
+	if ln := h.GetUserLine(userId, lineId); ln != nil {
+		return ln.Request(ctx, msgType, payload)
+	}
+	for _, reply := range h.requestRemote(ctx, userId, []string{lineId}, msgType, payload) {
+		if reply.LineId == lineId {
+			return reply.Packet, reply.Err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrLineNotFound
+}
+
+// 某个连接的应答
+type LineReply struct {
+	LineId string
+	Packet *bytes.RequestPacket
+	Err    error
+}
+
+// 向用户的所有连接并发地发起请求，等待全部应答或失败后返回
+//
+// 如果使用了集群背板，用户在其它节点上的连接也会收到请求；ctx 结束前没有回传应答的节点，其连接不在结果中
+func (h *Hub) RequestUserLines(ctx context.Context, userId string, msgType byte, payload any) []*LineReply {
+	var remote []*LineReply
+	done := make(chan core.Empty)
+	go func() {
+		defer close(done)
+		remote = h.requestRemote(ctx, userId, nil, msgType, payload)
+	}()
+	replies := h.requestLocal(ctx, userId, nil, msgType, payload)
+	<-done
+	return append(replies, remote...)
+}
+
+// 向本节点上用户的连接并发地发起请求，lineIds 为空时为所有连接
+func (h *Hub) requestLocal(ctx context.Context, userId string, lineIds []string, msgType byte, payload any) []*LineReply {
+	lines := h.userLinesFor(userId, lineIds...)
+	replies := make([]*LineReply, len(lines))
+	var wg sync.WaitGroup
+	for i, ln := range lines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			packet, err := ln.Request(ctx, msgType, payload)
+			replies[i] = &LineReply{LineId: ln.id, Packet: packet, Err: err}
+		}()
+	}
+	wg.Wait()
+	return replies
+}
+
+// 在节点之间传递的应答
+type clusterLineReply struct {
+	LineId string `json:"lid"`
+	Data   []byte `json:"data,omitempty"` // 客户端的应答，按数据包协议重新编码
+	Err    string `json:"err,omitempty"`
+}
+
+// 通过集群背板向持有用户连接的其它节点发起请求，等待这些节点回传应答或 ctx 结束
+func (h *Hub) requestRemote(ctx context.Context, userId string, lineIds []string, msgType byte, payload any) []*LineReply {
+	if h.backplane == nil || h.protocol == nil {
+		return nil
+	}
+	nodes, err := h.backplane.Nodes(ctx, userId)
+	if err != nil {
+		fmt.Printf("[HUB] backplane get nodes failed, userId: %s, err: %v\n", userId, err)
+		return nil
+	}
+	nodes = slices.DeleteFunc(nodes, func(node string) bool { return node == h.nodeId })
+	if len(nodes) == 0 {
+		return nil
+	}
+	data, err := h.protocol.EncodeReq(msgType, 0, payload)
+	if err != nil {
+		fmt.Printf("[HUB] encode cluster request failed, userId: %s, err: %v\n", userId, err)
+		return nil
+	}
+
+	callId := fmt.Sprintf("%s:%d", h.nodeId, h.callSeq.Add(1))
+	replyChan := make(chan []*LineReply, len(nodes))
+	h.calls.Store(callId, replyChan)
+	defer h.calls.Delete(callId)
+
+	// 对方以请求方的截止时间为准，没有截止时间时使用背板的超时时间
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(backplaneTimeout)
+	}
+	msg := &ClusterMessage{
+		Kind:     ClusterMsgRequest,
+		FromNode: h.nodeId,
+		UserIds:  []string{userId},
+		LineIds:  lineIds,
+		Data:     data,
+		CallId:   callId,
+		Deadline: deadline.UnixMilli(),
+	}
+	sent := 0
+	for _, node := range nodes {
+		if err := h.backplane.Send(ctx, node, msg); err != nil {
+			fmt.Printf("[HUB] backplane send request failed, node: %s, err: %v\n", node, err)
+			continue
+		}
+		sent++
+	}
+
+	var replies []*LineReply
+	for ; sent > 0; sent-- {
+		select {
+		case r := <-replyChan:
+			replies = append(replies, r...)
+		case <-ctx.Done():
+			return replies
+		}
+	}
+	return replies
+}
+
+// 处理其它节点转发过来的请求：向本节点上的连接发起请求，并将应答回传给发起请求的节点
+func (h *Hub) serveClusterRequest(msg *ClusterMessage) {
+	err := h.pool.Submit(func() {
+		ctx, cancel := context.WithDeadline(context.Background(), time.UnixMilli(msg.Deadline))
+		defer cancel()
+
+		var replies []clusterLineReply
+		if h.protocol == nil {
+			fmt.Printf("[HUB] cluster request from: %s, err: %v\n", msg.FromNode, ErrNoProtocol)
+		} else if packet, err := h.protocol.DecodeReq(msg.Data); err != nil {
+			fmt.Printf("[HUB] bad cluster request from: %s, err: %v\n", msg.FromNode, err)
+		} else {
+			for _, userId := range msg.UserIds {
+				for _, r := range h.requestLocal(ctx, userId, msg.LineIds, packet.MsgType, packet.Payload) {
+					replies = append(replies, h.encodeClusterReply(r))
+				}
+			}
+		}
+		data, err := json.Marshal(replies)
+		if err != nil {
+			return
+		}
+		sendCtx, sendCancel := context.WithTimeout(context.Background(), backplaneTimeout)
+		defer sendCancel()
+		reply := &ClusterMessage{Kind: ClusterMsgReply, FromNode: h.nodeId, CallId: msg.CallId, Data: data}
+		if err := h.backplane.Send(sendCtx, msg.FromNode, reply); err != nil {
+			fmt.Printf("[HUB] backplane send reply failed, node: %s, err: %v\n", msg.FromNode, err)
+		}
+	})
+	if err != nil {
+		fmt.Printf("[HUB] serve cluster request failed, from: %s, err: %v\n", msg.FromNode, err)
+	}
+}
+
+func (h *Hub) encodeClusterReply(r *LineReply) clusterLineReply {
+	reply := clusterLineReply{LineId: r.LineId}
+	if r.Err != nil {
+		reply.Err = r.Err.Error()
+		return reply
+	}
+	data, err := h.protocol.EncodeReq(r.Packet.MsgType, r.Packet.RequestId, r.Packet.Payload)
+	if err != nil {
+		reply.Err = err.Error()
+		return reply
+	}
+	reply.Data = data
+	return reply
+}
+
+// 将其它节点回传的应答交给等待中的请求；已经超时的应答直接丢弃
+func (h *Hub) resolveClusterReply(msg *ClusterMessage) {
+	v, ok := h.calls.Load(msg.CallId)
+	if !ok {
+		return
+	}
+	var remote []clusterLineReply
+	if err := json.Unmarshal(msg.Data, &remote); err != nil {
+		fmt.Printf("[HUB] bad cluster reply from: %s, err: %v\n", msg.FromNode, err)
+	}
+	replies := make([]*LineReply, 0, len(remote))
+	for _, r := range remote {
+		reply := &LineReply{LineId: r.LineId}
+		if len(r.Err) > 0 {
+			reply.Err = remoteRequestError(r.Err)
+		} else {
+			reply.Packet, reply.Err = h.protocol.DecodeReq(r.Data)
+		}
+		replies = append(replies, reply)
+	}
+	select {
+	case v.(chan []*LineReply) <- replies:
+	default:
+	}
+}
+
+// 还原其它节点上的请求错误，使调用方可以通过 errors.Is 判断
+func remoteRequestError(msg string) error {
+	for _, err := range []error{ErrLineClosed, ErrLineNotFound, ErrNoProtocol, context.DeadlineExceeded, context.Canceled} {
+		if msg == err.Error() {
+			return err
+		}
+	}
+	return errors.New(msg)
+}
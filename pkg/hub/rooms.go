@@ -0,0 +1,196 @@
+package hub
+
+import (
+	"goapp/pkg/core"
+	"slices"
+)
+
+// 房间中的成员
+type RoomMember struct {
+	UserId   string
+	Platform core.Platform
+	LineId   string
+}
+
+// 连接加入房间；已关闭的连接不会加入
+func (ln *Line) JoinRoom(rooms ...string) {
+	h := ln.hub
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+
+	if ln.isClosed.Load() || h.isClosed.Load() {
+		return
+	}
+	for _, room := range rooms {
+		if len(room) == 0 {
+			continue
+		}
+		members, ok := h.rooms[room]
+		if !ok {
+			members = make(map[*Line]core.Empty)
+			h.rooms[room] = members
+		}
+		members[ln] = core.Empty{}
+		if ln.rooms == nil {
+			ln.rooms = make(map[string]core.Empty)
+		}
+		ln.rooms[room] = core.Empty{}
+	}
+}
+
+// 连接离开房间，不指定房间时离开所有房间
+func (ln *Line) LeaveRoom(rooms ...string) {
+	h := ln.hub
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+
+	if len(rooms) == 0 {
+		for room := range ln.rooms {
+			rooms = append(rooms, room)
+		}
+	}
+	for _, room := range rooms {
+		delete(ln.rooms, room)
+		members, ok := h.rooms[room]
+		if !ok {
+			continue
+		}
+		delete(members, ln)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// 连接所在的所有房间
+func (ln *Line) Rooms() []string {
+	ln.hub.roomsMutex.RLock()
+	defer ln.hub.roomsMutex.RUnlock()
+
+	rooms := make([]string, 0, len(ln.rooms))
+	for room := range ln.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// 将用户的指定连接加入房间，不指定连接时加入该用户的所有连接
+//
+// 如果使用了集群背板，用户在其它节点上的连接也会加入
+func (h *Hub) JoinRoom(room, userId string, lineIds ...string) {
+	if len(room) == 0 || len(userId) == 0 {
+		return
+	}
+	h.joinRoomLocal(room, userId, lineIds...)
+	if h.backplane != nil {
+		h.pool.Submit(func() {
+			h.forwardMessage(&ClusterMessage{Kind: ClusterMsgJoinRoom, Room: room, LineIds: lineIds}, []string{userId})
+		})
+	}
+}
+
+// 将用户的指定连接移出房间，不指定连接时移出该用户的所有连接
+//
+// 如果使用了集群背板，用户在其它节点上的连接也会移出
+func (h *Hub) LeaveRoom(room, userId string, lineIds ...string) {
+	if len(room) == 0 || len(userId) == 0 {
+		return
+	}
+	h.leaveRoomLocal(room, userId, lineIds...)
+	if h.backplane != nil {
+		h.pool.Submit(func() {
+			h.forwardMessage(&ClusterMessage{Kind: ClusterMsgLeaveRoom, Room: room, LineIds: lineIds}, []string{userId})
+		})
+	}
+}
+
+func (h *Hub) joinRoomLocal(room, userId string, lineIds ...string) {
+	for _, ln := range h.userLinesFor(userId, lineIds...) {
+		ln.JoinRoom(room)
+	}
+}
+
+func (h *Hub) leaveRoomLocal(room, userId string, lineIds ...string) {
+	for _, ln := range h.userLinesFor(userId, lineIds...) {
+		ln.LeaveRoom(room)
+	}
+}
+
+// 本节点上用户的指定连接，不指定时返回所有连接
+func (h *Hub) userLinesFor(userId string, lineIds ...string) []*Line {
+	uls := h.GetUserLines(userId)
+	if uls == nil {
+		return nil
+	}
+	uls.RLock()
+	defer uls.RUnlock()
+
+	lines := make([]*Line, 0, len(uls.lines))
+	for _, ln := range uls.lines {
+		if len(lineIds) == 0 || slices.Contains(lineIds, ln.id) {
+			lines = append(lines, ln)
+		}
+	}
+	return lines
+}
+
+// 向房间内的所有连接推送消息，排除指定的连接
+//
+// 如果使用了集群背板，房间内在其它节点上的连接也会收到消息
+func (h *Hub) PushToRoom(room string, data []byte, exceptLineIds ...string) {
+	if len(room) == 0 || len(data) == 0 {
+		return
+	}
+	h.pool.Submit(func() {
+		h.pushToRoomLocal(room, data, exceptLineIds...)
+		h.broadcastCluster(&ClusterMessage{Kind: ClusterMsgPushToRoom, Room: room, LineIds: exceptLineIds, Data: data})
+	})
+}
+
+func (h *Hub) pushToRoomLocal(room string, data []byte, exceptLineIds ...string) {
+	// 只在复制房间内的连接时持有读锁：入队可能因背压而阻塞，不能因为一个慢连接阻塞所有房间；
+	// 复制之后才断开的连接，其发送队列已关闭，入队时会被忽略
+	h.roomsMutex.RLock()
+	lines := make([]*Line, 0, len(h.rooms[room]))
+	for line := range h.rooms[room] {
+		if !slices.Contains(exceptLineIds, line.id) {
+			lines = append(lines, line)
+		}
+	}
+	h.roomsMutex.RUnlock()
+
+	for _, line := range lines {
+		line.enqueue(data)
+	}
+}
+
+// 本节点上房间内的所有成员
+func (h *Hub) RoomMembers(room string) []RoomMember {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+
+	members := make([]RoomMember, 0, len(h.rooms[room]))
+	for ln := range h.rooms[room] {
+		members = append(members, RoomMember{UserId: ln.userId, Platform: ln.platform, LineId: ln.id})
+	}
+	return members
+}
+
+// 本节点上房间内的连接数量
+func (h *Hub) RoomCount(room string) int {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	return len(h.rooms[room])
+}
+
+// 本节点上所有非空的房间
+func (h *Hub) Rooms() []string {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+
+	rooms := make([]string, 0, len(h.rooms))
+	for room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
@@ -0,0 +1,187 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapp/pkg/bytes"
+	"slices"
+	"time"
+)
+
+// 路由器生成的响应码，业务处理器可以通过 RpcError 返回自定义的响应码
+const (
+	RpcCodeOk          byte = 1
+	RpcCodeUnknownType byte = 2 // 没有注册该消息类型的处理器
+	RpcCodeBadRequest  byte = 3 // 请求格式错误
+	RpcCodeTimeout     byte = 4 // 处理超时
+	RpcCodeInternal    byte = 5 // 处理器内部错误
+	RpcCodeRejected    byte = 6 // 被中间件拒绝，比如限流、鉴权失败
+)
+
+// 处理器返回该错误时，使用其中的响应码响应客户端
+type RpcError struct {
+	Code byte
+	Msg  string
+}
+
+func (e *RpcError) Error() string { return fmt.Sprintf("rpc error, code: %d, msg: %s", e.Code, e.Msg) }
+
+// 出错时响应的负载
+type RpcErrorPayload struct {
+	Msg string `json:"msg" msgpack:"msg"`
+}
+
+// 一次 RPC 调用的上下文
+type RpcContext struct {
+	context.Context
+	Hub     *Hub
+	Message *LineMessage
+	Meta    *bytes.PacketMetaData
+	// 响应的消息类型，默认与请求相同
+	RespType byte
+
+	protocol *bytes.PacketProtocol
+}
+
+// 将请求的负载解码到 v 中
+func (c *RpcContext) Bind(v any) error {
+	_, err := c.protocol.DecodeReqTo(c.Message.Data, v)
+	return err
+}
+
+// 处理器：返回值会被编码为响应的负载
+type RpcHandler func(c *RpcContext) (any, error)
+
+type RpcMiddleware func(next RpcHandler) RpcHandler
+
+// 将解码为 T 的请求交给 handler 处理，解码失败时响应 RpcCodeBadRequest
+func TypedRpcHandler[T any](handler func(c *RpcContext, req *T) (any, error)) RpcHandler {
+	return func(c *RpcContext) (any, error) {
+		req := new(T)
+		if err := c.Bind(req); err != nil {
+			return nil, &RpcError{Code: RpcCodeBadRequest, Msg: err.Error()}
+		}
+		return handler(c, req)
+	}
+}
+
+// 基于 bytes.PacketProtocol 的 RPC 路由器：按消息类型分发客户端消息，并以相同的 RequestId 响应
+type RpcRouter struct {
+	hub         *Hub
+	protocol    *bytes.PacketProtocol
+	timeout     time.Duration
+	middlewares []RpcMiddleware
+	handlers    map[byte]RpcHandler
+}
+
+// timeout 为每个请求的处理超时时间，默认 10 秒
+func NewRpcRouter(hub *Hub, protocol *bytes.PacketProtocol, timeout time.Duration) *RpcRouter {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &RpcRouter{
+		hub:      hub,
+		protocol: protocol,
+		timeout:  timeout,
+		handlers: make(map[byte]RpcHandler),
+	}
+}
+
+// 添加全局中间件，只对之后注册的处理器生效
+func (r *RpcRouter) Use(middlewares ...RpcMiddleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// 注册处理器；middlewares 仅作用于该处理器，在全局中间件之后执行
+//
+// 注册需要在开始分发之前完成
+func (r *RpcRouter) Handle(msgType byte, handler RpcHandler, middlewares ...RpcMiddleware) {
+	all := append(slices.Clone(r.middlewares), middlewares...)
+	for i := len(all) - 1; i >= 0; i-- {
+		handler = all[i](handler)
+	}
+	r.handlers[msgType] = handler
+}
+
+// 异步分发客户端的消息
+func (r *RpcRouter) Dispatch(msg *LineMessage) error {
+	return r.hub.pool.Submit(func() {
+		r.serve(msg)
+	})
+}
+
+type rpcResult struct {
+	resp any
+	err  error
+}
+
+func (r *RpcRouter) serve(msg *LineMessage) {
+	meta, err := r.protocol.GetMeta(msg.Data)
+	if err != nil {
+		fmt.Printf("[HUB] rpc bad packet, userId: %s, lineId: %s, err: %v\n", msg.UserId, msg.LineId, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	c := &RpcContext{
+		Context:  ctx,
+		Hub:      r.hub,
+		Message:  msg,
+		Meta:     meta,
+		RespType: meta.MsgType,
+		protocol: r.protocol,
+	}
+
+	handler, ok := r.handlers[meta.MsgType]
+	if !ok {
+		r.respondError(c, &RpcError{Code: RpcCodeUnknownType, Msg: fmt.Sprintf("unknown msg type: %d", meta.MsgType)})
+		return
+	}
+
+	// 处理器在协程池中执行，超时后继续运行直到返回，协程池限制了同时运行的处理器数量
+	done := make(chan rpcResult, 1)
+	err = r.hub.pool.Submit(func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- rpcResult{err: fmt.Errorf("rpc handler panic: %v", rec)}
+			}
+		}()
+		resp, err := handler(c)
+		done <- rpcResult{resp, err}
+	})
+	if err != nil {
+		r.respondError(c, &RpcError{Code: RpcCodeRejected, Msg: "server busy"})
+		return
+	}
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			r.respondError(c, res.err)
+			return
+		}
+		r.respond(c, RpcCodeOk, res.resp)
+	case <-ctx.Done():
+		r.respondError(c, &RpcError{Code: RpcCodeTimeout, Msg: "timeout"})
+	}
+}
+
+func (r *RpcRouter) respondError(c *RpcContext, err error) {
+	var rpcErr *RpcError
+	if !errors.As(err, &rpcErr) {
+		fmt.Printf("[HUB] rpc handler failed, userId: %s, lineId: %s, msgType: %d, err: %v\n", c.Message.UserId, c.Message.LineId, c.Meta.MsgType, err)
+		rpcErr = &RpcError{Code: RpcCodeInternal, Msg: "internal error"}
+	}
+	r.respond(c, rpcErr.Code, &RpcErrorPayload{Msg: rpcErr.Msg})
+}
+
+func (r *RpcRouter) respond(c *RpcContext, code byte, payload any) {
+	resp, err := r.protocol.EncodeResp(int32(c.RespType), c.Meta.RequestId, code, payload)
+	if err != nil {
+		fmt.Printf("[HUB] rpc encode resp failed, userId: %s, lineId: %s, err: %v\n", c.Message.UserId, c.Message.LineId, err)
+		return
+	}
+	r.hub.PushToUserLines(c.Message.UserId, resp, c.Message.LineId)
+}
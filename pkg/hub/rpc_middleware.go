@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// 打印每次调用的耗时及结果
+func RpcLogger() RpcMiddleware {
+	return func(next RpcHandler) RpcHandler {
+		return func(c *RpcContext) (any, error) {
+			start := time.Now()
+			resp, err := next(c)
+			fmt.Printf("[HUB] rpc: userId->%v, platform->%v, line->%v, msgType->%v, requestId->%v, cost->%v, err->%v\n",
+				c.Message.UserId, c.Message.Platform, c.Message.LineId, c.Meta.MsgType, c.Meta.RequestId, time.Since(start), err)
+			return resp, err
+		}
+	}
+}
+
+type lineLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// 按连接限流：每 interval 放入一个令牌，桶的容量为 burst；超出时响应 RpcCodeRejected
+func RpcRateLimit(interval time.Duration, burst int) RpcMiddleware {
+	var mu sync.Mutex
+	limiters := make(map[string]*lineLimiter)
+	lastSweep := time.Now()
+
+	get := func(key string) *rate.Limiter {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Now()
+		// 定期清理长时间未使用的限流器，防止断开的连接一直占用内存
+		if now.Sub(lastSweep) > time.Minute {
+			lastSweep = now
+			for k, v := range limiters {
+				if now.Sub(v.lastSeen) > time.Minute {
+					delete(limiters, k)
+				}
+			}
+		}
+		l, ok := limiters[key]
+		if !ok {
+			l = &lineLimiter{limiter: rate.NewLimiter(rate.Every(interval), burst)}
+			limiters[key] = l
+		}
+		l.lastSeen = now
+		return l.limiter
+	}
+
+	return func(next RpcHandler) RpcHandler {
+		return func(c *RpcContext) (any, error) {
+			if !get(c.Message.UserId + ":" + c.Message.LineId).Allow() {
+				return nil, &RpcError{Code: RpcCodeRejected, Msg: "too many requests"}
+			}
+			return next(c)
+		}
+	}
+}
@@ -56,6 +56,23 @@ func (u *UserLines) Get(lineId string) *Line {
 	return nil
 }
 
+// 是否包含所有指定的连接
+func (u *UserLines) hasLines(lineIds ...string) bool {
+	if len(lineIds) == 0 {
+		return false
+	}
+
+	u.RLock()
+	defer u.RUnlock()
+
+	for _, id := range lineIds {
+		if !slices.ContainsFunc(u.lines, func(ln *Line) bool { return ln.id == id }) {
+			return false
+		}
+	}
+	return true
+}
+
 // 获取指定平台的所有连接
 func (u *UserLines) GetPlatformLines(platforms ...core.Platform) []*Line {
 	if len(platforms) == 0 {
@@ -83,7 +100,7 @@ func (u *UserLines) ClosePlatforms(platforms ...core.Platform) {
 	defer u.RUnlock()
 
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.closeChan == nil {
+		if line.isClosed.Load() || line.chansClosed.Load() {
 			continue
 		}
 		if slices.Contains(platforms, line.platform) {
@@ -102,7 +119,7 @@ func (u *UserLines) ClosePlatformsExcept(exceptPlatforms ...core.Platform) {
 	defer u.RUnlock()
 
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.closeChan == nil {
+		if line.isClosed.Load() || line.chansClosed.Load() {
 			continue
 		}
 		if !slices.Contains(exceptPlatforms, line.platform) {
@@ -121,7 +138,7 @@ func (u *UserLines) CloseLines(lineIds ...string) {
 	defer u.RUnlock()
 
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.closeChan == nil {
+		if line.isClosed.Load() || line.chansClosed.Load() {
 			continue
 		}
 		if slices.Contains(lineIds, line.id) {
@@ -141,7 +158,7 @@ func (u *UserLines) CloseLinesExcept(exceptLineIds ...string) {
 
 	for _, line := range u.lines {
 		if !slices.Contains(exceptLineIds, line.id) {
-			if line.isClosed.Load() || line.closeChan == nil {
+			if line.isClosed.Load() || line.chansClosed.Load() {
 				continue
 			}
 			line.closeChan <- core.Empty{}
@@ -160,7 +177,7 @@ func (u *UserLines) closeInactiveLines(maxIdleSeconds int64) {
 
 	for _, line := range u.lines {
 		if time.Now().Unix()-line.lastActive > maxIdleSeconds {
-			if line.isClosed.Load() || line.closeChan == nil {
+			if line.isClosed.Load() || line.chansClosed.Load() {
 				continue
 			}
 			line.closeChan <- core.Empty{}
@@ -174,7 +191,7 @@ func (u *UserLines) CloseAll() {
 	defer u.RUnlock()
 
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.closeChan == nil {
+		if line.isClosed.Load() || line.chansClosed.Load() {
 			continue
 		}
 		line.closeChan <- core.Empty{}
@@ -186,16 +203,24 @@ func (u *UserLines) PushMessage(data []byte) {
 	if len(data) == 0 {
 		return
 	}
+	u.pushMessage(data)
+}
 
+// 返回是否有连接接收了消息；连接都已关闭或正在排空时返回 false
+func (u *UserLines) pushMessage(data []byte) bool {
 	u.RLock()
 	defer u.RUnlock()
 
+	accepted := false
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
+		if line.isClosed.Load() || line.hub.isClosed.Load() {
 			continue
 		}
-		line.writeChan <- data
+		if line.enqueue(data) {
+			accepted = true
+		}
 	}
+	return accepted
 }
 
 // 向该用户的所有连接发送消息，除了指定平台
@@ -208,13 +233,13 @@ func (u *UserLines) PushMessageExceptPlatforms(data []byte, exceptPlatforms ...c
 	defer u.RUnlock()
 
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
+		if line.isClosed.Load() || line.hub.isClosed.Load() {
 			continue
 		}
 		if slices.Contains(exceptPlatforms, line.platform) {
 			continue
 		}
-		line.writeChan <- data
+		line.enqueue(data)
 	}
 }
 
@@ -228,13 +253,13 @@ func (u *UserLines) PushMessageExceptLines(data []byte, exceptLineIds ...string)
 	defer u.RUnlock()
 
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
+		if line.isClosed.Load() || line.hub.isClosed.Load() {
 			continue
 		}
 		if slices.Contains(exceptLineIds, line.id) {
 			continue
 		}
-		line.writeChan <- data
+		line.enqueue(data)
 	}
 }
 
@@ -248,11 +273,11 @@ func (u *UserLines) PushMessageToPlatforms(data []byte, platforms ...core.Platfo
 	defer u.RUnlock()
 
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
+		if line.isClosed.Load() || line.hub.isClosed.Load() {
 			continue
 		}
 		if slices.Contains(platforms, line.platform) {
-			line.writeChan <- data
+			line.enqueue(data)
 		}
 	}
 }
@@ -267,11 +292,11 @@ func (u *UserLines) PushMessageToLines(data []byte, lineIds ...string) {
 	defer u.RUnlock()
 
 	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
+		if line.isClosed.Load() || line.hub.isClosed.Load() {
 			continue
 		}
 		if slices.Contains(lineIds, line.id) {
-			line.writeChan <- data
+			line.enqueue(data)
 		}
 	}
 }
@@ -0,0 +1,141 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapp/pkg/core"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 关闭阶段：数值小的阶段先执行，同一阶段内的钩子并发执行
+type Stage int
+
+const (
+	StageIngress Stage = 100 // 停止接收新的请求和连接
+	StageDrain   Stage = 200 // 排空长连接，将未发送的消息发送完毕
+	StageWorkers Stage = 300 // 停止后台消费者、定时任务，等待处理中的任务完成
+	StageFlush   Stage = 400 // 刷新日志等缓冲
+	StageRelease Stage = 500 // 释放协程池、数据库、缓存等资源
+)
+
+// 默认的单个钩子超时时间
+const defaultHookTimeout = 10 * time.Second
+
+type hook struct {
+	name    string
+	stage   Stage
+	timeout time.Duration
+	fn      func(ctx context.Context) error
+}
+
+// 协调各子系统有序关闭
+type Manager struct {
+	mutex sync.Mutex
+	hooks []*hook
+
+	stopping atomic.Bool
+	doneChan chan core.Empty
+	err      error
+}
+
+func NewManager() *Manager {
+	return &Manager{doneChan: make(chan core.Empty)}
+}
+
+// 注册关闭钩子；timeout 为该钩子的最长执行时间，<=0 时为 10 秒
+//
+// 钩子应当在 ctx 结束时尽快返回；开始关闭之后注册的钩子不会被执行
+func (m *Manager) Register(name string, stage Stage, timeout time.Duration, fn func(ctx context.Context) error) {
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hooks = append(m.hooks, &hook{name, stage, timeout, fn})
+}
+
+// 是否已经开始关闭
+func (m *Manager) Stopping() bool { return m.stopping.Load() }
+
+// 所有钩子执行完毕后关闭
+func (m *Manager) Done() <-chan core.Empty { return m.doneChan }
+
+// 按阶段依次执行所有钩子，返回所有钩子的错误；只会执行一次，重复调用时等待第一次调用完成
+//
+// ctx 为整个关闭过程的期限，超时后剩余的钩子仍会执行，但会立即得到已结束的 ctx
+func (m *Manager) Shutdown(ctx context.Context) error {
+	if !m.stopping.CompareAndSwap(false, true) {
+		select {
+		case <-m.doneChan:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return m.err
+	}
+	defer close(m.doneChan)
+
+	m.mutex.Lock()
+	hooks := slices.Clone(m.hooks)
+	m.mutex.Unlock()
+	slices.SortStableFunc(hooks, func(a, b *hook) int { return int(a.stage - b.stage) })
+
+	var errs []error
+	for start := 0; start < len(hooks); {
+		end := start + 1
+		for end < len(hooks) && hooks[end].stage == hooks[start].stage {
+			end++
+		}
+		errs = append(errs, m.runStage(ctx, hooks[start:end])...)
+		start = end
+	}
+	m.err = errors.Join(errs...)
+	return m.err
+}
+
+func (m *Manager) runStage(ctx context.Context, hooks []*hook) []error {
+	errs := make([]error, len(hooks))
+	var wg sync.WaitGroup
+	for i, h := range hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = runHook(ctx, h)
+		}()
+	}
+	wg.Wait()
+	return slices.DeleteFunc(errs, func(err error) bool { return err == nil })
+}
+
+func runHook(ctx context.Context, h *hook) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s: %w", h.name, err)
+		}
+		fmt.Printf("[LIFECYCLE] hook done, stage: %d, name: %s, cost: %v, err: %v\n", h.stage, h.name, time.Since(start), err)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- h.fn(ctx)
+	}()
+	// 钩子没有遵守 ctx 时，不再等待它，继续执行后面的钩子
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 计数器，只增不减
+type Counter interface {
+	Add(delta float64, labelValues ...string)
+}
+
+// 可增可减的瞬时值
+type Gauge interface {
+	Set(value float64, labelValues ...string)
+	Add(delta float64, labelValues ...string)
+}
+
+// 分布统计，一般用于耗时
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// 指标的提供者
+//
+// 相同名称的指标多次创建时，应当返回同一个指标；labelValues 的顺序与创建时的 labelNames 一致
+type Provider interface {
+	Counter(name, help string, labelNames ...string) Counter
+	Gauge(name, help string, labelNames ...string) Gauge
+	// buckets 为空时使用实现的默认分桶
+	Histogram(name, help string, buckets []float64, labelNames ...string) Histogram
+}
+
+type nop struct{}
+
+func (nop) Add(float64, ...string)     {}
+func (nop) Set(float64, ...string)     {}
+func (nop) Observe(float64, ...string) {}
+
+func (nop) Counter(string, string, ...string) Counter                { return nop{} }
+func (nop) Gauge(string, string, ...string) Gauge                    { return nop{} }
+func (nop) Histogram(string, string, []float64, ...string) Histogram { return nop{} }
+
+// 不做任何统计的提供者
+func Nop() Provider { return nop{} }
+
+type holder struct{ p Provider }
+
+var defaultProvider atomic.Value
+
+func init() {
+	defaultProvider.Store(holder{nop{}})
+}
+
+// 设置默认的提供者，需要在创建各组件之前调用；p 为 nil 时恢复为 Nop
+func SetDefault(p Provider) {
+	if p == nil {
+		p = nop{}
+	}
+	defaultProvider.Store(holder{p})
+}
+
+// 默认的提供者，未设置时为 Nop
+func Default() Provider { return defaultProvider.Load().(holder).p }
+
+// 返回 p，p 为 nil 时返回默认的提供者
+func Or(p Provider) Provider {
+	if p == nil {
+		return Default()
+	}
+	return p
+}
+
+// 记录从 start 开始经过的秒数
+func ObserveSince(h Histogram, start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
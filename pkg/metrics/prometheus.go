@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 基于 Prometheus 的提供者
+type PrometheusProvider struct {
+	mutex     sync.Mutex
+	namespace string
+	registry  *prometheus.Registry
+	metrics   map[string]any // key: name
+}
+
+// namespace 为所有指标名称的前缀，可以为空；同时会注册 Go 运行时及进程的指标
+func NewPrometheusProvider(namespace string) *PrometheusProvider {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return &PrometheusProvider{
+		namespace: namespace,
+		registry:  registry,
+		metrics:   make(map[string]any),
+	}
+}
+
+// 指标的注册表，可以用来注册其它的 Collector
+func (p *PrometheusProvider) Registry() *prometheus.Registry { return p.registry }
+
+// 用于暴露指标的 HTTP 处理器
+func (p *PrometheusProvider) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{Registry: p.registry})
+}
+
+// 获取已经创建的指标，不存在时调用 create 创建并注册
+func (p *PrometheusProvider) getOrCreate(name string, create func() prometheus.Collector) any {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if m, ok := p.metrics[name]; ok {
+		return m
+	}
+	c := create()
+	p.registry.MustRegister(c)
+	p.metrics[name] = c
+	return c
+}
+
+func (p *PrometheusProvider) Counter(name, help string, labelNames ...string) Counter {
+	vec, ok := p.getOrCreate(name, func() prometheus.Collector {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: p.namespace, Name: name, Help: help}, labelNames)
+	}).(*prometheus.CounterVec)
+	if !ok {
+		return nop{}
+	}
+	return promCounter{vec}
+}
+
+func (p *PrometheusProvider) Gauge(name, help string, labelNames ...string) Gauge {
+	vec, ok := p.getOrCreate(name, func() prometheus.Collector {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: p.namespace, Name: name, Help: help}, labelNames)
+	}).(*prometheus.GaugeVec)
+	if !ok {
+		return nop{}
+	}
+	return promGauge{vec}
+}
+
+func (p *PrometheusProvider) Histogram(name, help string, buckets []float64, labelNames ...string) Histogram {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	vec, ok := p.getOrCreate(name, func() prometheus.Collector {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: p.namespace, Name: name, Help: help, Buckets: buckets}, labelNames)
+	}).(*prometheus.HistogramVec)
+	if !ok {
+		return nop{}
+	}
+	return promHistogram{vec}
+}
+
+type promCounter struct{ vec *prometheus.CounterVec }
+
+func (c promCounter) Add(delta float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+type promGauge struct{ vec *prometheus.GaugeVec }
+
+func (g promGauge) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+func (g promGauge) Add(delta float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+type promHistogram struct{ vec *prometheus.HistogramVec }
+
+func (h promHistogram) Observe(value float64, labelValues ...string) {
+	h.vec.WithLabelValues(labelValues...).Observe(value)
+}
@@ -0,0 +1,283 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// 同时只有持有此咨询锁的一个进程执行迁移
+const migrateLockKey int64 = 0x6d696772617465 // "migrate"
+
+// 迁移脚本的文件名：{版本}_{名称}.up.sql 及 {版本}_{名称}.down.sql，版本为正整数，按版本从小到大执行
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([\w-]+)\.(up|down)\.sql$`)
+
+var ErrNoDownScript = errors.New("no down script")
+
+// 一个版本的迁移
+type Migration struct {
+	Version   int64
+	Name      string
+	Up        string
+	Down      string
+	Checksum  string    // up 脚本的 sha256
+	AppliedAt time.Time // 未执行时为零值
+}
+
+func (m *Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// 已执行的迁移记录
+const recordTable = "schema_migrations"
+
+type record struct {
+	bun.BaseModel `bun:"table:schema_migrations"`
+
+	Version   int64     `bun:"version,pk"`
+	Name      string    `bun:"name,notnull"`
+	Checksum  string    `bun:"checksum,notnull"`
+	AppliedAt time.Time `bun:"applied_at,notnull,default:current_timestamp"`
+}
+
+type Option func(*Migrator)
+
+// 只输出将要执行的脚本，不修改数据库
+func WithDryRun(w io.Writer) Option {
+	return func(m *Migrator) {
+		m.dryRun = w
+	}
+}
+
+// 执行迁移时输出进度
+func WithLogger(w io.Writer) Option {
+	return func(m *Migrator) {
+		m.log = w
+	}
+}
+
+// 执行 fsys 根目录中的 SQL 迁移脚本，已执行的版本记录在 schema_migrations 表中
+//
+// 每个版本在独立的事务中执行；多个进程同时执行时通过 PostgreSQL 的咨询锁互斥
+type Migrator struct {
+	db     *bun.DB
+	fsys   fs.FS
+	dryRun io.Writer
+	log    io.Writer
+}
+
+func NewMigrator(db *bun.DB, fsys fs.FS, options ...Option) *Migrator {
+	m := &Migrator{db: db, fsys: fsys, log: io.Discard}
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+// 读取所有的迁移脚本，按版本排序
+func (m *Migrator) Load() ([]*Migration, error) {
+	entries, err := fs.ReadDir(m.fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		data, err := fs.ReadFile(m.fsys, path.Join(".", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mg
+		} else if mg.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s, %s", version, mg.Name, match[2])
+		}
+		if match[3] == "up" {
+			mg.Up = string(data)
+			sum := sha256.Sum256(data)
+			mg.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mg.Down = string(data)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if len(mg.Up) == 0 {
+			return nil, fmt.Errorf("migration %s has no up script", mg)
+		}
+		migrations = append(migrations, mg)
+	}
+	slices.SortFunc(migrations, func(a, b *Migration) int { return int(a.Version - b.Version) })
+	return migrations, nil
+}
+
+// 所有的迁移及其执行时间
+func (m *Migrator) Status(ctx context.Context) ([]*Migration, error) {
+	migrations, err := m.Load()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	for _, mg := range migrations {
+		if r, ok := applied[mg.Version]; ok {
+			mg.AppliedAt = r.AppliedAt
+		}
+	}
+	return migrations, nil
+}
+
+// 按顺序执行所有未执行的迁移，返回执行的迁移
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	migrations, err := m.Load()
+	if err != nil {
+		return nil, err
+	}
+	var done []*Migration
+	err = m.withLock(ctx, func(conn bun.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range migrations {
+			if r, ok := applied[mg.Version]; ok {
+				if r.Checksum != mg.Checksum {
+					fmt.Fprintf(m.log, "migration %s changed after applied, ignored\n", mg)
+				}
+				continue
+			}
+			if err := m.run(ctx, conn, mg, mg.Up, true); err != nil {
+				return err
+			}
+			done = append(done, mg)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// 按倒序回滚最近执行的 steps 个迁移，返回回滚的迁移
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	migrations, err := m.Load()
+	if err != nil {
+		return nil, err
+	}
+	var done []*Migration
+	err = m.withLock(ctx, func(conn bun.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range slices.Backward(migrations) {
+			if len(done) >= steps {
+				break
+			}
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			if len(mg.Down) == 0 {
+				return fmt.Errorf("%w: %s", ErrNoDownScript, mg)
+			}
+			if err := m.run(ctx, conn, mg, mg.Down, false); err != nil {
+				return err
+			}
+			done = append(done, mg)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// 在同一个连接上持有咨询锁并执行 fn；dry run 时不加锁
+func (m *Migrator) withLock(ctx context.Context, fn func(conn bun.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dryRun != nil {
+		return fn(conn)
+	}
+	fmt.Fprintln(m.log, "waiting for migration lock...")
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", migrateLockKey); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(?)", migrateLockKey)
+
+	_, err = conn.NewCreateTable().Model((*record)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// 已执行的迁移；迁移记录表不存在时视为都未执行
+func (m *Migrator) applied(ctx context.Context, db bun.IDB) (map[int64]*record, error) {
+	var exists bool
+	err := db.NewSelect().ColumnExpr("to_regclass(?) IS NOT NULL", recordTable).Scan(ctx, &exists)
+	if err != nil || !exists {
+		return map[int64]*record{}, err
+	}
+	var records []*record
+	if err := db.NewSelect().Model(&records).Scan(ctx); err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]*record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// 在事务中执行脚本并更新迁移记录
+func (m *Migrator) run(ctx context.Context, conn bun.Conn, mg *Migration, script string, up bool) error {
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	if m.dryRun != nil {
+		fmt.Fprintf(m.dryRun, "-- %s.%s.sql\n%s\n", mg, direction, script)
+		return nil
+	}
+
+	fmt.Fprintf(m.log, "migrating %s %s...\n", mg, direction)
+	start := time.Now()
+	err := conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// 脚本可能包含 ? 等字符，不经过 bun 的格式化，直接交给驱动执行
+		if _, err := tx.Tx.ExecContext(ctx, script); err != nil {
+			return err
+		}
+		if up {
+			_, err := tx.NewInsert().Model(&record{Version: mg.Version, Name: mg.Name, Checksum: mg.Checksum}).Exec(ctx)
+			return err
+		}
+		_, err := tx.NewDelete().Model((*record)(nil)).Where("version = ?", mg.Version).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("migration %s %s failed: %w", mg, direction, err)
+	}
+	fmt.Fprintf(m.log, "migrated %s %s in %s\n", mg, direction, time.Since(start).Round(time.Millisecond))
+	return nil
+}
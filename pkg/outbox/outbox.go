@@ -0,0 +1,91 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"goapp/pkg/bytes"
+	"goapp/pkg/distribute"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// 消息发布的目标，Relay 根据目标选择 Publisher
+const (
+	DestinationQueue = "queue" // distribute.MessageQueue
+	DestinationRmq   = "rmq"   // rmq.Queue，主题即队列名
+)
+
+// 发布时附加在消息中的 outbox id，消费者可以据此去重
+const HeaderOutboxId = "_outbox_id"
+
+// 计算聚合咨询锁的键时使用的种子，与其它咨询锁区分
+const aggregateLockSeed int64 = 0x6f62616767 // "obagg"
+
+// 消息内容的编码方式，msgpack 可以保留 []byte 类型的字段
+var bodyMarshaler bytes.PayloadMarshaler = &bytes.MsgPackMarshaler{}
+
+// 待发布的消息，与业务数据在同一个事务中写入，由 Relay 在事务提交后发布
+type Message struct {
+	bun.BaseModel `bun:"outbox,alias:ob"`
+
+	ID           int64     `bun:"id,pk,autoincrement"`           // 自增 id
+	Destination  string    `bun:"destination,notnull"`           // 发布的目标
+	Topic        string    `bun:"topic,notnull"`                 // 主题
+	AggregateKey string    `bun:"aggregate_key,notnull"`         // 聚合的键，比如用户 id，相同键的消息保证顺序
+	Seq          int64     `bun:"seq,notnull"`                   // 聚合内的序号，与写入事务的提交顺序一致，同一聚合的消息按此顺序发布
+	Body         []byte    `bun:"body,notnull,type:bytea"`       // 编码后的消息内容
+	Attempts     int       `bun:"attempts,notnull,default:0"`    // 发布失败的次数
+	LastError    string    `bun:"last_error,notnull,default:''"` // 最后一次发布失败的错误
+	CreatedAt    time.Time `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+	AvailableAt  time.Time `bun:"available_at,notnull,nullzero,default:current_timestamp"` // 失败后下次发布的时间
+	SentAt       time.Time `bun:"sent_at,nullzero"`                                        // 发布成功的时间，为空时待发布
+}
+
+// 解码消息内容
+func (m *Message) DecodeBody() (map[string]any, error) {
+	body := make(map[string]any)
+	err := bodyMarshaler.Unmarshal(m.Body, &body)
+	return body, err
+}
+
+// 在 db 中写入一条待发布的消息；db 一般为业务数据所在的 bun.Tx，事务回滚时消息同样不会发布
+//
+// 写入时持有聚合的咨询锁直到事务结束，同一聚合的写入事务依次提交，序号的顺序即提交的顺序；
+// 自增 id 在提交前分配，不同事务的提交顺序可能与 id 不同。db 不是事务时在新的事务中写入
+func Add(ctx context.Context, db bun.IDB, destination, topic, key string, body map[string]any) error {
+	if len(body) == 0 {
+		return errors.New("empty message body")
+	}
+	if d, ok := db.(*bun.DB); ok {
+		return d.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			return Add(ctx, tx, destination, topic, key, body)
+		})
+	}
+	data, err := bodyMarshaler.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewRaw("SELECT pg_advisory_xact_lock(hashtextextended(?, ?))", key, aggregateLockSeed).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewInsert().Model(&Message{
+		Destination:  destination,
+		Topic:        topic,
+		AggregateKey: key,
+		Body:         data,
+	}).
+		Value("seq", "(SELECT coalesce(max(seq), 0) + 1 FROM outbox WHERE aggregate_key = ?)", key).
+		Exec(ctx)
+	return err
+}
+
+// 写入一条发布到 distribute.MessageQueue 的类型化消息，消费者通过 distribute.Topic 订阅
+func AddTopic[T any](ctx context.Context, db bun.IDB, topic *distribute.Topic[T], key string, msg T) error {
+	body, err := topic.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return Add(ctx, db, DestinationQueue, topic.Name(), key, body)
+}
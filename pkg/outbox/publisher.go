@@ -0,0 +1,46 @@
+package outbox
+
+import (
+	"context"
+	"goapp/pkg/bytes"
+	"goapp/pkg/distribute"
+	"goapp/pkg/rmq"
+	"strconv"
+)
+
+// 将 outbox 中的消息发布到消息队列
+type Publisher interface {
+	Publish(ctx context.Context, msg *Message, body map[string]any) error
+}
+
+type PublisherFunc func(ctx context.Context, msg *Message, body map[string]any) error
+
+func (f PublisherFunc) Publish(ctx context.Context, msg *Message, body map[string]any) error {
+	return f(ctx, msg, body)
+}
+
+// 发布到 distribute.MessageQueue，消息内容中附加 HeaderOutboxId
+func QueuePublisher(queue distribute.MessageQueue) Publisher {
+	return PublisherFunc(func(ctx context.Context, msg *Message, body map[string]any) error {
+		body[HeaderOutboxId] = msg.ID
+		return queue.Publish(ctx, msg.Topic, body)
+	})
+}
+
+// 发布到 RabbitMQ 中与主题同名的队列；消息内容由 marshaler 编码（为 nil 时使用 msgpack），MessageId 为 outbox id
+func RmqPublisher(client *rmq.Client, marshaler bytes.PayloadMarshaler) Publisher {
+	if marshaler == nil {
+		marshaler = bodyMarshaler
+	}
+	return PublisherFunc(func(ctx context.Context, msg *Message, body map[string]any) error {
+		queue, err := client.NewQueue(msg.Topic)
+		if err != nil {
+			return err
+		}
+		data, err := marshaler.Marshal(body)
+		if err != nil {
+			return err
+		}
+		return queue.Push(data, rmq.WithMsgID(strconv.FormatInt(msg.ID, 10)), rmq.WithMsgType(msg.Topic))
+	})
+}
@@ -0,0 +1,254 @@
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"goapp/pkg/metrics"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// 多个 Relay 同时运行时，只有持有此咨询锁的一个发布，保证同一聚合的消息按顺序发布
+const relayLockKey int64 = 0x6f7574626f78 // "outbox"
+
+type RelayOption func(*Relay)
+
+// 注册发布目标对应的 Publisher
+func WithPublisher(destination string, p Publisher) RelayOption {
+	return func(r *Relay) {
+		r.publishers[destination] = p
+	}
+}
+
+// 没有待发布的消息时，轮询的间隔；默认 1 秒
+func WithPollInterval(interval time.Duration) RelayOption {
+	return func(r *Relay) {
+		if interval > 0 {
+			r.interval = interval
+		}
+	}
+}
+
+// 每批发布的消息数；默认 100
+func WithBatchSize(n int) RelayOption {
+	return func(r *Relay) {
+		if n > 0 {
+			r.batchSize = n
+		}
+	}
+}
+
+// 发布失败后重试的间隔：第 n 次失败后等待 base * 2^(n-1)，最长为 max；默认 1 秒、5 分钟
+//
+// 消息不会被丢弃，失败的消息会一直重试，期间同一聚合后续的消息都不会发布
+func WithRetryBackoff(base, max time.Duration) RelayOption {
+	return func(r *Relay) {
+		if base > 0 {
+			r.retryBase = base
+		}
+		if max > 0 {
+			r.retryMax = max
+		}
+	}
+}
+
+// 已发布的消息保留的时间，之后被删除；默认 7 天
+func WithRetention(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		if d > 0 {
+			r.retention = d
+		}
+	}
+}
+
+// 设置指标的提供者，未设置时使用 metrics.Default()
+func WithMetrics(p metrics.Provider) RelayOption {
+	return func(r *Relay) {
+		r.metrics = newRelayMetrics(p)
+	}
+}
+
+// 将 outbox 中的消息发布到消息队列，发布成功后标记为已发布
+//
+// 消息至少发布一次：发布后标记失败时会再次发布，消费者需要根据 HeaderOutboxId 去重
+type Relay struct {
+	db         *bun.DB
+	publishers map[string]Publisher
+	interval   time.Duration
+	batchSize  int
+	retryBase  time.Duration
+	retryMax   time.Duration
+	retention  time.Duration
+	metrics    *relayMetrics
+}
+
+func NewRelay(db *bun.DB, options ...RelayOption) *Relay {
+	r := &Relay{
+		db:         db,
+		publishers: make(map[string]Publisher),
+		interval:   time.Second,
+		batchSize:  100,
+		retryBase:  time.Second,
+		retryMax:   5 * time.Minute,
+		retention:  7 * 24 * time.Hour,
+		metrics:    newRelayMetrics(nil),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// 持续发布，直到 ctx 结束；ctx 结束时等待正在发布的一批完成后返回
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		// 一批发满时立即发布下一批
+		for ctx.Err() == nil {
+			n, err := r.RelayOnce(context.WithoutCancel(ctx))
+			if err != nil {
+				fmt.Printf("[OUTBOX] relay failed, err: %v\n", err)
+			}
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+		if time.Since(lastCleanup) > time.Hour {
+			lastCleanup = time.Now()
+			if n, err := r.Cleanup(ctx); err != nil {
+				fmt.Printf("[OUTBOX] cleanup failed, err: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("[OUTBOX] cleanup sent messages: %d\n", n)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 发布一批消息，返回本批处理的消息数（包括发布失败的）；其它 Relay 正在发布时返回 0
+//
+// 发布期间只在一个连接上持有咨询锁，不持有事务：每条消息发布后立即标记，一条发布失败不会导致本批已发布的消息再次发布
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	err = conn.NewSelect().ColumnExpr("pg_try_advisory_lock(?)", relayLockKey).Scan(ctx, &locked)
+	if err != nil || !locked {
+		return 0, err
+	}
+	defer conn.NewRaw("SELECT pg_advisory_unlock(?)", relayLockKey).Exec(context.WithoutCancel(ctx))
+
+	// 跳过有消息正在等待重试的聚合
+	var msgs []*Message
+	err = conn.NewSelect().Model(&msgs).
+		Where("sent_at IS NULL").
+		Where("aggregate_key NOT IN (SELECT aggregate_key FROM outbox WHERE sent_at IS NULL AND available_at > now())").
+		Order("id").
+		Limit(r.batchSize).
+		Scan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// 同一聚合内按序号发布
+	slices.SortStableFunc(msgs, func(a, b *Message) int {
+		return cmp.Or(strings.Compare(a.AggregateKey, b.AggregateKey), cmp.Compare(a.Seq, b.Seq))
+	})
+
+	// 本批中发布失败的聚合，其后续的消息留到下次
+	handled := 0
+	blocked := make(map[string]bool)
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			break
+		}
+		handled++
+		if blocked[msg.AggregateKey] {
+			continue
+		}
+		if err := r.publish(ctx, msg); err != nil {
+			blocked[msg.AggregateKey] = true
+			r.metrics.relayed.Add(1, msg.Destination, "failed")
+			fmt.Printf("[OUTBOX] publish failed, id: %d, topic: %s, key: %s, attempts: %d, err: %v\n", msg.ID, msg.Topic, msg.AggregateKey, msg.Attempts+1, err)
+			if err := r.markFailed(ctx, conn, msg, err); err != nil {
+				return handled, err
+			}
+			continue
+		}
+		_, err := conn.NewUpdate().Model((*Message)(nil)).
+			Set("sent_at = now()").
+			Where("id = ?", msg.ID).
+			Exec(ctx)
+		if err != nil {
+			return handled, err
+		}
+		r.metrics.relayed.Add(1, msg.Destination, "ok")
+		metrics.ObserveSince(r.metrics.lag, msg.CreatedAt, msg.Destination)
+	}
+	return handled, nil
+}
+
+func (r *Relay) publish(ctx context.Context, msg *Message) error {
+	p, ok := r.publishers[msg.Destination]
+	if !ok {
+		return fmt.Errorf("no publisher for destination: %s", msg.Destination)
+	}
+	body, err := msg.DecodeBody()
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, msg, body)
+}
+
+func (r *Relay) markFailed(ctx context.Context, db bun.IDB, msg *Message, cause error) error {
+	delay := r.retryBase
+	for i := 0; i < msg.Attempts && delay < r.retryMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, r.retryMax)
+	_, err := db.NewUpdate().Model((*Message)(nil)).
+		Set("attempts = attempts + 1").
+		Set("last_error = ?", cause.Error()).
+		Set("available_at = now() + ? * interval '1 millisecond'", delay.Milliseconds()).
+		Where("id = ?", msg.ID).
+		Exec(ctx)
+	return err
+}
+
+// 删除超过保留时间的已发布消息，返回删除的数量
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	res, err := r.db.NewDelete().Model((*Message)(nil)).
+		Where("sent_at < now() - ? * interval '1 second'", int64(r.retention.Seconds())).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type relayMetrics struct {
+	relayed metrics.Counter   // 发布的消息数，按目标和结果区分
+	lag     metrics.Histogram // 从写入到发布成功的时间
+}
+
+func newRelayMetrics(p metrics.Provider) *relayMetrics {
+	p = metrics.Or(p)
+	return &relayMetrics{
+		relayed: p.Counter("outbox_relayed_total", "Outbox messages relayed to a queue.", "destination", "result"),
+		lag:     p.Histogram("outbox_lag_seconds", "Time between writing an outbox message and publishing it.", nil, "destination"),
+	}
+}
@@ -0,0 +1,45 @@
+package presence
+
+import (
+	"context"
+	"goapp/pkg/core"
+	"time"
+)
+
+// 连接的类型
+type LineKind string
+
+const (
+	LineKindWebSocket LineKind = "ws"
+	LineKindSSE       LineKind = "sse"
+)
+
+// 一条在线连接的信息
+type LineInfo struct {
+	UserId      string        `json:"userId"`
+	Node        string        `json:"node"`
+	Kind        LineKind      `json:"kind"`
+	LineId      string        `json:"lineId"`
+	Platform    core.Platform `json:"platform"`
+	ConnectedAt int64         `json:"connectedAt"` // unix 秒
+}
+
+// 在线状态注册表：记录集群内所有节点上的连接
+//
+// 连接需要通过 Heartbeat 定期续期，超过 TTL 未续期的连接视为已离线（比如节点宕机）
+type Registry interface {
+	// 当前节点的标识
+	Node() string
+	// 续期间隔必须小于 TTL，一般为 TTL 的 1/3
+	TTL() time.Duration
+	Register(ctx context.Context, line *LineInfo) error
+	Unregister(ctx context.Context, line *LineInfo) error
+	// 批量续期
+	Heartbeat(ctx context.Context, lines []*LineInfo) error
+	// 用户是否在任一节点上有连接
+	IsOnline(ctx context.Context, userId string) (bool, error)
+	// 获取用户在所有节点上的连接
+	ListLines(ctx context.Context, userId string) ([]*LineInfo, error)
+	// 在线的用户数
+	CountOnline(ctx context.Context) (int64, error)
+}
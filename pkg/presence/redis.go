@@ -0,0 +1,184 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goapp/pkg/cache"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// KEYS: lines, infos, online
+	// ARGV: member, expireAt, info, userId, ttl
+	luaRegister = redis.NewScript(`
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], ARGV[5])
+	redis.call('PEXPIRE', KEYS[2], ARGV[5])
+	local cur = redis.call('ZSCORE', KEYS[3], ARGV[4])
+	if cur == false or tonumber(cur) < tonumber(ARGV[2]) then
+		redis.call('ZADD', KEYS[3], ARGV[2], ARGV[4])
+	end
+	return 1
+	`)
+	// KEYS: lines, infos, online
+	// ARGV: member, now, userId
+	luaUnregister = redis.NewScript(`
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	redis.call('HDEL', KEYS[2], ARGV[1])
+	local expired = redis.call('ZRANGE', KEYS[1], '-inf', ARGV[2], 'BYSCORE')
+	if #expired > 0 then
+		redis.call('ZREM', KEYS[1], unpack(expired))
+		redis.call('HDEL', KEYS[2], unpack(expired))
+	end
+	local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	if #last == 0 then
+		redis.call('ZREM', KEYS[3], ARGV[3])
+	else
+		redis.call('ZADD', KEYS[3], last[2], ARGV[3])
+	end
+	return 1
+	`)
+)
+
+// 基于 Redis 的在线状态注册表
+//
+// {prefix}:lines:{userId} 为 ZSET，成员为 kind:node:lineId，分数为过期时间（毫秒）
+//
+// 成员包含节点标识：同一 lineId 重连到其他节点后，旧节点上的注销不会删掉新连接
+//
+// {prefix}:infos:{userId} 为 HASH，存放连接的详细信息
+//
+// {prefix}:online 为 ZSET，成员为 userId，分数为该用户所有连接中最晚的过期时间
+type RedisRegistry struct {
+	cache  *cache.Cache
+	prefix string
+	node   string
+	ttl    time.Duration
+}
+
+// ttl 默认 90 秒
+func NewRedisRegistry(cache *cache.Cache, prefix, node string, ttl time.Duration) *RedisRegistry {
+	if ttl <= 0 {
+		ttl = 90 * time.Second
+	}
+	return &RedisRegistry{cache: cache, prefix: prefix, node: node, ttl: ttl}
+}
+
+func (r *RedisRegistry) Node() string { return r.node }
+
+func (r *RedisRegistry) TTL() time.Duration { return r.ttl }
+
+func (r *RedisRegistry) linesKey(userId string) string {
+	return fmt.Sprintf("%s:lines:%s", r.prefix, userId)
+}
+
+func (r *RedisRegistry) infosKey(userId string) string {
+	return fmt.Sprintf("%s:infos:%s", r.prefix, userId)
+}
+
+func (r *RedisRegistry) onlineKey() string {
+	return r.prefix + ":online"
+}
+
+func (r *RedisRegistry) member(line *LineInfo) string {
+	if len(line.Node) == 0 {
+		line.Node = r.node
+	}
+	return string(line.Kind) + ":" + line.Node + ":" + line.LineId
+}
+
+func (r *RedisRegistry) register(ctx context.Context, c redis.Scripter, line *LineInfo) error {
+	member := r.member(line)
+	info, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	expireAt := time.Now().Add(r.ttl).UnixMilli()
+	keys := []string{r.linesKey(line.UserId), r.infosKey(line.UserId), r.onlineKey()}
+	return luaRegister.Eval(ctx, c, keys, member, expireAt, info, line.UserId, r.ttl.Milliseconds()).Err()
+}
+
+func (r *RedisRegistry) Register(ctx context.Context, line *LineInfo) error {
+	if line == nil {
+		return nil
+	}
+	return r.register(ctx, r.cache.Master(), line)
+}
+
+func (r *RedisRegistry) Unregister(ctx context.Context, line *LineInfo) error {
+	if line == nil {
+		return nil
+	}
+	keys := []string{r.linesKey(line.UserId), r.infosKey(line.UserId), r.onlineKey()}
+	return luaUnregister.Run(ctx, r.cache.Master(), keys, r.member(line), time.Now().UnixMilli(), line.UserId).Err()
+}
+
+func (r *RedisRegistry) Heartbeat(ctx context.Context, lines []*LineInfo) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	pipe := r.cache.Master().Pipeline()
+	for _, line := range lines {
+		if err := r.register(ctx, pipe, line); err != nil && err != redis.Nil {
+			return err
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisRegistry) IsOnline(ctx context.Context, userId string) (bool, error) {
+	score, err := r.cache.Master().ZScore(ctx, r.onlineKey(), userId).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return int64(score) > time.Now().UnixMilli(), nil
+}
+
+func (r *RedisRegistry) ListLines(ctx context.Context, userId string) ([]*LineInfo, error) {
+	members, err := r.cache.Master().ZRangeByScore(ctx, r.linesKey(userId), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	vals, err := r.cache.Master().HMGet(ctx, r.infosKey(userId), members...).Result()
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]*LineInfo, 0, len(vals))
+	for _, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var line LineInfo
+		if err := json.Unmarshal([]byte(str), &line); err != nil {
+			continue
+		}
+		lines = append(lines, &line)
+	}
+	return lines, nil
+}
+
+func (r *RedisRegistry) CountOnline(ctx context.Context) (int64, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	// 顺便清理已过期的用户
+	if err := r.cache.Master().ZRemRangeByScore(ctx, r.onlineKey(), "-inf", now).Err(); err != nil {
+		return 0, err
+	}
+	return r.cache.Master().ZCard(ctx, r.onlineKey()).Result()
+}
@@ -0,0 +1,101 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"goapp/pkg/core"
+	"time"
+)
+
+// 访问注册表时的超时时间
+const trackerTimeout = 5 * time.Second
+
+// 将一个节点上的连接同步到注册表：连接加入、断开时注册、注销，并定期为所有连接续期
+//
+// 供 pkg/hub 与 pkg/sse 共用；nil 的 Tracker 上调用任何方法都不做任何事
+type Tracker struct {
+	registry Registry
+	pool     core.CoroutinePool
+	tag      string
+	collect  func() []*LineInfo
+	cancel   context.CancelFunc
+}
+
+// tag 为日志前缀，如 "HUB"；collect 返回本节点上所有连接的在线信息，用于续期
+func NewTracker(registry Registry, pool core.CoroutinePool, tag string, collect func() []*LineInfo) *Tracker {
+	if registry == nil {
+		return nil
+	}
+	return &Tracker{registry: registry, pool: pool, tag: tag, collect: collect}
+}
+
+func (t *Tracker) Registry() Registry {
+	if t == nil {
+		return nil
+	}
+	return t.registry
+}
+
+func (t *Tracker) Register(line *LineInfo) {
+	if t == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+	defer cancel()
+	if err := t.registry.Register(ctx, line); err != nil {
+		fmt.Printf("[%s] presence register failed, userId: %s, lineId: %s, err: %v\n", t.tag, line.UserId, line.LineId, err)
+	}
+}
+
+func (t *Tracker) Unregister(line *LineInfo) {
+	if t == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+	defer cancel()
+	if err := t.registry.Unregister(ctx, line); err != nil {
+		fmt.Printf("[%s] presence unregister failed, userId: %s, lineId: %s, err: %v\n", t.tag, line.UserId, line.LineId, err)
+	}
+}
+
+// 开始定期续期，间隔为 TTL 的 1/3
+func (t *Tracker) Start() error {
+	if t == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	interval := max(t.registry.TTL()/3, time.Second)
+	return t.pool.Submit(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				hbCtx, hbCancel := context.WithTimeout(ctx, trackerTimeout)
+				if err := t.registry.Heartbeat(hbCtx, t.collect()); err != nil {
+					fmt.Printf("[%s] presence heartbeat failed, err: %v\n", t.tag, err)
+				}
+				hbCancel()
+			}
+		}
+	})
+}
+
+// 停止续期，并注销本节点上的所有连接
+func (t *Tracker) Stop() {
+	if t == nil {
+		return
+	}
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+	defer cancel()
+	for _, line := range t.collect() {
+		t.registry.Unregister(ctx, line)
+	}
+}
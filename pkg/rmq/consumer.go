@@ -0,0 +1,208 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapp/pkg/ids"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// 处理器返回包装了 ErrRequeue 的错误时，消息重新入队；其它错误不重新入队，进入死信交换机
+	ErrRequeue = errors.New("requeue")
+	// 消息已经确认或拒绝过
+	ErrSettled = errors.New("delivery already settled")
+)
+
+// 投递给消费者的消息，每条消息只能确认或拒绝一次
+type Delivery struct {
+	amqp.Delivery
+	settled atomic.Bool
+}
+
+// 确认消息
+func (d *Delivery) Ack() error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return ErrSettled
+	}
+	return d.Delivery.Ack(false)
+}
+
+// 拒绝消息；requeue 为 false 时，队列配置了死信交换机的消息转发到死信交换机，否则丢弃
+func (d *Delivery) Nack(requeue bool) error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return ErrSettled
+	}
+	return d.Delivery.Nack(false, requeue)
+}
+
+// 拒绝消息并重新入队，之后会再次投递，Redelivered 为 true
+func (d *Delivery) Requeue() error {
+	return d.Nack(true)
+}
+
+func (d *Delivery) Settled() bool {
+	return d.settled.Load()
+}
+
+// 处理消息；可以在处理器中手动确认或拒绝，否则根据返回值处理：
+// nil 确认，包装了 ErrRequeue 的错误重新入队，其它错误拒绝且不重新入队
+type DeliveryHandler func(ctx context.Context, d *Delivery) error
+
+type ConsumerOption func(*Consumer)
+
+// 未确认的消息的最大数量；默认 10
+func WithPrefetch(n int) ConsumerOption {
+	return func(c *Consumer) {
+		if n > 0 {
+			c.prefetch = n
+		}
+	}
+}
+
+// 同时处理消息的协程数；默认 1，此时消息按顺序处理
+func WithConcurrency(n int) ConsumerOption {
+	return func(c *Consumer) {
+		if n > 0 {
+			c.concurrency = n
+		}
+	}
+}
+
+// 消费者的标签，为空时随机生成
+func WithConsumerTag(tag string) ConsumerOption {
+	return func(c *Consumer) {
+		c.tag = tag
+	}
+}
+
+// 从队列中消费消息，手动确认；连接断开后自动重新消费
+type Consumer struct {
+	client      *Client
+	queue       string
+	handler     DeliveryHandler
+	prefetch    int
+	concurrency int
+	tag         string
+	metrics     *consumerMetrics
+}
+
+func (c *Client) NewConsumer(queue string, handler DeliveryHandler, options ...ConsumerOption) *Consumer {
+	consumer := &Consumer{
+		client:      c,
+		queue:       queue,
+		handler:     handler,
+		prefetch:    10,
+		concurrency: 1,
+		metrics:     newConsumerMetrics(nil),
+	}
+	for _, option := range options {
+		option(consumer)
+	}
+	if len(consumer.tag) == 0 {
+		consumer.tag = ids.NewUUID()
+	}
+	return consumer
+}
+
+// 持续消费，直到 ctx 结束；ctx 结束时停止接收消息，等待处理中的消息处理完毕后返回
+func (c *Consumer) Run(ctx context.Context) {
+	for {
+		err := c.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("[RMQ] consumer stopped, queue: %s, err: %v, reconnecting...\n", c.queue, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (c *Consumer) consume(ctx context.Context) error {
+	conn, err := c.client.conn()
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	if err = ch.Qos(c.prefetch, 0, false); err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(c.queue, c.tag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	// 通道关闭或取消消费后 deliveries 被关闭，处理协程随之退出
+	var wg sync.WaitGroup
+	for range c.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range deliveries {
+				c.handle(ctx, &Delivery{Delivery: d})
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		ch.Cancel(c.tag, false)
+		wg.Wait()
+		return nil
+	case err := <-closed:
+		wg.Wait()
+		if err == nil {
+			return errNotConnected
+		}
+		return err
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, d *Delivery) {
+	c.metrics.delivered.Add(1, c.queue)
+	err := c.safeHandle(ctx, d)
+	if d.Settled() {
+		return
+	}
+	var result string
+	switch {
+	case err == nil:
+		result = "ack"
+		err = d.Ack()
+	case errors.Is(err, ErrRequeue):
+		result = "requeue"
+		err = d.Requeue()
+	default:
+		result = "nack"
+		fmt.Printf("[RMQ] handle message failed, queue: %s, id: %s, err: %v\n", c.queue, d.MessageId, err)
+		err = d.Nack(false)
+	}
+	if err != nil {
+		fmt.Printf("[RMQ] settle message failed, queue: %s, id: %s, err: %v\n", c.queue, d.MessageId, err)
+		result = "settle_failed"
+	}
+	c.metrics.settled.Add(1, c.queue, result)
+}
+
+func (c *Consumer) safeHandle(ctx context.Context, d *Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.handler(ctx, d)
+}
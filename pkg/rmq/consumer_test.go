@@ -0,0 +1,102 @@
+// 消息处理后的确认、拒绝及死信参数在 handle、QueueSpec.args 中，均未导出；不需要连接 RabbitMQ，因此在包内测试，
+// 需要 RabbitMQ 及只使用导出 API 的测试放在 test/pkg 下
+
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// 记录确认及拒绝的结果
+type testAcknowledger struct {
+	mu      sync.Mutex
+	results []string
+}
+
+func (a *testAcknowledger) record(result string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.results = append(a.results, result)
+	return nil
+}
+
+func (a *testAcknowledger) Ack(tag uint64, multiple bool) error {
+	return a.record("ack")
+}
+
+func (a *testAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		return a.record("requeue")
+	}
+	return a.record("nack")
+}
+
+func (a *testAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestConsumerHandleSettles(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler DeliveryHandler
+		want    string
+	}{
+		{"ack", func(ctx context.Context, d *Delivery) error { return nil }, "ack"},
+		{"requeue", func(ctx context.Context, d *Delivery) error { return fmt.Errorf("%w: busy", ErrRequeue) }, "requeue"},
+		// 不重新入队的消息由 RabbitMQ 转发到死信交换机
+		{"dead letter", func(ctx context.Context, d *Delivery) error { return errors.New("bad message") }, "nack"},
+		{"panic", func(ctx context.Context, d *Delivery) error { panic("boom") }, "nack"},
+		{"manual ack", func(ctx context.Context, d *Delivery) error {
+			d.Ack()
+			return errors.New("ignored")
+		}, "ack"},
+		{"manual requeue", func(ctx context.Context, d *Delivery) error {
+			d.Requeue()
+			return nil
+		}, "requeue"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ack := &testAcknowledger{}
+			c := NewClient("amqp://localhost").NewConsumer("orders", tc.handler)
+			c.handle(context.Background(), &Delivery{Delivery: amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, MessageId: "m1"}})
+			if len(ack.results) != 1 || ack.results[0] != tc.want {
+				t.Fatalf("expected %s once, got %v", tc.want, ack.results)
+			}
+		})
+	}
+}
+
+func TestQueueSpecDeadLetterArgs(t *testing.T) {
+	q := QueueSpec{
+		Name:                 "orders",
+		DeadLetterExchange:   "orders" + deadLetterExchangeSuffix,
+		DeadLetterRoutingKey: "failed",
+		MessageTtl:           time.Minute,
+		Args:                 amqp.Table{"x-max-length": 100},
+	}
+	args := q.args()
+	if args[deadLetterExchangeArg] != "orders.dlx" || args[deadLetterRoutingKeyArg] != "failed" ||
+		args[amqp.QueueMessageTTLArg] != int64(60000) || args["x-max-length"] != 100 {
+		t.Fatalf("unexpected args: %v", args)
+	}
+	// 不修改调用方传入的参数
+	if len(q.Args) != 1 {
+		t.Fatalf("args modified: %v", q.Args)
+	}
+	if DeadLetterQueue("orders") != "orders.dead" {
+		t.Fatalf("unexpected dead letter queue: %s", DeadLetterQueue("orders"))
+	}
+
+	// 未配置死信及有效期时不设置相关参数
+	if args := (&QueueSpec{Name: "plain"}).args(); len(args) != 0 {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
@@ -0,0 +1,35 @@
+package rmq
+
+import (
+	"goapp/pkg/metrics"
+)
+
+type queueMetrics struct {
+	published  metrics.Counter // 发布的消息数，按结果区分：ok、nack、returned、failed
+	retries    metrics.Counter // 发布重试的次数
+	delivered  metrics.Counter // 投递给消费者的消息数
+	reconnects metrics.Counter // 通道重连的次数，按结果区分
+}
+
+func newQueueMetrics(p metrics.Provider) *queueMetrics {
+	p = metrics.Or(p)
+	return &queueMetrics{
+		published:  p.Counter("rmq_published_total", "Messages published to RabbitMQ.", "queue", "result"),
+		retries:    p.Counter("rmq_publish_retries_total", "Publish retries.", "queue"),
+		delivered:  p.Counter("rmq_delivered_total", "Messages delivered to consumers.", "queue"),
+		reconnects: p.Counter("rmq_reconnects_total", "Channel reconnect attempts.", "queue", "result"),
+	}
+}
+
+type consumerMetrics struct {
+	delivered metrics.Counter // 投递给消费者的消息数
+	settled   metrics.Counter // 处理后确认或拒绝的消息数，按结果区分：ack、nack、requeue
+}
+
+func newConsumerMetrics(p metrics.Provider) *consumerMetrics {
+	p = metrics.Or(p)
+	return &consumerMetrics{
+		delivered: p.Counter("rmq_delivered_total", "Messages delivered to consumers.", "queue"),
+		settled:   p.Counter("rmq_settled_total", "Messages acked, nacked or requeued by consumers.", "queue", "result"),
+	}
+}
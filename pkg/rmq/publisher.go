@@ -0,0 +1,220 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// RabbitMQ 拒绝了消息，比如队列已满
+	ErrNacked = errors.New("message nacked by broker")
+	// 使用 WithMandatory 发布的消息没有路由到任何队列
+	ErrUnroutable = errors.New("message unroutable")
+)
+
+// 等待发布确认的最长时间
+const confirmTimeout = 5 * time.Second
+
+// 退回的消息保留的时间，超过后认为发布方已不再等待
+const returnedTtl = time.Minute
+
+// 发布确认，即 *amqp.DeferredConfirmation
+type confirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+func waitConfirm(ctx context.Context, confirm confirmation) error {
+	ack, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !ack {
+		return ErrNacked
+	}
+	return nil
+}
+
+// 发布到交换机，每条消息都等待 RabbitMQ 确认
+//
+// 使用独立的通道，连接断开后下次发布时重新打开
+type Publisher struct {
+	client   *Client
+	exchange string
+	mutex    sync.Mutex
+	channel  *amqp.Channel
+	returns  *returnTracker
+	metrics  *queueMetrics
+}
+
+// 创建发布到 exchange 的发布者，exchange 为空时使用默认交换机，路由键即队列名
+func (c *Client) NewPublisher(exchange string) *Publisher {
+	return &Publisher{
+		client:   c,
+		exchange: exchange,
+		metrics:  newQueueMetrics(nil),
+	}
+}
+
+func (p *Publisher) open() (*amqp.Channel, *returnTracker, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.channel != nil && !p.channel.IsClosed() {
+		return p.channel, p.returns, nil
+	}
+	conn, err := p.client.conn()
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+	p.channel = ch
+	p.returns = newReturnTracker(ch.NotifyReturn(make(chan amqp.Return)))
+	return p.channel, p.returns, nil
+}
+
+// 使用路由键发布消息，等待确认后返回；ctx 结束或确认超时时返回错误，此时消息可能已被投递
+func (p *Publisher) Publish(ctx context.Context, routingKey string, data []byte, options ...optionFunc) error {
+	option := newMessageOption(options)
+
+	var err error
+	for i := range option.retryTimes {
+		if i > 0 {
+			p.metrics.retries.Add(1, p.exchange)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(resendDelay):
+			}
+		}
+		err = p.publish(ctx, routingKey, data, option)
+		if err == nil {
+			p.metrics.published.Add(1, p.exchange, "ok")
+			return nil
+		}
+		if errors.Is(err, ErrUnroutable) {
+			// 重试也无法路由
+			p.metrics.published.Add(1, p.exchange, "returned")
+			return err
+		}
+		if errors.Is(err, ErrNacked) {
+			p.metrics.published.Add(1, p.exchange, "nack")
+		}
+	}
+	p.metrics.published.Add(1, p.exchange, "failed")
+	return err
+}
+
+func (p *Publisher) publish(ctx context.Context, routingKey string, data []byte, option *messageOption) error {
+	ch, returns, err := p.open()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, p.exchange, routingKey, option.mandatory, false, option.publishing(data))
+	if err != nil {
+		return err
+	}
+	return waitPublished(ctx, confirm, returns, option.msgID, option.mandatory)
+}
+
+// 等待发布确认；mandatory 的消息确认后再检查是否被退回
+func waitPublished(ctx context.Context, confirm confirmation, returns *returnTracker, msgId string, mandatory bool) error {
+	if err := waitConfirm(ctx, confirm); err != nil {
+		return err
+	}
+	// 无法路由的消息，RabbitMQ 先退回，再确认
+	if mandatory {
+		if ret := returns.take(msgId); ret != nil {
+			return fmt.Errorf("%w: exchange: %s, routing key: %s, reply: %s", ErrUnroutable, ret.Exchange, ret.RoutingKey, ret.ReplyText)
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.channel == nil {
+		return nil
+	}
+	err := p.channel.Close()
+	p.channel = nil
+	return err
+}
+
+// 记录通道上退回的消息
+//
+// 退回与确认由同一个协程按顺序分发，且退回的通道没有缓冲：收到确认时，退回已被 run 接收；
+// take 的请求由 run 在处理完这次退回后才响应，因此不会漏掉
+type returnTracker struct {
+	queries chan returnQuery
+	done    chan struct{}
+}
+
+type returnQuery struct {
+	msgId string
+	reply chan *amqp.Return
+}
+
+type returnedMsg struct {
+	ret amqp.Return
+	at  time.Time
+}
+
+func newReturnTracker(returns <-chan amqp.Return) *returnTracker {
+	t := &returnTracker{queries: make(chan returnQuery), done: make(chan struct{})}
+	go t.run(returns)
+	return t
+}
+
+func (t *returnTracker) run(returns <-chan amqp.Return) {
+	defer close(t.done)
+	returned := make(map[string]*returnedMsg)
+	for {
+		select {
+		case ret, ok := <-returns:
+			// 通道关闭
+			if !ok {
+				return
+			}
+			fmt.Printf("[RMQ] message returned, exchange: %s, routing key: %s, id: %s, reply: %s\n", ret.Exchange, ret.RoutingKey, ret.MessageId, ret.ReplyText)
+			for id, r := range returned {
+				if time.Since(r.at) > returnedTtl {
+					delete(returned, id)
+				}
+			}
+			returned[ret.MessageId] = &returnedMsg{ret, time.Now()}
+		case q := <-t.queries:
+			r, ok := returned[q.msgId]
+			if !ok {
+				q.reply <- nil
+				continue
+			}
+			delete(returned, q.msgId)
+			q.reply <- &r.ret
+		}
+	}
+}
+
+// 取出 id 对应的退回消息，没有退回时返回 nil
+func (t *returnTracker) take(msgId string) *amqp.Return {
+	q := returnQuery{msgId, make(chan *amqp.Return, 1)}
+	select {
+	case t.queries <- q:
+		return <-q.reply
+	case <-t.done:
+		return nil
+	}
+}
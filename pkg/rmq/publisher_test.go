@@ -0,0 +1,92 @@
+// 确认、超时与退回的处理在未导出的 waitPublished 及 returnTracker 中，需要伪造确认及退回的通道，因此在包内测试
+
+package rmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// 立即返回 ack，或者一直等到 ctx 结束
+type testConfirmation struct {
+	ack   bool
+	block bool
+}
+
+func (c *testConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	if c.block {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	return c.ack, nil
+}
+
+func TestWaitPublishedConfirm(t *testing.T) {
+	returns := make(chan amqp.Return)
+	tracker := newReturnTracker(returns)
+	defer close(returns)
+	ctx := context.Background()
+
+	if err := waitPublished(ctx, &testConfirmation{ack: true}, tracker, "m1", true); err != nil {
+		t.Fatalf("acked: %v", err)
+	}
+	if err := waitPublished(ctx, &testConfirmation{ack: false}, tracker, "m1", true); !errors.Is(err, ErrNacked) {
+		t.Fatalf("expected ErrNacked, got %v", err)
+	}
+}
+
+func TestWaitPublishedTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := waitPublished(ctx, &testConfirmation{block: true}, nil, "m1", false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("confirm timeout not honored")
+	}
+}
+
+func TestWaitPublishedReturned(t *testing.T) {
+	returns := make(chan amqp.Return)
+	tracker := newReturnTracker(returns)
+	defer close(returns)
+	ctx := context.Background()
+
+	// RabbitMQ 先退回再确认；退回的通道没有缓冲，发送返回时 tracker 已经收到
+	returns <- amqp.Return{MessageId: "m1", Exchange: "orders", RoutingKey: "missing", ReplyText: "NO_ROUTE"}
+
+	// 未设置 mandatory 的消息不检查退回
+	if err := waitPublished(ctx, &testConfirmation{ack: true}, tracker, "m2", true); err != nil {
+		t.Fatalf("other message: %v", err)
+	}
+	err := waitPublished(ctx, &testConfirmation{ack: true}, tracker, "m1", true)
+	if !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("expected ErrUnroutable, got %v", err)
+	}
+	// 退回的消息只取出一次
+	if err := waitPublished(ctx, &testConfirmation{ack: true}, tracker, "m1", true); err != nil {
+		t.Fatalf("return taken twice: %v", err)
+	}
+}
+
+func TestReturnTrackerClosed(t *testing.T) {
+	returns := make(chan amqp.Return)
+	tracker := newReturnTracker(returns)
+	returns <- amqp.Return{MessageId: "m1"}
+	close(returns)
+
+	// 通道关闭后不再阻塞
+	done := make(chan *amqp.Return, 1)
+	go func() { done <- tracker.take("m1") }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("take blocked after channel closed")
+	}
+}
@@ -21,24 +21,25 @@ type Queue struct {
 	channel    *amqp.Channel
 	queue      amqp.Queue
 
-	chNotifyClose   chan *amqp.Error
-	chNotifyConfirm chan amqp.Confirmation
-	chReconnected   chan core.Empty
+	chNotifyClose chan *amqp.Error
+	chReconnected chan core.Empty
 
 	connected atomic.Bool
 
 	cancelComsume       context.CancelFunc
 	cancelAutoReconnect context.CancelFunc
+
+	metrics *queueMetrics
 }
 
 func newQueue(name string) *Queue {
 	return &Queue{
-		name:            name,
-		mutex:           sync.RWMutex{},
-		connected:       atomic.Bool{},
-		chNotifyClose:   make(chan *amqp.Error, 1),
-		chNotifyConfirm: make(chan amqp.Confirmation, 1),
-		chReconnected:   make(chan core.Empty, 1),
+		name:          name,
+		mutex:         sync.RWMutex{},
+		connected:     atomic.Bool{},
+		chNotifyClose: make(chan *amqp.Error, 1),
+		chReconnected: make(chan core.Empty, 1),
+		metrics:       newQueueMetrics(nil),
 	}
 }
 
@@ -74,8 +75,10 @@ func (c *Queue) autoReconnect() {
 				time.Sleep(reconnectDelay)
 				if err := c.init(); err != nil {
 					fmt.Println("reconnect failed:", err)
+					c.metrics.reconnects.Add(1, c.name, "failed")
 					continue
 				} else {
+					c.metrics.reconnects.Add(1, c.name, "ok")
 					c.chReconnected <- core.Empty{}
 				}
 			}
@@ -93,10 +96,8 @@ func (c *Queue) init() error {
 
 	c.connected.Store(false)
 	close(c.chNotifyClose)
-	close(c.chNotifyConfirm)
 
 	c.chNotifyClose = make(chan *amqp.Error, 1)
-	c.chNotifyConfirm = make(chan amqp.Confirmation, 1)
 
 	var channel *amqp.Channel
 	var err error
@@ -120,7 +121,6 @@ func (c *Queue) init() error {
 	}
 
 	channel.NotifyClose(c.chNotifyClose)
-	channel.NotifyPublish(c.chNotifyConfirm)
 
 	c.channel = channel
 	c.queue = queue
@@ -133,10 +133,40 @@ type messageOption struct {
 	msgType string
 	userID  string
 	appID   string
+	headers amqp.Table
 
+	mandatory  bool
 	retryTimes int
 }
 
+func newMessageOption(options []optionFunc) *messageOption {
+	option := &messageOption{}
+	for _, optionFunc := range options {
+		optionFunc(option)
+	}
+	if len(option.msgID) == 0 {
+		option.msgID = ids.NewUUID()
+	}
+	if option.retryTimes < 1 {
+		option.retryTimes = 1
+	}
+	return option
+}
+
+func (o *messageOption) publishing(data []byte) amqp.Publishing {
+	return amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "text/plain",
+		Headers:      o.headers,
+		Body:         data,
+		MessageId:    o.msgID,
+		Timestamp:    time.Now(),
+		Type:         o.msgType,
+		UserId:       o.userID,
+		AppId:        o.appID,
+	}
+}
+
 type optionFunc func(*messageOption)
 
 func WithMsgID(msgID string) optionFunc {
@@ -159,6 +189,20 @@ func WithAppID(appID string) optionFunc {
 		option.appID = appID
 	}
 }
+func WithHeaders(headers amqp.Table) optionFunc {
+	return func(option *messageOption) {
+		option.headers = headers
+	}
+}
+
+// 消息无法路由到任何队列时由 RabbitMQ 退回，发布返回 ErrUnroutable；仅用于 Publisher
+func WithMandatory() optionFunc {
+	return func(option *messageOption) {
+		option.mandatory = true
+	}
+}
+
+// 发布失败、被拒绝或确认超时时重试
 func WithRetry(retryTimes int) optionFunc {
 	if retryTimes < 1 {
 		retryTimes = 1
@@ -168,58 +212,51 @@ func WithRetry(retryTimes int) optionFunc {
 	}
 }
 
+// 发布到队列，等待 RabbitMQ 确认后返回
 func (c *Queue) Push(data []byte, options ...optionFunc) error {
-	option := &messageOption{}
-	for _, optionFunc := range options {
-		optionFunc(option)
-	}
-	if len(option.msgID) == 0 {
-		option.msgID = ids.NewUUID()
-	}
-	if option.retryTimes < 1 {
-		option.retryTimes = 1
-	}
+	option := newMessageOption(options)
 
 	var err error
-	for range option.retryTimes {
-		if err = c.internalPush(data, option); err != nil {
+	for i := range option.retryTimes {
+		if i > 0 {
+			c.metrics.retries.Add(1, c.name)
 			fmt.Println("push failed. Retrying...")
 			time.Sleep(resendDelay)
-			continue
 		}
-		confirm := <-c.chNotifyConfirm
-		if confirm.Ack {
-			fmt.Printf("push confirmed [%d]", confirm.DeliveryTag)
+		err = c.internalPush(data, option)
+		if err == nil {
+			c.metrics.published.Add(1, c.name, "ok")
 			return nil
 		}
+		if errors.Is(err, ErrNacked) {
+			c.metrics.published.Add(1, c.name, "nack")
+		}
 	}
+	c.metrics.published.Add(1, c.name, "failed")
 	return err
 }
 
 func (c *Queue) internalPush(data []byte, option *messageOption) error {
 	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-
 	if !c.connected.Load() {
+		c.mutex.RUnlock()
 		return errNotConnected
 	}
-
-	return c.channel.Publish(
+	ctx, cancel := context.WithTimeout(context.Background(), confirmTimeout)
+	defer cancel()
+	confirm, err := c.channel.PublishWithDeferredConfirmWithContext(
+		ctx,
 		"",     // Exchange
 		c.name, // Routing key
 		false,  // Mandatory
 		false,  // Immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         data,
-			MessageId:    option.msgID,
-			Timestamp:    time.Now(),
-			Type:         option.msgType,
-			UserId:       option.userID,
-			AppId:        option.appID,
-		},
+		option.publishing(data),
 	)
+	c.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	return waitConfirm(ctx, confirm)
 }
 
 func (c *Queue) Consume(prefetchCount int) (<-chan amqp.Delivery, error) {
@@ -270,6 +307,7 @@ func (c *Queue) Consume(prefetchCount int) (<-chan amqp.Delivery, error) {
 				close(deliveries)
 				return // close consumer
 			case d := <-del:
+				c.metrics.delivered.Add(1, c.name)
 				deliveries <- d
 			case <-c.chReconnected:
 				for range 5 {
@@ -303,7 +341,6 @@ func (c *Queue) close() error {
 	time.Sleep(500 * time.Millisecond)
 
 	close(c.chNotifyClose)
-	close(c.chNotifyConfirm)
 	close(c.chReconnected)
 
 	if err := c.channel.Close(); err != nil {
@@ -23,6 +23,7 @@ type Client struct {
 	chNotifyConnClose chan *amqp.Error
 	chCloseNormal     chan core.Empty
 	queues            map[string]*Queue
+	topology          topology // 声明过的交换机、队列和绑定
 }
 
 const (
@@ -52,6 +53,16 @@ func (c *Client) Connect() error {
 	return nil
 }
 
+// 当前的连接，重连期间返回 errNotConnected
+func (c *Client) conn() (*amqp.Connection, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.connection == nil || c.connection.IsClosed() {
+		return nil, errNotConnected
+	}
+	return c.connection, nil
+}
+
 func (c *Client) NewQueue(name string) (*Queue, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -113,6 +124,14 @@ func (c *Client) init() error {
 	conn.NotifyClose(c.chNotifyConnClose)
 	c.connection = conn
 
+	// 重新声明交换机、队列和绑定，需要在队列及消费者恢复之前完成
+	if ch, err := conn.Channel(); err == nil {
+		if err := c.topology.declare(ch); err != nil {
+			fmt.Println("redeclare topology failed:", err)
+		}
+		ch.Close()
+	}
+
 	// 对已有的队列重新初始化
 	for _, queue := range c.queues {
 		queue.open(conn)
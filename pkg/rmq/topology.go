@@ -0,0 +1,176 @@
+package rmq
+
+import (
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type ExchangeKind string
+
+const (
+	ExchangeDirect ExchangeKind = amqp.ExchangeDirect // 路由键完全匹配
+	ExchangeTopic  ExchangeKind = amqp.ExchangeTopic  // 路由键按模式匹配，* 匹配一个单词，# 匹配零个或多个单词
+	ExchangeFanout ExchangeKind = amqp.ExchangeFanout // 忽略路由键，投递到所有绑定的队列
+)
+
+// 交换机，均为持久化的
+type Exchange struct {
+	Name       string
+	Kind       ExchangeKind
+	AutoDelete bool       // 没有绑定时自动删除
+	Args       amqp.Table // 额外的参数，比如 alternate-exchange
+}
+
+// 队列，均为持久化的
+type QueueSpec struct {
+	Name string
+	// 被拒绝且不重新入队、过期或超出长度的消息转发到此交换机
+	DeadLetterExchange string
+	// 转发到死信交换机时使用的路由键，为空时使用原消息的路由键
+	DeadLetterRoutingKey string
+	// 消息在队列中的有效期，为 0 时不过期
+	MessageTtl time.Duration
+	Args       amqp.Table
+}
+
+func (q *QueueSpec) args() amqp.Table {
+	args := amqp.Table{}
+	for k, v := range q.Args {
+		args[k] = v
+	}
+	if len(q.DeadLetterExchange) > 0 {
+		args[deadLetterExchangeArg] = q.DeadLetterExchange
+	}
+	if len(q.DeadLetterRoutingKey) > 0 {
+		args[deadLetterRoutingKeyArg] = q.DeadLetterRoutingKey
+	}
+	if q.MessageTtl > 0 {
+		args[amqp.QueueMessageTTLArg] = q.MessageTtl.Milliseconds()
+	}
+	return args
+}
+
+// 将队列绑定到交换机；fanout 交换机忽略路由键，topic 交换机的路由键可以包含通配符
+type Binding struct {
+	Queue      string
+	Exchange   string
+	RoutingKey string
+	Args       amqp.Table
+}
+
+// 死信相关的队列参数，以及死信交换机及队列的名称后缀
+const (
+	deadLetterExchangeArg   = "x-dead-letter-exchange"
+	deadLetterRoutingKeyArg = "x-dead-letter-routing-key"
+
+	deadLetterExchangeSuffix = ".dlx"
+	deadLetterQueueSuffix    = ".dead"
+)
+
+// 队列对应的死信队列
+func DeadLetterQueue(queue string) string { return queue + deadLetterQueueSuffix }
+
+// 记录声明过的交换机、队列和绑定，重连后重新声明
+type topology struct {
+	exchanges []Exchange
+	queues    []QueueSpec
+	bindings  []Binding
+}
+
+func (t *topology) declare(ch *amqp.Channel) error {
+	for _, ex := range t.exchanges {
+		if err := declareExchange(ch, ex); err != nil {
+			return err
+		}
+	}
+	for _, q := range t.queues {
+		if err := declareQueue(ch, q); err != nil {
+			return err
+		}
+	}
+	for _, b := range t.bindings {
+		if err := bind(ch, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func declareExchange(ch *amqp.Channel, ex Exchange) error {
+	return ch.ExchangeDeclare(ex.Name, string(ex.Kind), true, ex.AutoDelete, false, false, ex.Args)
+}
+
+func declareQueue(ch *amqp.Channel, q QueueSpec) error {
+	_, err := ch.QueueDeclare(q.Name, true, false, false, false, q.args())
+	return err
+}
+
+func bind(ch *amqp.Channel, b Binding) error {
+	return ch.QueueBind(b.Queue, b.RoutingKey, b.Exchange, false, b.Args)
+}
+
+// 在临时通道上执行声明；声明失败时 RabbitMQ 会关闭通道，因此不复用其它通道
+func (c *Client) withChannel(fn func(ch *amqp.Channel) error) error {
+	conn, err := c.conn()
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	return fn(ch)
+}
+
+// 声明交换机，重连后自动重新声明
+func (c *Client) DeclareExchange(ex Exchange) error {
+	err := c.withChannel(func(ch *amqp.Channel) error { return declareExchange(ch, ex) })
+	if err == nil {
+		c.mutex.Lock()
+		c.topology.exchanges = append(c.topology.exchanges, ex)
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+// 声明队列，重连后自动重新声明；参数与已存在的队列不一致时返回错误
+func (c *Client) DeclareQueue(q QueueSpec) error {
+	err := c.withChannel(func(ch *amqp.Channel) error { return declareQueue(ch, q) })
+	if err == nil {
+		c.mutex.Lock()
+		c.topology.queues = append(c.topology.queues, q)
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+// 绑定队列与交换机，重连后自动重新绑定
+func (c *Client) Bind(b Binding) error {
+	err := c.withChannel(func(ch *amqp.Channel) error { return bind(ch, b) })
+	if err == nil {
+		c.mutex.Lock()
+		c.topology.bindings = append(c.topology.bindings, b)
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+// 声明带有死信队列的队列：未设置 DeadLetterExchange 时，声明名为 {queue}.dlx 的 fanout 交换机，
+// 以及绑定到它的 {queue}.dead 队列，被拒绝且不重新入队的消息都会进入死信队列
+func (c *Client) DeclareQueueWithDeadLetter(q QueueSpec) error {
+	if len(q.DeadLetterExchange) == 0 {
+		q.DeadLetterExchange = q.Name + deadLetterExchangeSuffix
+		if err := c.DeclareExchange(Exchange{Name: q.DeadLetterExchange, Kind: ExchangeFanout}); err != nil {
+			return err
+		}
+		if err := c.DeclareQueue(QueueSpec{Name: DeadLetterQueue(q.Name)}); err != nil {
+			return err
+		}
+		if err := c.Bind(Binding{Queue: DeadLetterQueue(q.Name), Exchange: q.DeadLetterExchange}); err != nil {
+			return err
+		}
+	}
+	return c.DeclareQueue(q)
+}
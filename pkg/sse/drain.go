@@ -0,0 +1,64 @@
+package sse
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 排空时推送给客户端的关闭事件
+const EventClose = "close"
+
+// 排空时检查连接是否已全部关闭的间隔
+const drainCheckInterval = 50 * time.Millisecond
+
+// 是否正在排空
+func (h *Hub) Draining() bool { return h.draining.Load() }
+
+// 排空本节点上的连接，用于滚动发布时平滑下线
+//
+// 不再接受新的连接；已进入发送通道的事件发送完毕后，推送 close 事件并结束响应，
+// 客户端重连到其它节点后，通过 Last-Event-ID 补发期间错过的事件。
+// 所有连接关闭或 ctx 结束后关闭 Hub；ctx 结束时返回 ctx 的错误
+func (h *Hub) Drain(ctx context.Context) error {
+	if h.isClosed.Load() || !h.draining.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	var err error
+	h.connections.Range(func(key, value any) bool {
+		err = value.(*UserLines).drain(ctx, h.drainFrame)
+		return err == nil
+	})
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for h.LiveCount() > 0 && err == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	fmt.Printf("[SSE] drained, remaining lines: %d, err: %v\n", h.LiveCount(), err)
+	h.Close(0)
+	return err
+}
+
+// 在每个连接的发送通道末尾放入关闭事件
+func (u *UserLines) drain(ctx context.Context, frame *Frame) error {
+	u.RLock()
+	defer u.RUnlock()
+
+	for _, line := range u.lines {
+		if line.isClosed.Load() || line.writeChan == nil {
+			continue
+		}
+		select {
+		case line.writeChan <- frame:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
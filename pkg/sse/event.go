@@ -0,0 +1,42 @@
+package sse
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 结构化的 SSE 事件
+//
+// Data 为 string 或 []byte 时原样发送，其他类型编码为 JSON；多行数据会拆分为多个 data 字段。
+// 启用事件日志时 ID 由日志分配，此处的 ID 会被忽略；否则为空时由 Hub 分配单调递增的 id
+type Event struct {
+	ID    string
+	Event string // 事件名称，客户端通过 addEventListener(Event) 监听；为空时触发 message 事件
+	Data  any
+	Retry time.Duration // 建议客户端断线重连的间隔
+}
+
+// 编码为待发送的帧
+func (e *Event) frame() (Frame, error) {
+	f := Frame{
+		ID:    e.ID,
+		Event: e.Event,
+		Retry: e.Retry.Milliseconds(),
+	}
+	switch v := e.Data.(type) {
+	case nil:
+	case string:
+		f.Data = v
+	case []byte:
+		f.Data = string(v)
+	case json.RawMessage:
+		f.Data = string(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return f, err
+		}
+		f.Data = string(data)
+	}
+	return f, nil
+}
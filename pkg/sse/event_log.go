@@ -0,0 +1,123 @@
+package sse
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 记录在事件日志中的帧
+type LoggedFrame struct {
+	Frame
+	LineIds []string `json:"lineIds,omitempty"` // 目标连接，为空表示该用户的所有连接
+}
+
+func (f *LoggedFrame) isFor(lineId string) bool {
+	return len(f.LineIds) == 0 || slices.Contains(f.LineIds, lineId)
+}
+
+// 每个用户一份有界的事件日志，用于客户端断线重连后，根据 Last-Event-ID 补发期间错过的事件
+type EventLog interface {
+	// 追加事件，返回分配的单调递增的 id
+	Append(ctx context.Context, userId string, frame *LoggedFrame) (string, error)
+	// 获取 lastId 之后的所有事件，按 id 升序排列
+	Since(ctx context.Context, userId, lastId string) ([]*LoggedFrame, error)
+}
+
+type memoryStream struct {
+	frames   []*LoggedFrame
+	expireAt time.Time
+}
+
+// 基于内存的事件日志，仅适用于单节点部署
+type MemoryEventLog struct {
+	mutex     sync.Mutex
+	streams   map[string]*memoryStream
+	maxLen    int
+	ttl       time.Duration
+	ids       eventIdGenerator
+	lastSweep time.Time
+}
+
+// maxLen 每个用户最多保留的事件数，默认 256；ttl 用户没有新事件后日志保留的时间，默认 10 分钟
+func NewMemoryEventLog(maxLen int, ttl time.Duration) *MemoryEventLog {
+	if maxLen <= 0 {
+		maxLen = 256
+	}
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	return &MemoryEventLog{
+		streams:   make(map[string]*memoryStream),
+		maxLen:    maxLen,
+		ttl:       ttl,
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *MemoryEventLog) Append(ctx context.Context, userId string, frame *LoggedFrame) (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	frame.ID = strconv.FormatUint(l.ids.next(), 10)
+	s, ok := l.streams[userId]
+	if !ok {
+		s = &memoryStream{}
+		l.streams[userId] = s
+	}
+	s.frames = append(s.frames, frame)
+	if len(s.frames) > l.maxLen {
+		s.frames = slices.Delete(s.frames, 0, len(s.frames)-l.maxLen)
+	}
+	s.expireAt = now.Add(l.ttl)
+	return frame.ID, nil
+}
+
+func (l *MemoryEventLog) Since(ctx context.Context, userId, lastId string) ([]*LoggedFrame, error) {
+	last, err := strconv.ParseUint(lastId, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	s, ok := l.streams[userId]
+	if !ok || time.Now().After(s.expireAt) {
+		return nil, nil
+	}
+	idx, _ := slices.BinarySearchFunc(s.frames, last, func(f *LoggedFrame, id uint64) int {
+		switch v := f.seq(); {
+		case v < id:
+			return -1
+		case v > id:
+			return 1
+		}
+		return 0
+	})
+	out := make([]*LoggedFrame, 0, len(s.frames))
+	for _, f := range s.frames[idx:] {
+		if f.seq() > last {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// 清理过期的日志：每隔 ttl 清理一次
+func (l *MemoryEventLog) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.ttl {
+		return
+	}
+	l.lastSweep = now
+	for k, s := range l.streams {
+		if now.After(s.expireAt) {
+			delete(l.streams, k)
+		}
+	}
+}
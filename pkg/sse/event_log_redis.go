@@ -0,0 +1,91 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goapp/pkg/cache"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// KEYS: seq, events
+	// ARGV: now(微秒), frame, maxLen, ttl(毫秒)
+	luaAppendEvent = redis.NewScript(`
+	local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+	local id = math.max(cur + 1, tonumber(ARGV[1]))
+	local idstr = string.format('%d', id)
+	redis.call('SET', KEYS[1], idstr, 'PX', ARGV[4])
+	redis.call('ZADD', KEYS[2], id, idstr .. '|' .. ARGV[2])
+	redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -tonumber(ARGV[3]) - 1)
+	redis.call('PEXPIRE', KEYS[2], ARGV[4])
+	return idstr
+	`)
+)
+
+// 基于 Redis 的事件日志，适用于多节点部署
+//
+// {prefix}:seq:{userId} 存放最后分配的 id；{prefix}:events:{userId} 为 ZSET，分数为 id，成员为 "id|帧"
+type RedisEventLog struct {
+	cache  *cache.Cache
+	prefix string
+	maxLen int
+	ttl    time.Duration
+}
+
+// maxLen 每个用户最多保留的事件数，默认 256；ttl 用户没有新事件后日志保留的时间，默认 10 分钟
+func NewRedisEventLog(cache *cache.Cache, prefix string, maxLen int, ttl time.Duration) *RedisEventLog {
+	if maxLen <= 0 {
+		maxLen = 256
+	}
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	return &RedisEventLog{cache: cache, prefix: prefix, maxLen: maxLen, ttl: ttl}
+}
+
+func (l *RedisEventLog) Append(ctx context.Context, userId string, frame *LoggedFrame) (string, error) {
+	frame.ID = ""
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return "", err
+	}
+	keys := []string{
+		fmt.Sprintf("%s:seq:%s", l.prefix, userId),
+		fmt.Sprintf("%s:events:%s", l.prefix, userId),
+	}
+	id, err := luaAppendEvent.Run(ctx, l.cache.Master(), keys, time.Now().UnixMicro(), data, l.maxLen, l.ttl.Milliseconds()).Text()
+	if err != nil {
+		return "", err
+	}
+	frame.ID = id
+	return id, nil
+}
+
+func (l *RedisEventLog) Since(ctx context.Context, userId, lastId string) ([]*LoggedFrame, error) {
+	members, err := l.cache.Master().ZRangeByScore(ctx, fmt.Sprintf("%s:events:%s", l.prefix, userId), &redis.ZRangeBy{
+		Min: "(" + lastId,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]*LoggedFrame, 0, len(members))
+	for _, m := range members {
+		id, raw, ok := strings.Cut(m, "|")
+		if !ok {
+			continue
+		}
+		var f LoggedFrame
+		if err := json.Unmarshal([]byte(raw), &f); err != nil {
+			continue
+		}
+		f.ID = id
+		frames = append(frames, &f)
+	}
+	return frames, nil
+}
@@ -0,0 +1,75 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SSE 协议中的一个事件帧
+type Frame struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"` // 为空时客户端触发 message 事件
+	Data  string `json:"data"`
+	Retry int64  `json:"retry,omitempty"` // 客户端断线重连的间隔，单位毫秒
+}
+
+// 换行符会破坏帧结构，id、event 字段中的换行直接去掉
+var fieldReplacer = strings.NewReplacer("\r\n", "", "\r", "", "\n", "")
+
+// data 中的各种换行统一为 \n，再拆分为多个 data 字段
+var dataReplacer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// 按照 SSE 协议写出该帧
+func (f *Frame) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	if len(f.ID) > 0 {
+		bw.WriteString("id: ")
+		bw.WriteString(fieldReplacer.Replace(f.ID))
+		bw.WriteByte('\n')
+	}
+	if len(f.Event) > 0 {
+		bw.WriteString("event: ")
+		bw.WriteString(fieldReplacer.Replace(f.Event))
+		bw.WriteByte('\n')
+	}
+	if f.Retry > 0 {
+		bw.WriteString("retry: ")
+		bw.WriteString(strconv.FormatInt(f.Retry, 10))
+		bw.WriteByte('\n')
+	}
+	for line := range strings.SplitSeq(dataReplacer.Replace(f.Data), "\n") {
+		bw.WriteString("data: ")
+		bw.WriteString(line)
+		bw.WriteByte('\n')
+	}
+	bw.WriteByte('\n')
+	n := bw.Buffered()
+	return int64(n), bw.Flush()
+}
+
+// 事件 id 的数值，无法解析时返回 0
+func (f *Frame) seq() uint64 {
+	v, _ := strconv.ParseUint(f.ID, 10, 64)
+	return v
+}
+
+// 单调递增的事件 id 生成器：max(上一个 id + 1, 当前微秒时间戳)
+//
+// 这样即使进程重启，新的 id 也大概率大于客户端持有的 Last-Event-ID
+type eventIdGenerator struct {
+	last atomic.Uint64
+}
+
+func (g *eventIdGenerator) next() uint64 {
+	for {
+		last := g.last.Load()
+		id := max(last+1, uint64(time.Now().UnixMicro()))
+		if g.last.CompareAndSwap(last, id) {
+			return id
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"goapp/pkg/core"
+	"goapp/pkg/presence"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,10 +25,20 @@ type Hub struct {
 	unregisteredChan         chan *Line
 	errorChan                chan *LineError
 
-	isClosed atomic.Bool
+	presence *presence.Tracker
+
+	eventLog EventLog
+	retry    time.Duration
+	ids      eventIdGenerator
+
+	metrics *hubMetrics
+
+	draining   atomic.Bool
+	drainFrame *Frame
+	isClosed   atomic.Bool
 }
 
-func NewHub(pool core.CoroutinePool, liveCheckDuration time.Duration) (*Hub, error) {
+func NewHub(pool core.CoroutinePool, liveCheckDuration time.Duration, options ...HubOption) (*Hub, error) {
 	if pool == nil {
 		return nil, errors.New("pool must not nil")
 	}
@@ -43,16 +55,26 @@ func NewHub(pool core.CoroutinePool, liveCheckDuration time.Duration) (*Hub, err
 		registeredChan:           make(chan *Line, 2048),
 		unregisteredChan:         make(chan *Line, 2048),
 		errorChan:                make(chan *LineError, 2048),
+		drainFrame:               &Frame{Event: EventClose, Data: "server restarting"},
+	}
+	for _, opt := range options {
+		opt(h)
+	}
+	if h.metrics == nil {
+		h.metrics = newHubMetrics("sse", nil)
 	}
 	// 新的连接加入
 	err := h.pool.Submit(func() {
 		for ln := range h.registeredChanInternal {
 			ln.closeChan = make(chan core.Empty)
-			ln.writeChan = make(chan string, 2048)
+			ln.writeChan = make(chan *Frame, 2048)
 			// 新的连接加入
-			lines, _ := h.connections.LoadOrStore(ln.userId, &UserLines{lines: []*Line{}})
+			lines, _ := h.connections.LoadOrStore(ln.userId, &UserLines{hub: h, userId: ln.userId, lines: []*Line{}})
 			lines.(*UserLines).add(ln)
 			h.connCount.Add(1)
+			h.metrics.lineAdded(ln, 1)
+			h.presence.Register(ln.presenceInfo())
+			close(ln.readyChan)
 
 			h.registeredChan <- ln
 		}
@@ -72,6 +94,8 @@ func NewHub(pool core.CoroutinePool, liveCheckDuration time.Duration) (*Hub, err
 			userLines := lines.(*UserLines)
 			userLines.remove(ln.id)
 			h.connCount.Add(-1)
+			h.metrics.lineAdded(ln, -1)
+			h.presence.Unregister(ln.presenceInfo())
 			// 先删后关，防止在关闭之后，出现向通道意外发送的情况
 			h.closeLineChans(ln)
 
@@ -86,10 +110,20 @@ func NewHub(pool core.CoroutinePool, liveCheckDuration time.Duration) (*Hub, err
 	if err != nil {
 		return nil, err
 	}
+	// 定期续期在线状态
+	if err = h.presence.Start(); err != nil {
+		return nil, err
+	}
 	return h, nil
 }
 
 func (h *Hub) Serve(c *gin.Context, userId string, platform core.Platform, lineId string, extraData core.MapX) {
+	// 排空时拒绝新的连接，客户端按照 Retry-After 重试，由负载均衡分配到其它节点
+	if h.draining.Load() {
+		c.Header("Retry-After", "1")
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
 	// 设置 SSE 响应头
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
@@ -98,12 +132,14 @@ func (h *Hub) Serve(c *gin.Context, userId string, platform core.Platform, lineI
 
 	// 存下该平台新的连接
 	ln := &Line{
-		hub:       h,
-		writer:    c.Writer,
-		userId:    userId,
-		platform:  core.Platform(platform),
-		id:        lineId,
-		extraData: extraData,
+		hub:         h,
+		writer:      c.Writer,
+		userId:      userId,
+		platform:    core.Platform(platform),
+		id:          lineId,
+		extraData:   extraData,
+		connectedAt: time.Now().Unix(),
+		readyChan:   make(chan core.Empty),
 	}
 	ln.start(c)
 }
@@ -142,6 +178,7 @@ func (h *Hub) Close(wait time.Duration) {
 		}
 	}()
 
+	h.presence.Stop()
 	uls := make([]*UserLines, 0)
 	h.connections.Range(func(key, value any) bool {
 		uls = append(uls, value.(*UserLines))
@@ -202,40 +239,98 @@ func (h *Hub) CloseUserLines(userIds ...string) {
 	}
 }
 
-// 推送消息给指定用户的所有连接
+// 推送消息给指定用户的所有连接；启用事件日志时，不在线的用户也会记录，以便重连后补发
 func (h *Hub) PushMessage(userIds []string, data string) {
 	if len(userIds) == 0 || len(data) == 0 {
 		return
 	}
+	h.pushFrame(userIds, Frame{Data: data})
+}
+
+// 向用户指定的线路发送消息
+func (h *Hub) PushToUserLines(userId string, data string, lineIds ...string) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return h.pushFrameToUserLines(userId, Frame{Data: data}, lineIds...)
+}
+
+// 广播消息
+func (h *Hub) BroadcastMessage(data string) {
+	if len(data) == 0 {
+		return
+	}
+	h.broadcastFrame(Frame{Data: data})
+}
+
+// 推送事件给指定用户的所有连接；启用事件日志时，不在线的用户也会记录，以便重连后补发
+func (h *Hub) PushEvent(userIds []string, event *Event) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	frame, err := event.frame()
+	if err != nil {
+		return err
+	}
+	h.pushFrame(userIds, frame)
+	return nil
+}
+
+// 向用户指定的线路发送事件
+func (h *Hub) PushEventToUserLines(userId string, event *Event, lineIds ...string) error {
+	frame, err := event.frame()
+	if err != nil {
+		return err
+	}
+	return h.pushFrameToUserLines(userId, frame, lineIds...)
+}
+
+// 广播事件
+func (h *Hub) BroadcastEvent(event *Event) error {
+	frame, err := event.frame()
+	if err != nil {
+		return err
+	}
+	h.broadcastFrame(frame)
+	return nil
+}
+
+func (h *Hub) pushFrame(userIds []string, frame Frame) {
 	h.pool.Submit(func() {
 		for _, userId := range userIds {
 			lines, ok := h.connections.Load(userId)
 			if !ok {
+				if h.eventLog != nil {
+					h.prepareFrame(userId, frame, nil)
+				}
 				continue
 			}
-			lines.(*UserLines).PushMessage(data)
+			lines.(*UserLines).push(frame, nil, nil)
 		}
 	})
 }
 
-// 向用户指定的线路发送消息
-func (h *Hub) PushToUserLines(userId string, data string, lineIds ...string) error {
+func (h *Hub) pushFrameToUserLines(userId string, frame Frame, lineIds ...string) error {
 	uls := h.GetUserLines(userId)
 	if uls == nil {
-		return errors.New("userlines empty")
+		if h.eventLog == nil {
+			return errors.New("userlines empty")
+		}
+		if len(lineIds) > 0 {
+			h.prepareFrame(userId, frame, lineIds)
+		}
+		return nil
+	}
+	if len(lineIds) > 0 {
+		uls.push(frame, lineIds, linesMatcher(lineIds))
 	}
-	uls.PushMessageToLines(data, lineIds...)
 	return nil
 }
 
-// 广播消息
-func (h *Hub) BroadcastMessage(data string) {
-	if len(data) == 0 {
-		return
-	}
+func (h *Hub) broadcastFrame(frame Frame) {
 	h.pool.Submit(func() {
 		h.connections.Range(func(key, lns any) bool {
-			lns.(*UserLines).PushMessage(data)
+			lns.(*UserLines).push(frame, nil, nil)
 			return true
 		})
 	})
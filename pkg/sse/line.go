@@ -2,7 +2,6 @@ package sse
 
 import (
 	"errors"
-	"fmt"
 	"goapp/pkg/core"
 	"sync/atomic"
 	"time"
@@ -26,14 +25,25 @@ type Line struct {
 	platform  core.Platform
 	extraData core.MapX
 
-	writeChan chan string
+	connectedAt int64
+
+	writeChan chan *Frame
 	closeChan chan core.Empty
+	readyChan chan core.Empty // 注册完成后关闭
 
 	isClosed atomic.Bool
 }
 
-func (ln *Line) push(msg string) error {
-	_, err := fmt.Fprintf(ln.writer, "id: %d\ndata: %s\n\n", time.Now().UnixMicro(), msg)
+func (ln *Line) Id() string { return ln.id }
+
+func (ln *Line) UserId() string { return ln.userId }
+
+func (ln *Line) Platform() core.Platform { return ln.platform }
+
+func (ln *Line) ConnectedAt() int64 { return ln.connectedAt }
+
+func (ln *Line) push(frame *Frame) error {
+	_, err := frame.WriteTo(ln.writer)
 	if err != nil {
 		return err
 	}
@@ -47,13 +57,27 @@ func (ln *Line) start(c *gin.Context) {
 	}
 
 	ln.hub.registeredChanInternal <- ln
+	// 等待注册完成，之后推送的消息都会进入 writeChan，补发时不会遗漏
+	select {
+	case <-ln.readyChan:
+	case <-c.Request.Context().Done():
+		ln.close(errors.New("client disconnect"))
+		return
+	}
 
 	// 创建定时器用于心跳检测
 	ticker := time.NewTicker(ln.hub.liveCheckDuration)
 	defer ticker.Stop()
 
-	// 发送初始连接确认
-	ln.push("open")
+	// 发送初始连接确认；open、ping 不带 id，不会影响客户端的 Last-Event-ID
+	ln.push(&Frame{Data: "open", Retry: ln.hub.retry.Milliseconds()})
+
+	// 补发断线期间错过的事件，注册后进入 writeChan 的重复事件会被跳过
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if len(lastEventId) == 0 {
+		lastEventId = c.Query("lastEventId")
+	}
+	replayed := ln.replay(lastEventId)
 
 	// 主循环处理消息
 	for {
@@ -62,12 +86,23 @@ func (ln *Line) start(c *gin.Context) {
 		}
 
 		select {
-		case msg := <-ln.writeChan:
+		case frame := <-ln.writeChan:
+			if frame == nil || (replayed > 0 && frame.seq() > 0 && frame.seq() <= replayed) {
+				continue
+			}
+			// 排空时的关闭事件，之前的事件都已发送
+			if frame == ln.hub.drainFrame {
+				ln.push(frame)
+				ln.close(nil)
+				return
+			}
 			// 发送消息到客户端
-			ln.push(msg)
+			if ln.push(frame) == nil {
+				ln.hub.metrics.sent.Add(1, ln.hub.metrics.name)
+			}
 		case <-ticker.C:
 			// 发送心跳保持连接
-			ln.push("ping")
+			ln.push(&Frame{Data: "ping"})
 		case <-ln.closeChan:
 			// 服务端主动关闭连接
 			ln.isClosed.Store(true)
@@ -0,0 +1,40 @@
+package sse
+
+import (
+	"goapp/pkg/metrics"
+)
+
+type hubMetrics struct {
+	name           string
+	lines          metrics.Gauge   // 连接数，按平台区分
+	sent           metrics.Counter // 写入连接的事件数
+	replayed       metrics.Counter // 重连时补发的事件数
+	eventLogErrors metrics.Counter // 访问事件日志失败的次数
+}
+
+func newHubMetrics(name string, p metrics.Provider) *hubMetrics {
+	p = metrics.Or(p)
+	return &hubMetrics{
+		name:           name,
+		lines:          p.Gauge("sse_lines", "Number of live SSE lines on this node.", "hub", "platform"),
+		sent:           p.Counter("sse_events_sent_total", "Events written to clients.", "hub"),
+		replayed:       p.Counter("sse_events_replayed_total", "Events replayed to reconnecting clients.", "hub"),
+		eventLogErrors: p.Counter("sse_event_log_errors_total", "Failed event log operations.", "hub", "op"),
+	}
+}
+
+// 设置指标的提供者；name 用于区分同一进程中的多个 Hub，默认为 "sse"
+//
+// 未设置时使用 metrics.Default()
+func WithMetrics(name string, p metrics.Provider) HubOption {
+	return func(h *Hub) {
+		if len(name) == 0 {
+			name = "sse"
+		}
+		h.metrics = newHubMetrics(name, p)
+	}
+}
+
+func (m *hubMetrics) lineAdded(ln *Line, delta float64) {
+	m.lines.Add(delta, m.name, ln.platform.String())
+}
@@ -0,0 +1,76 @@
+package sse
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 访问事件日志时的超时时间
+const eventLogTimeout = 5 * time.Second
+
+// 使用事件日志：推送的事件会记录到日志中，客户端携带 Last-Event-ID 重连时，补发期间错过的事件
+func WithEventLog(log EventLog) HubOption {
+	return func(h *Hub) {
+		h.eventLog = log
+	}
+}
+
+// 建议客户端断线重连的间隔，连接建立时通过 retry 字段下发
+func WithRetry(retry time.Duration) HubOption {
+	return func(h *Hub) {
+		h.retry = retry
+	}
+}
+
+func (h *Hub) EventLog() EventLog { return h.eventLog }
+
+// 为即将推送给用户的消息分配 id，并记录到事件日志中
+//
+// 记录失败时仍然分配本地 id，保证消息可以正常推送，只是无法补发
+func (h *Hub) prepareFrame(userId string, f Frame, lineIds []string) *LoggedFrame {
+	frame := &LoggedFrame{Frame: f, LineIds: lineIds}
+	if h.eventLog != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), eventLogTimeout)
+		defer cancel()
+		_, err := h.eventLog.Append(ctx, userId, frame)
+		if err == nil {
+			return frame
+		}
+		h.metrics.eventLogErrors.Add(1, h.metrics.name, "append")
+		fmt.Printf("[SSE] event log append failed, userId: %s, err: %v\n", userId, err)
+	}
+	if len(f.ID) == 0 {
+		frame.ID = strconv.FormatUint(h.ids.next(), 10)
+	}
+	return frame
+}
+
+// 补发 lastEventId 之后该连接错过的事件，返回最后补发的事件序号
+func (ln *Line) replay(lastEventId string) uint64 {
+	if ln.hub.eventLog == nil || len(lastEventId) == 0 {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventLogTimeout)
+	defer cancel()
+	frames, err := ln.hub.eventLog.Since(ctx, ln.userId, lastEventId)
+	if err != nil {
+		ln.hub.metrics.eventLogErrors.Add(1, ln.hub.metrics.name, "since")
+		fmt.Printf("[SSE] event log replay failed, userId: %s, lineId: %s, err: %v\n", ln.userId, ln.id, err)
+		return 0
+	}
+
+	var last uint64
+	for _, f := range frames {
+		last = max(last, f.seq())
+		if !f.isFor(ln.id) {
+			continue
+		}
+		if err := ln.push(&f.Frame); err != nil {
+			break
+		}
+		ln.hub.metrics.replayed.Add(1, ln.hub.metrics.name)
+	}
+	return last
+}
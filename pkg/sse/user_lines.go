@@ -10,6 +10,8 @@ import (
 // 用户在各个平台的所有连接
 type UserLines struct {
 	sync.RWMutex
+	hub       *Hub
+	userId    string
 	lines     []*Line
 	lineCount atomic.Int32
 }
@@ -166,16 +168,7 @@ func (u *UserLines) PushMessage(data string) {
 	if len(data) == 0 {
 		return
 	}
-
-	u.RLock()
-	defer u.RUnlock()
-
-	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
-			continue
-		}
-		line.writeChan <- data
-	}
+	u.push(Frame{Data: data}, nil, nil)
 }
 
 // 向该用户的所有连接发送消息，除了指定平台
@@ -183,19 +176,7 @@ func (u *UserLines) PushMessageExceptPlatforms(data string, exceptPlatforms ...c
 	if len(exceptPlatforms) == 0 || len(data) == 0 {
 		return
 	}
-
-	u.RLock()
-	defer u.RUnlock()
-
-	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
-			continue
-		}
-		if slices.Contains(exceptPlatforms, line.platform) {
-			continue
-		}
-		line.writeChan <- data
-	}
+	u.pushMatched(Frame{Data: data}, exceptPlatformsMatcher(exceptPlatforms))
 }
 
 // 向该用户的所有连接发送消息，除了指定连接
@@ -203,19 +184,7 @@ func (u *UserLines) PushMessageExceptLines(data string, exceptLineIds ...string)
 	if len(exceptLineIds) == 0 || len(data) == 0 {
 		return
 	}
-
-	u.RLock()
-	defer u.RUnlock()
-
-	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
-			continue
-		}
-		if slices.Contains(exceptLineIds, line.id) {
-			continue
-		}
-		line.writeChan <- data
-	}
+	u.pushMatched(Frame{Data: data}, exceptLinesMatcher(exceptLineIds))
 }
 
 // 向该用户的指定平台发送消息
@@ -223,18 +192,7 @@ func (u *UserLines) PushMessageToPlatforms(data string, platforms ...core.Platfo
 	if len(platforms) == 0 || len(data) == 0 {
 		return
 	}
-
-	u.RLock()
-	defer u.RUnlock()
-
-	for _, line := range u.lines {
-		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
-			continue
-		}
-		if slices.Contains(platforms, line.platform) {
-			line.writeChan <- data
-		}
-	}
+	u.pushMatched(Frame{Data: data}, platformsMatcher(platforms))
 }
 
 // 向该用户的指定连接发送消息
@@ -242,6 +200,106 @@ func (u *UserLines) PushMessageToLines(data string, lineIds ...string) {
 	if len(lineIds) == 0 || len(data) == 0 {
 		return
 	}
+	u.push(Frame{Data: data}, lineIds, linesMatcher(lineIds))
+}
+
+// 向该用户的所有连接发送事件
+func (u *UserLines) PushEvent(event *Event) error {
+	frame, err := event.frame()
+	if err != nil {
+		return err
+	}
+	u.push(frame, nil, nil)
+	return nil
+}
+
+// 向该用户的所有连接发送事件，除了指定平台
+func (u *UserLines) PushEventExceptPlatforms(event *Event, exceptPlatforms ...core.Platform) error {
+	if len(exceptPlatforms) == 0 {
+		return nil
+	}
+	frame, err := event.frame()
+	if err != nil {
+		return err
+	}
+	u.pushMatched(frame, exceptPlatformsMatcher(exceptPlatforms))
+	return nil
+}
+
+// 向该用户的所有连接发送事件，除了指定连接
+func (u *UserLines) PushEventExceptLines(event *Event, exceptLineIds ...string) error {
+	if len(exceptLineIds) == 0 {
+		return nil
+	}
+	frame, err := event.frame()
+	if err != nil {
+		return err
+	}
+	u.pushMatched(frame, exceptLinesMatcher(exceptLineIds))
+	return nil
+}
+
+// 向该用户的指定平台发送事件
+func (u *UserLines) PushEventToPlatforms(event *Event, platforms ...core.Platform) error {
+	if len(platforms) == 0 {
+		return nil
+	}
+	frame, err := event.frame()
+	if err != nil {
+		return err
+	}
+	u.pushMatched(frame, platformsMatcher(platforms))
+	return nil
+}
+
+// 向该用户的指定连接发送事件
+func (u *UserLines) PushEventToLines(event *Event, lineIds ...string) error {
+	if len(lineIds) == 0 {
+		return nil
+	}
+	frame, err := event.frame()
+	if err != nil {
+		return err
+	}
+	u.push(frame, lineIds, linesMatcher(lineIds))
+	return nil
+}
+
+func platformsMatcher(platforms []core.Platform) func(line *Line) bool {
+	return func(line *Line) bool { return slices.Contains(platforms, line.platform) }
+}
+
+func exceptPlatformsMatcher(exceptPlatforms []core.Platform) func(line *Line) bool {
+	return func(line *Line) bool { return !slices.Contains(exceptPlatforms, line.platform) }
+}
+
+func linesMatcher(lineIds []string) func(line *Line) bool {
+	return func(line *Line) bool { return slices.Contains(lineIds, line.id) }
+}
+
+func exceptLinesMatcher(exceptLineIds []string) func(line *Line) bool {
+	return func(line *Line) bool { return !slices.Contains(exceptLineIds, line.id) }
+}
+
+// 向当前符合条件的连接发送消息，事件日志中记录这些连接的 id，重连后只补发给它们
+func (u *UserLines) pushMatched(frame Frame, match func(line *Line) bool) {
+	u.RLock()
+	lineIds := make([]string, 0, len(u.lines))
+	for _, line := range u.lines {
+		if match(line) {
+			lineIds = append(lineIds, line.id)
+		}
+	}
+	u.RUnlock()
+	if len(lineIds) == 0 {
+		return
+	}
+	u.push(frame, lineIds, match)
+}
+
+// 分配 id、记录事件日志后，投递给符合条件的连接；match 为 nil 表示所有连接
+func (u *UserLines) push(frame Frame, lineIds []string, match func(line *Line) bool) {
+	logged := u.hub.prepareFrame(u.userId, frame, lineIds)
 
 	u.RLock()
 	defer u.RUnlock()
@@ -250,8 +308,9 @@ func (u *UserLines) PushMessageToLines(data string, lineIds ...string) {
 		if line.isClosed.Load() || line.hub.isClosed.Load() || line.writeChan == nil {
 			continue
 		}
-		if slices.Contains(lineIds, line.id) {
-			line.writeChan <- data
+		if match != nil && !match(line) {
+			continue
 		}
+		line.writeChan <- &logged.Frame
 	}
 }
@@ -15,6 +15,19 @@ func Days(dur time.Duration) int64 {
 	return int64(dur.Hours() / 24)
 }
 
+// 配置中以秒为单位的时长，未配置（不大于 0）时使用默认值
+func SecondsOr(seconds int64, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// 向上取整的秒数，用于 Retry-After 等需要整秒的场景
+func CeilSeconds(dur time.Duration) int64 {
+	return int64((dur + time.Second - 1) / time.Second)
+}
+
 // 上个月此时
 func LastMonthNow(t time.Time) time.Time {
 	return t.AddDate(0, -1, 0)
@@ -0,0 +1,170 @@
+package authes_test
+
+import (
+	"context"
+	"goapp/internal/app/features/authes/stores"
+	"goapp/pkg/cache"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newAttemptStore(t *testing.T) (*miniredis.Miniredis, *stores.LoginAttemptStore) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	c, err := cache.NewCacheWithAddr(context.Background(), mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mr, stores.NewLoginAttemptStoreWithCache(c)
+}
+
+var testPhone = stores.AttemptSubject{Kind: stores.AttemptKindPhone, Value: "8613800000001"}
+
+func TestLoginAttemptProgressiveDelay(t *testing.T) {
+	_, store := newAttemptStore(t)
+	ctx := context.Background()
+	reserve := func() time.Duration {
+		t.Helper()
+		wait, err := store.Reserve(ctx, time.Hour, 2, 200*time.Millisecond, 400*time.Millisecond, testPhone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wait
+	}
+	fail := func() {
+		t.Helper()
+		if _, _, err := store.RecordFailure(ctx, testPhone, 0, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 前 2 次失败不需要等待
+	for range 3 {
+		if wait := reserve(); wait != 0 {
+			t.Fatalf("unexpected wait within free attempts: %v", wait)
+		}
+		fail()
+	}
+	// 失败 3 次后等待 200ms，之后翻倍，最长 400ms
+	if wait := reserve(); wait <= 0 || wait > 200*time.Millisecond {
+		t.Fatalf("unexpected first delay: %v", wait)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if wait := reserve(); wait != 0 {
+		t.Fatalf("still waiting after delay: %v", wait)
+	}
+	fail()
+	if wait := reserve(); wait <= 200*time.Millisecond || wait > 400*time.Millisecond {
+		t.Fatalf("delay not doubled: %v", wait)
+	}
+	time.Sleep(450 * time.Millisecond)
+	if wait := reserve(); wait != 0 {
+		t.Fatalf("still waiting after delay: %v", wait)
+	}
+	fail()
+	if wait := reserve(); wait <= 200*time.Millisecond || wait > 400*time.Millisecond {
+		t.Fatalf("delay exceeds max: %v", wait)
+	}
+}
+
+func TestLoginAttemptReserveConcurrent(t *testing.T) {
+	_, store := newAttemptStore(t)
+	ctx := context.Background()
+
+	// 没有免等待次数时，同时登录只有一个请求能预留到尝试
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for range 20 {
+		wg.Go(func() {
+			wait, err := store.Reserve(ctx, time.Hour, 0, time.Minute, time.Minute, testPhone)
+			if err != nil {
+				t.Error(err)
+			}
+			if wait == 0 {
+				reserved.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if n := reserved.Load(); n != 1 {
+		t.Fatalf("expected 1 reservation, got %d", n)
+	}
+}
+
+func TestLoginAttemptRelease(t *testing.T) {
+	_, store := newAttemptStore(t)
+	ctx := context.Background()
+	ip := stores.AttemptSubject{Kind: stores.AttemptKindIp, Value: "1.1.1.1"}
+
+	for range 2 {
+		if _, err := store.Reserve(ctx, time.Hour, 5, time.Second, time.Minute, testPhone, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Release(ctx, testPhone, ip); err != nil {
+		t.Fatal(err)
+	}
+	states, err := store.States(ctx, testPhone, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if state.Fails != 1 {
+			t.Fatalf("reservation not released: %+v", state)
+		}
+	}
+
+	// 撤销不存在的预留不会产生负数
+	for range 2 {
+		if err := store.Release(ctx, testPhone); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if states, _ := store.States(ctx, testPhone); states[0].Fails != 0 {
+		t.Fatalf("unexpected fails: %+v", states[0])
+	}
+}
+
+func TestLoginAttemptLockout(t *testing.T) {
+	mr, store := newAttemptStore(t)
+	ctx := context.Background()
+
+	for i := range 3 {
+		if wait, err := store.Reserve(ctx, time.Hour, 10, time.Second, time.Minute, testPhone); err != nil || wait != 0 {
+			t.Fatalf("reserve: %v, %v", wait, err)
+		}
+		fails, locked, err := store.RecordFailure(ctx, testPhone, 3, 15*time.Minute)
+		if err != nil || fails != int64(i+1) || locked != (i == 2) {
+			t.Fatalf("failure %d: %d, %v, %v", i+1, fails, locked, err)
+		}
+	}
+
+	// 锁定期间不能预留，等待时间为剩余的锁定时间
+	wait, err := store.Reserve(ctx, time.Hour, 10, time.Second, time.Minute, testPhone)
+	if err != nil || wait <= 14*time.Minute || wait > 15*time.Minute {
+		t.Fatalf("locked: %v, %v", wait, err)
+	}
+	// 锁定到期后重新统计
+	mr.FastForward(15 * time.Minute)
+	if wait, err := store.Reserve(ctx, time.Hour, 10, time.Second, time.Minute, testPhone); err != nil || wait != 0 {
+		t.Fatalf("after lock expired: %v, %v", wait, err)
+	}
+	if states, _ := store.States(ctx, testPhone); states[0].Fails != 1 || states[0].LockedFor != 0 {
+		t.Fatalf("unexpected state after lock: %+v", states[0])
+	}
+
+	// 手动解除锁定
+	if _, locked, err := store.RecordFailure(ctx, testPhone, 1, 15*time.Minute); err != nil || !locked {
+		t.Fatalf("lock again: %v, %v", locked, err)
+	}
+	if locked, err := store.Unlock(ctx, testPhone); err != nil || !locked {
+		t.Fatalf("unlock: %v, %v", locked, err)
+	}
+	if wait, err := store.Reserve(ctx, time.Hour, 10, time.Second, time.Minute, testPhone); err != nil || wait != 0 {
+		t.Fatalf("after unlock: %v, %v", wait, err)
+	}
+}
@@ -0,0 +1,63 @@
+package authes_test
+
+import (
+	"context"
+	"goapp/internal/app/shared/claims"
+	"goapp/pkg/ids"
+	"testing"
+)
+
+func TestRevokeOtherSessions(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	userId := ids.NewUID()
+
+	saveTokens(t, store, &claims.AuthorizedClaims{UserId: userId, ClientId: "c1"}, "a1", "r1")
+	saveTokens(t, store, &claims.AuthorizedClaims{UserId: userId, ClientId: "c2"}, "a2", "r2")
+	saveTokens(t, store, &claims.AuthorizedClaims{UserId: userId}, "a3", "r3")
+	other := ids.NewUID()
+	saveTokens(t, store, &claims.AuthorizedClaims{UserId: other, ClientId: "c4"}, "a4", "r4")
+
+	revoked, err := store.RevokeOtherSessions(ctx, userId, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0] != "c2" {
+		t.Fatalf("unexpected revoked sessions: %v", revoked)
+	}
+
+	// 当前会话及其它用户的令牌保留，其余令牌全部失效
+	for _, token := range []string{"a1", "a4"} {
+		if _, err := store.GetAccessTokenClaims(ctx, token); err != nil {
+			t.Fatalf("access token %s revoked: %v", token, err)
+		}
+	}
+	for _, token := range []string{"r1", "r4"} {
+		if store.GetRefreshTokenCredential(ctx, token) == nil {
+			t.Fatalf("refresh token %s revoked", token)
+		}
+	}
+	for _, token := range []string{"a2", "a3"} {
+		if _, err := store.GetAccessTokenClaims(ctx, token); err == nil {
+			t.Fatalf("access token %s not revoked", token)
+		}
+	}
+	for _, token := range []string{"r2", "r3"} {
+		if store.GetRefreshTokenCredential(ctx, token) != nil {
+			t.Fatalf("refresh token %s not revoked", token)
+		}
+	}
+	sessions, err := store.ListSessions(ctx, userId)
+	if err != nil || len(sessions) != 1 || sessions[0].ClientId != "c1" {
+		t.Fatalf("unexpected sessions: %v, %v", sessions, err)
+	}
+
+	// 没有 clientId 时吊销全部
+	revoked, err = store.RevokeOtherSessions(ctx, userId, "")
+	if err != nil || len(revoked) != 1 || revoked[0] != "c1" {
+		t.Fatalf("revoke all: %v, %v", revoked, err)
+	}
+	if _, err := store.GetAccessTokenClaims(ctx, "a1"); err == nil {
+		t.Fatal("access token a1 not revoked")
+	}
+}
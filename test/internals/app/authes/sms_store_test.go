@@ -0,0 +1,173 @@
+package authes_test
+
+import (
+	"context"
+	"errors"
+	"goapp/internal/app/features/authes/stores"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireSmsSendCooldown(t *testing.T) {
+	mr, store := newTestStore(t)
+	ctx := context.Background()
+	acquire := func(phone, ip string) (time.Duration, error) {
+		return store.AcquireSmsSend(ctx, phone, ip, time.Minute, 10*time.Second, 10, 0)
+	}
+
+	if wait, err := acquire("8613800000001", "1.1.1.1"); err != nil || wait != 0 {
+		t.Fatalf("first send: %v, %v", wait, err)
+	}
+	// 手机号冷却中，换 IP 也需要等待
+	if wait, err := acquire("8613800000001", "2.2.2.2"); err != nil || wait <= 50*time.Second || wait > time.Minute {
+		t.Fatalf("phone cooldown: %v, %v", wait, err)
+	}
+	// IP 冷却中，换手机号也需要等待
+	if wait, err := acquire("8613800000002", "1.1.1.1"); err != nil || wait <= 0 || wait > 10*time.Second {
+		t.Fatalf("ip cooldown: %v, %v", wait, err)
+	}
+	// 冷却中的请求不开始新的冷却，也不计入次数
+	mr.FastForward(10 * time.Second)
+	if wait, err := acquire("8613800000002", "1.1.1.1"); err != nil || wait != 0 {
+		t.Fatalf("after ip cooldown: %v, %v", wait, err)
+	}
+	mr.FastForward(time.Minute)
+	if wait, err := acquire("8613800000001", "2.2.2.2"); err != nil || wait != 0 {
+		t.Fatalf("after phone cooldown: %v, %v", wait, err)
+	}
+}
+
+func TestAcquireSmsSendConcurrent(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+
+	// 同时发送时只有一个请求通过
+	var wg sync.WaitGroup
+	var acquired atomic.Int32
+	for range 20 {
+		wg.Go(func() {
+			wait, err := store.AcquireSmsSend(ctx, "8613800000001", "1.1.1.1", time.Minute, 0, 0, 0)
+			if err != nil {
+				t.Error(err)
+			}
+			if wait == 0 {
+				acquired.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if n := acquired.Load(); n != 1 {
+		t.Fatalf("expected 1 send, got %d", n)
+	}
+}
+
+func TestAcquireSmsSendDailyLimit(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+
+	// 手机号每天最多 2 次
+	for range 2 {
+		if wait, err := store.AcquireSmsSend(ctx, "8613800000001", "1.1.1.1", 0, 0, 2, 0); err != nil || wait != 0 {
+			t.Fatalf("send: %v, %v", wait, err)
+		}
+	}
+	if _, err := store.AcquireSmsSend(ctx, "8613800000001", "1.1.1.2", 0, 0, 2, 0); !errors.Is(err, stores.ErrSmsDailyLimit) {
+		t.Fatalf("expected phone daily limit, got %v", err)
+	}
+
+	// 同一 IP 每天最多 3 次，不论手机号
+	for _, phone := range []string{"8613800000002", "8613800000003", "8613800000004"} {
+		if wait, err := store.AcquireSmsSend(ctx, phone, "2.2.2.2", 0, 0, 0, 3); err != nil || wait != 0 {
+			t.Fatalf("send to %s: %v, %v", phone, wait, err)
+		}
+	}
+	if _, err := store.AcquireSmsSend(ctx, "8613800000005", "2.2.2.2", 0, 0, 0, 3); !errors.Is(err, stores.ErrSmsDailyLimit) {
+		t.Fatalf("expected ip daily limit, got %v", err)
+	}
+}
+
+func TestVerifySmsCodeSingleUse(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	phone := "8613800000001"
+	if err := store.SaveSmsCode(ctx, phone, "123456", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同时验证时只有一个请求成功，之后验证码作废
+	var wg sync.WaitGroup
+	var verified atomic.Int32
+	for range 20 {
+		wg.Go(func() {
+			ok, err := store.VerifySmsCode(ctx, phone, "123456", 100)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				verified.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if n := verified.Load(); n != 1 {
+		t.Fatalf("expected 1 successful verification, got %d", n)
+	}
+	if ok, _ := store.VerifySmsCode(ctx, phone, "123456", 100); ok {
+		t.Fatal("code reused")
+	}
+}
+
+func TestVerifySmsCodeMaxAttempts(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	phone := "8613800000001"
+	if err := store.SaveSmsCode(ctx, phone, "123456", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// 错误次数达到上限后，正确的验证码也不能再使用
+	for range 3 {
+		if ok, err := store.VerifySmsCode(ctx, phone, "000000", 3); err != nil || ok {
+			t.Fatalf("wrong code: %v, %v", ok, err)
+		}
+	}
+	if ok, err := store.VerifySmsCode(ctx, phone, "123456", 3); err != nil || ok {
+		t.Fatalf("code should be invalidated: %v, %v", ok, err)
+	}
+
+	// 重新发送后重置验证次数
+	if err := store.SaveSmsCode(ctx, phone, "654321", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.VerifySmsCode(ctx, phone, "654321", 3); err != nil || !ok {
+		t.Fatalf("new code: %v, %v", ok, err)
+	}
+}
+
+func TestVerifyCaptchaSingleUse(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	if err := store.SaveCsrfToken(ctx, "csrf", "1234", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// 验证失败同样作废，不能继续猜测
+	if ok, err := store.VerifyCaptcha(ctx, "csrf", "0000", true); err != nil || ok {
+		t.Fatalf("wrong captcha: %v, %v", ok, err)
+	}
+	if ok, _ := store.VerifyCaptcha(ctx, "csrf", "1234", true); ok {
+		t.Fatal("captcha reused after failed verification")
+	}
+
+	if err := store.SaveCsrfToken(ctx, "csrf", "1234", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.VerifyCaptcha(ctx, "csrf", "1234", true); err != nil || !ok {
+		t.Fatalf("captcha: %v, %v", ok, err)
+	}
+	if ok, _ := store.VerifyCaptcha(ctx, "csrf", "1234", true); ok {
+		t.Fatal("captcha reused")
+	}
+}
@@ -0,0 +1,34 @@
+package authes_test
+
+import (
+	"context"
+	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/app/shared/claims"
+	"goapp/pkg/cache"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestStore(t *testing.T) (*miniredis.Miniredis, *stores.AuthStore) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	c, err := cache.NewCacheWithAddr(context.Background(), mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mr, stores.NewAuthStoreWithCache(c)
+}
+
+// 为客户端保存一对令牌，clientId 为空时不建立会话
+func saveTokens(t *testing.T, store *stores.AuthStore, cc *claims.AuthorizedClaims, access, refresh string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.SaveAccessToken(ctx, access, time.Hour, cc); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveRefreshToken(ctx, refresh, cc, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+package authes_test
+
+import (
+	"context"
+	"goapp/internal/app/features/authes/stores"
+	"goapp/internal/app/shared/claims"
+	"goapp/pkg/ids"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 模拟一次刷新：记录 token 已轮换并删除，之后签发同一令牌族的新令牌
+func rotateRefreshToken(t *testing.T, store *stores.AuthStore, cc *claims.AuthorizedClaims, token string, rotatedAt time.Time, access, refresh string) {
+	t.Helper()
+	ctx := context.Background()
+	err := store.MarkRefreshTokenRotated(ctx, token, &stores.RotatedRefreshToken{
+		FamilyId:  cc.FamilyId,
+		UserId:    cc.UserId,
+		ClientId:  cc.ClientId,
+		RotatedAt: rotatedAt.UnixMilli(),
+	}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.ConsumeRefreshToken(ctx, token); err != nil || !ok {
+		t.Fatalf("consume %s: %v, %v", token, ok, err)
+	}
+	saveTokens(t, store, cc, access, refresh)
+}
+
+func TestConsumeRefreshTokenConcurrent(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	cc := &claims.AuthorizedClaims{UserId: ids.NewUID(), ClientId: "c1", FamilyId: ids.NewUID().String()}
+	saveTokens(t, store, cc, "a1", "r1")
+
+	// 并发刷新时只有一个请求能使用刷新令牌
+	var wg sync.WaitGroup
+	var consumed atomic.Int32
+	for range 20 {
+		wg.Go(func() {
+			ok, err := store.ConsumeRefreshToken(ctx, "r1")
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				consumed.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if n := consumed.Load(); n != 1 {
+		t.Fatalf("expected 1 consumer, got %d", n)
+	}
+	if store.GetRefreshTokenCredential(ctx, "r1") != nil {
+		t.Fatal("refresh token not deleted")
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	userId := ids.NewUID()
+	stolen := &claims.AuthorizedClaims{UserId: userId, ClientId: "c1", FamilyId: ids.NewUID().String()}
+	other := &claims.AuthorizedClaims{UserId: userId, ClientId: "c2", FamilyId: ids.NewUID().String()}
+	saveTokens(t, store, stolen, "a1", "r1")
+	saveTokens(t, store, other, "a3", "r3")
+	if err := store.DeleteAccessToken(ctx, "a1"); err != nil {
+		t.Fatal(err)
+	}
+	rotateRefreshToken(t, store, stolen, "r1", time.Now().Add(-time.Minute), "a2", "r2")
+
+	// 轮换一分钟后再次使用 r1，吊销令牌族中的 a2、r2 及会话，其它令牌族不受影响
+	rotated, revoked, err := store.RevokeReusedRefreshToken(ctx, "r1", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == nil || rotated.FamilyId != stolen.FamilyId || rotated.ClientId != "c1" || revoked != 2 {
+		t.Fatalf("unexpected reuse result: %+v, %d", rotated, revoked)
+	}
+	if _, err := store.GetAccessTokenClaims(ctx, "a2"); err == nil {
+		t.Fatal("access token of the family not revoked")
+	}
+	if store.GetRefreshTokenCredential(ctx, "r2") != nil {
+		t.Fatal("refresh token of the family not revoked")
+	}
+	if _, err := store.GetAccessTokenClaims(ctx, "a3"); err != nil {
+		t.Fatalf("other family revoked: %v", err)
+	}
+	sessions, err := store.ListSessions(ctx, userId)
+	if err != nil || len(sessions) != 1 || sessions[0].ClientId != "c2" {
+		t.Fatalf("unexpected sessions: %v, %v", sessions, err)
+	}
+
+	// 再次使用时已经没有可吊销的令牌
+	if _, revoked, err := store.RevokeReusedRefreshToken(ctx, "r1", 10*time.Second); err != nil || revoked != 0 {
+		t.Fatalf("second reuse: %d, %v", revoked, err)
+	}
+}
+
+func TestRefreshTokenReuseWithinGrace(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	cc := &claims.AuthorizedClaims{UserId: ids.NewUID(), ClientId: "c1", FamilyId: ids.NewUID().String()}
+	saveTokens(t, store, cc, "a1", "r1")
+	rotateRefreshToken(t, store, cc, "r1", time.Now(), "a2", "r2")
+
+	// 轮换后很快再次使用视为客户端并发刷新，不吊销
+	rotated, revoked, err := store.RevokeReusedRefreshToken(ctx, "r1", 10*time.Second)
+	if err != nil || rotated != nil || revoked != 0 {
+		t.Fatalf("reuse within grace: %+v, %d, %v", rotated, revoked, err)
+	}
+	if store.GetRefreshTokenCredential(ctx, "r2") == nil {
+		t.Fatal("refresh token revoked within grace")
+	}
+
+	// 没有轮换过的令牌不处理
+	if rotated, _, err := store.RevokeReusedRefreshToken(ctx, "unknown", 0); err != nil || rotated != nil {
+		t.Fatalf("unknown token: %+v, %v", rotated, err)
+	}
+}
@@ -0,0 +1,54 @@
+package cryptos_test
+
+import (
+	"errors"
+	"goapp/pkg/cryptos"
+	"strings"
+	"testing"
+)
+
+// 测试使用较小的参数，避免耗时
+var fastParams = cryptos.Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func TestPasswordHashAndVerify(t *testing.T) {
+	h := cryptos.NewPasswordHasher(cryptos.WithArgon2id(fastParams))
+	hash, err := h.Hash("p@ssw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+	if ok, rehash, err := h.Verify("p@ssw0rd", hash); !ok || rehash || err != nil {
+		t.Fatalf("verify failed: %v, %v, %v", ok, rehash, err)
+	}
+	if ok, _, err := h.Verify("wrong", hash); ok || err != nil {
+		t.Fatalf("wrong password verified: %v, %v", ok, err)
+	}
+	if _, _, err := h.Verify("p@ssw0rd", "plain"); !errors.Is(err, cryptos.ErrPasswordHashFormat) {
+		t.Fatalf("expected format error, got %v", err)
+	}
+}
+
+func TestPasswordRehashWhenConfigChanged(t *testing.T) {
+	old := cryptos.NewPasswordHasher(cryptos.WithBcrypt(4))
+	hash, err := old.Hash("p@ssw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 算法改变
+	params := fastParams
+	h := cryptos.NewPasswordHasher(cryptos.WithArgon2id(params))
+	if ok, rehash, err := h.Verify("p@ssw0rd", hash); !ok || !rehash || err != nil {
+		t.Fatalf("bcrypt hash should be verified and rehashed: %v, %v, %v", ok, rehash, err)
+	}
+
+	// 参数改变
+	hash, _ = h.Hash("p@ssw0rd")
+	params.Time = 2
+	h = cryptos.NewPasswordHasher(cryptos.WithArgon2id(params))
+	if ok, rehash, err := h.Verify("p@ssw0rd", hash); !ok || !rehash || err != nil {
+		t.Fatalf("argon2id hash should be verified and rehashed: %v, %v, %v", ok, rehash, err)
+	}
+}
@@ -0,0 +1,263 @@
+package distribute_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"goapp/pkg/distribute"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newMemoryQueue(t *testing.T, dir string, options ...distribute.QueueOption) *distribute.MemoryMessageQueue {
+	t.Helper()
+	q, err := distribute.NewMemoryMessageQueue(nil, dir, 100, 10, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMemoryQueueConsumerGroups(t *testing.T) {
+	q := newMemoryQueue(t, "")
+	defer q.Close()
+	ctx := context.Background()
+
+	// 订阅前发布的消息同样会被投递
+	for i := range 20 {
+		q.Publish(ctx, "orders", map[string]any{"n": i})
+	}
+
+	var mu sync.Mutex
+	byConsumer := map[string]int{}
+	seen := map[string]int{}
+	handler := func(consumer string) distribute.ConsumeMsgHandler {
+		return func(ctx context.Context, id string, msg map[string]any) error {
+			if _, ok := msg["n"].(string); !ok {
+				t.Errorf("value should be string, got %T", msg["n"])
+			}
+			mu.Lock()
+			byConsumer[consumer]++
+			seen[id]++
+			mu.Unlock()
+			return nil
+		}
+	}
+	q.Subscribe(ctx, "orders", "billing", "b1", handler("b1"))
+	q.Subscribe(ctx, "orders", "billing", "b2", handler("b2"))
+	q.Subscribe(ctx, "orders", "audit", "a1", handler("a1"))
+	for i := 20; i < 40; i++ {
+		q.Publish(ctx, "orders", map[string]any{"n": i})
+	}
+
+	waitFor(t, 3*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return byConsumer["b1"]+byConsumer["b2"] == 40 && byConsumer["a1"] == 40
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	// 每个消费者组各收到一次
+	for id, n := range seen {
+		if n != 2 {
+			t.Fatalf("message %s delivered %d times", id, n)
+		}
+	}
+}
+
+func TestMemoryQueueRetryAndDeadLetter(t *testing.T) {
+	q := newMemoryQueue(t, "", distribute.WithMaxDeliveries(3), distribute.WithRetryBackoff(20*time.Millisecond, 50*time.Millisecond))
+	defer q.Close()
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	q.Subscribe(ctx, "jobs", "workers", "w1", func(ctx context.Context, id string, msg map[string]any) error {
+		calls.Add(1)
+		return errors.New("boom")
+	})
+	q.Publish(ctx, "jobs", map[string]any{"job": "resize"})
+
+	var letters []*distribute.DeadLetter
+	waitFor(t, 3*time.Second, func() bool {
+		letters, _ = q.DeadLetters(ctx, "jobs", "", 10)
+		return len(letters) == 1
+	})
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 deliveries, got %d", calls.Load())
+	}
+	dl := letters[0]
+	if dl.Deliveries != 3 || dl.Error != "boom" || dl.Group != "workers" || dl.Body["job"] != "resize" {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+
+	n, err := q.PurgeDeadLetters(ctx, "jobs")
+	if err != nil || n != 1 {
+		t.Fatalf("purge: %d, %v", n, err)
+	}
+}
+
+func TestMemoryQueueDelayed(t *testing.T) {
+	q := newMemoryQueue(t, "")
+	defer q.Close()
+	ctx := context.Background()
+
+	got := make(chan time.Time, 1)
+	q.Subscribe(ctx, "reminders", "g", "c", func(ctx context.Context, id string, msg map[string]any) error {
+		got <- time.Now()
+		return nil
+	})
+	start := time.Now()
+	q.PublishAfter(ctx, "reminders", map[string]any{"to": "u1"}, 200*time.Millisecond)
+	if n, _ := q.DelayedCount(ctx, "reminders"); n != 1 {
+		t.Fatalf("expected 1 delayed message, got %d", n)
+	}
+	select {
+	case at := <-got:
+		if at.Sub(start) < 200*time.Millisecond {
+			t.Fatalf("delivered too early: %v", at.Sub(start))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("delayed message not delivered")
+	}
+}
+
+func TestMemoryQueueDiskMode(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	q := newMemoryQueue(t, dir)
+	acked := make(chan string, 10)
+	subCtx, cancel := context.WithCancel(ctx)
+	q.Subscribe(subCtx, "events", "g", "c1", func(ctx context.Context, id string, msg map[string]any) error {
+		acked <- msg["name"].(string)
+		return nil
+	})
+	q.Publish(ctx, "events", map[string]any{"name": "first"})
+	if name := <-acked; name != "first" {
+		t.Fatalf("got %s", name)
+	}
+	cancel()
+	q.Publish(ctx, "events", map[string]any{"name": "second"})
+	q.PublishAfter(ctx, "events", map[string]any{"name": "third"}, 100*time.Millisecond)
+	q.Close()
+
+	// 重启后只投递没有确认的消息，延迟消息同样被恢复
+	q = newMemoryQueue(t, dir)
+	defer q.Close()
+	var mu sync.Mutex
+	var names []string
+	q.Subscribe(ctx, "events", "g", "c2", func(ctx context.Context, id string, msg map[string]any) error {
+		mu.Lock()
+		names = append(names, msg["name"].(string))
+		mu.Unlock()
+		return nil
+	})
+	waitFor(t, 3*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(names) == 2
+	})
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(names) != 2 || names[0] != "second" || names[1] != "third" {
+		t.Fatalf("unexpected messages after restart: %v", names)
+	}
+}
+
+func TestMemoryQueueDiskModeBinaryBody(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	blob := string([]byte{0xff, 0xfe, 0x00, '\n', '"', 0x80})
+	q := newMemoryQueue(t, dir)
+	q.Publish(ctx, "files", map[string]any{"name": "now", "blob": []byte(blob)})
+	q.PublishAfter(ctx, "files", map[string]any{"name": "later", "blob": []byte(blob)}, 100*time.Millisecond)
+	q.Close()
+
+	q = newMemoryQueue(t, dir)
+	defer q.Close()
+	received := make(chan map[string]any, 2)
+	q.Subscribe(ctx, "files", "g", "c1", func(ctx context.Context, id string, msg map[string]any) error {
+		received <- msg
+		return nil
+	})
+	for _, name := range []string{"now", "later"} {
+		select {
+		case msg := <-received:
+			if msg["name"] != name {
+				t.Fatalf("expected %s, got %v", name, msg["name"])
+			}
+			if msg["blob"] != blob {
+				t.Fatalf("body corrupted: %q", msg["blob"])
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s not delivered", name)
+		}
+	}
+}
+
+func TestMemoryQueueDiskModeCompaction(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	q := newMemoryQueue(t, dir, distribute.WithLogCompaction(50, 0))
+
+	var acked atomic.Int32
+	subCtx, cancel := context.WithCancel(ctx)
+	q.Subscribe(subCtx, "events", "g", "c1", func(ctx context.Context, id string, msg map[string]any) error {
+		acked.Add(1)
+		return nil
+	})
+	// 每条消息产生添加、投递、确认 3 条记录，运行中压缩后日志不会无限增长
+	for i := range 300 {
+		q.Publish(ctx, "events", map[string]any{"n": i})
+		waitFor(t, time.Second, func() bool { return acked.Load() == int32(i+1) })
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "queue.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > 400 {
+		t.Fatalf("queue log not compacted: %d lines", lines)
+	}
+
+	// 压缩后的日志在重启后仍然有效：已确认的消息不会再次投递
+	cancel()
+	q.Publish(ctx, "events", map[string]any{"n": "last"})
+	q.Close()
+	q = newMemoryQueue(t, dir)
+	defer q.Close()
+	received := make(chan any, 10)
+	q.Subscribe(ctx, "events", "g", "c2", func(ctx context.Context, id string, msg map[string]any) error {
+		received <- msg["n"]
+		return nil
+	})
+	select {
+	case n := <-received:
+		if n != "last" {
+			t.Fatalf("unexpected message after restart: %v", n)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not delivered after restart")
+	}
+	select {
+	case n := <-received:
+		t.Fatalf("acked message redelivered: %v", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
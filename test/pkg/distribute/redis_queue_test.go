@@ -0,0 +1,153 @@
+package distribute_test
+
+import (
+	"context"
+	"errors"
+	"goapp/pkg/distribute"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/panjf2000/ants/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisQueue(t *testing.T, options ...distribute.QueueOption) (*miniredis.Miniredis, *distribute.RedisMessageQueue) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	pool, err := ants.NewPool(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Release)
+	q, err := distribute.NewRedisMessageQueue(context.Background(), &redis.Options{Addr: mr.Addr()}, pool, 100, 10, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(q.Close)
+	return mr, q
+}
+
+func TestRedisQueueRetryDeadLetterAndReplay(t *testing.T) {
+	_, q := newRedisQueue(t,
+		distribute.WithMaxDeliveries(3),
+		distribute.WithRetryBackoff(100*time.Millisecond, 200*time.Millisecond),
+	)
+	ctx := context.Background()
+
+	var calls, succeeded atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	err := q.Subscribe(ctx, "orders", "billing", "b1", func(ctx context.Context, id string, msg map[string]any) error {
+		if failing.Load() {
+			calls.Add(1)
+			return errors.New("boom")
+		}
+		if msg["n"] != "1" {
+			t.Errorf("unexpected message: %v", msg)
+		}
+		succeeded.Add(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Publish(ctx, "orders", map[string]any{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 失败的消息按退避重试，投递 3 次后转入死信队列
+	var letters []*distribute.DeadLetter
+	waitFor(t, 15*time.Second, func() bool {
+		letters, err = q.DeadLetters(ctx, "orders", "", 10)
+		return err == nil && len(letters) == 1
+	})
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("expected 3 deliveries, got %d", n)
+	}
+	dl := letters[0]
+	if dl.Topic != "orders" || dl.Group != "billing" || dl.Deliveries != 3 || dl.Error != "boom" || dl.Body["n"] != "1" {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+
+	// 重新发布后被再次消费，并从死信队列中删除
+	failing.Store(false)
+	n, err := q.ReplayDeadLetters(ctx, "orders", dl.Id)
+	if err != nil || n != 1 {
+		t.Fatalf("replay: %d, %v", n, err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return succeeded.Load() == 1 })
+	if letters, _ := q.DeadLetters(ctx, "orders", "", 10); len(letters) != 0 {
+		t.Fatalf("dead letter not removed after replay: %v", letters)
+	}
+}
+
+func TestRedisQueuePurgeDeadLetters(t *testing.T) {
+	mr, q := newRedisQueue(t)
+	ctx := context.Background()
+
+	var ids []string
+	for range 3 {
+		id, err := mr.XAdd(distribute.DeadLetterTopic("orders"), "*", []string{"n", "1", "_dead_topic", "orders"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	n, err := q.PurgeDeadLetters(ctx, "orders", ids[0])
+	if err != nil || n != 1 {
+		t.Fatalf("purge one: %d, %v", n, err)
+	}
+	letters, err := q.DeadLetters(ctx, "orders", "", 10)
+	if err != nil || len(letters) != 2 || letters[0].Id != ids[1] {
+		t.Fatalf("unexpected dead letters: %v, %v", letters, err)
+	}
+
+	n, err = q.PurgeDeadLetters(ctx, "orders")
+	if err != nil || n != 2 {
+		t.Fatalf("purge all: %d, %v", n, err)
+	}
+	if letters, _ := q.DeadLetters(ctx, "orders", "", 10); len(letters) != 0 {
+		t.Fatalf("dead letters left: %v", letters)
+	}
+}
+
+func TestRedisQueueDelayedBinaryBody(t *testing.T) {
+	mr, q := newRedisQueue(t, distribute.WithDelayedMover(nil, 100*time.Millisecond))
+	ctx := context.Background()
+
+	// 非 UTF-8 的字节、分隔符、空字节都需要原样投递
+	blob := string([]byte{0xff, 0xfe, 0x00, ':', '1', '0', ':', 0x80, '"', '\\'})
+	if err := q.PublishAfter(ctx, "files", map[string]any{"blob": []byte(blob), "12:ab": ""}, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := q.DelayedCount(ctx, "files"); n != 1 {
+		t.Fatalf("unexpected delayed count: %d", n)
+	}
+	if ok, _ := mr.SIsMember("mq:delayed-topics", "files"); !ok {
+		t.Fatal("topic not tracked")
+	}
+
+	received := make(chan map[string]any, 1)
+	err := q.Subscribe(ctx, "files", "g", "c1", func(ctx context.Context, id string, msg map[string]any) error {
+		received <- msg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg["blob"] != blob || msg["12:ab"] != "" || len(msg) != 2 {
+			t.Fatalf("body corrupted: %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed message not delivered")
+	}
+	// 延迟消息全部投递后，主题从集合中移除
+	if ok, _ := mr.SIsMember("mq:delayed-topics", "files"); ok {
+		t.Fatal("topic not removed after delayed messages drained")
+	}
+}
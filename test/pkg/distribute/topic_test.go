@@ -0,0 +1,99 @@
+package distribute_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goapp/pkg/distribute"
+	"testing"
+)
+
+// 与 Redis 一样，消息字段的值以字符串的形式返回
+type loopbackQueue struct {
+	distribute.MessageQueue
+	handlers map[string]distribute.ConsumeMsgHandler
+}
+
+func (q *loopbackQueue) Publish(ctx context.Context, topic string, body map[string]any) error {
+	msg := make(map[string]any, len(body))
+	for k, v := range body {
+		if b, ok := v.([]byte); ok {
+			msg[k] = string(b)
+		} else {
+			msg[k] = fmt.Sprint(v)
+		}
+	}
+	return q.handlers[topic](ctx, "1-0", msg)
+}
+
+func (q *loopbackQueue) Subscribe(ctx context.Context, topic, group, consumer string, handler distribute.ConsumeMsgHandler) error {
+	q.handlers[topic] = handler
+	return nil
+}
+
+type orderV1 struct {
+	Id    string `json:"id" msgpack:"id"`
+	Price int    `json:"price" msgpack:"price"`
+}
+
+type orderV2 struct {
+	Id    string  `json:"id" msgpack:"id"`
+	Price float64 `json:"price" msgpack:"price"` // 单位由分改为元
+}
+
+func TestTopicRoundTrip(t *testing.T) {
+	for _, codec := range []distribute.Codec{distribute.JsonCodec, distribute.MsgPackCodec} {
+		q := &loopbackQueue{handlers: map[string]distribute.ConsumeMsgHandler{}}
+		topic := distribute.NewTopic[orderV1](q, "orders", codec, 1)
+
+		var got orderV1
+		var id string
+		topic.Subscribe(context.Background(), "g", "c", func(ctx context.Context, msg orderV1) error {
+			got, id = msg, distribute.MessageIdFromContext(ctx)
+			return nil
+		})
+		if err := topic.Publish(context.Background(), orderV1{Id: "a", Price: 100}); err != nil {
+			t.Fatalf("%s: publish: %v", codec.Name(), err)
+		}
+		if got != (orderV1{Id: "a", Price: 100}) || id != "1-0" {
+			t.Fatalf("%s: got %+v, id %q", codec.Name(), got, id)
+		}
+	}
+}
+
+func TestTopicUpgrade(t *testing.T) {
+	q := &loopbackQueue{handlers: map[string]distribute.ConsumeMsgHandler{}}
+	v1 := distribute.NewTopic[orderV1](q, "orders", distribute.JsonCodec, 1)
+	v2 := distribute.NewTopic[orderV2](q, "orders", distribute.MsgPackCodec, 2).
+		Upgrade(1, func(payload []byte, codec distribute.Codec) (orderV2, error) {
+			var old orderV1
+			err := codec.Unmarshal(payload, &old)
+			return orderV2{Id: old.Id, Price: float64(old.Price) / 100}, err
+		})
+
+	var got orderV2
+	v2.Subscribe(context.Background(), "g", "c", func(ctx context.Context, msg orderV2) error {
+		got = msg
+		return nil
+	})
+	// 旧版本的生产者，编码方式也不同
+	if err := v1.Publish(context.Background(), orderV1{Id: "a", Price: 150}); err != nil {
+		t.Fatal(err)
+	}
+	if got != (orderV2{Id: "a", Price: 1.5}) {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestTopicRejectsUntypedMessage(t *testing.T) {
+	topic := distribute.NewTopic[orderV1](nil, "orders", nil, 1)
+	_, err := topic.Decode(map[string]any{"id": "a"})
+	if err != distribute.ErrNotTypedMessage {
+		t.Fatalf("expected ErrNotTypedMessage, got %v", err)
+	}
+	b, _ := json.Marshal(orderV1{Id: "a"})
+	_, err = topic.Decode(map[string]any{distribute.HeaderPayload: string(b), distribute.HeaderCodec: "xml", distribute.HeaderSchema: "1"})
+	if err == nil {
+		t.Fatal("expected unknown codec error")
+	}
+}
@@ -0,0 +1,108 @@
+package hub_test
+
+import (
+	"context"
+	"goapp/pkg/cache"
+	"goapp/pkg/hub"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestCache(t *testing.T) (*miniredis.Miniredis, *cache.Cache) {
+	mr := miniredis.RunT(t)
+	c, err := cache.NewCacheWithAddr(context.Background(), mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return mr, c
+}
+
+func TestRedisBackplaneRoutesFollowHeartbeat(t *testing.T) {
+	_, c := newTestCache(t)
+	bp := hub.NewRedisBackplane(c, "hub:test", 300*time.Millisecond)
+	ctx := context.Background()
+
+	for _, node := range []string{"node1", "node2"} {
+		if err := bp.Heartbeat(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+		if err := bp.Join(ctx, node, "u1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodes, err := bp.Nodes(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(nodes)
+	if !slices.Equal(nodes, []string{"node1", "node2"}) {
+		t.Fatalf("unexpected nodes: %v", nodes)
+	}
+
+	// node2 宕机不再续期：存活记录过期后路由立即失效，node1 的路由一直有效
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if err := bp.Heartbeat(ctx, "node1"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	nodes, err = bp.Nodes(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(nodes, []string{"node1"}) {
+		t.Fatalf("dead node should be filtered: %v", nodes)
+	}
+	members, err := c.SMembers(ctx, "hub:test:route:u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(members, []string{"node1"}) {
+		t.Fatalf("dead node route should be reaped: %v", members)
+	}
+
+	if err := bp.Leave(ctx, "node1", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, _ := bp.Nodes(ctx, "u1"); len(nodes) != 0 {
+		t.Fatalf("unexpected nodes after leave: %v", nodes)
+	}
+}
+
+func TestHubKeepsRouteAfterReconnect(t *testing.T) {
+	_, c := newTestCache(t)
+	bp := hub.NewRedisBackplane(c, "hub:test", time.Second)
+	h := newTestHub(t, hub.WithBackplane("node1", bp))
+
+	// 断开后立即重连，Leave 不能覆盖之后的 Join
+	for i := 0; i < 5; i++ {
+		conn := dialTestHub(t, h, "u1", "l1")
+		conn.Close()
+		select {
+		case <-h.UnegisteredChan():
+		case <-time.After(3 * time.Second):
+			t.Fatal("line not unregistered")
+		}
+	}
+	dialTestHub(t, h, "u1", "l1")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		nodes, err := bp.Nodes(context.Background(), "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Equal(nodes, []string{"node1"}) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("route lost after reconnect: %v", nodes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
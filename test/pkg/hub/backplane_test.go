@@ -0,0 +1,114 @@
+package hub_test
+
+import (
+	"goapp/pkg/core"
+	"goapp/pkg/hub"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/panjf2000/ants/v2"
+)
+
+func newTestHub(t *testing.T, options ...hub.HubOption) *hub.Hub {
+	pool, err := ants.NewPool(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Release)
+
+	h, err := hub.NewHub(nil, time.Minute, time.Minute, 10*time.Second, 10*time.Second, pool, 5*time.Second, false,
+		func(r *http.Request) bool { return true }, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 在释放协程池、关闭背板使用的缓存之前关闭
+	t.Cleanup(func() { h.Close(0) })
+	return h
+}
+
+// 在 h 上建立一条 userId/lineId 的连接，返回客户端
+func dialTestHub(t *testing.T, h *hub.Hub, userId, lineId string) *websocket.Conn {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.UpgradeWebSocket(userId, core.Web, lineId, nil, w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	select {
+	case ln := <-h.RegisteredChan():
+		if ln.Id() != lineId {
+			t.Fatalf("unexpected line registered: %s", ln.Id())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("line not registered")
+	}
+	return conn
+}
+
+func readTestMessage(t *testing.T, conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBackplanePushAcrossNodes(t *testing.T) {
+	bp := hub.NewMemoryBackplane()
+	h1 := newTestHub(t, hub.WithBackplane("node1", bp))
+	h2 := newTestHub(t, hub.WithBackplane("node2", bp))
+
+	conn := dialTestHub(t, h2, "u1", "l1")
+
+	nodes, _ := bp.Nodes(t.Context(), "u1")
+	if len(nodes) != 1 || nodes[0] != "node2" {
+		t.Fatalf("unexpected route: %v", nodes)
+	}
+
+	h1.PushMessage([]string{"u1"}, []byte("push"))
+	if msg := readTestMessage(t, conn); msg != "push" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+
+	if err := h1.PushToUserLines("u1", []byte("line"), "l1"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readTestMessage(t, conn); msg != "line" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+
+	h1.BroadcastMessage([]byte("broadcast"))
+	if msg := readTestMessage(t, conn); msg != "broadcast" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+}
+
+func TestBackplaneLeaveOnClose(t *testing.T) {
+	bp := hub.NewMemoryBackplane()
+	h1 := newTestHub(t, hub.WithBackplane("node1", bp))
+	h2 := newTestHub(t, hub.WithBackplane("node2", bp))
+
+	dialTestHub(t, h2, "u1", "l1")
+	h1.CloseUserLines("u1")
+
+	select {
+	case <-h2.UnegisteredChan():
+	case <-time.After(3 * time.Second):
+		t.Fatal("line not unregistered")
+	}
+
+	nodes, _ := bp.Nodes(t.Context(), "u1")
+	if len(nodes) != 0 {
+		t.Fatalf("route not removed: %v", nodes)
+	}
+}
@@ -0,0 +1,92 @@
+package hub_test
+
+import (
+	"errors"
+	"goapp/pkg/hub"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 客户端不读取，大消息很快会填满 TCP 缓冲区，使发送队列堆积
+func floodTestLine(h *hub.Hub, userId string, n int) {
+	data := []byte(strings.Repeat("x", 256*1024))
+	for range n {
+		if uls := h.GetUserLines(userId); uls != nil {
+			uls.PushMessage(data)
+		}
+	}
+}
+
+func TestBackpressureDropNewest(t *testing.T) {
+	h := newTestHub(t, hub.WithBackpressure(hub.BackpressureDropNewest, 1))
+	dialTestHub(t, h, "u1", "l1")
+
+	floodTestLine(h, "u1", 200)
+	if stats := h.Stats(); stats.Dropped == 0 || stats.MaxQueueDepth > 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	select {
+	case e := <-h.ErrorChan():
+		var bpErr *hub.BackpressureError
+		if !errors.As(e.Error, &bpErr) || bpErr.Policy != hub.BackpressureDropNewest {
+			t.Fatalf("unexpected line error: %v", e.Error)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("backpressure error not reported")
+	}
+}
+
+func TestBackpressureDisconnect(t *testing.T) {
+	h := newTestHub(t, hub.WithBackpressure(hub.BackpressureDisconnect, 1))
+	dialTestHub(t, h, "u1", "l1")
+
+	floodTestLine(h, "u1", 200)
+	select {
+	case ln := <-h.UnegisteredChan():
+		if ln.Id() != "l1" {
+			t.Fatalf("unexpected line unregistered: %s", ln.Id())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("slow line not disconnected")
+	}
+	if stats := h.Stats(); stats.Disconnected != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestBackpressureCoalesce(t *testing.T) {
+	h := newTestHub(t,
+		hub.WithBackpressure(hub.BackpressureCoalesce, 4),
+		hub.WithCoalesceKey(func(data []byte) string {
+			key, _, _ := strings.Cut(string(data), ":")
+			return key
+		}),
+	)
+	dialTestHub(t, h, "u1", "l1")
+
+	payload := strings.Repeat("x", 256*1024)
+	for range 200 {
+		h.GetUserLines("u1").PushMessage([]byte("cursor:" + payload))
+	}
+	if stats := h.Stats(); stats.Coalesced == 0 || stats.Dropped != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestQueueDepthCountsInflight(t *testing.T) {
+	h := newTestHub(t, hub.WithBackpressure(hub.BackpressureDropNewest, 1))
+	dialTestHub(t, h, "u1", "l1")
+
+	// 客户端不读取，写循环取出消息后阻塞在写出上，该消息仍需计入队列深度并占用容量
+	h.GetUserLines("u1").PushMessage([]byte(strings.Repeat("x", 64*1024*1024)))
+	time.Sleep(200 * time.Millisecond)
+	if stats := h.Stats(); stats.QueuedMessages != 1 {
+		t.Fatalf("inflight message not counted: %+v", stats)
+	}
+	h.GetUserLines("u1").PushMessage([]byte("x"))
+	if stats := h.Stats(); stats.Dropped != 1 {
+		t.Fatalf("inflight message should occupy the queue: %+v", stats)
+	}
+}
@@ -0,0 +1,64 @@
+package hub_test
+
+import (
+	"context"
+	"errors"
+	"goapp/pkg/core"
+	"goapp/pkg/hub"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHubDrain(t *testing.T) {
+	h := newTestHub(t)
+	conn := dialTestHub(t, h, "u1", "l1")
+
+	h.PushMessage([]string{"u1"}, []byte("before drain"))
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := h.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if h.LiveCount() != 0 {
+		t.Fatalf("lines not drained: %d", h.LiveCount())
+	}
+
+	// 排空前推送的消息先于关闭帧到达
+	if msg := readTestMessage(t, conn); msg != "before drain" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Fatalf("expected service restart close, got: %v", err)
+	}
+
+	err = h.UpgradeWebSocket("u2", core.Web, "l2", nil, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !errors.Is(err, hub.ErrHubDraining) {
+		t.Fatalf("expected ErrHubDraining, got: %v", err)
+	}
+}
+
+func TestDrainingLineMessagesGoToMailbox(t *testing.T) {
+	mb := hub.NewMemoryMailbox(10, time.Minute)
+	h := newTestHub(t, hub.WithMailbox(mb, encodeTestMail))
+	dialTestHub(t, h, "u1", "l1")
+
+	// 客户端不读取，写循环阻塞在大消息上，连接在排空期间保持打开
+	h.GetUserLines("u1").PushMessage(make([]byte, 64*1024*1024))
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	go h.Drain(ctx)
+	for !h.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	h.PushMessage([]string{"u1"}, []byte("after drain"))
+	waitMailbox(t, mb, "u1", 1)
+}
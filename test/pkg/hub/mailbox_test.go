@@ -0,0 +1,116 @@
+package hub_test
+
+import (
+	"context"
+	"errors"
+	"goapp/pkg/hub"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 补发时带上消息 id：id|data
+func encodeTestMail(msg *hub.MailboxMessage) ([]byte, error) {
+	return []byte(msg.Id + "|" + string(msg.Data)), nil
+}
+
+func waitMailbox(t *testing.T, mb hub.Mailbox, userId string, n int) []*hub.MailboxMessage {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		msgs, err := mb.Fetch(context.Background(), userId, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) == n || time.Now().After(deadline) {
+			if len(msgs) != n {
+				t.Fatalf("expected %d mails, got %d", n, len(msgs))
+			}
+			return msgs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMailboxFlushAndAck(t *testing.T) {
+	mb := hub.NewMemoryMailbox(10, time.Minute)
+	h := newTestHub(t, hub.WithMailbox(mb, encodeTestMail))
+
+	h.PushMessage([]string{"u1"}, []byte("a"))
+	h.PushMessage([]string{"u1"}, []byte("b"))
+	mails := waitMailbox(t, mb, "u1", 2)
+
+	conn := dialTestHub(t, h, "u1", "l1")
+	for _, want := range []string{"a", "b"} {
+		msg := readTestMessage(t, conn)
+		if _, data, _ := strings.Cut(msg, "|"); data != want {
+			t.Fatalf("unexpected mail: %s, want %s", msg, want)
+		}
+	}
+
+	// 确认第一条后，只剩第二条
+	if err := h.AckMailbox(context.Background(), "u1", mails[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	left := waitMailbox(t, mb, "u1", 1)
+	if string(left[0].Data) != "b" {
+		t.Fatalf("unexpected left mail: %s", left[0].Data)
+	}
+
+	// 在线时不会存入信箱
+	h.PushMessage([]string{"u1"}, []byte("c"))
+	if msg := readTestMessage(t, conn); msg != "c" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+	waitMailbox(t, mb, "u1", 1)
+}
+
+func TestMailboxSkipsRemoteUsers(t *testing.T) {
+	bp := hub.NewMemoryBackplane()
+	mb := hub.NewMemoryMailbox(10, time.Minute)
+	h1 := newTestHub(t, hub.WithBackplane("node1", bp), hub.WithMailbox(mb, encodeTestMail))
+	h2 := newTestHub(t, hub.WithBackplane("node2", bp))
+
+	conn := dialTestHub(t, h2, "u1", "l1")
+	h1.PushMessage([]string{"u1", "u2"}, []byte("hi"))
+	if msg := readTestMessage(t, conn); msg != "hi" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+	waitMailbox(t, mb, "u2", 1)
+	waitMailbox(t, mb, "u1", 0)
+}
+
+// 查询路由总是失败的背板
+type failingNodesBackplane struct {
+	*hub.MemoryBackplane
+}
+
+func (b failingNodesBackplane) Nodes(ctx context.Context, userId string) ([]string, error) {
+	return nil, errors.New("route lookup failed")
+}
+
+func TestMailboxFallbackWhenRemoteUnreachable(t *testing.T) {
+	// 路由指向已宕机、不再接收消息的节点
+	bp := hub.NewMemoryBackplane()
+	mb := hub.NewMemoryMailbox(10, time.Minute)
+	h := newTestHub(t, hub.WithBackplane("node1", bp), hub.WithMailbox(mb, encodeTestMail))
+	bp.Join(context.Background(), "dead", "u1")
+	h.PushMessage([]string{"u1"}, []byte("stale"))
+	waitMailbox(t, mb, "u1", 1)
+
+	// 查询路由失败
+	mb2 := hub.NewMemoryMailbox(10, time.Minute)
+	h2 := newTestHub(t, hub.WithBackplane("node1", failingNodesBackplane{hub.NewMemoryBackplane()}), hub.WithMailbox(mb2, encodeTestMail))
+	h2.PushMessage([]string{"u1"}, []byte("lookup"))
+	waitMailbox(t, mb2, "u1", 1)
+}
+
+func TestMemoryMailboxCap(t *testing.T) {
+	mb := hub.NewMemoryMailbox(2, time.Minute)
+	for _, v := range []string{"a", "b", "c"} {
+		mb.Put(context.Background(), "u1", []byte(v))
+	}
+	msgs := waitMailbox(t, mb, "u1", 2)
+	if string(msgs[0].Data) != "b" || string(msgs[1].Data) != "c" {
+		t.Fatalf("unexpected mails: %s %s", msgs[0].Data, msgs[1].Data)
+	}
+}
@@ -0,0 +1,138 @@
+package hub_test
+
+import (
+	"context"
+	"errors"
+	"goapp/pkg/bytes"
+	"goapp/pkg/hub"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLineRequest(t *testing.T) {
+	h := newTestHub(t, hub.WithProtocol(bytes.NewJsonProtocol(nil, nil)))
+	conn := dialTestHub(t, h, "u1", "l1")
+
+	// 客户端收到请求后，使用相同的 RequestId 应答
+	go func() {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		requestId := int32(data[1])<<24 | int32(data[2])<<16 | int32(data[3])<<8 | int32(data[4])
+		conn.WriteMessage(websocket.BinaryMessage, encodeTestReq(data[0], requestId, map[string]string{"ack": "ok"}))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	packet, err := h.Request(ctx, "u1", "l1", 20, map[string]string{"reason": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.MsgType != 20 || packet.RequestId >= 0 {
+		t.Fatalf("unexpected reply meta: %+v", packet.PacketMetaData)
+	}
+	if payload, ok := packet.Payload.(map[string]any); !ok || payload["ack"] != "ok" {
+		t.Fatalf("unexpected reply payload: %+v", packet.Payload)
+	}
+
+	// 客户端的应答不会进入 MessageChan
+	select {
+	case msg := <-h.MessageChan():
+		t.Fatalf("reply leaked to message chan: %v", msg.Data)
+	default:
+	}
+}
+
+func TestLineRequestTimeoutAndClose(t *testing.T) {
+	h := newTestHub(t, hub.WithProtocol(bytes.NewJsonProtocol(nil, nil)))
+	conn := dialTestHub(t, h, "u1", "l1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := h.Request(ctx, "u1", "l1", 20, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := h.Request(context.Background(), "u1", "l1", 20, nil)
+		errChan <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, hub.ErrLineClosed) {
+			t.Fatalf("expected line closed, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("request not failed after line closed")
+	}
+}
+
+// 客户端收到请求后，使用相同的 RequestId 应答 ack
+func replyTestRequests(conn *websocket.Conn, ack string) {
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			requestId := int32(data[1])<<24 | int32(data[2])<<16 | int32(data[3])<<8 | int32(data[4])
+			conn.WriteMessage(websocket.BinaryMessage, encodeTestReq(data[0], requestId, map[string]string{"ack": ack}))
+		}
+	}()
+}
+
+func TestRequestAcrossNodes(t *testing.T) {
+	bp := hub.NewMemoryBackplane()
+	protocol := bytes.NewJsonProtocol(nil, nil)
+	h1 := newTestHub(t, hub.WithBackplane("node1", bp), hub.WithProtocol(protocol))
+	h2 := newTestHub(t, hub.WithBackplane("node2", bp), hub.WithProtocol(protocol))
+
+	replyTestRequests(dialTestHub(t, h1, "u1", "l1"), "local")
+	replyTestRequests(dialTestHub(t, h2, "u1", "l2"), "remote")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	replies := h1.RequestUserLines(ctx, "u1", 20, map[string]string{"reason": "test"})
+	acks := map[string]any{}
+	for _, r := range replies {
+		if r.Err != nil {
+			t.Fatalf("line %s failed: %v", r.LineId, r.Err)
+		}
+		acks[r.LineId] = r.Packet.Payload.(map[string]any)["ack"]
+	}
+	if len(acks) != 2 || acks["l1"] != "local" || acks["l2"] != "remote" {
+		t.Fatalf("unexpected replies: %v", acks)
+	}
+
+	// 单个连接在其它节点上
+	packet, err := h1.Request(ctx, "u1", "l2", 20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.MsgType != 20 || packet.Payload.(map[string]any)["ack"] != "remote" {
+		t.Fatalf("unexpected reply: %+v", packet)
+	}
+	if _, err := h1.Request(ctx, "u1", "l3", 20, nil); !errors.Is(err, hub.ErrLineNotFound) {
+		t.Fatalf("expected line not found, got %v", err)
+	}
+}
+
+func TestRequestAcrossNodesTimeout(t *testing.T) {
+	bp := hub.NewMemoryBackplane()
+	protocol := bytes.NewJsonProtocol(nil, nil)
+	h1 := newTestHub(t, hub.WithBackplane("node1", bp), hub.WithProtocol(protocol))
+	h2 := newTestHub(t, hub.WithBackplane("node2", bp), hub.WithProtocol(protocol))
+	dialTestHub(t, h2, "u1", "l1") // 不应答
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := h1.Request(ctx, "u1", "l1", 20, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
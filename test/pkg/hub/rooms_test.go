@@ -0,0 +1,95 @@
+package hub_test
+
+import (
+	"goapp/pkg/hub"
+	"testing"
+	"time"
+)
+
+func TestRoomPush(t *testing.T) {
+	h := newTestHub(t)
+	c1 := dialTestHub(t, h, "u1", "l1")
+	c2 := dialTestHub(t, h, "u2", "l2")
+	dialTestHub(t, h, "u3", "l3")
+
+	h.JoinRoom("doc", "u1")
+	h.JoinRoom("doc", "u2")
+	if n := h.RoomCount("doc"); n != 2 {
+		t.Fatalf("unexpected room count: %d", n)
+	}
+
+	h.PushToRoom("doc", []byte("hello"), "l1")
+	if msg := readTestMessage(t, c2); msg != "hello" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+
+	h.LeaveRoom("doc", "u2")
+	h.PushToRoom("doc", []byte("again"))
+	if msg := readTestMessage(t, c1); msg != "again" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+
+	members := h.RoomMembers("doc")
+	if len(members) != 1 || members[0].LineId != "l1" {
+		t.Fatalf("unexpected members: %+v", members)
+	}
+
+	// 连接断开后自动离开房间
+	c1.Close()
+	select {
+	case <-h.UnegisteredChan():
+	case <-time.After(3 * time.Second):
+		t.Fatal("line not unregistered")
+	}
+	if rooms := h.Rooms(); len(rooms) != 0 {
+		t.Fatalf("room not cleaned up: %v", rooms)
+	}
+}
+
+func TestRoomAcrossNodes(t *testing.T) {
+	bp := hub.NewMemoryBackplane()
+	h1 := newTestHub(t, hub.WithBackplane("node1", bp))
+	h2 := newTestHub(t, hub.WithBackplane("node2", bp))
+
+	conn := dialTestHub(t, h2, "u1", "l1")
+
+	h1.JoinRoom("group", "u1")
+	deadline := time.Now().Add(3 * time.Second)
+	for h2.RoomCount("group") == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	h1.PushToRoom("group", []byte("hi"))
+	if msg := readTestMessage(t, conn); msg != "hi" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+}
+
+func TestRoomSlowLineDoesNotBlockOtherRooms(t *testing.T) {
+	h := newTestHub(t, hub.WithBackpressure(hub.BackpressureBlock, 1))
+	dialTestHub(t, h, "slow", "l1") // 从不读取
+	conn := dialTestHub(t, h, "u2", "l2")
+
+	h.JoinRoom("a", "slow")
+	// 写满 TCP 缓冲区后，向房间 a 推送的协程阻塞在入队上
+	big := make([]byte, 1<<20)
+	for range 32 {
+		h.PushToRoom("a", big)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		h.JoinRoom("b", "u2")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("join blocked by a slow line in another room")
+	}
+	h.PushToRoom("b", []byte("hello"))
+	if msg := readTestMessage(t, conn); msg != "hello" {
+		t.Fatalf("unexpected msg: %s", msg)
+	}
+}
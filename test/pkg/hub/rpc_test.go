@@ -0,0 +1,129 @@
+package hub_test
+
+import (
+	"encoding/json"
+	"goapp/pkg/bytes"
+	"goapp/pkg/hub"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/panjf2000/ants/v2"
+)
+
+type echoReq struct {
+	Text string `json:"text"`
+}
+
+// 按照 PacketProtocol 的格式构造请求：1 字节类型 + 4 字节 RequestId + 4 字节时间戳 + 负载
+func encodeTestReq(msgType byte, requestId int32, payload any) []byte {
+	out := []byte{msgType, byte(requestId >> 24), byte(requestId >> 16), byte(requestId >> 8), byte(requestId), 0, 0, 0, 0}
+	if payload != nil {
+		body, _ := json.Marshal(payload)
+		out = append(out, body...)
+	}
+	return out
+}
+
+func readTestResp(t *testing.T, conn *websocket.Conn) (msgType byte, requestId int32, code byte, body []byte) {
+	data := []byte(readTestMessage(t, conn))
+	if len(data) < 10 {
+		t.Fatalf("bad resp: %v", data)
+	}
+	requestId = int32(data[1])<<24 | int32(data[2])<<16 | int32(data[3])<<8 | int32(data[4])
+	return data[0], requestId, data[9], data[10:]
+}
+
+func TestRpcRouter(t *testing.T) {
+	h := newTestHub(t)
+	router := hub.NewRpcRouter(h, bytes.NewJsonProtocol(nil, nil), 200*time.Millisecond)
+	var order []string
+	router.Use(func(next hub.RpcHandler) hub.RpcHandler {
+		return func(c *hub.RpcContext) (any, error) {
+			order = append(order, "global")
+			return next(c)
+		}
+	})
+	router.Handle(10, hub.TypedRpcHandler(func(c *hub.RpcContext, req *echoReq) (any, error) {
+		order = append(order, "handler")
+		c.RespType = 11
+		return req, nil
+	}), func(next hub.RpcHandler) hub.RpcHandler {
+		return func(c *hub.RpcContext) (any, error) {
+			order = append(order, "local")
+			return next(c)
+		}
+	})
+	router.Handle(20, func(c *hub.RpcContext) (any, error) {
+		<-c.Done()
+		return nil, nil
+	})
+	go func() {
+		for msg := range h.MessageChan() {
+			router.Dispatch(msg)
+		}
+	}()
+
+	conn := dialTestHub(t, h, "u1", "l1")
+
+	conn.WriteMessage(websocket.BinaryMessage, encodeTestReq(10, 7, &echoReq{Text: "hi"}))
+	msgType, requestId, code, body := readTestResp(t, conn)
+	if msgType != 11 || requestId != 7 || code != hub.RpcCodeOk || string(body) != `{"text":"hi"}` {
+		t.Fatalf("unexpected resp: %d %d %d %s", msgType, requestId, code, body)
+	}
+	if len(order) != 3 || order[0] != "global" || order[1] != "local" || order[2] != "handler" {
+		t.Fatalf("unexpected middleware order: %v", order)
+	}
+
+	conn.WriteMessage(websocket.BinaryMessage, encodeTestReq(99, 8, nil))
+	if _, requestId, code, _ := readTestResp(t, conn); requestId != 8 || code != hub.RpcCodeUnknownType {
+		t.Fatalf("unexpected unknown type resp: %d %d", requestId, code)
+	}
+
+	conn.WriteMessage(websocket.BinaryMessage, encodeTestReq(20, 9, nil))
+	if _, requestId, code, _ := readTestResp(t, conn); requestId != 9 || code != hub.RpcCodeTimeout {
+		t.Fatalf("unexpected timeout resp: %d %d", requestId, code)
+	}
+}
+
+func TestRpcHandlersBoundedByPool(t *testing.T) {
+	pool, err := ants.NewPool(16, ants.WithNonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Release)
+	h, err := hub.NewHub(nil, time.Minute, time.Minute, 10*time.Second, 10*time.Second, pool, 5*time.Second, false,
+		func(r *http.Request) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 处理器忽略超时一直不返回
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	var running atomic.Int32
+	router := hub.NewRpcRouter(h, bytes.NewJsonProtocol(nil, nil), 50*time.Millisecond)
+	router.Handle(20, func(c *hub.RpcContext) (any, error) {
+		running.Add(1)
+		<-release
+		return nil, nil
+	})
+	go func() {
+		for msg := range h.MessageChan() {
+			router.Dispatch(msg)
+		}
+	}()
+
+	conn := dialTestHub(t, h, "u1", "l1")
+	idle := pool.Free()
+	for i := range 32 {
+		conn.WriteMessage(websocket.BinaryMessage, encodeTestReq(20, int32(i), nil))
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := int(running.Load()); n == 0 || n > idle {
+		t.Fatalf("handlers should be bounded by the pool: running %d, idle workers %d", n, idle)
+	}
+}
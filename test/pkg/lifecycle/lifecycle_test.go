@@ -0,0 +1,62 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"goapp/pkg/lifecycle"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	m := lifecycle.NewManager()
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+	m.Register("release", lifecycle.StageRelease, 0, record("release"))
+	m.Register("ingress", lifecycle.StageIngress, 0, record("ingress"))
+	m.Register("drain", lifecycle.StageDrain, 0, record("drain"))
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(order, []string{"ingress", "drain", "release"}) {
+		t.Fatalf("unexpected order: %v", order)
+	}
+	select {
+	case <-m.Done():
+	default:
+		t.Fatal("done not closed")
+	}
+}
+
+func TestShutdownHookTimeout(t *testing.T) {
+	m := lifecycle.NewManager()
+	ran := false
+	m.Register("stuck", lifecycle.StageDrain, 50*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	m.Register("after", lifecycle.StageRelease, 0, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	start := time.Now()
+	err := m.Shutdown(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+	if !ran || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("stuck hook blocked shutdown, ran: %v, cost: %v", ran, time.Since(start))
+	}
+}
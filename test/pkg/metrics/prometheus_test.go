@@ -0,0 +1,43 @@
+package metrics_test
+
+import (
+	"goapp/pkg/metrics"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusProvider(t *testing.T) {
+	p := metrics.NewPrometheusProvider("test")
+	// 同名指标重复创建时返回同一个指标，不会重复注册
+	p.Counter("pushed_total", "pushed", "hub").Add(1, "chat")
+	p.Counter("pushed_total", "pushed", "hub").Add(2, "chat")
+	p.Gauge("lines", "lines", "hub").Set(3, "chat")
+	p.Histogram("write_seconds", "write", nil, "hub").Observe(0.01, "chat")
+
+	w := httptest.NewRecorder()
+	p.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	for _, want := range []string{
+		`test_pushed_total{hub="chat"} 3`,
+		`test_lines{hub="chat"} 3`,
+		`test_write_seconds_count{hub="chat"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("missing %q in output", want)
+		}
+	}
+}
+
+func TestDefaultProvider(t *testing.T) {
+	if _, ok := metrics.Default().(*metrics.PrometheusProvider); ok {
+		t.Fatal("default provider should be nop")
+	}
+	p := metrics.NewPrometheusProvider("")
+	metrics.SetDefault(p)
+	defer metrics.SetDefault(nil)
+	if metrics.Or(nil) != p {
+		t.Fatal("Or(nil) should return the default provider")
+	}
+}
@@ -0,0 +1,65 @@
+package migrate_test
+
+import (
+	"goapp/migrations"
+	"goapp/pkg/migrate"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadOrdersAndPairsScripts(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0010_b.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"0002_a.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+		"0002_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"readme.txt":      {Data: []byte("ignored")},
+	}
+	all, err := migrate.NewMigrator(nil, fsys).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(all))
+	}
+	if all[0].Version != 2 || all[1].Version != 10 {
+		t.Fatalf("unexpected order: %v, %v", all[0], all[1])
+	}
+	if all[0].Down != "DROP TABLE a;" || all[1].Down != "" {
+		t.Fatalf("down scripts not paired: %q, %q", all[0].Down, all[1].Down)
+	}
+	if all[0].String() != "0002_a" || len(all[0].Checksum) != 64 {
+		t.Fatalf("unexpected name or checksum: %s, %s", all[0], all[0].Checksum)
+	}
+}
+
+func TestLoadRejectsInvalidScripts(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"down only": {
+			"0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		},
+		"name mismatch": {
+			"0001_a.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+			"0001_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		},
+	}
+	for name, fsys := range cases {
+		if _, err := migrate.NewMigrator(nil, fsys).Load(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrationsHaveDownScripts(t *testing.T) {
+	all, err := migrate.NewMigrator(nil, migrations.FS).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for _, mg := range all {
+		if len(mg.Down) == 0 {
+			t.Errorf("%s has no down script", mg)
+		}
+	}
+}
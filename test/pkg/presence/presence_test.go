@@ -0,0 +1,159 @@
+package presence_test
+
+import (
+	"context"
+	"goapp/pkg/cache"
+	"goapp/pkg/core"
+	"goapp/pkg/presence"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/panjf2000/ants/v2"
+)
+
+func newTestCache(t *testing.T) *cache.Cache {
+	mr := miniredis.RunT(t)
+	c, err := cache.NewCacheWithAddr(context.Background(), mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func newTestRegistry(t *testing.T, node string, ttl time.Duration) *presence.RedisRegistry {
+	return presence.NewRedisRegistry(newTestCache(t), "presence", node, ttl)
+}
+
+func testLine(userId, lineId string) *presence.LineInfo {
+	return &presence.LineInfo{UserId: userId, Kind: presence.LineKindWebSocket, LineId: lineId, Platform: core.Web, ConnectedAt: time.Now().Unix()}
+}
+
+func assertOnline(t *testing.T, r presence.Registry, userId string, want bool, lines int) {
+	t.Helper()
+	ctx := context.Background()
+	online, err := r.IsOnline(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if online != want {
+		t.Fatalf("online: got %v, want %v", online, want)
+	}
+	infos, err := r.ListLines(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != lines {
+		t.Fatalf("lines: got %d, want %d", len(infos), lines)
+	}
+}
+
+func TestRegistryOnlineOffline(t *testing.T) {
+	r := newTestRegistry(t, "node1", time.Minute)
+	ctx := context.Background()
+
+	l1, l2 := testLine("u1", "l1"), testLine("u1", "l2")
+	for _, l := range []*presence.LineInfo{l1, l2} {
+		if err := r.Register(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertOnline(t, r, "u1", true, 2)
+	if infos, _ := r.ListLines(ctx, "u1"); infos[0].Node != "node1" {
+		t.Fatalf("unexpected node: %s", infos[0].Node)
+	}
+	if n, err := r.CountOnline(ctx); err != nil || n != 1 {
+		t.Fatalf("count online: %d, %v", n, err)
+	}
+
+	// 断开一条连接后仍在线，全部断开后离线
+	if err := r.Unregister(ctx, l1); err != nil {
+		t.Fatal(err)
+	}
+	assertOnline(t, r, "u1", true, 1)
+	if err := r.Unregister(ctx, l2); err != nil {
+		t.Fatal(err)
+	}
+	assertOnline(t, r, "u1", false, 0)
+	if n, _ := r.CountOnline(ctx); n != 0 {
+		t.Fatalf("count online after unregister: %d", n)
+	}
+}
+
+func TestRegistryReconnectOtherNode(t *testing.T) {
+	c := newTestCache(t)
+	r1 := presence.NewRedisRegistry(c, "presence", "node1", time.Minute)
+	r2 := presence.NewRedisRegistry(c, "presence", "node2", time.Minute)
+	ctx := context.Background()
+
+	// 同一条连接重连到 node2 后，node1 才注销旧连接
+	old, cur := testLine("u1", "l1"), testLine("u1", "l1")
+	if err := r1.Register(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Register(ctx, cur); err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.Unregister(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	assertOnline(t, r1, "u1", true, 1)
+	if infos, _ := r1.ListLines(ctx, "u1"); infos[0].Node != "node2" {
+		t.Fatalf("unexpected node: %s", infos[0].Node)
+	}
+}
+
+func TestRegistryTtlExpiry(t *testing.T) {
+	r := newTestRegistry(t, "node1", 200*time.Millisecond)
+	ctx := context.Background()
+
+	l1, l2 := testLine("u1", "l1"), testLine("u2", "l2")
+	for _, l := range []*presence.LineInfo{l1, l2} {
+		if err := r.Register(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 只为 u1 续期，u2 超过 TTL 后视为离线（比如节点宕机，没有注销）
+	for range 4 {
+		time.Sleep(100 * time.Millisecond)
+		if err := r.Heartbeat(ctx, []*presence.LineInfo{l1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertOnline(t, r, "u1", true, 1)
+	assertOnline(t, r, "u2", false, 0)
+	if n, _ := r.CountOnline(ctx); n != 1 {
+		t.Fatalf("count online: %d", n)
+	}
+}
+
+func TestTrackerHeartbeatAndStop(t *testing.T) {
+	r := newTestRegistry(t, "node1", 3*time.Second)
+	pool, err := ants.NewPool(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Release)
+
+	line := testLine("u1", "l1")
+	tracker := presence.NewTracker(r, pool, "TEST", func() []*presence.LineInfo { return []*presence.LineInfo{line} })
+	tracker.Register(line)
+	if err := tracker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// 续期间隔为 TTL 的 1/3，超过 TTL 后仍在线
+	time.Sleep(4 * time.Second)
+	assertOnline(t, r, "u1", true, 1)
+
+	tracker.Stop()
+	assertOnline(t, r, "u1", false, 0)
+
+	// 没有注册表时不做任何事
+	none := presence.NewTracker(nil, pool, "TEST", nil)
+	none.Register(line)
+	if none.Registry() != nil || none.Start() != nil {
+		t.Fatal("nil tracker should do nothing")
+	}
+	none.Stop()
+}
@@ -0,0 +1,49 @@
+package rmq_test
+
+import (
+	"errors"
+	"goapp/pkg/rmq"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// 记录确认及拒绝的结果
+type testAcknowledger struct {
+	results []string
+}
+
+func (a *testAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.results = append(a.results, "ack")
+	return nil
+}
+
+func (a *testAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		a.results = append(a.results, "requeue")
+	} else {
+		a.results = append(a.results, "nack")
+	}
+	return nil
+}
+
+func (a *testAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestDeliverySettlesOnce(t *testing.T) {
+	ack := &testAcknowledger{}
+	d := &rmq.Delivery{Delivery: amqp.Delivery{Acknowledger: ack}}
+	if err := d.Nack(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ack(); !errors.Is(err, rmq.ErrSettled) {
+		t.Fatalf("expected ErrSettled, got %v", err)
+	}
+	if err := d.Requeue(); !errors.Is(err, rmq.ErrSettled) {
+		t.Fatalf("expected ErrSettled, got %v", err)
+	}
+	if !d.Settled() || len(ack.results) != 1 || ack.results[0] != "nack" {
+		t.Fatalf("unexpected results: %v", ack.results)
+	}
+}
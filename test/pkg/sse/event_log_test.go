@@ -0,0 +1,133 @@
+package sse_test
+
+import (
+	"bufio"
+	"context"
+	"goapp/pkg/core"
+	"goapp/pkg/sse"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/panjf2000/ants/v2"
+)
+
+func TestMemoryEventLog(t *testing.T) {
+	ctx := context.Background()
+	log := sse.NewMemoryEventLog(3, time.Minute)
+
+	var ids []string
+	for i := range 5 {
+		id, err := log.Append(ctx, "u1", &sse.LoggedFrame{Frame: sse.Frame{Data: strconv.Itoa(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) > 0 {
+			prev, _ := strconv.ParseUint(ids[len(ids)-1], 10, 64)
+			cur, _ := strconv.ParseUint(id, 10, 64)
+			if cur <= prev {
+				t.Fatalf("id not monotonic: %s <= %s", id, ids[len(ids)-1])
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	// 只保留最后 3 条
+	frames, err := log.Since(ctx, "u1", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 || frames[0].Data != "2" {
+		t.Fatalf("unexpected frames: %+v", frames)
+	}
+
+	frames, _ = log.Since(ctx, "u1", ids[3])
+	if len(frames) != 1 || frames[0].ID != ids[4] {
+		t.Fatalf("unexpected frames since %s: %+v", ids[3], frames)
+	}
+
+	frames, _ = log.Since(ctx, "u2", "0")
+	if len(frames) != 0 {
+		t.Fatalf("unexpected frames for u2: %+v", frames)
+	}
+}
+
+func TestReplayOnReconnect(t *testing.T) {
+	pool, err := ants.NewPool(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Release)
+
+	log := sse.NewMemoryEventLog(16, time.Minute)
+	h, err := sse.NewHub(pool, time.Minute, sse.WithEventLog(log), sse.WithRetry(3*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/sse", func(c *gin.Context) {
+		h.Serve(c, "u1", core.Platform(1), c.Query("id"), core.MapX{})
+	})
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	// 用户离线期间推送的消息
+	h.PushMessage([]string{"u1"}, "a")
+	h.PushToUserLines("u1", "b", "line1")
+	h.PushToUserLines("u1", "c", "line2")
+	h.PushMessage([]string{"u1"}, "d")
+
+	var frames []*sse.LoggedFrame
+	for range 50 {
+		frames, _ = log.Since(context.Background(), "u1", "0")
+		if len(frames) == 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("expected 4 logged frames, got %d", len(frames))
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/sse?id=line1", nil)
+	req.Header.Set("Last-Event-ID", frames[0].ID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() []string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimRight(line, "\n")
+			if len(line) == 0 {
+				return lines
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	open := readEvent()
+	if strings.Join(open, "|") != "retry: 3000|data: open" {
+		t.Fatalf("unexpected open event: %v", open)
+	}
+	// line2 的消息不会补发给 line1
+	for _, want := range []*sse.LoggedFrame{frames[1], frames[3]} {
+		got := readEvent()
+		if strings.Join(got, "|") != "id: "+want.ID+"|data: "+want.Data {
+			t.Fatalf("unexpected replayed event: %v, want %s", got, want.Data)
+		}
+	}
+}
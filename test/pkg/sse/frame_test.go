@@ -0,0 +1,28 @@
+package sse_test
+
+import (
+	"bytes"
+	"goapp/pkg/sse"
+	"testing"
+)
+
+func TestFrameWriteTo(t *testing.T) {
+	cases := []struct {
+		frame sse.Frame
+		want  string
+	}{
+		{sse.Frame{Data: "ping"}, "data: ping\n\n"},
+		{sse.Frame{ID: "1", Event: "token", Data: "hi"}, "id: 1\nevent: token\ndata: hi\n\n"},
+		{sse.Frame{Data: "a\nb\r\nc\rd", Retry: 3000}, "retry: 3000\ndata: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{sse.Frame{ID: "1\n2", Event: "do\r\nne", Data: ""}, "id: 12\nevent: done\ndata: \n\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if _, err := c.frame.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.want {
+			t.Errorf("frame %+v: got %q, want %q", c.frame, buf.String(), c.want)
+		}
+	}
+}